@@ -0,0 +1,442 @@
+// Package cdsmock is a fake of the Copernicus data store (CDS, ADS and EWDS) APIs, for tests and
+// demos. It serves the catalogue (collections and forms), process execution, job status, results
+// and the download of results, with scriptable job state transitions and synthetic payloads.
+//
+// In a test, start it with httptest.NewServer(cdsmock.New()) and point CDS_URL (or
+// globals.CDS_URL before globals.Init) at the server's URL.
+package cdsmock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Job states, as reported by the Copernicus APIs.
+const (
+	StatusAccepted   = "accepted"
+	StatusRunning    = "running"
+	StatusSuccessful = "successful"
+	StatusFailed     = "failed"
+	StatusDismissed  = "dismissed"
+)
+
+// DefaultScript is the states a job goes through: each poll of the job moves it to the next
+// state, and it stays in the last one.
+var DefaultScript = []string{StatusAccepted, StatusRunning, StatusSuccessful}
+
+// timeLayout is how the Copernicus APIs write times.
+const timeLayout = "2006-01-02T15:04:05.000000"
+
+// Server is a fake Copernicus data store. It is an http.Handler.
+type Server struct {
+	mu       sync.Mutex
+	router   *mux.Router
+	datasets map[string]Dataset
+	order    []string // dataset IDs in catalogue order
+	key      string
+	script   []string
+	jobs     map[string]*Job
+	nextID   int
+}
+
+// Job is a job of the fake data store.
+type Job struct {
+	ID        string                 `json:"job_id"`
+	Dataset   string                 `json:"dataset"`
+	Inputs    map[string]interface{} `json:"inputs"`
+	Script    []string               `json:"script"`
+	Step      int                    `json:"step"`  // Index of the current state in Script
+	Polls     int                    `json:"polls"` // Number of status requests so far
+	Message   string                 `json:"message,omitempty"`
+	Created   time.Time              `json:"created"`
+	Downloads int                    `json:"downloads"` // Number of result downloads so far
+}
+
+// Status is the current state of the job.
+func (j *Job) Status() string {
+	return j.Script[j.Step]
+}
+
+// New creates a fake data store with the default dataset and script. Options change them.
+func New(options ...Option) *Server {
+	s := &Server{
+		datasets: map[string]Dataset{},
+		script:   DefaultScript,
+		jobs:     map[string]*Job{},
+	}
+	for _, dataset := range DefaultDatasets() {
+		s.AddDataset(dataset)
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.routes()
+	return s
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithKey makes the server require a PRIVATE-TOKEN header with this key on retrieve requests.
+func WithKey(key string) Option {
+	return func(s *Server) { s.key = key }
+}
+
+// WithScript sets the states new jobs go through.
+func WithScript(states ...string) Option {
+	return func(s *Server) { s.script = states }
+}
+
+// WithDatasets replaces the default datasets.
+func WithDatasets(datasets ...Dataset) Option {
+	return func(s *Server) {
+		s.datasets = map[string]Dataset{}
+		s.order = nil
+		for _, dataset := range datasets {
+			s.AddDataset(dataset)
+		}
+	}
+}
+
+// AddDataset adds a dataset to the catalogue, replacing any dataset with the same ID.
+func (s *Server) AddDataset(dataset Dataset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.datasets[dataset.ID]; !ok {
+		s.order = append(s.order, dataset.ID)
+	}
+	s.datasets[dataset.ID] = dataset
+}
+
+// SetScript sets the states jobs created from now on go through.
+func (s *Server) SetScript(states ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = states
+}
+
+// SetJobStatus moves a job to a state right away, with an optional message for failures.
+func (s *Server) SetJobStatus(jobID string, status string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return fmt.Errorf("job %s not found", jobID)
+	}
+	job.Script = append(job.Script[:job.Step+1:job.Step+1], status)
+	job.Step++
+	job.Message = message
+	return nil
+}
+
+// Jobs returns a copy of the jobs created so far.
+func (s *Server) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for i := 1; i <= s.nextID; i++ {
+		if job, ok := s.jobs[jobName(i)]; ok {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) routes() {
+	s.router = mux.NewRouter()
+	// Clients built with the public URLs of the services keep their "/api" prefix
+	for _, prefix := range []string{"", "/api"} {
+		r := s.router.PathPrefix(prefix + "/").Subrouter()
+		r.HandleFunc("/catalogue/v1/collections/", s.getCollections).Methods("GET")
+		r.HandleFunc("/catalogue/v1/collections", s.getCollections).Methods("GET")
+		r.HandleFunc("/catalogue/v1/collections/{id}", s.getCollection).Methods("GET")
+		r.HandleFunc("/catalogue/v1/collections/{id}/form.json", s.getForm).Methods("GET")
+		r.HandleFunc("/retrieve/v1/processes/{id}/execution", s.authorized(s.postExecution)).Methods("POST")
+		r.HandleFunc("/retrieve/v1/jobs/{id}", s.authorized(s.getJob)).Methods("GET")
+		r.HandleFunc("/retrieve/v1/jobs/{id}", s.authorized(s.deleteJob)).Methods("DELETE")
+		r.HandleFunc("/retrieve/v1/jobs/{id}/results", s.authorized(s.getResults)).Methods("GET")
+		r.HandleFunc("/download/{id}", s.download).Methods("GET")
+	}
+
+	// Scripting endpoints, for demos driven over HTTP
+	s.router.HandleFunc("/mock/script", s.putScript).Methods("PUT")
+	s.router.HandleFunc("/mock/jobs", s.getJobs).Methods("GET")
+	s.router.HandleFunc("/mock/jobs/{id}", s.putJobStatus).Methods("PUT")
+}
+
+// authorized rejects retrieve requests without the server's key, if it has one.
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.key != "" && r.Header.Get("PRIVATE-TOKEN") != s.key {
+			respond(w, http.StatusUnauthorized, map[string]string{"title": "Authentication failed", "detail": "invalid PRIVATE-TOKEN"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) getCollections(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	collections := make([]map[string]interface{}, 0, len(s.order))
+	for _, id := range s.order {
+		collections = append(collections, s.datasets[id].collection(baseURL(r)))
+	}
+	respond(w, http.StatusOK, map[string]interface{}{
+		"collections":    collections,
+		"links":          []interface{}{},
+		"numberMatched":  len(collections),
+		"numberReturned": len(collections),
+	})
+}
+
+func (s *Server) getCollection(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := s.dataset(mux.Vars(r)["id"])
+	if !ok {
+		notFound(w, "collection")
+		return
+	}
+	respond(w, http.StatusOK, dataset.collection(baseURL(r)))
+}
+
+func (s *Server) getForm(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := s.dataset(mux.Vars(r)["id"])
+	if !ok {
+		notFound(w, "collection")
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(dataset.Form))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dataset.Form)
+}
+
+func (s *Server) postExecution(w http.ResponseWriter, r *http.Request) {
+	datasetID := mux.Vars(r)["id"]
+	if _, ok := s.dataset(datasetID); !ok {
+		notFound(w, "process")
+		return
+	}
+	var execute struct {
+		Inputs map[string]interface{} `json:"inputs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&execute); err != nil {
+		respond(w, http.StatusBadRequest, map[string]string{"title": "invalid request", "detail": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	job := &Job{
+		ID:      jobName(s.nextID),
+		Dataset: datasetID,
+		Inputs:  execute.Inputs,
+		Script:  append([]string{}, s.script...),
+		Created: time.Now().UTC(),
+	}
+	s.jobs[job.ID] = job
+	status := job.statusInfo()
+	s.mu.Unlock()
+
+	respond(w, http.StatusCreated, status)
+}
+
+// getJob reports the state of a job, then moves it to the next state of its script.
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[mux.Vars(r)["id"]]
+	if !ok {
+		notFound(w, "job")
+		return
+	}
+	job.Polls++
+	status := job.statusInfo()
+	if job.Step < len(job.Script)-1 {
+		job.Step++
+	}
+	respond(w, http.StatusOK, status)
+}
+
+func (s *Server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[mux.Vars(r)["id"]]
+	if !ok {
+		notFound(w, "job")
+		return
+	}
+	if job.Status() != StatusSuccessful && job.Status() != StatusFailed {
+		job.Script = append(job.Script[:job.Step+1:job.Step+1], StatusDismissed)
+		job.Step++
+	}
+	respond(w, http.StatusOK, job.statusInfo())
+}
+
+func (s *Server) getResults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[mux.Vars(r)["id"]]
+	if !ok {
+		notFound(w, "job")
+		return
+	}
+	switch job.Status() {
+	case StatusSuccessful:
+	case StatusFailed:
+		respond(w, http.StatusBadRequest, map[string]string{"title": "job failed", "detail": job.Message})
+		return
+	default:
+		respond(w, http.StatusNotFound, map[string]string{"title": "results not ready", "detail": "job is " + job.Status()})
+		return
+	}
+
+	payload, mediaType := s.datasets[job.Dataset].payload(job.Inputs)
+	respond(w, http.StatusOK, map[string]interface{}{
+		"asset": map[string]interface{}{
+			"value": map[string]interface{}{
+				"type":            mediaType,
+				"href":            baseURL(r) + "download/" + job.ID,
+				"file:size":       len(payload),
+				"file:checksum":   "",
+				"file:local_path": "",
+			},
+		},
+	})
+}
+
+// download serves the result of a job, with support for Range requests.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[mux.Vars(r)["id"]]
+	if !ok || job.Status() != StatusSuccessful {
+		s.mu.Unlock()
+		notFound(w, "result")
+		return
+	}
+	job.Downloads++
+	payload, mediaType := s.datasets[job.Dataset].payload(job.Inputs)
+	created := job.Created
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", mediaType)
+	http.ServeContent(w, r, job.ID, created, bytes.NewReader(payload))
+}
+
+func (s *Server) putScript(w http.ResponseWriter, r *http.Request) {
+	var states []string
+	if err := json.NewDecoder(r.Body).Decode(&states); err != nil || len(states) == 0 {
+		respond(w, http.StatusBadRequest, map[string]string{"title": "invalid script", "detail": "expected a list of states"})
+		return
+	}
+	s.SetScript(states...)
+	respond(w, http.StatusOK, states)
+}
+
+func (s *Server) getJobs(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, s.Jobs())
+}
+
+// putJobStatus moves a job to the state given by ?status=, with an optional ?message=.
+func (s *Server) putJobStatus(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		respond(w, http.StatusBadRequest, map[string]string{"title": "invalid request", "detail": "status is required"})
+		return
+	}
+	if err := s.SetJobStatus(mux.Vars(r)["id"], status, r.URL.Query().Get("message")); err != nil {
+		notFound(w, "job")
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": status})
+}
+
+func (s *Server) dataset(id string) (Dataset, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dataset, ok := s.datasets[id]
+	return dataset, ok
+}
+
+// statusInfo is the job as the Copernicus APIs report it.
+func (j *Job) statusInfo() map[string]interface{} {
+	created := j.Created.Format(timeLayout)
+	info := map[string]interface{}{
+		"processID": j.Dataset,
+		"type":      "process",
+		"jobID":     j.ID,
+		"status":    j.Status(),
+		"created":   created,
+		"updated":   time.Now().UTC().Format(timeLayout),
+		"metadata": map[string]interface{}{
+			"request": map[string]interface{}{"ids": j.Inputs},
+			"log":     j.log(),
+		},
+	}
+	if j.Status() != StatusAccepted {
+		info["started"] = created
+	}
+	switch j.Status() {
+	case StatusSuccessful, StatusFailed, StatusDismissed:
+		info["finished"] = time.Now().UTC().Format(timeLayout)
+	}
+	if j.Message != "" {
+		info["message"] = j.Message
+	} else if j.Status() == StatusFailed {
+		info["message"] = "The job failed (scripted by the mock)"
+	}
+	return info
+}
+
+// log is a log line per state the job went through.
+func (j *Job) log() [][][]interface{} {
+	lines := make([][][]interface{}, 0, j.Step+1)
+	for i := 0; i <= j.Step; i++ {
+		lines = append(lines, [][]interface{}{{j.Created.Format(timeLayout), "INFO", "job " + j.Script[i]}})
+	}
+	return lines
+}
+
+func jobName(n int) string {
+	return "mock-job-" + strconv.Itoa(n)
+}
+
+// baseURL is the URL the request was sent to, up to the API root.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	prefix := "/"
+	if len(r.URL.Path) >= 5 && r.URL.Path[:5] == "/api/" {
+		prefix = "/api/"
+	}
+	return scheme + "://" + r.Host + prefix
+}
+
+func notFound(w http.ResponseWriter, what string) {
+	respond(w, http.StatusNotFound, map[string]string{"title": what + " not found"})
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,85 @@
+package cdsmock
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Dataset is a collection of the fake catalogue.
+type Dataset struct {
+	ID           string
+	Title        string
+	Description  string
+	Keywords     []string
+	DOI          string
+	LicenceID    string
+	LicenceTitle string
+	Form         json.RawMessage // The form.json of the dataset
+	Extent       interface{}     // STAC extent of the dataset
+}
+
+// DefaultDatasets are the datasets of a new Server: a reduced form of ERA5 on single levels.
+func DefaultDatasets() []Dataset {
+	return []Dataset{{
+		ID:           "reanalysis-era5-single-levels",
+		Title:        "ERA5 hourly data on single levels from 1940 to present",
+		Description:  "Synthetic copy of the ERA5 single levels dataset, served by the Copernicus mock.",
+		Keywords:     []string{"Product type: Reanalysis", "Spatial coverage: Global", "Temporal coverage: Past"},
+		DOI:          "10.24381/cds.adbb2d47",
+		LicenceID:    "licence-to-use-copernicus-products",
+		LicenceTitle: "Licence to use Copernicus Products",
+		Form:         json.RawMessage(era5Form),
+		Extent: map[string]interface{}{
+			"spatial":  map[string]interface{}{"bbox": [][]float64{{-180, -90, 180, 90}}},
+			"temporal": map[string]interface{}{"interval": [][]interface{}{{"1940-01-01T00:00:00Z", nil}}},
+		},
+	}}
+}
+
+// collection is the dataset as the catalogue reports it.
+func (d Dataset) collection(base string) map[string]interface{} {
+	published := time.Date(2018, 6, 14, 0, 0, 0, 0, time.UTC)
+	return map[string]interface{}{
+		"type":         "Collection",
+		"id":           d.ID,
+		"stac_version": "1.0.0",
+		"title":        d.Title,
+		"description":  d.Description,
+		"keywords":     d.Keywords,
+		"license":      "other",
+		"published":    published,
+		"updated":      published,
+		"sci:doi":      d.DOI,
+		"extent":       d.Extent,
+		"links": []map[string]interface{}{
+			{"rel": "self", "type": "application/json", "href": base + "catalogue/v1/collections/" + d.ID},
+			{"rel": "form", "type": "application/json", "href": base + "catalogue/v1/collections/" + d.ID + "/form.json"},
+			{"rel": "license", "type": "application/json", "title": d.LicenceTitle, "href": base + "catalogue/v1/licences/" + d.LicenceID},
+		},
+	}
+}
+
+const era5Form = `[
+	{"name": "product_type", "label": "Product type", "type": "StringListWidget", "required": true,
+		"details": {"values": ["reanalysis", "ensemble_members", "ensemble_mean", "ensemble_spread"]}},
+	{"name": "variable", "label": "Variable", "type": "StringListWidget", "required": true,
+		"details": {"values": ["2m_temperature", "2m_dewpoint_temperature", "total_precipitation", "surface_pressure",
+			"10m_u_component_of_wind", "10m_v_component_of_wind"]}},
+	{"name": "year", "label": "Year", "type": "StringListWidget", "required": true,
+		"details": {"values": ["2018", "2019", "2020", "2021", "2022", "2023", "2024", "2025", "2026"]}},
+	{"name": "month", "label": "Month", "type": "StringListWidget", "required": true,
+		"details": {"values": ["01", "02", "03", "04", "05", "06", "07", "08", "09", "10", "11", "12"]}},
+	{"name": "day", "label": "Day", "type": "StringListWidget", "required": true,
+		"details": {"values": ["01", "02", "03", "04", "05", "06", "07", "08", "09", "10", "11", "12", "13", "14", "15",
+			"16", "17", "18", "19", "20", "21", "22", "23", "24", "25", "26", "27", "28", "29", "30", "31"]}},
+	{"name": "time", "label": "Time", "type": "StringListWidget", "required": true,
+		"details": {"values": ["00:00", "03:00", "06:00", "09:00", "12:00", "15:00", "18:00", "21:00"]}},
+	{"name": "geographical_area", "label": "Geographical area", "type": "ExclusiveGroupWidget",
+		"children": ["global", "area"], "details": {"default": "global"}},
+	{"name": "global", "label": "Whole available region", "type": "FreeEditionWidget", "details": {}},
+	{"name": "area", "label": "Sub-region extraction", "type": "GeographicExtentWidget",
+		"details": {"range": {"n": 90, "w": -180, "s": -90, "e": 180}, "precision": 2}},
+	{"name": "data_format", "label": "Data format", "type": "StringChoiceWidget", "required": true,
+		"details": {"values": ["grib", "netcdf"], "default": ["grib"]}},
+	{"name": "licences", "label": "Terms of use", "type": "LicenceWidget", "required": true, "details": {}}
+]`
@@ -0,0 +1,154 @@
+package cdsmock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxRecords caps the time steps of a synthetic payload.
+const maxRecords = 10000
+
+// payload is the synthetic result of a request: a NetCDF classic file with a record per time step
+// if the request asks for NetCDF, or otherwise deterministic bytes of about a kilobyte per time step.
+// The same request always gives the same payload.
+func (d Dataset) payload(inputs map[string]interface{}) ([]byte, string) {
+	seed, _ := json.Marshal(inputs)
+	records := timeSteps(inputs)
+	format := fmt.Sprint(inputs["data_format"])
+	if format == "<nil>" {
+		format = fmt.Sprint(inputs["format"])
+	}
+	if strings.HasPrefix(format, "netcdf") {
+		return netCDF(records, variables(inputs), seed), "application/netcdf"
+	}
+
+	var payload bytes.Buffer
+	payload.WriteString("GRIB")
+	block := sha256.Sum256(append([]byte(d.ID), seed...))
+	for payload.Len() < records*1024 {
+		payload.Write(block[:])
+		block = sha256.Sum256(block[:])
+	}
+	return payload.Bytes(), "application/x-grib"
+}
+
+// timeSteps is the number of time steps a request covers: the days of a "start/end" date range,
+// or the combinations of its years, months, days and times.
+func timeSteps(inputs map[string]interface{}) int {
+	steps := 1
+	if date, ok := inputs["date"].(string); ok && strings.Contains(date, "/") {
+		bounds := strings.SplitN(date, "/", 2)
+		start, err1 := time.Parse("2006-01-02", strings.TrimSpace(bounds[0]))
+		end, err2 := time.Parse("2006-01-02", strings.TrimSpace(bounds[1]))
+		if err1 == nil && err2 == nil && !end.Before(start) {
+			steps = int(end.Sub(start).Hours()/24) + 1
+		}
+	} else {
+		for _, name := range []string{"year", "month", "day"} {
+			steps *= count(inputs[name])
+		}
+	}
+	steps *= count(inputs["time"])
+	if steps > maxRecords {
+		return maxRecords
+	}
+	return steps
+}
+
+// count is the number of values of a field, at least one.
+func count(value interface{}) int {
+	if values, ok := value.([]interface{}); ok && len(values) > 0 {
+		return len(values)
+	}
+	return 1
+}
+
+// variables are the variables of a request, or a default one.
+func variables(inputs map[string]interface{}) []string {
+	var names []string
+	switch v := inputs["variable"].(type) {
+	case []interface{}:
+		for _, name := range v {
+			names = append(names, fmt.Sprint(name))
+		}
+	case string:
+		names = append(names, v)
+	}
+	if len(names) == 0 {
+		names = []string{"2m_temperature"}
+	}
+	return names
+}
+
+// netCDF writes a NetCDF classic (CDF-1) file with an unlimited time dimension, an int time
+// variable and a float variable per requested variable, all along time.
+func netCDF(records int, names []string, seed []byte) []byte {
+	const (
+		ncInt   = 4
+		ncFloat = 5
+	)
+	var header bytes.Buffer
+	write32 := func(v uint32) { binary.Write(&header, binary.BigEndian, v) }
+	writeName := func(name string) {
+		write32(uint32(len(name)))
+		header.WriteString(name)
+		header.Write(make([]byte, (4-len(name)%4)%4))
+	}
+
+	header.WriteString("CDF\x01")
+	write32(uint32(records))
+	write32(0x0A) // Dimensions
+	write32(1)
+	writeName("time")
+	write32(0)
+	write32(0x0C) // Global attributes
+	write32(1)
+	writeName("source")
+	write32(2) // char
+	source := "Copernicus mock"
+	write32(uint32(len(source)))
+	header.WriteString(source)
+	header.Write(make([]byte, (4-len(source)%4)%4))
+
+	vars := append([]string{"time"}, names...)
+	write32(0x0B) // Variables
+	write32(uint32(len(vars)))
+	var begins []int
+	for i, name := range vars {
+		writeName(name)
+		write32(1) // Rank
+		write32(0) // Along time
+		write32(0) // No attributes
+		write32(0)
+		if i == 0 {
+			write32(ncInt)
+		} else {
+			write32(ncFloat)
+		}
+		write32(4) // Size of a record
+		begins = append(begins, header.Len())
+		write32(0) // Offset, set below
+	}
+	start := header.Len()
+	file := header.Bytes()
+	for i, at := range begins {
+		binary.BigEndian.PutUint32(file[at:at+4], uint32(start+4*i))
+	}
+
+	out := bytes.NewBuffer(file)
+	digest := sha256.Sum256(seed)
+	for r := 0; r < records; r++ {
+		binary.Write(out, binary.BigEndian, int32(r))
+		for v := range names {
+			value := 273.15 + 10*math.Sin(float64(r)/24*2*math.Pi+float64(v)+float64(digest[v%len(digest)]))
+			binary.Write(out, binary.BigEndian, float32(value))
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,30 @@
+// Command cdsmock runs the fake Copernicus data store, for demos and manual tests of the
+// Copernicus endpoints without a real key. Point CDS_URL (or ADS_URL, EWDS_URL) at it.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/isotiropoulos/storage-api/cdsmock"
+)
+
+func main() {
+	port := os.Getenv("CDS_MOCK_PORT")
+	if port == "" {
+		port = "8090"
+	}
+
+	var options []cdsmock.Option
+	if key := os.Getenv("CDS_MOCK_KEY"); key != "" {
+		options = append(options, cdsmock.WithKey(key))
+	}
+	if script := os.Getenv("CDS_MOCK_SCRIPT"); script != "" {
+		options = append(options, cdsmock.WithScript(strings.Split(script, ",")...))
+	}
+
+	log.Println("Copernicus mock listening on :" + port)
+	log.Fatal(http.ListenAndServe(":"+port, cdsmock.New(options...)))
+}
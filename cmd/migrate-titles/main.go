@@ -0,0 +1,21 @@
+// Command migrate-titles gives a title key to the files and folders stored before titles were
+// unique in their folder, renaming those whose folder already holds their title. Run it once,
+// with the MONGO_URL and DATABASE of the API; it creates the indexes of the API first. Each rename
+// is logged.
+package main
+
+import (
+	"log"
+
+	db "github.com/isotiropoulos/storage-api/dbs/meta"
+)
+
+func main() {
+	db.NewDB()
+	if err := db.EnsureIndexes(); err != nil {
+		log.Fatalln("Titles can't be migrated: ", err.Error())
+	}
+	if err := db.MigrateTitleKeys(); err != nil {
+		log.Fatalln("Could not migrate titles: ", err.Error())
+	}
+}
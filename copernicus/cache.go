@@ -0,0 +1,121 @@
+package copernicus
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+)
+
+// statusSubmitting is the status of a record while its request is submitted to Copernicus. The
+// caller that set it holds the submission, and identical requests wait for it.
+const statusSubmitting = "submitting"
+
+// A submission is waited for at most submitTimeout, checking every submitPoll. A record left
+// submitting for longer belongs to a replica that stopped, and its submission is claimed again.
+var (
+	submitTimeout = 30 * time.Second
+	submitPoll    = 100 * time.Millisecond
+)
+
+// ErrSubmissionPending is returned when an identical request is still being submitted.
+var ErrSubmissionPending = errors.New("identical request still being submitted")
+
+// cacheTTL is how long a stored dataset is reused for identical requests, overridden by
+// COP_CACHE_TTL. Zero means stored datasets never go stale.
+var cacheTTL time.Duration
+
+// Lookup returns the record of a request fingerprint and its reference file. reusable is false
+// when a new job has to be submitted: the request is new, its job failed or was dismissed, its
+// reference file is gone, or its stored dataset is older than the cache TTL. Jobs still in
+// progress are always reused.
+func Lookup(fingerprint string) (record models.CopernicusRecord, file models.File, reusable bool) {
+	record, err := globals.CopernicusDB.GetOneByID(fingerprint)
+	if err != nil {
+		return models.CopernicusRecord{}, models.File{}, false
+	}
+
+	switch record.Details.Status {
+	case "failed", "dismissed", statusSubmitting:
+		return record, models.File{}, false
+	}
+
+	file, err = globals.FileDB.GetOneByID(record.FileId)
+	if err != nil {
+		return record, models.File{}, false
+	}
+
+	if cacheTTL > 0 && file.Size > 0 {
+		submitted := record.DateCreation
+		if submitted.IsZero() {
+			// Records from before the date was kept
+			submitted = file.Meta.DateCreation
+		}
+		if time.Since(submitted) > cacheTTL {
+			return record, file, false
+		}
+	}
+	return record, file, true
+}
+
+// claimSubmission looks up a request fingerprint like Lookup and, when a new job has to be
+// submitted, claims the submission for the caller: the record of the request is marked as
+// submitting, so that identical requests wait for it and then reuse its record. release gives
+// the claim back, restoring the previous record, if the job can't be submitted.
+func claimSubmission(fingerprint string, input models.CopernicusInput, subject string) (record models.CopernicusRecord, file models.File, reusable bool, release func(), err error) {
+	deadline := time.Now().Add(submitTimeout)
+	for {
+		record, file, reusable = Lookup(fingerprint)
+		if reusable {
+			return record, file, true, nil, nil
+		}
+
+		pending := record.Details.Status == statusSubmitting && time.Since(record.DateCreation) < submitTimeout
+		if !pending {
+			var previous *models.CopernicusRecord
+			if record.Id != "" {
+				stored := record
+				previous = &stored
+			}
+			claim := models.CopernicusRecord{
+				Id:            fingerprint,
+				DatasetName:   input.DatasetName,
+				Service:       input.Service,
+				RequestParams: input.Body,
+				Requesters:    []string{subject},
+				DateCreation:  time.Now(),
+			}
+			claim.Details.Status = statusSubmitting
+			claimed, err := globals.CopernicusDB.ClaimSubmission(claim, previous)
+			if err != nil {
+				return record, models.File{}, false, nil, err
+			}
+			if claimed {
+				return record, models.File{}, false, releaseSubmission(fingerprint, previous), nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return record, models.File{}, false, nil, ErrSubmissionPending
+		}
+		time.Sleep(submitPoll)
+	}
+}
+
+// releaseSubmission returns a function that gives back the claim of a submission: the record it
+// replaced is restored, or the record of a new request deleted.
+func releaseSubmission(fingerprint string, previous *models.CopernicusRecord) func() {
+	return func() {
+		var err error
+		if previous != nil {
+			err = globals.CopernicusDB.ReplaceOne(*previous)
+		} else {
+			err = globals.CopernicusDB.DeleteOneByID(fingerprint)
+		}
+		if err != nil {
+			log.Println("Could not release submission of copernicus record "+fingerprint+": ", err.Error())
+		}
+	}
+}
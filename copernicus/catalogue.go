@@ -0,0 +1,359 @@
+package copernicus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	CDSModels "github.com/SLG-European-Projects/cds-go/models"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// catalogueInterval is how often the catalogue of each service is refreshed, overridden by
+// COP_CATALOGUE_REFRESH.
+var catalogueInterval = 6 * time.Hour
+
+// catalogueCheck is how often refreshes are checked for, and the least time between two attempts.
+const catalogueCheck = 5 * time.Minute
+
+// CatalogueRetry is how long clients are asked to wait for a catalogue that isn't fetched yet.
+const CatalogueRetry = 30 * time.Second
+
+// ErrCatalogueNotReady is returned for a service whose catalogue is being fetched for the first time.
+var ErrCatalogueNotReady = errors.New("catalogue not fetched yet")
+
+// refreshing holds the services whose catalogue this replica is refreshing.
+var (
+	refreshingMu sync.Mutex
+	refreshing   = map[string]bool{}
+)
+
+// refreshCatalogues refreshes the catalogue of each service when it is due, until ctx is done.
+// The state of the last refresh is shared, so replicas don't refresh the same service twice.
+func refreshCatalogues(ctx context.Context) {
+	for {
+		for _, service := range Services() {
+			state, err := globals.CopernicusCatalogueDB.GetState(service.Name)
+			due := err != nil || (time.Since(state.DateRefreshed) >= catalogueInterval && time.Since(state.DateAttempted) >= catalogueCheck)
+			if !due {
+				continue
+			}
+			refreshOnce(service.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(catalogueCheck):
+		}
+	}
+}
+
+// refreshOnce refreshes the catalogue of a service, unless this replica is refreshing it already.
+func refreshOnce(name string) {
+	refreshingMu.Lock()
+	if refreshing[name] {
+		refreshingMu.Unlock()
+		return
+	}
+	refreshing[name] = true
+	refreshingMu.Unlock()
+
+	defer func() {
+		refreshingMu.Lock()
+		delete(refreshing, name)
+		refreshingMu.Unlock()
+	}()
+	if err := RefreshCatalogue(name); err != nil {
+		log.Println("Could not refresh the catalogue of "+name+": ", err.Error())
+	}
+}
+
+// RefreshCatalogue stores the collections of a service and their forms. A form that can't be
+// fetched keeps its previous version, and datasets the service no longer lists are removed.
+// If the list itself can't be fetched, the stored catalogue is left as is.
+func RefreshCatalogue(service string) error {
+	name, err := ServiceName(service)
+	if err != nil {
+		return err
+	}
+	client := globals.CopernicusServices[name]
+
+	state, _ := globals.CopernicusCatalogueDB.GetState(name)
+	state.Service = name
+	state.DateAttempted = time.Now()
+	if err = globals.CopernicusCatalogueDB.SetState(state); err != nil {
+		return err
+	}
+
+	list, err := client.GetCollections()
+	if err == nil && len(list.Collections) == 0 {
+		err = errors.New("service listed no datasets")
+	}
+	if err != nil {
+		state.Error = err.Error()
+		globals.CopernicusCatalogueDB.SetState(state)
+		return err
+	}
+
+	started := time.Now()
+	listHash := sha256.New()
+	for i, collection := range list.Collections {
+		entry, err := catalogueEntry(name, i, collection)
+		if err != nil {
+			log.Println("Could not read collection "+collection.Id+": ", err.Error())
+			continue
+		}
+		entry.DateRefreshed = started
+
+		// Keep the previous form if the service has no newer one
+		if previous, err := globals.CopernicusCatalogueDB.GetOneByID(entry.Id); err == nil {
+			entry.Form, entry.FormETag, entry.UpstreamETag = previous.Form, previous.FormETag, previous.UpstreamETag
+		}
+		form, upstreamETag, err := fetchForm(client.C, collection.Id, entry.UpstreamETag)
+		switch {
+		case err != nil:
+			entry.FormError = err.Error()
+		case form != nil:
+			entry.Form = string(form)
+			entry.FormETag = ETag(form)
+			entry.UpstreamETag = upstreamETag
+		}
+		entry.Variables = formVariables(entry.Form)
+		forms.Delete(client.C.BaseURL + collection.Id)
+
+		if err = globals.CopernicusCatalogueDB.ReplaceOne(entry); err != nil {
+			return err
+		}
+		listHash.Write([]byte(entry.Collection))
+	}
+
+	if err = globals.CopernicusCatalogueDB.DeleteStale(name, started); err != nil {
+		return err
+	}
+	state.ETag = fmt.Sprintf("%x", listHash.Sum(nil))
+	state.Count = len(list.Collections)
+	state.DateRefreshed = time.Now()
+	state.Error = ""
+	return globals.CopernicusCatalogueDB.SetState(state)
+}
+
+// Catalogue returns the collections of a service from the catalogue, and the ETag of the list.
+// The catalogue of a service is fetched in the background the first time it is asked for, and
+// ErrCatalogueNotReady is returned until it is stored.
+func Catalogue(service string) (CDSModels.CollectionList, string, error) {
+	var list CDSModels.CollectionList
+	name, err := ServiceName(service)
+	if err != nil {
+		return list, "", err
+	}
+
+	state, err := globals.CopernicusCatalogueDB.GetState(name)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && state.DateRefreshed.IsZero()) {
+		// Unless a replica started on it lately
+		if time.Since(state.DateAttempted) >= catalogueCheck {
+			go refreshOnce(name)
+		}
+		if state.Error != "" {
+			return list, "", fmt.Errorf("%w: %s", ErrCatalogueNotReady, state.Error)
+		}
+		return list, "", ErrCatalogueNotReady
+	}
+	if err != nil {
+		return list, "", err
+	}
+
+	cursor, err := globals.CopernicusCatalogueDB.GetCursorByService(name)
+	if err != nil {
+		return list, "", err
+	}
+	entries, err := decodeEntries(cursor)
+	if err != nil {
+		return list, "", err
+	}
+
+	list.Collections = make([]CDSModels.Collection, 0, len(entries))
+	for _, entry := range entries {
+		var collection CDSModels.Collection
+		if err := json.Unmarshal([]byte(entry.Collection), &collection); err != nil {
+			return list, "", err
+		}
+		list.Collections = append(list.Collections, collection)
+	}
+	list.NumberMatched = len(list.Collections)
+	list.NumberReturned = len(list.Collections)
+	return list, state.ETag, nil
+}
+
+// SearchCatalogue returns the datasets of the catalogue that match a query.
+func SearchCatalogue(query models.CatalogueQuery) ([]models.CatalogueEntry, error) {
+	if query.Service != "" {
+		name, err := ServiceName(query.Service)
+		if err != nil {
+			return nil, err
+		}
+		query.Service = name
+	}
+	cursor, err := globals.CopernicusCatalogueDB.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntries(cursor)
+}
+
+// storedForm returns the form of a collection from the catalogue, and its ETag.
+func storedForm(service string, collectionID string) ([]models.FormField, string, error) {
+	entry, err := globals.CopernicusCatalogueDB.GetOneByID(service + ":" + collectionID)
+	if err != nil {
+		return nil, "", err
+	}
+	if entry.Form == "" {
+		return nil, "", fmt.Errorf("form of %s not fetched yet: %s", collectionID, entry.FormError)
+	}
+	var form []models.FormField
+	err = json.Unmarshal([]byte(entry.Form), &form)
+	return form, entry.FormETag, err
+}
+
+// fetchForm gets the form of a collection from its service. It returns a nil form if the service
+// answers that the form didn't change since upstreamETag.
+func fetchForm(client CDSModels.ClientProperties, collectionID string, upstreamETag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, client.BaseURL+"catalogue/v1/collections/"+collectionID+"/form.json", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if upstreamETag != "" {
+		req.Header.Set("If-None-Match", upstreamETag)
+	}
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, upstreamETag, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("could not get form of %s: %s", collectionID, resp.Status)
+	}
+
+	// Check that the form can be read before storing it
+	var form []models.FormField
+	if err = json.NewDecoder(resp.Body).Decode(&form); err != nil {
+		return nil, "", err
+	}
+	raw, err := json.Marshal(form)
+	return raw, resp.Header.Get("ETag"), err
+}
+
+// catalogueEntry turns a collection into an entry of the catalogue, reading its temporal
+// coverage and spatial extent from its STAC extent.
+func catalogueEntry(service string, position int, collection CDSModels.Collection) (models.CatalogueEntry, error) {
+	raw, err := json.Marshal(collection)
+	if err != nil {
+		return models.CatalogueEntry{}, err
+	}
+	entry := models.CatalogueEntry{
+		Id:           service + ":" + collection.Id,
+		Service:      service,
+		CollectionID: collection.Id,
+		Position:     position,
+		Title:        collection.Title,
+		Description:  collection.Description,
+		Keywords:     collection.Keywords,
+		Collection:   string(raw),
+	}
+
+	var extent struct {
+		Spatial struct {
+			BBox [][]float64 `json:"bbox"`
+		} `json:"spatial"`
+		Temporal struct {
+			Interval [][]*string `json:"interval"`
+		} `json:"temporal"`
+	}
+	if collection.Extent != nil {
+		extentJSON, _ := json.Marshal(collection.Extent)
+		if err := json.Unmarshal(extentJSON, &extent); err != nil {
+			// An extent that can't be read only leaves the dataset out of temporal and spatial searches
+			return entry, nil
+		}
+	}
+	if bbox := extent.Spatial.BBox; len(bbox) > 0 && len(bbox[0]) == 4 {
+		entry.Extent = &models.SpatialExtent{West: bbox[0][0], South: bbox[0][1], East: bbox[0][2], North: bbox[0][3]}
+	}
+	if interval := extent.Temporal.Interval; len(interval) > 0 && len(interval[0]) == 2 {
+		entry.TemporalStart = extentTime(interval[0][0])
+		entry.TemporalEnd = extentTime(interval[0][1])
+	}
+	return entry, nil
+}
+
+// extentTime parses a bound of a STAC temporal interval. Open bounds are nil.
+func extentTime(value *string) *time.Time {
+	if value == nil {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, *value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// formVariables returns the names and labels of the variables of a form.
+func formVariables(formJSON string) []string {
+	var form []models.FormField
+	if formJSON == "" || json.Unmarshal([]byte(formJSON), &form) != nil {
+		return nil
+	}
+	var variables []string
+	for _, field := range form {
+		if !strings.HasPrefix(field.Name, "variable") {
+			continue
+		}
+		variables = append(variables, field.Details.Values...)
+		for _, group := range field.Details.Groups {
+			variables = append(variables, groupValues(group)...)
+		}
+		for _, label := range field.Details.Labels {
+			variables = append(variables, label)
+		}
+	}
+	return variables
+}
+
+// ETag returns a strong ETag of some content.
+func ETag(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// decodeEntries reads a cursor of catalogue entries.
+func decodeEntries(cursor *mongo.Cursor) ([]models.CatalogueEntry, error) {
+	defer cursor.Close(context.Background())
+	entries := []models.CatalogueEntry{}
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		var entry models.CatalogueEntry
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &entry)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
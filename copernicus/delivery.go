@@ -0,0 +1,164 @@
+package copernicus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// NewDelivery creates a file for the dataset of a record in a user's folder, titled like the
+// dataset unless a title is given. If the dataset is already stored it is copied right away;
+// otherwise it's copied once the job completes.
+func NewDelivery(record models.CopernicusRecord, folder models.Folder, subject string, title string) (models.File, error) {
+	source, err := globals.FileDB.GetOneByID(record.FileId)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	fileID, err := utils.GenerateUUID()
+	if err != nil {
+		return models.File{}, err
+	}
+
+	if title == "" {
+		title = source.Meta.Title
+	}
+
+	now := time.Now()
+	file := models.File{
+		Id:            fileID,
+		FolderID:      folder.Id,
+		Ancestors:     append(append([]string{}, folder.Ancestors...), folder.Id),
+		OriginalTitle: source.OriginalTitle,
+		FileType:      source.FileType,
+		Meta: models.Meta{
+			Creator:      subject,
+			Title:        title,
+			DateCreation: now,
+			Read:         folder.Meta.Read,
+			Write:        folder.Meta.Write,
+			Update:       models.Updated{Date: now, User: subject},
+		},
+	}
+	// A delivery is never refused for its title: it's numbered if the folder already holds it
+	_, _, err = utils.Place(models.ConflictRename, title, true, func(title string) error {
+		file.Meta.Title = title
+		file.Path = utils.ItemPath(folder.Path, title)
+		return globals.FileDB.InsertOne(file)
+	}, nil, nil)
+	if err != nil {
+		return models.File{}, err
+	}
+	if err = globals.FolderDB.UpdateFiles(file.Id, file.FolderID); err != nil {
+		return models.File{}, err
+	}
+	if err = globals.FolderDB.UpdateMetaAncestors(file.Ancestors, subject); err != nil {
+		return models.File{}, err
+	}
+
+	delivery := models.CopernicusDelivery{FileId: file.Id, FolderID: folder.Id, Subject: subject}
+	if err = globals.CopernicusDB.AddDelivery(record.Id, delivery); err != nil {
+		return models.File{}, err
+	}
+
+	if source.Size == 0 {
+		return file, EnsureQueued(record, subject)
+	}
+	if err = Deliver(record, delivery); err != nil {
+		return file, err
+	}
+	return globals.FileDB.GetOneByID(file.Id)
+}
+
+// DeliverPending copies the dataset of a record into every delivered file still waiting for it.
+func DeliverPending(record models.CopernicusRecord) error {
+	var errs []error
+	for _, delivery := range record.Deliveries {
+		if !delivery.Delivered {
+			errs = append(errs, Deliver(record, delivery))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Deliver copies the stored dataset of a record into a delivered file, as regular parts in the
+// file's bucket. Deliveries whose file was deleted in the meantime are dropped.
+func Deliver(record models.CopernicusRecord, delivery models.CopernicusDelivery) error {
+	source, err := globals.FileDB.GetOneByID(record.FileId)
+	if err != nil {
+		return err
+	}
+	if source.Size == 0 {
+		// Not stored yet
+		return nil
+	}
+
+	target, err := globals.FileDB.GetOneByID(delivery.FileId)
+	if err != nil {
+		return globals.CopernicusDB.RemoveDelivery(record.Id, delivery.FileId)
+	}
+	if target.Size > 0 {
+		return globals.CopernicusDB.MarkDelivered(record.Id, delivery.FileId)
+	}
+
+	bucketFrom := source.Ancestors[0]
+	bucketTo := target.Ancestors[0]
+
+	// Parts of an interrupted delivery are replaced
+	if err = discardParts(target.Id, bucketTo); err != nil {
+		return err
+	}
+
+	partsCursor, err := globals.PartsDB.GetCursorByFileID(source.Id)
+	if err != nil {
+		return err
+	}
+	defer partsCursor.Close(context.Background())
+
+	for partsCursor.Next(context.Background()) {
+		var result bson.M
+		var part models.Part
+		if err = partsCursor.Decode(&result); err != nil {
+			return err
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &part)
+
+		newPartID, err := utils.GenerateUUID()
+		if err != nil {
+			return err
+		}
+		if err = globals.Storage.CopyFile(part.Id, newPartID, bucketFrom, bucketTo); err != nil {
+			return err
+		}
+
+		part.Id = newPartID
+		part.FileID = target.Id
+		if err = globals.PartsDB.InsertOne(part); err != nil {
+			return err
+		}
+	}
+
+	target.Size = source.Size
+	target.Total = source.Total
+	target.Meta.Update = models.Updated{Date: time.Now(), User: delivery.Subject}
+	if _, err = globals.FileDB.UpdateWithId(target); err != nil {
+		return err
+	}
+	provenance := source.Provenance
+	if provenance == nil {
+		provenance = recordProvenance(record, source.Meta.Update.Date)
+	}
+	if err = globals.FileDB.SetProvenance(target.Id, provenance); err != nil {
+		return err
+	}
+	if err = globals.FolderDB.UpdateAncestorSize(target.Ancestors, target.Size, true); err != nil {
+		return err
+	}
+	return globals.CopernicusDB.MarkDelivered(record.Id, delivery.FileId)
+}
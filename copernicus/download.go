@@ -0,0 +1,120 @@
+package copernicus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rangeReader streams a remote file and, when the connection drops, resumes it
+// from the last received byte with an HTTP Range request.
+type rangeReader struct {
+	ctx    context.Context
+	url    string
+	client *http.Client
+	body   io.ReadCloser
+	offset int64 // Bytes received so far
+	size   int64 // Total size, or -1 if unknown
+	fails  int   // Consecutive failed reconnections
+}
+
+// newRangeReader opens url and returns the reader and the size of the file (-1 if unknown).
+func newRangeReader(ctx context.Context, url string) (*rangeReader, int64, error) {
+	r := &rangeReader{ctx: ctx, url: url, client: &http.Client{}, size: -1}
+	if err := r.open(); err != nil {
+		return nil, 0, err
+	}
+	return r, r.size, nil
+}
+
+// open requests the file from the current offset.
+func (r *rangeReader) open() error {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make HTTP GET request: %w", err)
+	}
+
+	switch {
+	case r.offset == 0 && resp.StatusCode == http.StatusOK:
+		if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+			r.size = size
+		}
+	case r.offset > 0 && resp.StatusCode == http.StatusPartialContent:
+	default:
+		resp.Body.Close()
+		if r.offset > 0 && resp.StatusCode == http.StatusOK {
+			return errors.New("failed to resume download: server does not support range requests")
+		}
+		return fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+	}
+
+	r.body = resp.Body
+	return nil
+}
+
+// Read implements io.Reader.
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.fails = 0
+		}
+
+		complete := r.size >= 0 && r.offset >= r.size
+		if err == nil || (err == io.EOF && (complete || r.size < 0)) {
+			return n, err
+		}
+		if n > 0 {
+			// Deliver what was read; the error shows up again on the next call
+			return n, nil
+		}
+
+		// Connection dropped (or body ended early): resume from offset
+		if resumeErr := r.resume(err); resumeErr != nil {
+			return 0, resumeErr
+		}
+	}
+}
+
+// resume reopens the download after cause interrupted it, backing off between attempts.
+func (r *rangeReader) resume(cause error) error {
+	r.body.Close()
+	for {
+		if r.fails >= downloadRetries {
+			return fmt.Errorf("download interrupted at byte %d: %v", r.offset, cause)
+		}
+		r.fails++
+		log.Printf("Download interrupted at byte %d (%v), resuming (attempt %d)\n", r.offset, cause, r.fails)
+
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(time.Duration(r.fails) * time.Second):
+		}
+
+		err := r.open()
+		if err == nil {
+			return nil
+		}
+		cause = err
+	}
+}
+
+// Close implements io.Closer.
+func (r *rangeReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,228 @@
+package copernicus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/cache"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// cachedForm is a form and its ETag.
+type cachedForm struct {
+	fields []models.FormField
+	etag   string
+}
+
+// forms caches the form of each collection in memory, in front of the catalogue. Its TTL is
+// overridden by COP_FORM_CACHE_TTL.
+var forms = cache.New[string, cachedForm](256, time.Hour)
+
+// Widgets that don't take part in the request body.
+var formOnlyWidgets = []string{"LicenceWidget", "FreeEditionWidget", "LabelWidget"}
+
+// GetForm returns the form of a collection of a service and its ETag. The form is taken from
+// the cache or the catalogue, and only fetched from the service for datasets the catalogue
+// doesn't have yet. It is fetched directly because the client's form model leaves out the
+// children of exclusive groups.
+func GetForm(service string, collectionID string) ([]models.FormField, string, error) {
+	name, err := ServiceName(service)
+	if err != nil {
+		return nil, "", err
+	}
+	client := globals.CopernicusServices[name].C
+	cacheKey := client.BaseURL + collectionID
+	if form, ok := forms.Get(cacheKey); ok {
+		return form.fields, form.etag, nil
+	}
+
+	fields, etag, err := storedForm(name, collectionID)
+	if err != nil {
+		raw, upstreamETag, fetchErr := fetchForm(client, collectionID, "")
+		if fetchErr != nil {
+			return nil, "", fetchErr
+		}
+		if err = json.Unmarshal(raw, &fields); err != nil {
+			return nil, "", err
+		}
+		etag = ETag(raw)
+
+		// Datasets listed since the last refresh get their form right away
+		if entry, err := globals.CopernicusCatalogueDB.GetOneByID(name + ":" + collectionID); err == nil {
+			entry.Form, entry.FormETag, entry.UpstreamETag, entry.FormError = string(raw), etag, upstreamETag, ""
+			entry.Variables = formVariables(entry.Form)
+			globals.CopernicusCatalogueDB.ReplaceOne(entry)
+		}
+	}
+	forms.Set(cacheKey, cachedForm{fields: fields, etag: etag})
+	return fields, etag, nil
+}
+
+// Validate checks the body of a request against the form of its dataset: required fields,
+// allowed values, the number of selections and the bounds of areas, and that only one
+// widget of each exclusive group is used. It returns one error per invalid field.
+func Validate(service string, datasetName string, body map[string]interface{}) ([]models.FieldError, error) {
+	form, _, err := GetForm(service, datasetName)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldErrors []models.FieldError
+	addError := func(field string, format string, args ...interface{}) {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Children of exclusive groups are checked as part of their group
+	grouped := map[string]bool{}
+	for _, field := range form {
+		if field.Type == "ExclusiveGroupWidget" {
+			for _, child := range field.Children {
+				grouped[child] = true
+			}
+		}
+	}
+
+	for _, field := range form {
+		if field.Name == "" || utils.ItemInArray(formOnlyWidgets, field.Type) {
+			continue
+		}
+
+		if field.Type == "ExclusiveGroupWidget" {
+			var used []string
+			for _, child := range field.Children {
+				if _, ok := body[child]; ok {
+					used = append(used, child)
+				}
+			}
+			if len(used) > 1 {
+				for _, child := range used[1:] {
+					addError(child, "cannot be combined with %q", used[0])
+				}
+			}
+			// Without a selection the service falls back to the group's default
+			if len(used) == 0 && field.Required && field.Details.Default == nil {
+				addError(field.Name, "one of %s is required", strings.Join(field.Children, ", "))
+			}
+			continue
+		}
+
+		value, ok := body[field.Name]
+		if !ok {
+			if field.Required && !grouped[field.Name] {
+				addError(field.Name, "is required")
+			}
+			continue
+		}
+
+		values := formValues(value)
+		switch field.Type {
+		case "StringChoiceWidget":
+			if len(values) != 1 {
+				addError(field.Name, "takes a single value")
+				continue
+			}
+			if allowed := field.Details.Values; len(allowed) > 0 && !allowedValue(allowed, values[0]) {
+				addError(field.Name, "value %q is not one of %s", values[0], strings.Join(allowed, ", "))
+			}
+		case "StringListWidget", "StringListArrayWidget":
+			allowed := field.Details.Values
+			for _, group := range field.Details.Groups {
+				allowed = append(allowed, groupValues(group)...)
+			}
+			if len(values) == 0 && field.Required {
+				addError(field.Name, "is required")
+			}
+			if max := field.Details.MaximumSelections; max > 0 && len(values) > max {
+				addError(field.Name, "takes at most %d values, got %d", max, len(values))
+			}
+			var invalid []string
+			for _, v := range values {
+				if len(allowed) > 0 && !allowedValue(allowed, v) {
+					invalid = append(invalid, v)
+				}
+			}
+			if len(invalid) > 0 {
+				sort.Strings(invalid)
+				addError(field.Name, "values %s are not allowed", strings.Join(invalid, ", "))
+			}
+		case "GeographicExtentWidget":
+			validateArea(field, values, addError)
+		}
+	}
+	return fieldErrors, nil
+}
+
+// validateArea checks an area given as North, West, South, East.
+func validateArea(field models.FormField, values []string, addError func(string, string, ...interface{})) {
+	if len(values) != 4 {
+		addError(field.Name, "takes four values: North, West, South, East")
+		return
+	}
+	var bounds [4]float64
+	for i, v := range values {
+		bound, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			addError(field.Name, "value %q is not a number", v)
+			return
+		}
+		bounds[i] = bound
+	}
+	north, west, south, east := bounds[0], bounds[1], bounds[2], bounds[3]
+	if north < south {
+		addError(field.Name, "North (%g) is below South (%g)", north, south)
+	}
+	if r := field.Details.Range; r != nil {
+		if north > float64(r.N) || south < float64(r.S) || west < float64(r.W) || east > float64(r.E) {
+			addError(field.Name, "must be within North %g, West %g, South %g, East %g", r.N, r.W, r.S, r.E)
+		}
+	}
+}
+
+// formValues turns the value of a field into a list of strings.
+func formValues(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case []string:
+		return v
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// groupValues returns the values of a group of a StringListArrayWidget.
+func groupValues(group interface{}) []string {
+	g, ok := group.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return formValues(g["values"])
+}
+
+// allowedValue compares values as strings, or as numbers when both are numbers ("1" and "01").
+func allowedValue(allowed []string, value string) bool {
+	number, numErr := strconv.ParseFloat(value, 64)
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+		if numErr == nil {
+			if n, err := strconv.ParseFloat(a, 64); err == nil && n == number {
+				return true
+			}
+		}
+	}
+	return false
+}
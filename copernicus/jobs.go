@@ -0,0 +1,292 @@
+package copernicus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	CDSModels "github.com/SLG-European-Projects/cds-go/models"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// Errors of the job actions. Handlers map them to HTTP statuses.
+var (
+	ErrJobCompleted = errors.New("copernicus job already completed")
+	ErrJobNotFailed = errors.New("copernicus job has not failed or been dismissed")
+)
+
+// IsRequester reports whether a user requested the dataset of a record. Records without
+// requesters predate them and belong to the creator of their reference file.
+func IsRequester(record models.CopernicusRecord, subject string) bool {
+	for _, requester := range record.Requesters {
+		if requester == subject {
+			return true
+		}
+	}
+	for _, delivery := range record.Deliveries {
+		if delivery.Subject == subject {
+			return true
+		}
+	}
+	if len(record.Requesters) == 0 && len(record.Deliveries) == 0 {
+		file, err := globals.FileDB.GetOneByID(record.FileId)
+		return err == nil && file.Meta.Creator == subject
+	}
+	return false
+}
+
+// Refresh polls the job of a record and saves its status and log if they changed.
+// Split requests have no job; the worker rolls up the status of their chunks.
+func Refresh(record models.CopernicusRecord) (models.CopernicusRecord, error) {
+	if len(record.Children) > 0 {
+		return record, nil
+	}
+	client, err := clientFor(record)
+	if err != nil {
+		return record, err
+	}
+	job, err := client.GetOneJob(record.Details.JobID, jobParams)
+	if err != nil {
+		return record, err
+	}
+
+	jobLog := flattenLog(job)
+	if job.Status == record.Details.Status && len(jobLog) == len(record.Log) {
+		return record, nil
+	}
+
+	record.Details = job
+	record.Log = jobLog
+	if job.Status == "failed" && job.Message != nil {
+		record.Error = *job.Message
+	}
+	return globals.CopernicusDB.UpdateWithId(record)
+}
+
+// Dismiss cancels the job of a record, both on the Copernicus service and in the queue.
+// The job is shared by everyone who made the same request, so while others wait for it only
+// subject's request is withdrawn. A job that completed since it was last polled is left as is.
+func Dismiss(record models.CopernicusRecord, subject string) (models.CopernicusRecord, error) {
+	// A job the service can't be asked about is dismissed as last polled
+	if refreshed, err := Refresh(record); err == nil {
+		record = refreshed
+	}
+	file, err := globals.FileDB.GetOneByID(record.FileId)
+	if err == nil && file.Size > 0 {
+		return record, ErrJobCompleted
+	}
+	// The dataset of a successful job is on its way, unless it could not be stored
+	if record.Details.Status == "successful" {
+		if task, err := globals.CopernicusQueueDB.GetOneByID(record.Id); err != nil || task.State != models.TaskFailed {
+			return record, ErrJobCompleted
+		}
+	}
+	if hasOtherRequesters(record, subject) {
+		return withdraw(record, subject)
+	}
+
+	if len(record.Children) > 0 {
+		if err = dismissChunks(record, subject); err != nil {
+			return record, err
+		}
+	} else if record.Details.Status != "failed" && record.Details.Status != "dismissed" {
+		if err = dismissJob(record); err != nil {
+			return record, err
+		}
+	}
+
+	reason := "dismissed by " + subject
+	if err = globals.CopernicusQueueDB.Cancel(record.Id, reason); err != nil {
+		return record, err
+	}
+
+	record.Details.Status = "dismissed"
+	record.Error = reason
+	record, err = globals.CopernicusDB.UpdateWithId(record)
+	if err != nil {
+		return record, err
+	}
+	notifyEnd(record.Id, models.EventJobDismissed)
+	return record, nil
+}
+
+// hasOtherRequesters tells if anyone but subject requested the dataset of a record.
+func hasOtherRequesters(record models.CopernicusRecord, subject string) bool {
+	for _, requester := range record.Requesters {
+		if requester != subject {
+			return true
+		}
+	}
+	for _, delivery := range record.Deliveries {
+		if delivery.Subject != subject {
+			return true
+		}
+	}
+	return false
+}
+
+// withdraw removes subject's request for a dataset that others still wait for. Their pending
+// deliveries are dropped and the empty files they would have filled are deleted.
+func withdraw(record models.CopernicusRecord, subject string) (models.CopernicusRecord, error) {
+	if err := globals.CopernicusDB.RemoveRequester(record.Id, subject); err != nil {
+		return record, err
+	}
+	for _, delivery := range record.Deliveries {
+		if delivery.Subject != subject || delivery.Delivered {
+			continue
+		}
+		if err := removeDeliveryFile(delivery.FileId); err != nil {
+			return record, err
+		}
+	}
+	return globals.CopernicusDB.GetOneByID(record.Id)
+}
+
+// removeDeliveryFile deletes a delivered file that was never filled, and takes it out of its
+// folder. A file that is already gone is skipped.
+func removeDeliveryFile(fileID string) error {
+	file, err := globals.FileDB.GetOneByID(fileID)
+	if err != nil || file.Size > 0 {
+		return nil
+	}
+	if err = globals.FileDB.DeleteOneByID(file.Id); err != nil {
+		return err
+	}
+	folder, err := globals.FolderDB.GetOneByID(file.FolderID)
+	if err != nil {
+		return err
+	}
+	folder.Files = utils.RemoveFromSlice(folder.Files, file.Id)
+	_, err = globals.FolderDB.UpdateWithId(folder)
+	return err
+}
+
+// Retry submits the request of a failed or dismissed job again and queues the new job.
+// The record keeps its reference file, requesters and deliveries.
+func Retry(record models.CopernicusRecord, subject string) (models.CopernicusRecord, error) {
+	task, taskErr := globals.CopernicusQueueDB.GetOneByID(record.Id)
+	failed := record.Details.Status == "failed" || record.Details.Status == "dismissed" ||
+		(taskErr == nil && task.State == models.TaskFailed)
+	if !failed {
+		return record, ErrJobNotFailed
+	}
+
+	if len(record.Children) > 0 {
+		if err := retryChunks(record, subject); err != nil {
+			return record, err
+		}
+		record.Details.Status = "accepted"
+		record.Error = ""
+		if _, err := globals.CopernicusDB.UpdateWithId(record); err != nil {
+			return record, err
+		}
+		return record, Requeue(record, subject)
+	}
+
+	if record.Details.Status == "successful" {
+		// Only storing the result failed; the job itself needn't run again
+		record.Error = ""
+		if _, err := globals.CopernicusDB.UpdateWithId(record); err != nil {
+			return record, err
+		}
+		return record, Requeue(record, subject)
+	}
+
+	client, err := clientFor(record)
+	if err != nil {
+		return record, err
+	}
+	process, err := client.CreateProcess(record.DatasetName, record.RequestParams)
+	if err != nil {
+		return record, err
+	}
+
+	record.Details = process
+	record.Log = nil
+	record.Error = ""
+	record.DateCreation = time.Now()
+	if record, err = globals.CopernicusDB.UpdateWithId(record); err != nil {
+		return record, err
+	}
+	return record, Requeue(record, subject)
+}
+
+// dismissChunks dismisses the chunks of a split request that haven't completed.
+func dismissChunks(record models.CopernicusRecord, subject string) error {
+	var errs []error
+	for _, child := range record.Children {
+		chunkRecord, err := globals.CopernicusDB.GetOneByID(child.RecordID)
+		if err != nil {
+			continue
+		}
+		if _, err = Dismiss(chunkRecord, subject); err != nil && !errors.Is(err, ErrJobCompleted) {
+			errs = append(errs, fmt.Errorf("chunk %s: %v", child.Label, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retryChunks retries the chunks of a split request that failed or were dismissed.
+func retryChunks(record models.CopernicusRecord, subject string) error {
+	var errs []error
+	for _, child := range record.Children {
+		chunkRecord, err := globals.CopernicusDB.GetOneByID(child.RecordID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chunk %s: %v", child.Label, err))
+			continue
+		}
+		if _, err = Retry(chunkRecord, subject); err != nil && !errors.Is(err, ErrJobNotFailed) {
+			errs = append(errs, fmt.Errorf("chunk %s: %v", child.Label, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dismissJob deletes the job of a record on the Copernicus service, which dismisses it if it's still running.
+func dismissJob(record models.CopernicusRecord) error {
+	serviceClient, err := clientFor(record)
+	if err != nil {
+		return err
+	}
+	client := serviceClient.C
+	req, err := http.NewRequest(http.MethodDelete, client.BaseURL+"retrieve/v1/jobs/"+record.Details.JobID, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", client.ApiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A job the service no longer knows can't run anymore
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("could not dismiss copernicus job: %s", resp.Status)
+	}
+	return nil
+}
+
+// flattenLog writes each entry of a job log as a line.
+func flattenLog(job CDSModels.PostProcessExecution) []string {
+	if job.Metadata == nil {
+		return nil
+	}
+	var lines []string
+	for _, entry := range job.Metadata.Log {
+		var fields []string
+		for _, group := range entry {
+			for _, field := range group {
+				fields = append(fields, fmt.Sprint(field))
+			}
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return lines
+}
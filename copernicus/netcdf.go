@@ -0,0 +1,322 @@
+package copernicus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotClassicNetCDF is returned for files that aren't NetCDF classic (CDF-1 or CDF-2),
+// such as NetCDF-4 (HDF5) files, which can't be concatenated without the HDF5 library.
+var ErrNotClassicNetCDF = errors.New("not a NetCDF classic file")
+
+const (
+	ncDimension = 0x0A
+	ncVariable  = 0x0B
+	ncAttribute = 0x0C
+)
+
+// ncTypeSizes are the sizes of the classic NetCDF types.
+var ncTypeSizes = map[uint32]int64{1: 1, 2: 1, 3: 2, 4: 4, 5: 4, 6: 8}
+
+// ncHeader is the layout of a NetCDF classic file, without its attributes.
+type ncHeader struct {
+	raw         []byte // The header as read
+	version     byte
+	numrecs     int64
+	recordDim   int // Index of the record (unlimited) dimension, or -1
+	layout      []string
+	recordStart int64 // Offset of the first record; the header and fixed-size data come before it
+}
+
+// readNCHeader parses the header of a NetCDF classic file.
+func readNCHeader(r io.Reader) (ncHeader, error) {
+	var raw bytes.Buffer
+	in := io.TeeReader(r, &raw)
+	h := ncHeader{recordDim: -1}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(in, magic); err != nil {
+		return h, err
+	}
+	if string(magic[:3]) != "CDF" || (magic[3] != 1 && magic[3] != 2) {
+		return h, ErrNotClassicNetCDF
+	}
+	h.version = magic[3]
+
+	read32 := func() (uint32, error) {
+		var v uint32
+		err := binary.Read(in, binary.BigEndian, &v)
+		return v, err
+	}
+	readName := func() (string, error) {
+		n, err := read32()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, pad4(int64(n)))
+		if _, err = io.ReadFull(in, name); err != nil {
+			return "", err
+		}
+		return string(name[:n]), nil
+	}
+	readAttributes := func() error {
+		tag, err := read32()
+		if err != nil {
+			return err
+		}
+		count, err := read32()
+		if err != nil || tag == 0 {
+			return err
+		}
+		if tag != ncAttribute {
+			return fmt.Errorf("unexpected tag %#x in attribute list", tag)
+		}
+		for i := uint32(0); i < count; i++ {
+			if _, err = readName(); err != nil {
+				return err
+			}
+			ncType, err := read32()
+			if err != nil {
+				return err
+			}
+			n, err := read32()
+			if err != nil {
+				return err
+			}
+			size, ok := ncTypeSizes[ncType]
+			if !ok {
+				return fmt.Errorf("unknown type %d", ncType)
+			}
+			if _, err = io.CopyN(io.Discard, in, pad4(int64(n)*size)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	numrecs, err := read32()
+	if err != nil {
+		return h, err
+	}
+	h.numrecs = int64(numrecs)
+	if numrecs == 0xFFFFFFFF {
+		// Streaming: the count was never written
+		h.numrecs = -1
+	}
+
+	// Dimensions
+	tag, err := read32()
+	if err != nil {
+		return h, err
+	}
+	dimCount, err := read32()
+	if err != nil {
+		return h, err
+	}
+	if tag != 0 && tag != ncDimension {
+		return h, fmt.Errorf("unexpected tag %#x in dimension list", tag)
+	}
+	dimLengths := make([]uint32, dimCount)
+	for i := range dimLengths {
+		name, err := readName()
+		if err != nil {
+			return h, err
+		}
+		if dimLengths[i], err = read32(); err != nil {
+			return h, err
+		}
+		if dimLengths[i] == 0 {
+			h.recordDim = i
+		}
+		h.layout = append(h.layout, fmt.Sprintf("dim %s %d", name, dimLengths[i]))
+	}
+
+	// Global attributes
+	if err = readAttributes(); err != nil {
+		return h, err
+	}
+
+	// Variables
+	if tag, err = read32(); err != nil {
+		return h, err
+	}
+	varCount, err := read32()
+	if err != nil {
+		return h, err
+	}
+	if tag != 0 && tag != ncVariable {
+		return h, fmt.Errorf("unexpected tag %#x in variable list", tag)
+	}
+	h.recordStart = -1
+	var fixedEnd int64
+	for i := uint32(0); i < varCount; i++ {
+		name, err := readName()
+		if err != nil {
+			return h, err
+		}
+		rank, err := read32()
+		if err != nil {
+			return h, err
+		}
+		dimIDs := make([]uint32, rank)
+		for j := range dimIDs {
+			if dimIDs[j], err = read32(); err != nil {
+				return h, err
+			}
+		}
+		if err = readAttributes(); err != nil {
+			return h, err
+		}
+		ncType, err := read32()
+		if err != nil {
+			return h, err
+		}
+		vsize, err := read32()
+		if err != nil {
+			return h, err
+		}
+		var begin int64
+		if h.version == 1 {
+			b, err := read32()
+			if err != nil {
+				return h, err
+			}
+			begin = int64(b)
+		} else {
+			if err = binary.Read(in, binary.BigEndian, &begin); err != nil {
+				return h, err
+			}
+		}
+
+		isRecord := rank > 0 && h.recordDim >= 0 && int(dimIDs[0]) == h.recordDim
+		if isRecord {
+			if h.recordStart < 0 || begin < h.recordStart {
+				h.recordStart = begin
+			}
+		} else if end := begin + int64(vsize); end > fixedEnd {
+			fixedEnd = end
+		}
+		h.layout = append(h.layout, fmt.Sprintf("var %s %v %d %d %t", name, dimIDs, ncType, vsize, isRecord))
+	}
+
+	h.raw = raw.Bytes()
+	if h.recordDim < 0 || h.recordStart < 0 {
+		return h, errors.New("NetCDF file has no record dimension to concatenate along")
+	}
+	if fixedEnd > h.recordStart {
+		return h, errors.New("NetCDF file has fixed-size data after its records")
+	}
+	return h, nil
+}
+
+// sameLayout reports whether two files have the same dimensions (except for the number of
+// records) and variables, so that their records can be concatenated.
+func (h ncHeader) sameLayout(other ncHeader) bool {
+	if h.version != other.version || len(h.layout) != len(other.layout) {
+		return false
+	}
+	for i := range h.layout {
+		if h.layout[i] != other.layout[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ncSource is a NetCDF classic file to concatenate.
+type ncSource struct {
+	open func() (io.ReadCloser, error)
+	size int64
+}
+
+// concatNetCDF writes the concatenation of NetCDF classic files along their record dimension.
+// The header, attributes and fixed-size variables (e.g. coordinates) of the first file are kept,
+// and the records of each file follow in order.
+func concatNetCDF(w io.Writer, sources []ncSource) error {
+	if len(sources) == 0 {
+		return errors.New("nothing to concatenate")
+	}
+
+	type scanned struct {
+		header  ncHeader
+		recsize int64
+	}
+	files := make([]scanned, len(sources))
+	var total int64
+	for i, source := range sources {
+		reader, err := source.open()
+		if err != nil {
+			return err
+		}
+		header, err := readNCHeader(bufio.NewReader(reader))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("file %d: %w", i, err)
+		}
+		if i > 0 && !files[0].header.sameLayout(header) {
+			return fmt.Errorf("file %d: variables or dimensions differ from the first file", i)
+		}
+
+		if header.numrecs < 0 {
+			return fmt.Errorf("file %d: record count not set (streamed file)", i)
+		}
+		recordBytes := source.size - header.recordStart
+		var recsize int64
+		if header.numrecs > 0 {
+			recsize = recordBytes / header.numrecs
+			if recsize*header.numrecs != recordBytes {
+				return fmt.Errorf("file %d: size doesn't match its %d records", i, header.numrecs)
+			}
+		}
+		if i > 0 && recsize > 0 && files[0].recsize > 0 && recsize != files[0].recsize {
+			return fmt.Errorf("file %d: records differ in size from the first file", i)
+		}
+		files[i] = scanned{header: header, recsize: recsize}
+		total += header.numrecs
+	}
+	if total > 0xFFFFFFFE {
+		return errors.New("too many records for a NetCDF classic file")
+	}
+
+	first := files[0].header
+	header := append([]byte{}, first.raw...)
+	binary.BigEndian.PutUint32(header[4:8], uint32(total))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for i, source := range sources {
+		reader, err := source.open()
+		if err != nil {
+			return err
+		}
+		err = func() error {
+			defer reader.Close()
+			if i == 0 {
+				// Fixed-size data of the first file, then its records
+				if _, err := io.CopyN(io.Discard, reader, int64(len(first.raw))); err != nil {
+					return err
+				}
+				_, err := io.CopyN(w, reader, source.size-int64(len(first.raw)))
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, reader, files[i].header.recordStart); err != nil {
+				return err
+			}
+			_, err := io.CopyN(w, reader, files[i].recsize*files[i].header.numrecs)
+			return err
+		}()
+		if err != nil {
+			return fmt.Errorf("file %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func pad4(n int64) int64 {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,53 @@
+package copernicus
+
+import (
+	"log"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/notify"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// notifyEnd notifies the subscribers of a record that its job ended. A failed record that was
+// dismissed is reported as dismissed.
+func notifyEnd(recordID string, event string) {
+	record, err := globals.CopernicusDB.GetOneByID(recordID)
+	if err != nil {
+		log.Println("Could not notify end of copernicus record "+recordID+": ", err.Error())
+		return
+	}
+	if event == models.EventJobFailed && record.Details.Status == "dismissed" {
+		event = models.EventJobDismissed
+	}
+	service, _ := ServiceName(record.Service)
+
+	for _, subject := range record.Subscribers {
+		id, err := utils.GenerateUUID()
+		if err != nil {
+			log.Println("Could not create notification: ", err.Error())
+			return
+		}
+		notification := models.Notification{
+			Id:          id,
+			Event:       event,
+			Subject:     subject,
+			RecordID:    record.Id,
+			JobID:       record.Details.JobID,
+			Service:     service,
+			DatasetName: record.DatasetName,
+			FileIds:     []string{record.FileId},
+			Date:        time.Now(),
+		}
+		if event != models.EventJobSuccessful {
+			notification.Error = record.Error
+		}
+		for _, delivery := range record.Deliveries {
+			if delivery.Subject == subject {
+				notification.FileIds = append(notification.FileIds, delivery.FileId)
+			}
+		}
+		notify.Send(notification)
+	}
+}
@@ -0,0 +1,126 @@
+package copernicus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"github.com/minio/minio-go/v7"
+)
+
+// Pipeline settings, overridden by COP_UPLOAD_CONCURRENCY, COP_PART_RETRIES and COP_DOWNLOAD_RETRIES.
+var (
+	uploadConcurrency = 4
+	partRetries       = 3
+	downloadRetries   = 5
+)
+
+// storeParts reads data chunk by chunk and uploads each chunk as a part of the file.
+// At most uploadConcurrency chunks are held in memory at a time: reading waits for
+// a free buffer, which is handed back once its part is uploaded and recorded.
+// It returns the number of parts and the total size.
+func storeParts(ctx context.Context, data io.Reader, fileID string, bucket string) (int, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	buffers := make(chan []byte, uploadConcurrency)
+	for i := 0; i < uploadConcurrency; i++ {
+		buffers <- make([]byte, globals.PartSize)
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	parts := 0
+	var size int64
+	for {
+		var buf []byte
+		select {
+		case <-ctx.Done():
+		case buf = <-buffers:
+		}
+		if buf == nil {
+			break
+		}
+
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			wg.Add(1)
+			go func(chunk []byte, partNumber int) {
+				defer wg.Done()
+				defer func() { buffers <- buf }()
+				if err := uploadPart(ctx, chunk, partNumber, fileID, bucket); err != nil {
+					fail(fmt.Errorf("part %d: %v", partNumber, err))
+				}
+			}(buf[:n], parts)
+			parts++
+			size += int64(n)
+		} else {
+			buffers <- buf
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			fail(fmt.Errorf("error reading response body: %v", err))
+			break
+		}
+	}
+
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return parts, size, firstErr
+}
+
+// uploadPart uploads a chunk, retrying with a backoff, and records it as a models.Part.
+func uploadPart(ctx context.Context, chunk []byte, partNumber int, fileID string, bucket string) error {
+	partId, err := utils.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	var uploadInfo minio.UploadInfo
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// The part ID is kept across attempts, so a retry overwrites a partial object
+		uploadInfo, err = globals.Storage.PostPart(bucket, partId, bytes.NewReader(chunk), int64(len(chunk)), minio.PutObjectOptions{})
+		if err == nil {
+			break
+		}
+		if attempt+1 >= partRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(1<<attempt) * time.Second):
+		}
+	}
+
+	return globals.PartsDB.InsertOne(models.Part{
+		Id:         partId,
+		FileID:     fileID,
+		PartNumber: partNumber,
+		Size:       int64(len(chunk)),
+		UploadInfo: uploadInfo,
+	})
+}
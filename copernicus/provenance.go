@@ -0,0 +1,104 @@
+package copernicus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+)
+
+// ErrNoProvenance is returned for files that don't come from Copernicus.
+var ErrNoProvenance = errors.New("file has no provenance")
+
+// serviceNames are the names datasets of each service are cited with.
+var serviceNames = map[string]string{
+	globals.ServiceCDS:  "Copernicus Climate Change Service (C3S) Climate Data Store (CDS)",
+	globals.ServiceADS:  "Copernicus Atmosphere Monitoring Service (CAMS) Atmosphere Data Store (ADS)",
+	globals.ServiceEWDS: "Copernicus Emergency Management Service (CEMS) Early Warning Data Store (EWDS)",
+}
+
+// recordProvenance describes where the dataset of a record comes from, downloaded at downloaded.
+// The title, DOI and licences of the dataset are taken from the catalogue if it can be reached.
+func recordProvenance(record models.CopernicusRecord, downloaded time.Time) *models.CopernicusProvenance {
+	service, _ := ServiceName(record.Service)
+	provenance := &models.CopernicusProvenance{
+		Service:        service,
+		DatasetName:    record.DatasetName,
+		Parameters:     record.RequestParams,
+		JobID:          record.Details.JobID,
+		RecordID:       record.Id,
+		DateSubmitted:  record.DateCreation,
+		DateDownloaded: downloaded,
+	}
+	for _, child := range record.Children {
+		if chunkRecord, err := globals.CopernicusDB.GetOneByID(child.RecordID); err == nil {
+			provenance.ChunkJobIDs = append(provenance.ChunkJobIDs, chunkRecord.Details.JobID)
+		}
+	}
+
+	client, err := Client(service)
+	if err != nil {
+		return provenance
+	}
+	provenance.ServiceURL = client.C.BaseURL
+	collection, err := client.GetOneCollection(record.DatasetName)
+	if err != nil {
+		log.Println("Could not get catalogue entry of "+record.DatasetName+": ", err.Error())
+		return provenance
+	}
+	provenance.DatasetTitle = collection.Title
+	provenance.DOI = collection.SCIDOI
+	for _, link := range collection.Links {
+		if link.Rel == nil || *link.Rel != "license" {
+			continue
+		}
+		licence := models.CopernicusLicence{Id: collection.License, URL: link.Href}
+		if link.Title != nil {
+			licence.Title = *link.Title
+		}
+		provenance.Licences = append(provenance.Licences, licence)
+	}
+	if len(provenance.Licences) == 0 && collection.License != "" {
+		provenance.Licences = []models.CopernicusLicence{{Id: collection.License}}
+	}
+	return provenance
+}
+
+// Provenance returns the provenance of a file. Datasets stored before provenance was recorded
+// get it from their Copernicus record, if it still exists.
+func Provenance(file models.File) (models.CopernicusProvenance, error) {
+	if file.Provenance != nil {
+		return *file.Provenance, nil
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil || file.Size == 0 {
+		return models.CopernicusProvenance{}, ErrNoProvenance
+	}
+	return *recordProvenance(record, file.Meta.Update.Date), nil
+}
+
+// Citation returns a citation of the source of a dataset, following the attribution the
+// Copernicus licences ask for.
+func Citation(provenance models.CopernicusProvenance) string {
+	title := provenance.DatasetTitle
+	if title == "" {
+		title = provenance.DatasetName
+	}
+	service := serviceNames[provenance.Service]
+	if service == "" {
+		service = "Copernicus " + strings.ToUpper(provenance.Service)
+	}
+
+	citation := fmt.Sprintf("%s. %s.", title, service)
+	if provenance.DOI != "" {
+		citation += " DOI: " + provenance.DOI + "."
+	}
+	if !provenance.DateDownloaded.IsZero() {
+		citation += " (Accessed on " + provenance.DateDownloaded.UTC().Format("02-Jan-2006") + ")"
+	}
+	return citation
+}
@@ -0,0 +1,174 @@
+package copernicus
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/cache"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Queue settings, overridden by COP_WORKERS, COP_LEASE and COP_MAX_ATTEMPTS.
+var (
+	workers     = 4
+	lease       = time.Minute
+	maxAttempts = 5
+)
+
+// Enqueue adds the Copernicus job of a record to the task queue, unless the record has a task
+// already, which is left as it is.
+func Enqueue(record models.CopernicusRecord, subject string) error {
+	return globals.CopernicusQueueDB.Enqueue(newTask(record, subject))
+}
+
+// Requeue puts the Copernicus job of a record in the task queue in place of any task the record
+// had, for a job that is submitted or retried again.
+func Requeue(record models.CopernicusRecord, subject string) error {
+	return globals.CopernicusQueueDB.Requeue(newTask(record, subject))
+}
+
+// newTask returns the task that polls the job of a record from now on.
+func newTask(record models.CopernicusRecord, subject string) models.CopernicusTask {
+	now := time.Now()
+	return models.CopernicusTask{
+		Id:           record.Id,
+		FileId:       record.FileId,
+		JobID:        record.Details.JobID,
+		Subject:      subject,
+		State:        models.TaskQueued,
+		NextPoll:     now,
+		DateCreation: now,
+		DateUpdate:   now,
+	}
+}
+
+// EnsureQueued enqueues the job of a record whose result isn't stored yet, unless it's already queued.
+func EnsureQueued(record models.CopernicusRecord, subject string) error {
+	if _, err := globals.CopernicusQueueDB.GetOneByID(record.Id); err == nil {
+		return nil
+	}
+	switch record.Details.Status {
+	case "failed", "dismissed", statusSubmitting:
+		return nil
+	}
+	file, err := globals.FileDB.GetOneByID(record.FileId)
+	if err != nil || file.Size > 0 {
+		return err
+	}
+	if subject == "" {
+		subject = file.Meta.Creator
+	}
+	return Enqueue(record, subject)
+}
+
+// Start reads the queue settings and starts the worker pool and the scheduler. Jobs submitted
+// before the queue existed are enqueued, and tasks and schedules left behind by stopped replicas
+// are picked up again once their lease expires. The returned channel is closed once they all
+// stopped after ctx is done.
+func Start(ctx context.Context) <-chan struct{} {
+	if value, err := strconv.Atoi(os.Getenv("COP_WORKERS")); err == nil && value > 0 {
+		workers = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("COP_LEASE")); err == nil && value > 0 {
+		lease = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("COP_MAX_ATTEMPTS")); err == nil && value > 0 {
+		maxAttempts = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("COP_UPLOAD_CONCURRENCY")); err == nil && value > 0 {
+		uploadConcurrency = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("COP_PART_RETRIES")); err == nil && value > 0 {
+		partRetries = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("COP_DOWNLOAD_RETRIES")); err == nil && value >= 0 {
+		downloadRetries = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("COP_CACHE_TTL")); err == nil && value >= 0 {
+		cacheTTL = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("COP_FORM_CACHE_TTL")); err == nil && value > 0 {
+		forms = cache.New[string, cachedForm](256, value)
+	}
+	if value, err := time.ParseDuration(os.Getenv("COP_CATALOGUE_REFRESH")); err == nil && value > 0 {
+		catalogueInterval = value
+	}
+
+	if value, err := time.ParseDuration(os.Getenv("COP_SCHEDULE_INTERVAL")); err == nil && value > 0 {
+		scheduleInterval = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("COP_SCHEDULE_MAX_FAILURES")); err == nil && value > 0 {
+		maxScheduleFailures = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("COP_SCHEDULE_ACCESS_MAX_AGE")); err == nil && value > 0 {
+		maxAccessAge = value
+	}
+
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	run(enqueueOrphans)
+
+	owner := workerID()
+	log.Printf("Starting %d Copernicus workers as %s\n", workers, owner)
+	for i := 0; i < workers; i++ {
+		run(func() { work(ctx, owner) })
+	}
+	run(func() { schedule(ctx, owner) })
+	run(func() { refreshCatalogues(ctx) })
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	return stopped
+}
+
+// enqueueOrphans enqueues the records whose result isn't stored and that have no task.
+func enqueueOrphans() {
+	cursor, err := globals.CopernicusDB.GetCursorAll()
+	if err != nil {
+		log.Println("Could not list copernicus records: ", err.Error())
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		var record models.CopernicusRecord
+		if err := cursor.Decode(&result); err != nil {
+			log.Println("Could not resolve cursor: ", err.Error())
+			return
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &record)
+
+		if err = EnsureQueued(record, ""); err != nil {
+			log.Println("Could not enqueue copernicus record "+record.Id+": ", err.Error())
+		}
+	}
+}
+
+// workerID identifies this replica as lease owner.
+func workerID() string {
+	host, _ := os.Hostname()
+	id, err := utils.GenerateUUID()
+	if err != nil {
+		id = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return host + "-" + id
+}
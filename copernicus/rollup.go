@@ -0,0 +1,222 @@
+package copernicus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	db "github.com/isotiropoulos/storage-api/dbs/meta"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/minio/minio-go/v7"
+)
+
+// processParent rolls up the chunks of a split request once. The parent's file is stored when
+// all chunks are, and the parent fails as soon as one chunk does.
+func processParent(ctx context.Context, owner string, task models.CopernicusTask, record models.CopernicusRecord) {
+	status, reason, err := chunksStatus(record)
+	if err != nil {
+		retry(owner, task, err)
+		return
+	}
+
+	if status != record.Details.Status || reason != record.Error {
+		record.Details.Status = status
+		record.Error = reason
+		if _, err = globals.CopernicusDB.UpdateWithId(record); err != nil {
+			retry(owner, task, err)
+			return
+		}
+	}
+
+	switch status {
+	case "failed":
+		finish(owner, task, models.TaskFailed, reason)
+	case "successful":
+		err = withLease(ctx, owner, task.Id, func(ctx context.Context) error {
+			if err := storeParent(ctx, record, task.Subject); err != nil {
+				return err
+			}
+			record, err := globals.CopernicusDB.GetOneByID(task.Id)
+			if err != nil {
+				return err
+			}
+			return DeliverPending(record)
+		})
+		if errors.Is(err, db.ErrLeaseLost) {
+			log.Println("Lost lease of copernicus task " + task.Id + ", leaving it to its new owner")
+			return
+		}
+		if err != nil {
+			retry(owner, task, err)
+			return
+		}
+		finish(owner, task, models.TaskDone, "")
+	default:
+		task.Attempts = 0
+		task.LastError = ""
+		task.NextPoll = time.Now().Add(globals.CheckTime)
+		release(owner, task)
+	}
+}
+
+// chunksStatus tells whether all chunks of a split request are stored ("successful"), one of
+// them failed ("failed", with the reason) or they are still running.
+func chunksStatus(record models.CopernicusRecord) (string, string, error) {
+	stored := 0
+	for _, child := range record.Children {
+		chunkRecord, err := globals.CopernicusDB.GetOneByID(child.RecordID)
+		if err != nil {
+			return "failed", "chunk " + child.Label + ": copernicus record not found", nil
+		}
+		switch chunkRecord.Details.Status {
+		case "failed", "dismissed":
+			return "failed", "chunk " + child.Label + ": copernicus job " + chunkRecord.Details.Status + " " + chunkRecord.Error, nil
+		}
+		if task, err := globals.CopernicusQueueDB.GetOneByID(chunkRecord.Id); err == nil && task.State == models.TaskFailed {
+			return "failed", "chunk " + child.Label + ": " + task.LastError, nil
+		}
+		file, err := globals.FileDB.GetOneByID(chunkRecord.FileId)
+		if err != nil {
+			return "", "", err
+		}
+		if file.Size > 0 {
+			stored++
+		}
+	}
+	if stored == len(record.Children) {
+		return "successful", "", nil
+	}
+	return statusRunning, "", nil
+}
+
+// storeParent stores the file of a split request: the concatenation of its NetCDF chunks if
+// they are to be merged, or else a JSON manifest of the chunks. Chunks that can't be merged
+// (e.g. NetCDF-4 files) are kept as separate files and listed in the manifest instead.
+func storeParent(ctx context.Context, record models.CopernicusRecord, subject string) error {
+	file, err := globals.FileDB.GetOneByID(record.FileId)
+	if err != nil {
+		return fmt.Errorf("can't retrieve file document: %v", err)
+	}
+	if file.Size > 0 {
+		return nil
+	}
+	bucket := file.Ancestors[0]
+	if err = discardParts(file.Id, bucket); err != nil {
+		return err
+	}
+
+	var parts int
+	var size int64
+	if record.Split != nil && record.Split.Merge {
+		parts, size, err = storeMerged(ctx, record, file.Id, bucket)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Println("Could not merge chunks of copernicus record "+record.Id+": ", err.Error())
+			if discardErr := discardParts(file.Id, bucket); discardErr != nil {
+				return discardErr
+			}
+			record.Error = "chunks could not be merged and are kept as separate files: " + err.Error()
+			if _, err = globals.CopernicusDB.UpdateWithId(record); err != nil {
+				return err
+			}
+			file.FileType = "json"
+			parts, size, err = storeManifest(ctx, record, file.Id, bucket)
+		}
+	} else {
+		parts, size, err = storeManifest(ctx, record, file.Id, bucket)
+	}
+	if err != nil {
+		return err
+	}
+
+	file.Total = parts
+	file.Size = size
+	file.Meta.Update = models.Updated{Date: time.Now(), User: subject}
+	if _, err = globals.FileDB.UpdateWithId(file); err != nil {
+		return err
+	}
+	return globals.FileDB.SetProvenance(file.Id, recordProvenance(record, file.Meta.Update.Date))
+}
+
+// storeMerged concatenates the chunk files along time into the parts of a file.
+func storeMerged(ctx context.Context, record models.CopernicusRecord, fileID string, bucket string) (int, int64, error) {
+	sources := make([]ncSource, 0, len(record.Children))
+	for _, child := range record.Children {
+		chunkRecord, err := globals.CopernicusDB.GetOneByID(child.RecordID)
+		if err != nil {
+			return 0, 0, err
+		}
+		chunkFile, err := globals.FileDB.GetOneByID(chunkRecord.FileId)
+		if err != nil {
+			return 0, 0, err
+		}
+		sources = append(sources, ncSource{
+			open: func() (io.ReadCloser, error) { return openFile(ctx, chunkFile) },
+			size: chunkFile.Size,
+		})
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(concatNetCDF(writer, sources))
+	}()
+	defer reader.Close()
+	return storeParts(ctx, reader, fileID, bucket)
+}
+
+// chunkManifest is the content of the file of a split request whose chunks aren't merged.
+type chunkManifest struct {
+	DatasetName string                   `json:"dataset_name"`
+	Split       *models.SplitOptions     `json:"split"`
+	Chunks      []models.CopernicusChunk `json:"chunks"`
+}
+
+// storeManifest writes the manifest of the chunks into the parts of a file.
+func storeManifest(ctx context.Context, record models.CopernicusRecord, fileID string, bucket string) (int, int64, error) {
+	manifest, err := json.MarshalIndent(chunkManifest{DatasetName: record.DatasetName, Split: record.Split, Chunks: record.Children}, "", "  ")
+	if err != nil {
+		return 0, 0, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := writer.Write(manifest)
+		writer.CloseWithError(err)
+	}()
+	defer reader.Close()
+	return storeParts(ctx, reader, fileID, bucket)
+}
+
+// openFile reads the parts of a stored file in order.
+func openFile(ctx context.Context, file models.File) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		for partNumber := 0; partNumber < file.Total; partNumber++ {
+			if ctx.Err() != nil {
+				writer.CloseWithError(ctx.Err())
+				return
+			}
+			part, err := globals.PartsDB.GetOneByFileAndPart(file.Id, partNumber)
+			if err != nil {
+				writer.CloseWithError(fmt.Errorf("part %d: %v", partNumber, err))
+				return
+			}
+			object, _, _, err := globals.Storage.GetFile(part.Id, file.Ancestors[0], minio.GetObjectOptions{})
+			if err != nil {
+				writer.CloseWithError(fmt.Errorf("part %d: %v", partNumber, err))
+				return
+			}
+			_, err = io.Copy(writer, object)
+			object.Close()
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.Close()
+	}()
+	return reader, nil
+}
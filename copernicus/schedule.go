@@ -0,0 +1,297 @@
+package copernicus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	db "github.com/isotiropoulos/storage-api/dbs/meta"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/middleware"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Scheduler settings, overridden by COP_SCHEDULE_INTERVAL, COP_SCHEDULE_MAX_FAILURES and
+// COP_SCHEDULE_ACCESS_MAX_AGE.
+var (
+	scheduleInterval    = 30 * time.Second
+	maxScheduleFailures = 3
+	maxAccessAge        = 30 * 24 * time.Hour
+)
+
+// scheduleLease is how long a replica holds a schedule while running it.
+const scheduleLease = 10 * time.Minute
+
+// ErrInvalidSchedule is returned for schedules whose cron expression or template is invalid.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// NewSchedule checks a schedule request and stores the schedule. The template is rendered for
+// the first run and validated against the dataset's form, so that mistakes show up right away.
+// It returns the field errors of the template, if any. Each run checks the access of the
+// subject on the folder again.
+func NewSchedule(body models.PostScheduleBody, subject string, access models.ScheduleAccess) (models.CopernicusSchedule, []models.FieldError, error) {
+	service, err := ServiceName(body.Service)
+	if err != nil {
+		return models.CopernicusSchedule{}, []models.FieldError{{Field: "service", Message: err.Error()}}, ErrInvalidSchedule
+	}
+	// Runs are delivered as files named after the schedule
+	if err = utils.CheckTitle(body.Name); err != nil {
+		return models.CopernicusSchedule{}, []models.FieldError{{Field: "name", Message: err.Error()}}, ErrInvalidSchedule
+	}
+	cron, err := parseCron(body.Cron)
+	if err != nil {
+		return models.CopernicusSchedule{}, []models.FieldError{{Field: "cron", Message: err.Error()}}, ErrInvalidSchedule
+	}
+	nextRun := cron.Next(time.Now())
+	if nextRun.IsZero() {
+		return models.CopernicusSchedule{}, []models.FieldError{{Field: "cron", Message: "never matches"}}, ErrInvalidSchedule
+	}
+
+	params, err := renderTemplate(body.Body, nextRun)
+	if err != nil {
+		return models.CopernicusSchedule{}, []models.FieldError{{Field: "body", Message: err.Error()}}, ErrInvalidSchedule
+	}
+	fieldErrors, err := Validate(service, body.DatasetName, params)
+	if err != nil {
+		log.Println("Could not validate copernicus schedule: ", err.Error())
+	}
+	if len(fieldErrors) > 0 {
+		return models.CopernicusSchedule{}, fieldErrors, ErrInvalidSchedule
+	}
+
+	scheduleID, err := utils.GenerateUUID()
+	if err != nil {
+		return models.CopernicusSchedule{}, nil, err
+	}
+	name := body.Name
+	if name == "" {
+		name = body.DatasetName
+	}
+	schedule := models.CopernicusSchedule{
+		Id:           scheduleID,
+		Name:         name,
+		DatasetName:  body.DatasetName,
+		Service:      service,
+		Template:     body.Body,
+		Cron:         body.Cron,
+		Folder:       body.Folder,
+		Subject:      subject,
+		Access:       access,
+		NextRun:      nextRun,
+		DateCreation: time.Now(),
+	}
+	return schedule, nil, globals.CopernicusScheduleDB.InsertOne(schedule)
+}
+
+// Resume resumes a paused schedule from its next matching time, with the current access of
+// its subject.
+func Resume(schedule models.CopernicusSchedule, access models.ScheduleAccess) (models.CopernicusSchedule, error) {
+	cron, err := parseCron(schedule.Cron)
+	if err != nil {
+		return schedule, err
+	}
+	schedule.Access = access
+	if err = globals.CopernicusScheduleDB.UpdateAccess(schedule.Subject, access); err != nil {
+		return schedule, err
+	}
+	schedule.Paused = false
+	schedule.PauseReason = ""
+	schedule.Failures = 0
+	schedule.NextRun = cron.Next(time.Now())
+	return schedule, globals.CopernicusScheduleDB.SetPaused(schedule.Id, false, "", schedule.NextRun)
+}
+
+// schedule runs due schedules until ctx is done.
+func schedule(ctx context.Context, owner string) {
+	for {
+		s, err := globals.CopernicusScheduleDB.Claim(owner, scheduleLease)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Println("Could not claim copernicus schedule: ", err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(scheduleInterval):
+			}
+			continue
+		}
+
+		pauseReason := runSchedule(&s)
+		if err = globals.CopernicusScheduleDB.Finish(s, owner, pauseReason); err != nil && !errors.Is(err, db.ErrLeaseLost) {
+			log.Println("Could not finish copernicus schedule "+s.Id+": ", err.Error())
+		}
+	}
+}
+
+// runSchedule runs a due schedule once and moves it to its next time. Missed runs are not
+// made up for. It returns a reason to pause the schedule, if it keeps failing.
+func runSchedule(s *models.CopernicusSchedule) string {
+	now := time.Now()
+	scheduled := s.NextRun
+
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return "invalid cron expression: " + err.Error()
+	}
+	s.NextRun = cron.Next(now)
+	if s.NextRun.IsZero() {
+		return "cron expression no longer matches"
+	}
+
+	if reason := checkAccess(*s, now); reason != "" {
+		return reason
+	}
+
+	// Failures of the jobs of earlier runs count too
+	s.Failures = checkRuns(s.Id, s.Failures)
+	if s.Failures >= maxScheduleFailures {
+		return fmt.Sprintf("%d consecutive runs failed", s.Failures)
+	}
+
+	s.LastRun = now
+	run := models.CopernicusScheduleRun{
+		ScheduleID: s.Id,
+		Scheduled:  scheduled,
+		Started:    now,
+		State:      models.RunSubmitted,
+		DateUpdate: now,
+	}
+	run.Id, err = utils.GenerateUUID()
+	if err != nil {
+		log.Println("Could not create copernicus schedule run: ", err.Error())
+		return ""
+	}
+
+	run.FileId, run.Parameters, err = submitRun(*s, scheduled)
+	if err != nil {
+		run.State = models.RunFailed
+		run.Error = err.Error()
+		s.Failures++
+	}
+	if err = globals.CopernicusScheduleDB.InsertRun(run); err != nil {
+		log.Println("Could not save copernicus schedule run: ", err.Error())
+	}
+
+	if s.Failures >= maxScheduleFailures {
+		return fmt.Sprintf("%d consecutive runs failed, last: %s", s.Failures, run.Error)
+	}
+	return ""
+}
+
+// checkAccess returns a reason to pause a schedule whose subject can no longer write to its
+// folder. The access of users is as of their last request, so it is not trusted once it gets
+// older than maxAccessAge.
+func checkAccess(s models.CopernicusSchedule, now time.Time) string {
+	if s.Access.APIKeyID == "" && s.Access.DateUpdate.IsZero() {
+		return "access of the owner not known"
+	}
+	if s.Access.APIKeyID == "" && now.Sub(s.Access.DateUpdate) > maxAccessAge {
+		return "access of the owner not confirmed since " + s.Access.DateUpdate.UTC().Format("2006-01-02")
+	}
+	principal, err := middleware.AccessPrincipal(s.Subject, s.Access, s.Folder)
+	if err != nil {
+		return "owner has no access to the destination folder: " + err.Error()
+	}
+	if !utils.HasCapability(principal, models.CapWrite) {
+		return "owner can no longer write to the destination folder"
+	}
+	return ""
+}
+
+// submitRun submits the request of a run and delivers it as a dated file.
+func submitRun(s models.CopernicusSchedule, scheduled time.Time) (string, map[string]interface{}, error) {
+	params, err := renderTemplate(s.Template, scheduled)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fieldErrors, err := Validate(s.Service, s.DatasetName, params)
+	if err != nil {
+		log.Println("Could not validate copernicus schedule run: ", err.Error())
+	}
+	if len(fieldErrors) > 0 {
+		return "", params, fmt.Errorf("invalid request: %s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+
+	folder, err := globals.FolderDB.GetOneByID(s.Folder)
+	if err != nil {
+		return "", params, fmt.Errorf("destination folder not found: %v", err)
+	}
+
+	title := s.Name + "_" + scheduled.UTC().Format("2006-01-02")
+	if scheduled.UTC().Hour() != 0 || scheduled.UTC().Minute() != 0 {
+		title += "_" + scheduled.UTC().Format("1504")
+	}
+
+	file, err := Submit(models.CopernicusInput{DatasetName: s.DatasetName, Service: s.Service, Body: params}, s.Subject, &folder, title)
+	if err != nil {
+		return "", params, err
+	}
+	return file.Id, params, nil
+}
+
+// checkRuns updates the submitted runs of a schedule whose datasets were delivered or whose
+// jobs failed, in order, and returns the updated count of consecutive failures.
+func checkRuns(scheduleID string, failures int) int {
+	cursor, err := globals.CopernicusScheduleDB.GetCursorRuns(scheduleID, models.RunSubmitted)
+	if err != nil {
+		log.Println("Could not list copernicus schedule runs: ", err.Error())
+		return failures
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		var run models.CopernicusScheduleRun
+		if err := cursor.Decode(&result); err != nil {
+			log.Println("Could not resolve cursor: ", err.Error())
+			return failures
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &run)
+
+		run.State, run.Error = runState(run)
+		switch run.State {
+		case models.RunCompleted:
+			failures = 0
+		case models.RunFailed:
+			failures++
+		default:
+			continue
+		}
+		if err := globals.CopernicusScheduleDB.UpdateRun(run); err != nil {
+			log.Println("Could not update copernicus schedule run "+run.Id+": ", err.Error())
+		}
+	}
+	return failures
+}
+
+// runState tells whether the dataset of a submitted run was delivered or its job failed.
+func runState(run models.CopernicusScheduleRun) (string, string) {
+	file, err := globals.FileDB.GetOneByID(run.FileId)
+	if err != nil {
+		return models.RunFailed, "delivered file was deleted"
+	}
+	if file.Size > 0 {
+		return models.RunCompleted, ""
+	}
+
+	record, err := globals.CopernicusDB.GetOneByFileID(run.FileId)
+	if err != nil {
+		return models.RunFailed, "copernicus record not found"
+	}
+	if task, err := globals.CopernicusQueueDB.GetOneByID(record.Id); err == nil && task.State == models.TaskFailed {
+		return models.RunFailed, task.LastError
+	}
+	switch record.Details.Status {
+	case "failed", "dismissed":
+		return models.RunFailed, record.Error
+	}
+	return models.RunSubmitted, ""
+}
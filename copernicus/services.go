@@ -0,0 +1,123 @@
+package copernicus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	goCDS "github.com/SLG-European-Projects/cds-go"
+	CDSModels "github.com/SLG-European-Projects/cds-go/models"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Errors of service and key selection. Handlers map them to HTTP statuses.
+var (
+	ErrUnknownService = errors.New("unknown copernicus service")
+	ErrNoServiceKey   = errors.New("no copernicus key for this service; store a key of your own")
+)
+
+// ServiceName returns the name of a service as registered, or the default service if name is empty.
+func ServiceName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return globals.DefaultCopernicusService, nil
+	}
+	if _, ok := globals.CopernicusServices[name]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownService, name)
+	}
+	return name, nil
+}
+
+// Services lists the registered services.
+func Services() []models.CopernicusService {
+	services := make([]models.CopernicusService, 0, len(globals.CopernicusServices))
+	for name, client := range globals.CopernicusServices {
+		services = append(services, models.CopernicusService{
+			Name:      name,
+			URL:       client.C.BaseURL,
+			SharedKey: client.C.ApiKey != "",
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// CredentialID returns the ID of the key of a user for a service or, if subject is empty, of a bucket.
+// A user or bucket has at most one key per service.
+func CredentialID(service string, subject string, groupID string) string {
+	if subject != "" {
+		return service + ":user:" + subject
+	}
+	return service + ":group:" + groupID
+}
+
+// Client returns the client of a service, with the shared key of the service.
+func Client(service string) (*goCDS.Client, error) {
+	name, err := ServiceName(service)
+	if err != nil {
+		return nil, err
+	}
+	return globals.CopernicusServices[name], nil
+}
+
+// clientFor returns the client the job of a record is handled with: it has to be the account
+// that submitted the job.
+func clientFor(record models.CopernicusRecord) (*goCDS.Client, error) {
+	return serviceClient(record.Service, record.CredentialID)
+}
+
+// serviceClient returns the client of a service with a stored key, or with the shared key if
+// credentialID is empty.
+func serviceClient(service string, credentialID string) (*goCDS.Client, error) {
+	shared, err := Client(service)
+	if err != nil || credentialID == "" {
+		return shared, err
+	}
+
+	credential, err := globals.CopernicusCredentialDB.GetOneByID(credentialID)
+	if err != nil {
+		return nil, fmt.Errorf("copernicus key %s: %v", credentialID, err)
+	}
+	key, err := utils.DecryptSecret(credential.Key)
+	if err != nil {
+		return nil, fmt.Errorf("copernicus key %s: %v", credentialID, err)
+	}
+	return &goCDS.Client{C: CDSModels.ClientProperties{
+		BaseURL:    shared.C.BaseURL,
+		ApiKey:     key,
+		HTTPClient: shared.C.HTTPClient,
+	}}, nil
+}
+
+// credentialFor picks the stored key a request of subject is submitted with: the user's own key
+// for the service, then the key of the destination's bucket. It returns an empty ID if neither
+// exists and the shared key is to be used.
+func credentialFor(service string, subject string, destination *models.Folder) (string, error) {
+	owners := [][2]string{{subject, ""}}
+	if destination != nil {
+		bucketID := destination.Id
+		if destination.Level > 0 && len(destination.Ancestors) > 0 {
+			bucketID = destination.Ancestors[0]
+		}
+		owners = append(owners, [2]string{"", bucketID})
+	}
+	for _, owner := range owners {
+		credentialID := CredentialID(service, owner[0], owner[1])
+		_, err := globals.CopernicusCredentialDB.GetOneByID(credentialID)
+		if err == nil {
+			return credentialID, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return "", err
+		}
+	}
+
+	if globals.CopernicusServices[service].C.ApiKey == "" {
+		return "", ErrNoServiceKey
+	}
+	return "", nil
+}
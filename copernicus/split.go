@@ -0,0 +1,254 @@
+package copernicus
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// Ways to split a request.
+const (
+	SplitByYear     = "year"
+	SplitByMonth    = "month"
+	SplitByVariable = "variable"
+)
+
+// Status of a split request while its chunks are running. Its other statuses are those of jobs.
+const statusRunning = "running"
+
+// chunk is a part of a split request.
+type chunk struct {
+	label string
+	body  map[string]interface{}
+}
+
+// ValidateSplit checks the split options of a request.
+func ValidateSplit(input models.CopernicusInput) []models.FieldError {
+	if input.Split == nil {
+		return nil
+	}
+	var fieldErrors []models.FieldError
+	switch input.Split.By {
+	case SplitByYear, SplitByMonth, SplitByVariable:
+	default:
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "split.by", Message: "must be year, month or variable"})
+	}
+	if input.Split.Merge {
+		if input.Split.By == SplitByVariable {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "split.merge", Message: "only chunks split along time can be merged"})
+		}
+		if !strings.HasPrefix(strings.ToLower(RequestFormat(input.Body)), "netcdf") {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "split.merge", Message: "only NetCDF chunks can be merged"})
+		}
+	}
+	if len(fieldErrors) == 0 {
+		if _, err := splitRequest(input.Body, input.Split.By); err != nil {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "split.by", Message: err.Error()})
+		}
+	}
+	return fieldErrors
+}
+
+// splitRequest splits a request body into chunks along years, months or variables.
+// Time is taken from the year (and month) lists, or from a "date" range ("start/end").
+func splitRequest(body map[string]interface{}, by string) ([]chunk, error) {
+	if by == SplitByVariable {
+		variables := formValues(body["variable"])
+		if len(variables) == 0 {
+			return nil, fmt.Errorf("request has no variables to split")
+		}
+		chunks := make([]chunk, 0, len(variables))
+		for _, variable := range variables {
+			chunks = append(chunks, chunk{label: variable, body: withParams(body, map[string]interface{}{"variable": []interface{}{variable}})})
+		}
+		return chunks, nil
+	}
+
+	if date, ok := body["date"].(string); ok && strings.Contains(date, "/") {
+		return splitDateRange(body, date, by)
+	}
+
+	years := formValues(body["year"])
+	if len(years) == 0 {
+		return nil, fmt.Errorf("request has no years or date range to split")
+	}
+	var chunks []chunk
+	for _, year := range years {
+		if by == SplitByYear {
+			chunks = append(chunks, chunk{label: year, body: withParams(body, map[string]interface{}{"year": []interface{}{year}})})
+			continue
+		}
+		months := formValues(body["month"])
+		if len(months) == 0 {
+			return nil, fmt.Errorf("request has no months to split")
+		}
+		for _, month := range months {
+			label := year + "-" + fmt.Sprintf("%02s", month)
+			chunks = append(chunks, chunk{label: label, body: withParams(body, map[string]interface{}{"year": []interface{}{year}, "month": []interface{}{month}})})
+		}
+	}
+	return chunks, nil
+}
+
+// splitDateRange splits a "start/end" date range into yearly or monthly ranges.
+func splitDateRange(body map[string]interface{}, dateRange string, by string) ([]chunk, error) {
+	bounds := strings.SplitN(dateRange, "/", 2)
+	start, err := time.Parse("2006-01-02", strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid date range: %v", err)
+	}
+	end, err := time.Parse("2006-01-02", strings.TrimSpace(bounds[1]))
+	if err != nil || end.Before(start) {
+		return nil, fmt.Errorf("invalid date range %q", dateRange)
+	}
+
+	var chunks []chunk
+	for from := start; !from.After(end); {
+		var next time.Time
+		var label string
+		if by == SplitByYear {
+			next = time.Date(from.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+			label = strconv.Itoa(from.Year())
+		} else {
+			next = time.Date(from.Year(), from.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			label = from.Format("2006-01")
+		}
+		to := next.AddDate(0, 0, -1)
+		if to.After(end) {
+			to = end
+		}
+		chunks = append(chunks, chunk{label: label, body: withParams(body, map[string]interface{}{"date": from.Format("2006-01-02") + "/" + to.Format("2006-01-02")})})
+		from = next
+	}
+	return chunks, nil
+}
+
+// withParams returns a copy of body with some parameters replaced.
+func withParams(body map[string]interface{}, params map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		copied[key] = value
+	}
+	for key, value := range params {
+		copied[key] = value
+	}
+	return copied
+}
+
+// submitSplit submits each chunk of a split request like Submit, and a parent record that rolls
+// them up into one file: the NetCDF chunks concatenated along time if merge is set, or otherwise
+// a JSON manifest of the chunks. Chunks are shared with identical requests like any dataset.
+// If destination is given, chunks are delivered there as separate files, or, when merged, the
+// merged file is.
+func submitSplit(input models.CopernicusInput, subject string, destination *models.Folder, title string) (models.File, error) {
+	chunks, err := splitRequest(input.Body, input.Split.By)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusBadRequest, "Could not split request.", "COP0051", err}
+	}
+
+	fprint, err := utils.GenerateRequestFingerprint(input)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in creating file's fingerprint.", "COP0011", err}
+	}
+	// The parent is told apart from the unsplit request, and from other ways to split it
+	fprint = fmt.Sprintf("%x", sha256.Sum256([]byte(fprint+"|split|"+input.Split.By+"|"+strconv.FormatBool(input.Split.Merge))))
+
+	if title == "" {
+		title = input.DatasetName
+	}
+
+	var chunkDestination *models.Folder
+	if !input.Split.Merge {
+		chunkDestination = destination
+	}
+	children := make([]models.CopernicusChunk, 0, len(chunks))
+	for _, c := range chunks {
+		chunkInput := models.CopernicusInput{DatasetName: input.DatasetName, Service: input.Service, Body: c.body}
+		file, err := submit(chunkInput, subject, chunkDestination, title+"_"+c.label, false)
+		if err != nil {
+			return models.File{}, err
+		}
+		chunkID, _ := utils.GenerateRequestFingerprint(chunkInput)
+		children = append(children, models.CopernicusChunk{Label: c.label, RecordID: chunkID, FileId: file.Id})
+	}
+
+	copRec, postFile, reusable, release, err := claimSubmission(fprint, input, subject)
+	if errors.Is(err, ErrSubmissionPending) {
+		return models.File{}, &SubmitError{http.StatusServiceUnavailable, "Request is being submitted.", "COP0060", err}
+	}
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+	}
+	if reusable {
+		if err = globals.CopernicusDB.AddRequester(copRec.Id, subject); err != nil {
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+		}
+		if err = globals.CopernicusDB.AddSubscriber(copRec.Id, subject); err != nil {
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+		}
+	} else {
+		fileType := "json"
+		if input.Split.Merge {
+			fileType = RequestFormat(input.Body)
+		}
+		var submitErr *SubmitError
+		postFile, submitErr = newReferenceFile(input.DatasetName, fileType, subject)
+		if submitErr != nil {
+			release()
+			return models.File{}, submitErr
+		}
+
+		requesters := copRec.Requesters
+		if !utils.ItemInArray(requesters, subject) {
+			requesters = append(requesters, subject)
+		}
+		subscribers := copRec.Subscribers
+		if !utils.ItemInArray(subscribers, subject) {
+			subscribers = append(subscribers, subject)
+		}
+		resubmitted := copRec.Id != ""
+		copRec = models.CopernicusRecord{
+			Id:            fprint,
+			FileId:        postFile.Id,
+			DatasetName:   input.DatasetName,
+			Service:       input.Service,
+			RequestParams: input.Body,
+			Requesters:    requesters,
+			Subscribers:   subscribers,
+			Deliveries:    copRec.Deliveries,
+			DateCreation:  time.Now(),
+			Split:         input.Split,
+			Children:      children,
+		}
+		copRec.Details.Status = "accepted"
+		if err = globals.CopernicusDB.ReplaceOne(copRec); err != nil {
+			release()
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+		}
+		// A record submitted again replaces the task of its previous job
+		if resubmitted {
+			err = Requeue(copRec, subject)
+		} else {
+			err = Enqueue(copRec, subject)
+		}
+		if err != nil {
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not queue copernicus task.", "COP0039", err}
+		}
+	}
+
+	if destination != nil && input.Split.Merge {
+		postFile, err = NewDelivery(copRec, *destination, subject, title)
+		if err != nil {
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not deliver dataset to folder.", "COP0043", err}
+		}
+	}
+	return postFile, nil
+}
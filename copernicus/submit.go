@@ -0,0 +1,266 @@
+package copernicus
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// SubmitError is an error of Submit, with the status, message and internal code to report it with.
+type SubmitError struct {
+	Status  int
+	Message string
+	Code    string
+	Err     error
+}
+
+func (e *SubmitError) Error() string {
+	return e.Message + " " + e.Err.Error()
+}
+
+func (e *SubmitError) Unwrap() error {
+	return e.Err
+}
+
+// Submit makes a dataset available on behalf of subject. An identical request that can be reused
+// is shared; otherwise a Copernicus job is created with a reference file in the Copernicus bucket
+// and queued. If destination is given, the dataset is also delivered there as a file named title
+// (the dataset's title if empty), which is returned instead of the reference file.
+// Requests with split options are split into chunks (see submitSplit). Subject is notified
+// when the job ends, according to their notification preferences.
+// The request is expected to be validated already.
+func Submit(input models.CopernicusInput, subject string, destination *models.Folder, title string) (models.File, error) {
+	return submit(input, subject, destination, title, true)
+}
+
+// submit is Submit; the chunks of a split request are submitted without subscribing, so that
+// their requester is only notified of the whole request.
+func submit(input models.CopernicusInput, subject string, destination *models.Folder, title string, subscribe bool) (models.File, error) {
+	service, err := ServiceName(input.Service)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusBadRequest, "Unknown Copernicus service.", "COP0052", err}
+	}
+	input.Service = service
+
+	if input.Split != nil {
+		return submitSplit(input, subject, destination, title)
+	}
+
+	// Check if already downloaded
+	fprint, err := utils.GenerateRequestFingerprint(input)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in creating file's fingerprint.", "COP0011", err}
+	}
+
+	// Identical requests submitted at the same time share one job
+	copRec, postFile, reusable, release, err := claimSubmission(fprint, input, subject)
+	if errors.Is(err, ErrSubmissionPending) {
+		return models.File{}, &SubmitError{http.StatusServiceUnavailable, "Request is being submitted.", "COP0060", err}
+	}
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+	}
+	if reusable {
+		if err = globals.CopernicusDB.AddRequester(copRec.Id, subject); err != nil {
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+		}
+		if subscribe {
+			if err = globals.CopernicusDB.AddSubscriber(copRec.Id, subject); err != nil {
+				return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+			}
+		}
+		if destination != nil {
+			postFile, err = NewDelivery(copRec, *destination, subject, title)
+			if err != nil {
+				return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not deliver dataset to folder.", "COP0043", err}
+			}
+		}
+		return postFile, nil
+	}
+
+	// The job runs under the caller's own key if they have one
+	credentialID, err := credentialFor(service, subject, destination)
+	if errors.Is(err, ErrNoServiceKey) {
+		release()
+		return models.File{}, &SubmitError{http.StatusForbidden, "No Copernicus key for service.", "COP0053", err}
+	}
+	if err != nil {
+		release()
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not get Copernicus key.", "COP0054", err}
+	}
+	client, err := serviceClient(service, credentialID)
+	if err != nil {
+		release()
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not get Copernicus key.", "COP0054", err}
+	}
+
+	name := input.DatasetName
+	process, err := client.CreateProcess(name, input.Body)
+	if err != nil {
+		release()
+		return models.File{}, &SubmitError{http.StatusBadRequest, "Could not create Process.", "COP0010", err}
+	}
+
+	// The reference file of a stale or failed record is emptied for the new job, so that it
+	// keeps its ID and no stale copy is left in the Copernicus bucket
+	var submitErr *SubmitError
+	postFile = models.File{}
+	if copRec.FileId != "" {
+		if stale, err := globals.FileDB.GetOneByID(copRec.FileId); err == nil {
+			postFile, submitErr = resetReferenceFile(stale, subject)
+		}
+	}
+	if postFile.Id == "" && submitErr == nil {
+		postFile, submitErr = newReferenceFile(name, RequestFormat(input.Body), subject)
+	}
+	if submitErr != nil {
+		release()
+		return models.File{}, submitErr
+	}
+
+	// A refreshed record keeps its requesters and its pending deliveries
+	requesters := copRec.Requesters
+	if !utils.ItemInArray(requesters, subject) {
+		requesters = append(requesters, subject)
+	}
+	subscribers := copRec.Subscribers
+	if subscribe && !utils.ItemInArray(subscribers, subject) {
+		subscribers = append(subscribers, subject)
+	}
+	copInput := models.CopernicusRecord{
+		Id:            fprint,
+		FileId:        postFile.Id,
+		DatasetName:   input.DatasetName,
+		Service:       service,
+		CredentialID:  credentialID,
+		RequestParams: input.Body,
+		Details:       process,
+		Requesters:    requesters,
+		Subscribers:   subscribers,
+		Deliveries:    copRec.Deliveries,
+		DateCreation:  time.Now(),
+	}
+
+	err = globals.CopernicusDB.ReplaceOne(copInput)
+	if err != nil {
+		release()
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in storing copernicus input.", "COP0035", err}
+	}
+
+	// A record submitted again replaces the task of its previous job
+	if copRec.Id != "" {
+		err = Requeue(copInput, subject)
+	} else {
+		err = Enqueue(copInput, subject)
+	}
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not queue copernicus task.", "COP0039", err}
+	}
+
+	// The dataset itself stays in the Copernicus bucket; the caller gets its copy in the destination
+	if destination != nil {
+		postFile, err = NewDelivery(copInput, *destination, subject, title)
+		if err != nil {
+			return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not deliver dataset to folder.", "COP0043", err}
+		}
+	}
+	return postFile, nil
+}
+
+// resetReferenceFile empties the reference file of a record that is submitted again: its parts
+// are deleted, so that the new job stores its dataset in it. Deliveries are copies, so none of
+// them depends on the old parts.
+func resetReferenceFile(file models.File, subject string) (models.File, *SubmitError) {
+	if err := discardParts(file.Id, globals.COPERNICUS_BUCKET_ID); err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not reset reference file.", "COP0058", err}
+	}
+	file.Size = 0
+	file.Total = 0
+	file.Meta.Update = models.Updated{Date: time.Now(), User: subject}
+	if _, err := globals.FileDB.UpdateWithId(file); err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not reset reference file.", "COP0058", err}
+	}
+	if err := globals.FileDB.SetProvenance(file.Id, nil); err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not reset reference file.", "COP0058", err}
+	}
+	file.Provenance = nil
+	return file, nil
+}
+
+// newReferenceFile creates the file of a dataset in the Copernicus bucket.
+func newReferenceFile(title string, fileType string, subject string) (models.File, *SubmitError) {
+	postFile := models.File{FolderID: globals.COPERNICUS_BUCKET_ID}
+	//get bucket
+	folder, err := globals.FolderDB.GetOneByID(postFile.FolderID)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusBadRequest, "Could not find parent folder.", "COP0010", err}
+	}
+
+	//create file id
+	fileID, err := utils.GenerateUUID()
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in creating file's ID.", "COP0011", err}
+	}
+	postFile.Id = fileID
+	//Create and post file
+	update := models.Updated{
+		Date: time.Now(),
+		User: subject,
+	}
+
+	postFile.FileType = fileType
+	postFile.OriginalTitle = title
+	postFile.Size = 0
+	postFile.Ancestors = append(folder.Ancestors, postFile.FolderID)
+
+	meta := postFile.Meta
+	meta.DateCreation = time.Now()
+	meta.Creator = subject
+	meta.Read = folder.Meta.Read
+	meta.Write = folder.Meta.Write
+	meta.Update = update
+	postFile.Meta = meta
+	// Datasets of the same title are numbered, as the bucket holds each title once
+	_, _, err = utils.Place(models.ConflictRename, title, true, func(title string) error {
+		postFile.Meta.Title = title
+		postFile.Path = utils.ItemPath(folder.Path, title)
+		return globals.FileDB.InsertOne(postFile)
+	}, nil, nil)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Error in insterting file.", "COP0012", err}
+	}
+
+	// Update parent folder
+	err = globals.FolderDB.UpdateFiles(postFile.Id, postFile.FolderID)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not update parent folder.", "COP0013", err}
+	}
+
+	// Update ancestore's meta
+	err = globals.FolderDB.UpdateMetaAncestors(postFile.Ancestors, subject)
+	if err != nil {
+		return models.File{}, &SubmitError{http.StatusInternalServerError, "Could not update ancestore's meta.", "COP0014", err}
+	}
+	return postFile, nil
+}
+
+// RequestFormat returns the file format asked for in a request body, if any.
+func RequestFormat(body map[string]interface{}) string {
+	for _, key := range []string{"format", "data_format"} {
+		switch format := body[key].(type) {
+		case string:
+			return format
+		case []interface{}:
+			if len(format) > 0 {
+				if value, ok := format[0].(string); ok {
+					return value
+				}
+			}
+		}
+	}
+	return ""
+}
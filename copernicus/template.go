@@ -0,0 +1,163 @@
+package copernicus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholders of a schedule's parameter template, e.g. "{{yesterday}}", "{{today-3:day}}"
+// or "{{last 7 days}}". An optional format follows the colon.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([^}:]+?)\s*(?::\s*(\w+)\s*)?\}\}`)
+
+var (
+	relativeDayPattern = regexp.MustCompile(`^today\s*([+-])\s*(\d+)$`)
+	daysAgoPattern     = regexp.MustCompile(`^(\d+) days? ago$`)
+	lastDaysPattern    = regexp.MustCompile(`^last (\d+) days?$`)
+)
+
+// renderTemplate resolves the relative dates of a parameter template against the time of a run.
+//
+// Days are "today", "yesterday", "today-N", "today+N" and "N days ago"; "last N days" are the
+// N days before today. Formats are "date" (YYYY-MM-DD, the default), "year", "month" and "day";
+// ranges also take "dates". A range is written as "start/end" by default and as the list of its
+// distinct values otherwise. A placeholder that makes up a whole value may expand to a list.
+func renderTemplate(template map[string]interface{}, at time.Time) (map[string]interface{}, error) {
+	rendered := make(map[string]interface{}, len(template))
+	for key, value := range template {
+		v, err := renderValue(value, at)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		rendered[key] = v
+	}
+	return rendered, nil
+}
+
+func renderValue(value interface{}, at time.Time) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return renderString(v, at)
+	case []interface{}:
+		var list []interface{}
+		for _, item := range v {
+			rendered, err := renderValue(item, at)
+			if err != nil {
+				return nil, err
+			}
+			// Lists expanded from a placeholder are flattened
+			if items, ok := rendered.([]interface{}); ok {
+				list = append(list, items...)
+			} else {
+				list = append(list, rendered)
+			}
+		}
+		return list, nil
+	case map[string]interface{}:
+		return renderTemplate(v, at)
+	default:
+		return v, nil
+	}
+}
+
+func renderString(value string, at time.Time) (interface{}, error) {
+	// A whole-value placeholder may expand to a list
+	if match := placeholderPattern.FindStringSubmatch(value); match != nil && match[0] == strings.TrimSpace(value) {
+		return expandPlaceholder(match[1], match[2], at)
+	}
+
+	var renderErr error
+	rendered := placeholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		expanded, err := expandPlaceholder(match[1], match[2], at)
+		if err != nil {
+			renderErr = err
+			return placeholder
+		}
+		if list, ok := expanded.([]interface{}); ok {
+			parts := make([]string, len(list))
+			for i, item := range list {
+				parts[i] = fmt.Sprint(item)
+			}
+			return strings.Join(parts, ",")
+		}
+		return fmt.Sprint(expanded)
+	})
+	return rendered, renderErr
+}
+
+// expandPlaceholder resolves one placeholder expression.
+func expandPlaceholder(expr string, format string, at time.Time) (interface{}, error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	today := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+
+	if match := lastDaysPattern.FindStringSubmatch(expr); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		if n <= 0 {
+			return nil, fmt.Errorf("%q must span at least one day", expr)
+		}
+		return formatRange(today.AddDate(0, 0, -n), today.AddDate(0, 0, -1), format)
+	}
+
+	var day time.Time
+	switch {
+	case expr == "today":
+		day = today
+	case expr == "yesterday":
+		day = today.AddDate(0, 0, -1)
+	case expr == "tomorrow":
+		day = today.AddDate(0, 0, 1)
+	case relativeDayPattern.MatchString(expr):
+		match := relativeDayPattern.FindStringSubmatch(expr)
+		n, _ := strconv.Atoi(match[2])
+		if match[1] == "-" {
+			n = -n
+		}
+		day = today.AddDate(0, 0, n)
+	case daysAgoPattern.MatchString(expr):
+		n, _ := strconv.Atoi(daysAgoPattern.FindStringSubmatch(expr)[1])
+		day = today.AddDate(0, 0, -n)
+	default:
+		return nil, fmt.Errorf("unknown placeholder %q", expr)
+	}
+	return formatDay(day, format)
+}
+
+func formatDay(day time.Time, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", "date":
+		return day.Format("2006-01-02"), nil
+	case "year":
+		return day.Format("2006"), nil
+	case "month":
+		return day.Format("01"), nil
+	case "day":
+		return day.Format("02"), nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+func formatRange(start time.Time, end time.Time, format string) (interface{}, error) {
+	if format == "" {
+		return start.Format("2006-01-02") + "/" + end.Format("2006-01-02"), nil
+	}
+	if strings.ToLower(format) == "dates" {
+		format = "date"
+	}
+
+	var values []interface{}
+	seen := map[string]bool{}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		value, err := formatDay(day, format)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
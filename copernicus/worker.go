@@ -0,0 +1,256 @@
+package copernicus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	db "github.com/isotiropoulos/storage-api/dbs/meta"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxBackoff caps the delay between failed attempts.
+const maxBackoff = 5 * time.Minute
+
+var jobParams = map[string]interface{}{
+	"log":     true,
+	"request": true,
+}
+
+// work claims due tasks until ctx is done.
+func work(ctx context.Context, owner string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		task, err := globals.CopernicusQueueDB.Claim(owner, lease)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Println("Could not claim copernicus task: ", err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(globals.CheckTime):
+			}
+			continue
+		}
+		process(ctx, owner, task)
+	}
+}
+
+// process polls the job of a claimed task once. Running jobs are given back to the queue,
+// so that a task only holds its lease while a worker is actually busy with it.
+func process(ctx context.Context, owner string, task models.CopernicusTask) {
+	record, err := globals.CopernicusDB.GetOneByID(task.Id)
+	if err != nil {
+		// The reference file (and its record) was deleted
+		finish(owner, task, models.TaskFailed, "copernicus record not found: "+err.Error())
+		return
+	}
+
+	// Split requests have no job of their own
+	if len(record.Children) > 0 {
+		processParent(ctx, owner, task, record)
+		return
+	}
+
+	// Status or log changed => Update DB Document
+	record, err = Refresh(record)
+	if err != nil {
+		retry(owner, task, err)
+		return
+	}
+
+	switch record.Details.Status {
+	case "failed", "dismissed":
+		reason := "copernicus job " + record.Details.Status
+		if record.Error != "" {
+			reason += ": " + record.Error
+		}
+		finish(owner, task, models.TaskFailed, reason)
+	case "successful":
+		err = withLease(ctx, owner, task.Id, func(ctx context.Context) error {
+			if err := storeResult(ctx, record, task.Subject); err != nil {
+				return err
+			}
+			// Re-read the record to see deliveries added in the meantime
+			record, err := globals.CopernicusDB.GetOneByID(task.Id)
+			if err != nil {
+				return err
+			}
+			return DeliverPending(record)
+		})
+		if errors.Is(err, db.ErrLeaseLost) {
+			log.Println("Lost lease of copernicus task " + task.Id + ", leaving it to its new owner")
+			return
+		}
+		if err != nil {
+			retry(owner, task, err)
+			return
+		}
+		finish(owner, task, models.TaskDone, "")
+	default:
+		task.Attempts = 0
+		task.LastError = ""
+		task.NextPoll = time.Now().Add(globals.CheckTime)
+		release(owner, task)
+	}
+}
+
+// withLease runs fn while extending the lease of the task. If the lease is lost,
+// fn's context is canceled and db.ErrLeaseLost is returned.
+func withLease(ctx context.Context, owner string, taskID string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := globals.CopernicusQueueDB.Heartbeat(taskID, owner, lease); errors.Is(err, db.ErrLeaseLost) {
+					close(lost)
+					cancel()
+					return
+				} else if err != nil {
+					log.Println("Could not extend lease of copernicus task "+taskID+": ", err.Error())
+				}
+			}
+		}
+	}()
+
+	err := fn(ctx)
+	select {
+	case <-lost:
+		return db.ErrLeaseLost
+	default:
+		return err
+	}
+}
+
+// retry gives a task back with an exponential backoff, or fails it after maxAttempts.
+func retry(owner string, task models.CopernicusTask, cause error) {
+	task.Attempts++
+	task.LastError = cause.Error()
+	if task.Attempts >= maxAttempts {
+		finish(owner, task, models.TaskFailed, task.LastError)
+		return
+	}
+	backoff := time.Duration(math.Min(float64(globals.CheckTime)*math.Pow(2, float64(task.Attempts)), float64(maxBackoff)))
+	task.NextPoll = time.Now().Add(backoff)
+	release(owner, task)
+}
+
+func release(owner string, task models.CopernicusTask) {
+	if err := globals.CopernicusQueueDB.Release(task, owner); err != nil {
+		log.Println("Could not release copernicus task "+task.Id+": ", err.Error())
+	}
+}
+
+func finish(owner string, task models.CopernicusTask, state string, lastError string) {
+	if err := globals.CopernicusQueueDB.Finish(task.Id, owner, state, lastError); err != nil {
+		log.Println("Could not finish copernicus task "+task.Id+": ", err.Error())
+		return
+	}
+	event := models.EventJobSuccessful
+	if state == models.TaskFailed {
+		event = models.EventJobFailed
+		// Keep the reason on the record, where users can see it
+		if err := globals.CopernicusDB.SetError(task.Id, lastError); err != nil {
+			log.Println("Could not save error of copernicus record "+task.Id+": ", err.Error())
+		}
+	}
+	notifyEnd(task.Id, event)
+}
+
+// storeResult downloads the result of a successful job and stores it as the parts of the reference file.
+func storeResult(ctx context.Context, record models.CopernicusRecord, subject string) error {
+
+	// Grab reference file
+	file, err := globals.FileDB.GetOneByID(record.FileId)
+	if err != nil {
+		return fmt.Errorf("can't retrieve file document: %v", err)
+	}
+	if file.Size > 0 {
+		// Already stored by a previous owner of the task
+		return nil
+	}
+
+	// Parts of an interrupted attempt are replaced
+	if err = discardParts(record.FileId, globals.COPERNICUS_BUCKET_ID); err != nil {
+		return err
+	}
+
+	client, err := clientFor(record)
+	if err != nil {
+		return err
+	}
+	result, err := client.GetJobResult(record.Details.JobID)
+	if err != nil {
+		return err
+	}
+
+	dataReader, expectedSize, err := newRangeReader(ctx, result.Asset.Value.Href)
+	if err != nil {
+		return err
+	}
+	defer dataReader.Close()
+
+	totalPartsCount, size, err := storeParts(ctx, dataReader, record.FileId, globals.COPERNICUS_BUCKET_ID)
+	if err != nil {
+		return err
+	}
+	if expectedSize >= 0 && size != expectedSize {
+		return fmt.Errorf("downloaded %d bytes, expected %d", size, expectedSize)
+	}
+
+	//update dataset info
+	file.Total = totalPartsCount
+	file.Meta.Update = models.Updated{
+		Date: time.Now(),
+		User: subject,
+	}
+	file.Size = int64(size)
+
+	if _, err = globals.FileDB.UpdateWithId(file); err != nil {
+		return err
+	}
+	return globals.FileDB.SetProvenance(file.Id, recordProvenance(record, file.Meta.Update.Date))
+}
+
+// discardParts deletes the stored parts of a file.
+func discardParts(fileID string, bucket string) error {
+	partsCursor, err := globals.PartsDB.GetCursorByFileID(fileID)
+	if err != nil {
+		return err
+	}
+	defer partsCursor.Close(context.Background())
+
+	for partsCursor.Next(context.Background()) {
+		var result bson.M
+		var part models.Part
+		if err = partsCursor.Decode(&result); err != nil {
+			return err
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &part)
+		if err = globals.Storage.DeleteFile(part.Id, bucket); err != nil {
+			return err
+		}
+	}
+	return globals.PartsDB.DeleteManyWithFile(fileID)
+}
@@ -0,0 +1,313 @@
+package copernicus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	goCDS "github.com/SLG-European-Projects/cds-go"
+	"github.com/isotiropoulos/storage-api/cdsmock"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/memstore"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// era5Request covers 2 years of 3-hourly steps, which the mock answers with about 6 MB of GRIB:
+// two parts.
+func era5Request() models.CopernicusInput {
+	days := make([]interface{}, 31)
+	for i := range days {
+		days[i] = fmt.Sprintf("%02d", i+1)
+	}
+	return models.CopernicusInput{
+		DatasetName: "reanalysis-era5-single-levels",
+		Body: map[string]interface{}{
+			"product_type": []interface{}{"reanalysis"},
+			"variable":     []interface{}{"2m_temperature"},
+			"year":         []interface{}{"2023", "2024"},
+			"month":        []interface{}{"01", "02", "03", "04", "05", "06", "07", "08", "09", "10", "11", "12"},
+			"day":          days,
+			"time":         []interface{}{"00:00", "03:00", "06:00", "09:00", "12:00", "15:00", "18:00", "21:00"},
+			"data_format":  "grib",
+		},
+	}
+}
+
+// setup installs in-memory stores with the Copernicus bucket and points the Copernicus services
+// at a mock started with options.
+func setup(t *testing.T, options ...cdsmock.Option) (*memstore.Stores, *goCDS.Client) {
+	stores := memstore.New()
+	t.Cleanup(stores.Install())
+	server := httptest.NewServer(cdsmock.New(options...))
+	t.Cleanup(server.Close)
+
+	urls := []*string{&globals.CDS_URL, &globals.ADS_URL, &globals.EWDS_URL}
+	previousURLs := []string{globals.CDS_URL, globals.ADS_URL, globals.EWDS_URL}
+	previousServices := map[string]*goCDS.Client{}
+	for name, client := range globals.CopernicusServices {
+		previousServices[name] = client
+	}
+	checkTime := globals.CheckTime
+	t.Cleanup(func() {
+		for i, url := range urls {
+			*url = previousURLs[i]
+		}
+		for name, client := range previousServices {
+			globals.CopernicusServices[name] = client
+		}
+		globals.CheckTime = checkTime
+	})
+	for _, url := range urls {
+		*url = server.URL
+	}
+	globals.Init()
+	globals.CheckTime = time.Millisecond
+
+	stores.Folders.InsertOne(models.Folder{
+		Id:   globals.COPERNICUS_BUCKET_ID,
+		Meta: models.Meta{Title: "copernicus"},
+		Path: utils.ItemPath("", "copernicus"),
+	})
+	return stores, globals.CopernicusServices[globals.ServiceCDS]
+}
+
+// runQueue claims and processes the due tasks until the task of a record is done or failed,
+// and returns the statuses its record went through.
+func runQueue(t *testing.T, recordID string) ([]string, models.CopernicusTask) {
+	t.Helper()
+	var statuses []string
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		record, err := globals.CopernicusDB.GetOneByID(recordID)
+		if err != nil {
+			t.Fatalf("record: %v", err)
+		}
+		if len(statuses) == 0 || statuses[len(statuses)-1] != record.Details.Status {
+			statuses = append(statuses, record.Details.Status)
+		}
+
+		queued, err := globals.CopernicusQueueDB.GetOneByID(recordID)
+		if err != nil {
+			t.Fatalf("task: %v", err)
+		}
+		if queued.State != models.TaskQueued {
+			return statuses, queued
+		}
+
+		task, err := globals.CopernicusQueueDB.Claim("test", lease)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			time.Sleep(globals.CheckTime)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		process(context.Background(), "test", task)
+	}
+	t.Fatalf("task of %s still queued after going through %v", recordID, statuses)
+	return nil, models.CopernicusTask{}
+}
+
+func TestWorkerSuccessful(t *testing.T) {
+	stores, client := setup(t)
+
+	input := era5Request()
+	fieldErrors, err := Validate("", input.DatasetName, input.Body)
+	if err != nil || len(fieldErrors) > 0 {
+		t.Fatalf("request is invalid: %v %v", fieldErrors, err)
+	}
+	file, err := Submit(input, "user", nil, "")
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if file.FolderID != globals.COPERNICUS_BUCKET_ID || file.Size != 0 {
+		t.Fatalf("reference file is %+v", file)
+	}
+	if file.Path != "/copernicus/"+file.Meta.Title {
+		t.Errorf("reference file is at %s", file.Path)
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+
+	statuses, task := runQueue(t, record.Id)
+	if strings.Join(statuses, ",") != "accepted,running,successful" {
+		t.Errorf("record went through %v", statuses)
+	}
+	if task.State != models.TaskDone || task.LastError != "" {
+		t.Errorf("task ended as %s: %s", task.State, task.LastError)
+	}
+
+	// The stored parts are the result of the job, in order
+	result, err := client.GetJobResult(record.Details.JobID)
+	if err != nil {
+		t.Fatalf("GetJobResult: %v", err)
+	}
+	resp, err := http.Get(result.Asset.Value.Href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, _ = globals.FileDB.GetOneByID(file.Id)
+	parts := stores.Parts.ByFile(file.Id)
+	if file.Size != int64(len(want)) || file.Total != 2 || len(parts) != 2 {
+		t.Fatalf("stored %d bytes in %d parts (%d recorded), want %d bytes in 2", file.Size, file.Total, len(parts), len(want))
+	}
+	var stored bytes.Buffer
+	for i, part := range parts {
+		if part.PartNumber != i {
+			t.Errorf("part %d has number %d", i, part.PartNumber)
+		}
+		data, ok := stores.Storage.Object(globals.COPERNICUS_BUCKET_ID, part.Id)
+		if !ok || int64(len(data)) != part.Size {
+			t.Fatalf("part %d: object of %d bytes, recorded %d", i, len(data), part.Size)
+		}
+		stored.Write(data)
+	}
+	if !bytes.Equal(stored.Bytes(), want) {
+		t.Error("stored parts differ from the job result")
+	}
+	if file.Provenance == nil || file.Provenance.DatasetName != input.DatasetName {
+		t.Errorf("provenance is %+v", file.Provenance)
+	}
+
+	// The same request reuses the stored dataset
+	again, err := Submit(input, "other", nil, "")
+	if err != nil || again.Id != file.Id {
+		t.Errorf("second request got file %s (%v), want %s", again.Id, err, file.Id)
+	}
+}
+
+func TestWorkerFailed(t *testing.T) {
+	stores, _ := setup(t, cdsmock.WithScript(cdsmock.StatusAccepted, cdsmock.StatusRunning, cdsmock.StatusFailed))
+
+	file, err := Submit(era5Request(), "user", nil, "")
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+
+	statuses, task := runQueue(t, record.Id)
+	if strings.Join(statuses, ",") != "accepted,running,failed" {
+		t.Errorf("record went through %v", statuses)
+	}
+	if task.State != models.TaskFailed || !strings.HasPrefix(task.LastError, "copernicus job failed") {
+		t.Errorf("task ended as %s: %s", task.State, task.LastError)
+	}
+	record, _ = globals.CopernicusDB.GetOneByID(record.Id)
+	if record.Error == "" {
+		t.Error("record has no error")
+	}
+
+	file, _ = globals.FileDB.GetOneByID(file.Id)
+	if file.Size != 0 || len(stores.Parts.ByFile(file.Id)) != 0 {
+		t.Errorf("failed job stored %d bytes", file.Size)
+	}
+
+	// A failed job is submitted again
+	if _, _, reusable := Lookup(record.Id); reusable {
+		t.Error("failed record is reused")
+	}
+}
+
+func TestEnqueueKeepsClaim(t *testing.T) {
+	setup(t)
+
+	file, err := Submit(era5Request(), "user", nil, "")
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+	claimed, err := globals.CopernicusQueueDB.Claim("test", lease)
+	if err != nil || claimed.Id != record.Id {
+		t.Fatalf("claimed %s (%v), want %s", claimed.Id, err, record.Id)
+	}
+
+	// The record is queued again while a worker holds its task
+	if err = Enqueue(record, "other"); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	task, err := globals.CopernicusQueueDB.GetOneByID(record.Id)
+	if err != nil || task.LeaseOwner != "test" || task.Subject != "user" {
+		t.Errorf("task is %+v (%v), want the claim of test kept", task, err)
+	}
+	if _, err = globals.CopernicusQueueDB.Claim("other", lease); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("task was claimed twice: %v", err)
+	}
+}
+
+func TestDismissFinishedJob(t *testing.T) {
+	setup(t)
+
+	file, err := Submit(era5Request(), "user", nil, "")
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+
+	// The job ends on the service before it is polled again
+	r, _ := http.NewRequest(http.MethodPut, globals.CDS_URL+"/mock/jobs/"+record.Details.JobID+"?status="+cdsmock.StatusSuccessful, nil)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err = Dismiss(record, "user"); !errors.Is(err, ErrJobCompleted) {
+		t.Errorf("Dismiss: %v, want %v", err, ErrJobCompleted)
+	}
+	record, _ = globals.CopernicusDB.GetOneByID(record.Id)
+	task, _ := globals.CopernicusQueueDB.GetOneByID(record.Id)
+	if record.Details.Status != cdsmock.StatusSuccessful || task.State != models.TaskQueued {
+		t.Errorf("record is %s and task %s", record.Details.Status, task.State)
+	}
+}
+
+func TestRemoveDeliveryFileNotListed(t *testing.T) {
+	stores, _ := setup(t)
+
+	folder := models.Folder{Id: "deliveries", Meta: models.Meta{Title: "deliveries"}, Path: utils.ItemPath("", "deliveries")}
+	stores.Folders.InsertOne(folder)
+
+	// The folder doesn't list the file, with no files and then with others
+	for _, files := range [][]string{nil, {"other", "kept"}} {
+		folder.Files = files
+		stores.Folders.UpdateWithId(folder)
+		stores.Files.InsertOne(models.File{Id: "pending", Meta: models.Meta{Title: "pending.grib"}, FolderID: folder.Id, Ancestors: []string{folder.Id}})
+
+		if err := removeDeliveryFile("pending"); err != nil {
+			t.Fatalf("removeDeliveryFile: %v", err)
+		}
+		if _, err := globals.FileDB.GetOneByID("pending"); err == nil {
+			t.Error("delivered file is kept")
+		}
+		stored, _ := globals.FolderDB.GetOneByID(folder.Id)
+		if strings.Join(stored.Files, ",") != strings.Join(files, ",") {
+			t.Errorf("folder has files %v, want %v", stored.Files, files)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metaDB
+
+import (
+	"context"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	APIKEYSCOLLECTION = "apikeys"
+)
+
+// InsertOne is to insert an API key in the apikeys collection
+func (apikeystore *APIKeyStore) InsertOne(apiKey models.APIKey) error {
+	_, err := db.Collection(APIKEYSCOLLECTION).InsertOne(context.Background(), apiKey)
+	return err
+}
+
+// GetOneByID is to get an API key by ID.
+func (apikeystore *APIKeyStore) GetOneByID(keyID string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	err := db.Collection(APIKEYSCOLLECTION).FindOne(context.Background(), bson.M{"_id": keyID}).Decode(&apiKey)
+	return apiKey, err
+}
+
+// GetActiveByHash is to get a non-revoked API key by the hash of the key.
+func (apikeystore *APIKeyStore) GetActiveByHash(hash string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	err := db.Collection(APIKEYSCOLLECTION).FindOne(context.Background(), bson.M{"hash": hash, "revoked": false}).Decode(&apiKey)
+	return apiKey, err
+}
+
+// GetCursorByGroup is to get a cursor with the API keys of a bucket.
+func (apikeystore *APIKeyStore) GetCursorByGroup(groupID string) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(APIKEYSCOLLECTION).Find(context.Background(), bson.M{"group_id": groupID})
+	return cursor, err
+}
+
+// Revoke is to mark an API key as revoked.
+func (apikeystore *APIKeyStore) Revoke(keyID string) (models.APIKey, error) {
+	filter := bson.M{"_id": keyID}
+	update := bson.M{
+		"$set": bson.M{
+			"revoked":      true,
+			"date_revoked": time.Now(),
+		},
+	}
+	_, err := db.Collection(APIKEYSCOLLECTION).UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	return apikeystore.GetOneByID(keyID)
+}
+
+// UpdateLastUsed is to record the last time an API key was used.
+func (apikeystore *APIKeyStore) UpdateLastUsed(keyID string, lastUsed time.Time) error {
+	_, err := db.Collection(APIKEYSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": keyID}, bson.M{"$set": bson.M{"last_used": lastUsed}})
+	return err
+}
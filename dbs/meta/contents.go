@@ -0,0 +1,79 @@
+package metaDB
+
+import (
+	"context"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CONTENTSCOLLECTION = "contents"
+)
+
+// ReplaceOne is to insert the content of a file, or replace it if it was indexed before.
+func (contentstore *ContentStore) ReplaceOne(content models.FileContent) error {
+	_, err := db.Collection(CONTENTSCOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": content.Id}, content, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetOneByID is to get the content of a file by the file's ID.
+func (contentstore *ContentStore) GetOneByID(fileID string) (models.FileContent, error) {
+	var content models.FileContent
+	err := db.Collection(CONTENTSCOLLECTION).FindOne(context.Background(), bson.M{"_id": fileID}).Decode(&content)
+	return content, err
+}
+
+// DeleteOneByID is to delete the content of a file by the file's ID.
+func (contentstore *ContentStore) DeleteOneByID(fileID string) error {
+	_, err := db.Collection(CONTENTSCOLLECTION).DeleteOne(context.Background(), bson.M{"_id": fileID})
+	return err
+}
+
+// DeleteManyWithAncestore is to delete the content of the files under a folder.
+func (contentstore *ContentStore) DeleteManyWithAncestore(ancestore string) error {
+	_, err := db.Collection(CONTENTSCOLLECTION).DeleteMany(context.Background(), bson.M{"ancestors": ancestore})
+	return err
+}
+
+// UpdatePlacement is to set the folder and ancestors of the content of a moved file.
+func (contentstore *ContentStore) UpdatePlacement(fileID string, folderID string, ancestors []string) error {
+	_, err := db.Collection(CONTENTSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": fileID}, bson.M{"$set": bson.M{"folder": folderID, "ancestors": ancestors}})
+	return err
+}
+
+// Search is to get a cursor with the contents under the scopes of a query that have its words,
+// the most relevant first.
+func (contentstore *ContentStore) Search(query models.ContentQuery) (*mongo.Cursor, error) {
+	opts := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}}).
+		SetSkip(query.Skip).
+		SetLimit(query.Limit)
+	cursor, err := db.Collection(CONTENTSCOLLECTION).Find(context.Background(), contentFilter(query), opts)
+	return cursor, err
+}
+
+// CountSearch is to count the contents under the scopes of a query that have its words.
+func (contentstore *ContentStore) CountSearch(query models.ContentQuery) (int64, error) {
+	return db.Collection(CONTENTSCOLLECTION).CountDocuments(context.Background(), contentFilter(query))
+}
+
+// contentFilter is the filter of a content search.
+func contentFilter(query models.ContentQuery) bson.M {
+	scopes := query.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	filter := bson.M{
+		"$text":     bson.M{"$search": query.Text},
+		"ancestors": bson.M{"$in": scopes},
+	}
+	if query.FileType != "" {
+		filter["file_type"] = fileType(query.FileType)
+	}
+	return filter
+}
@@ -7,6 +7,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -19,6 +20,38 @@ func (copernicustore *CopernicusStore) InsertOne(copenicus_input models.Copernic
 	return err
 }
 
+// ReplaceOne is to insert a record or replace the record with the same fingerprint.
+func (copernicustore *CopernicusStore) ReplaceOne(copenicus_input models.CopernicusRecord) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := db.Collection(COPERNICUSCOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": copenicus_input.Id}, copenicus_input, opts)
+	return err
+}
+
+// ClaimSubmission is to claim the submission of a request: record is inserted if the request has
+// no record, or else previous, the record read by the caller, is marked with the status and date
+// of record. It returns false if the record was inserted or changed by another submission first.
+func (copernicustore *CopernicusStore) ClaimSubmission(record models.CopernicusRecord, previous *models.CopernicusRecord) (bool, error) {
+	if previous == nil {
+		_, err := db.Collection(COPERNICUSCOLLECTION).InsertOne(context.Background(), record)
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	filter := bson.M{"_id": previous.Id, "details.status": previous.Details.Status, "date_creation": previous.DateCreation}
+	if previous.DateCreation.IsZero() {
+		// Records from before the date was kept
+		filter["date_creation"] = bson.M{"$in": bson.A{previous.DateCreation, nil}}
+	}
+	update := bson.M{"$set": bson.M{"details.status": record.Details.Status, "date_creation": record.DateCreation}}
+	res, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
 // GetOneByID is to get a part by ID.
 func (copernicustore *CopernicusStore) GetOneByID(inputId string) (models.CopernicusRecord, error) {
 
@@ -28,16 +61,80 @@ func (copernicustore *CopernicusStore) GetOneByID(inputId string) (models.Copern
 	return coperInput, err
 }
 
-// GetOneByFileID is to get a part by ID.
+// GetOneByFileID is to get a record by its reference file or by one of its delivered files.
 func (copernicustore *CopernicusStore) GetOneByFileID(fileId string) (models.CopernicusRecord, error) {
 
 	var coprerRec models.CopernicusRecord
 
-	err := db.Collection(COPERNICUSCOLLECTION).FindOne(context.Background(), bson.M{"file_id": fileId}).Decode(&coprerRec)
+	filter := bson.M{"$or": []bson.M{{"file_id": fileId}, {"deliveries.file_id": fileId}}}
+	err := db.Collection(COPERNICUSCOLLECTION).FindOne(context.Background(), filter).Decode(&coprerRec)
 	return coprerRec, err
 }
 
-// DeleteOneByID is to delete an file from a particular collection by _id.
+// GetCursorByRequester is to get a cursor with the records requested by a user.
+// Records created before requesters were tracked are included too.
+func (copernicustore *CopernicusStore) GetCursorByRequester(subject string) (*mongo.Cursor, error) {
+	filter := bson.M{"$or": []bson.M{{"requesters": subject}, {"deliveries.subject": subject}, {"requesters": bson.M{"$exists": false}}}}
+	cursor, err := db.Collection(COPERNICUSCOLLECTION).Find(context.Background(), filter)
+	return cursor, err
+}
+
+// AddRequester is to add a user to the requesters of a record.
+func (copernicustore *CopernicusStore) AddRequester(inputId string, subject string) error {
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": inputId}, bson.M{"$addToSet": bson.M{"requesters": subject}})
+	return err
+}
+
+// AddSubscriber is to add a user to the users notified when the job of a record ends.
+func (copernicustore *CopernicusStore) AddSubscriber(inputId string, subject string) error {
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": inputId}, bson.M{"$addToSet": bson.M{"subscribers": subject}})
+	return err
+}
+
+// AddDelivery is to add a delivery to a record.
+func (copernicustore *CopernicusStore) AddDelivery(inputId string, delivery models.CopernicusDelivery) error {
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": inputId}, bson.M{"$push": bson.M{"deliveries": delivery}})
+	return err
+}
+
+// MarkDelivered is to mark the delivery of a file as delivered.
+func (copernicustore *CopernicusStore) MarkDelivered(inputId string, fileId string) error {
+	filter := bson.M{"_id": inputId, "deliveries.file_id": fileId}
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"deliveries.$.delivered": true}})
+	return err
+}
+
+// RemoveDelivery is to remove the delivery of a file from a record.
+func (copernicustore *CopernicusStore) RemoveDelivery(inputId string, fileId string) error {
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": inputId}, bson.M{"$pull": bson.M{"deliveries": bson.M{"file_id": fileId}}})
+	return err
+}
+
+// RemoveRequester is to remove a user from the requesters and subscribers of a record, along with
+// the deliveries still waiting for the dataset that they asked for.
+func (copernicustore *CopernicusStore) RemoveRequester(inputId string, subject string) error {
+	update := bson.M{"$pull": bson.M{
+		"requesters":  subject,
+		"subscribers": subject,
+		"deliveries":  bson.M{"subject": subject, "delivered": false},
+	}}
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": inputId}, update)
+	return err
+}
+
+// SetError is to save why the job of a record failed.
+func (copernicustore *CopernicusStore) SetError(inputId string, message string) error {
+	_, err := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": inputId}, bson.M{"$set": bson.M{"error": message}})
+	return err
+}
+
+// DeleteOneByID is to delete a record by its fingerprint.
+func (copernicustore *CopernicusStore) DeleteOneByID(inputId string) error {
+	_, err := db.Collection(COPERNICUSCOLLECTION).DeleteOne(context.Background(), bson.M{"_id": inputId})
+	return err
+}
+
+// DeleteOneByFileID is to delete the record of a reference file.
 func (copernicustore *CopernicusStore) DeleteOneByFileID(fileID string) error {
 	res, err := db.Collection(COPERNICUSCOLLECTION).DeleteOne(context.Background(), bson.M{"file_id": fileID})
 	// If no document matched the deletion filter
@@ -57,10 +154,13 @@ func (copernicustore *CopernicusStore) UpdateWithId(copenicus_input models.Coper
 	filter := bson.M{"_id": copenicus_input.Id}
 	update := bson.M{
 		"$set": bson.M{
-			"file_id":      copenicus_input.FileId,
-			"dataset_name": copenicus_input.DatasetName,
-			"parameters":   copenicus_input.RequestParams,
-			"details":      copenicus_input.Details,
+			"file_id":       copenicus_input.FileId,
+			"dataset_name":  copenicus_input.DatasetName,
+			"parameters":    copenicus_input.RequestParams,
+			"details":       copenicus_input.Details,
+			"log":           copenicus_input.Log,
+			"error":         copenicus_input.Error,
+			"date_creation": copenicus_input.DateCreation,
 		},
 	}
 	_, erro := db.Collection(COPERNICUSCOLLECTION).UpdateOne(context.TODO(), filter, update)
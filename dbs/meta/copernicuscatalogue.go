@@ -0,0 +1,98 @@
+package metaDB
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	COPERNICUSCATALOGUECOLLECTION      = "copernicuscatalogue"
+	COPERNICUSCATALOGUESTATECOLLECTION = "copernicuscataloguestate"
+)
+
+// ReplaceOne is to insert a dataset in the catalogue, replacing any previous one with the same ID.
+func (cataloguestore *CopernicusCatalogueStore) ReplaceOne(entry models.CatalogueEntry) error {
+	_, err := db.Collection(COPERNICUSCATALOGUECOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": entry.Id}, entry, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetOneByID is to get a dataset of the catalogue by ID.
+func (cataloguestore *CopernicusCatalogueStore) GetOneByID(entryID string) (models.CatalogueEntry, error) {
+	var entry models.CatalogueEntry
+	err := db.Collection(COPERNICUSCATALOGUECOLLECTION).FindOne(context.Background(), bson.M{"_id": entryID}).Decode(&entry)
+	return entry, err
+}
+
+// GetCursorByService is to get a cursor with the datasets of a service, in the service's order.
+func (cataloguestore *CopernicusCatalogueStore) GetCursorByService(service string) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(COPERNICUSCATALOGUECOLLECTION).Find(context.Background(), bson.M{"service": service}, options.Find().SetSort(bson.M{"position": 1}))
+	return cursor, err
+}
+
+// Search is to get a cursor with the datasets matching a query.
+func (cataloguestore *CopernicusCatalogueStore) Search(query models.CatalogueQuery) (*mongo.Cursor, error) {
+	filter := bson.M{}
+	if query.Service != "" {
+		filter["service"] = query.Service
+	}
+	var and []bson.M
+	if query.Keyword != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(query.Keyword), "$options": "i"}
+		and = append(and, bson.M{"$or": []bson.M{
+			{"collection_id": pattern},
+			{"title": pattern},
+			{"description": pattern},
+			{"keywords": pattern},
+		}})
+	}
+	if query.Variable != "" {
+		filter["variables"] = bson.M{"$regex": regexp.QuoteMeta(query.Variable), "$options": "i"}
+	}
+	// Datasets without a temporal coverage or extent are left out of temporal and spatial searches
+	if query.End != nil {
+		filter["temporal_start"] = bson.M{"$lte": *query.End}
+	}
+	if query.Start != nil {
+		and = append(and, bson.M{"$or": []bson.M{
+			{"temporal_end": bson.M{"$gte": *query.Start}},
+			{"temporal_end": nil, "temporal_start": bson.M{"$exists": true}},
+		}})
+	}
+	if area := query.Area; area != nil {
+		filter["extent.west"] = bson.M{"$lte": area.East}
+		filter["extent.east"] = bson.M{"$gte": area.West}
+		filter["extent.south"] = bson.M{"$lte": area.North}
+		filter["extent.north"] = bson.M{"$gte": area.South}
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	cursor, err := db.Collection(COPERNICUSCATALOGUECOLLECTION).Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "service", Value: 1}, {Key: "position", Value: 1}}))
+	return cursor, err
+}
+
+// DeleteStale is to delete the datasets of a service that weren't refreshed since a time, i.e. that the service no longer lists.
+func (cataloguestore *CopernicusCatalogueStore) DeleteStale(service string, refreshedBefore time.Time) error {
+	_, err := db.Collection(COPERNICUSCATALOGUECOLLECTION).DeleteMany(context.Background(), bson.M{"service": service, "date_refreshed": bson.M{"$lt": refreshedBefore}})
+	return err
+}
+
+// GetState is to get the outcome of the last refresh of the catalogue of a service.
+func (cataloguestore *CopernicusCatalogueStore) GetState(service string) (models.CatalogueState, error) {
+	var state models.CatalogueState
+	err := db.Collection(COPERNICUSCATALOGUESTATECOLLECTION).FindOne(context.Background(), bson.M{"_id": service}).Decode(&state)
+	return state, err
+}
+
+// SetState is to save the outcome of a refresh of the catalogue of a service.
+func (cataloguestore *CopernicusCatalogueStore) SetState(state models.CatalogueState) error {
+	_, err := db.Collection(COPERNICUSCATALOGUESTATECOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": state.Service}, state, options.Replace().SetUpsert(true))
+	return err
+}
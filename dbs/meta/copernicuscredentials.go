@@ -0,0 +1,44 @@
+package metaDB
+
+import (
+	"context"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	COPERNICUSCREDENTIALSCOLLECTION = "copernicuscredentials"
+)
+
+// ReplaceOne is to insert a credential in the copernicuscredentials collection, replacing any previous one with the same ID.
+func (credentialstore *CopernicusCredentialStore) ReplaceOne(credential models.CopernicusCredential) error {
+	_, err := db.Collection(COPERNICUSCREDENTIALSCOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": credential.Id}, credential, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetOneByID is to get a credential by ID.
+func (credentialstore *CopernicusCredentialStore) GetOneByID(credentialID string) (models.CopernicusCredential, error) {
+	var credential models.CopernicusCredential
+	err := db.Collection(COPERNICUSCREDENTIALSCOLLECTION).FindOne(context.Background(), bson.M{"_id": credentialID}).Decode(&credential)
+	return credential, err
+}
+
+// GetCursorByOwner is to get a cursor with the credentials of a user, or of a bucket if subject is empty.
+func (credentialstore *CopernicusCredentialStore) GetCursorByOwner(subject string, groupID string) (*mongo.Cursor, error) {
+	filter := bson.M{"subject": subject}
+	if subject == "" {
+		filter = bson.M{"group_id": groupID}
+	}
+	cursor, err := db.Collection(COPERNICUSCREDENTIALSCOLLECTION).Find(context.Background(), filter)
+	return cursor, err
+}
+
+// DeleteOneByID is to delete a credential by ID.
+func (credentialstore *CopernicusCredentialStore) DeleteOneByID(credentialID string) error {
+	_, err := db.Collection(COPERNICUSCREDENTIALSCOLLECTION).DeleteOne(context.Background(), bson.M{"_id": credentialID})
+	return err
+}
@@ -0,0 +1,141 @@
+package metaDB
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	COPERNICUSQUEUECOLLECTION = "copernicusqueue"
+)
+
+// ErrLeaseLost is returned when a worker no longer holds the lease of a task.
+var ErrLeaseLost = errors.New("lease of copernicus task lost")
+
+// Enqueue is to insert a task in the copernicusqueue collection, unless a task with the same ID
+// is there already: that task, and its lease, are kept.
+func (queuestore *CopernicusQueueStore) Enqueue(task models.CopernicusTask) error {
+	_, err := db.Collection(COPERNICUSQUEUECOLLECTION).InsertOne(context.Background(), task)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// Requeue is to insert a task in the copernicusqueue collection, replacing any previous task with
+// the same ID, so that a job submitted again is polled from the start.
+func (queuestore *CopernicusQueueStore) Requeue(task models.CopernicusTask) error {
+	_, err := db.Collection(COPERNICUSQUEUECOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": task.Id}, task, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetOneByID is to get a task by ID.
+func (queuestore *CopernicusQueueStore) GetOneByID(taskID string) (models.CopernicusTask, error) {
+	var task models.CopernicusTask
+	err := db.Collection(COPERNICUSQUEUECOLLECTION).FindOne(context.Background(), bson.M{"_id": taskID}).Decode(&task)
+	return task, err
+}
+
+// Claim is to atomically take the lease of the next due task. Tasks whose lease expired
+// (e.g. their worker died) can be claimed again. Returns mongo.ErrNoDocuments if no task is due.
+func (queuestore *CopernicusQueueStore) Claim(owner string, lease time.Duration) (models.CopernicusTask, error) {
+	now := time.Now()
+	filter := bson.M{
+		"state":       models.TaskQueued,
+		"next_poll":   bson.M{"$lte": now},
+		"lease_until": bson.M{"$lt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"lease_owner": owner,
+			"lease_until": now.Add(lease),
+			"heartbeat":   now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"next_poll": 1}).SetReturnDocument(options.After)
+
+	var task models.CopernicusTask
+	err := db.Collection(COPERNICUSQUEUECOLLECTION).FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&task)
+	return task, err
+}
+
+// Heartbeat is to extend the lease of a task held by owner.
+func (queuestore *CopernicusQueueStore) Heartbeat(taskID string, owner string, lease time.Duration) error {
+	now := time.Now()
+	filter := bson.M{"_id": taskID, "lease_owner": owner}
+	update := bson.M{"$set": bson.M{"lease_until": now.Add(lease), "heartbeat": now}}
+	res, err := db.Collection(COPERNICUSQUEUECOLLECTION).UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release is to give a task held by owner back to the queue, keeping its next poll time and attempts.
+func (queuestore *CopernicusQueueStore) Release(task models.CopernicusTask, owner string) error {
+	filter := bson.M{"_id": task.Id, "lease_owner": owner}
+	update := bson.M{
+		"$set": bson.M{
+			"lease_owner": "",
+			"lease_until": time.Time{},
+			"next_poll":   task.NextPoll,
+			"attempts":    task.Attempts,
+			"last_error":  task.LastError,
+		},
+	}
+	res, err := db.Collection(COPERNICUSQUEUECOLLECTION).UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Finish is to mark a task held by owner as done or failed.
+func (queuestore *CopernicusQueueStore) Finish(taskID string, owner string, state string, lastError string) error {
+	filter := bson.M{"_id": taskID, "lease_owner": owner}
+	update := bson.M{
+		"$set": bson.M{
+			"state":       state,
+			"lease_owner": "",
+			"lease_until": time.Time{},
+			"last_error":  lastError,
+			"date_update": time.Now(),
+		},
+	}
+	res, err := db.Collection(COPERNICUSQUEUECOLLECTION).UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Cancel is to fail a task whoever holds it. The lease owner finds out on its next heartbeat.
+func (queuestore *CopernicusQueueStore) Cancel(taskID string, lastError string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"state":       models.TaskFailed,
+			"lease_owner": "",
+			"lease_until": time.Time{},
+			"last_error":  lastError,
+			"date_update": time.Now(),
+		},
+	}
+	_, err := db.Collection(COPERNICUSQUEUECOLLECTION).UpdateOne(context.Background(), bson.M{"_id": taskID}, update)
+	return err
+}
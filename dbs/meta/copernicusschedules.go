@@ -0,0 +1,126 @@
+package metaDB
+
+import (
+	"context"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	COPERNICUSSCHEDULESCOLLECTION = "copernicusschedules"
+	COPERNICUSRUNSCOLLECTION      = "copernicusruns"
+)
+
+// InsertOne is to insert a schedule in the schedules collection
+func (schedulestore *CopernicusScheduleStore) InsertOne(schedule models.CopernicusSchedule) error {
+	_, err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).InsertOne(context.Background(), schedule)
+	return err
+}
+
+// GetOneByID is to get a schedule by ID.
+func (schedulestore *CopernicusScheduleStore) GetOneByID(scheduleID string) (models.CopernicusSchedule, error) {
+	var schedule models.CopernicusSchedule
+	err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).FindOne(context.Background(), bson.M{"_id": scheduleID}).Decode(&schedule)
+	return schedule, err
+}
+
+// GetCursorBySubject is to get a cursor with the schedules of a user.
+func (schedulestore *CopernicusScheduleStore) GetCursorBySubject(subject string) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).Find(context.Background(), bson.M{"subject": subject})
+	return cursor, err
+}
+
+// UpdateAccess is to save the access of a user on all of their schedules.
+func (schedulestore *CopernicusScheduleStore) UpdateAccess(subject string, access models.ScheduleAccess) error {
+	_, err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).UpdateMany(context.Background(), bson.M{"subject": subject}, bson.M{"$set": bson.M{"access": access}})
+	return err
+}
+
+// SetPaused is to pause or resume a schedule. Resuming resets its failures and next run.
+func (schedulestore *CopernicusScheduleStore) SetPaused(scheduleID string, paused bool, reason string, nextRun time.Time) error {
+	set := bson.M{"paused": paused, "pause_reason": reason}
+	if !paused {
+		set["failures"] = 0
+		set["next_run"] = nextRun
+	}
+	_, err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": scheduleID}, bson.M{"$set": set})
+	return err
+}
+
+// DeleteOneByID is to delete a schedule and its runs.
+func (schedulestore *CopernicusScheduleStore) DeleteOneByID(scheduleID string) error {
+	if _, err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).DeleteOne(context.Background(), bson.M{"_id": scheduleID}); err != nil {
+		return err
+	}
+	_, err := db.Collection(COPERNICUSRUNSCOLLECTION).DeleteMany(context.Background(), bson.M{"schedule_id": scheduleID})
+	return err
+}
+
+// Claim is to take the lease of the next due schedule that isn't paused.
+func (schedulestore *CopernicusScheduleStore) Claim(owner string, lease time.Duration) (models.CopernicusSchedule, error) {
+	now := time.Now()
+	filter := bson.M{
+		"paused":      false,
+		"next_run":    bson.M{"$lte": now},
+		"lease_until": bson.M{"$lt": now},
+	}
+	update := bson.M{"$set": bson.M{"lease_owner": owner, "lease_until": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"next_run": 1}).SetReturnDocument(options.After)
+
+	var schedule models.CopernicusSchedule
+	err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&schedule)
+	return schedule, err
+}
+
+// Finish is to save the outcome of a run of a schedule held by owner and release its lease.
+// A pause reason pauses the schedule.
+func (schedulestore *CopernicusScheduleStore) Finish(schedule models.CopernicusSchedule, owner string, pauseReason string) error {
+	set := bson.M{
+		"next_run":    schedule.NextRun,
+		"last_run":    schedule.LastRun,
+		"failures":    schedule.Failures,
+		"lease_owner": "",
+		"lease_until": time.Time{},
+	}
+	if pauseReason != "" {
+		set["paused"] = true
+		set["pause_reason"] = pauseReason
+	}
+	res, err := db.Collection(COPERNICUSSCHEDULESCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": schedule.Id, "lease_owner": owner}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// InsertRun is to insert a run of a schedule.
+func (schedulestore *CopernicusScheduleStore) InsertRun(run models.CopernicusScheduleRun) error {
+	_, err := db.Collection(COPERNICUSRUNSCOLLECTION).InsertOne(context.Background(), run)
+	return err
+}
+
+// UpdateRun is to save the state of a run.
+func (schedulestore *CopernicusScheduleStore) UpdateRun(run models.CopernicusScheduleRun) error {
+	update := bson.M{"$set": bson.M{"state": run.State, "error": run.Error, "date_update": time.Now()}}
+	_, err := db.Collection(COPERNICUSRUNSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": run.Id}, update)
+	return err
+}
+
+// GetCursorRuns is to get a cursor with the runs of a schedule, oldest first. An empty state matches any state.
+func (schedulestore *CopernicusScheduleStore) GetCursorRuns(scheduleID string, state string) (*mongo.Cursor, error) {
+	filter := bson.M{"schedule_id": scheduleID}
+	if state != "" {
+		filter["state"] = state
+	}
+	opts := options.Find().SetSort(bson.M{"scheduled": 1})
+	cursor, err := db.Collection(COPERNICUSRUNSCOLLECTION).Find(context.Background(), filter, opts)
+	return cursor, err
+}
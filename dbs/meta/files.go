@@ -7,6 +7,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -15,6 +16,7 @@ const (
 
 // InsertOne is to insert an file in the files collection
 func (filestore *FileStore) InsertOne(file models.File) error {
+	file.TitleKey = TitleKey(file.Meta.Title)
 	_, err := db.Collection(FILESCOLLECTION).InsertOne(context.Background(), file)
 	return err
 }
@@ -35,15 +37,6 @@ func (filestore *FileStore) GetOneByID(fileID string) (models.File, error) {
 	return file, err
 }
 
-// GetOneByFingerprint is to get a file by its taskID.
-func (filestore *FileStore) GetOneByFingerprint(fingerprint string) (models.File, error) {
-
-	var file models.File
-
-	err := db.Collection(FILESCOLLECTION).FindOne(context.Background(), bson.M{"copernicus_details.fingerprint": fingerprint}).Decode(&file)
-	return file, err
-}
-
 // GetCursorByFolderID is to get a cursor with files from a particular folder.
 func (filestore *FileStore) GetCursorByFolderID(folderID string) (*mongo.Cursor, error) {
 
@@ -73,6 +66,7 @@ func (filestore *FileStore) UpdateWithId(file models.File) (objUpdated models.Fi
 			"file_type":      file.FileType,
 			"size":           file.Size,
 			"total":          file.Total,
+			"title_key":      TitleKey(file.Meta.Title),
 		},
 	}
 	_, erro := db.Collection(FILESCOLLECTION).UpdateOne(context.TODO(), filter, update)
@@ -80,6 +74,13 @@ func (filestore *FileStore) UpdateWithId(file models.File) (objUpdated models.Fi
 	return file, erro
 }
 
+// SetProvenance is to record the origin of a file. It is set apart from the other fields so
+// that metadata updates can't change it.
+func (filestore *FileStore) SetProvenance(fileID string, provenance *models.CopernicusProvenance) error {
+	_, err := db.Collection(FILESCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": fileID}, bson.M{"$set": bson.M{"provenance": provenance}})
+	return err
+}
+
 func (filestore *FileStore) UpdateFileSize(fileID string, size int) (objUpdated models.File, err error) {
 	filestore.mu.Lock()
 
@@ -106,3 +107,67 @@ func (filestore *FileStore) DeleteManyWithAncestore(ancestore string) error {
 	_, err := db.Collection(FILESCOLLECTION).DeleteMany(context.Background(), bson.M{"ancestors": ancestore})
 	return err
 }
+
+// GetCursorByQuery is to get a cursor with a page of the files of a folder, filtered and sorted.
+func (filestore *FileStore) GetCursorByQuery(query models.ListQuery) (*mongo.Cursor, error) {
+	filter := pageFilter(listFilter("folder", query), query)
+	cursor, err := db.Collection(FILESCOLLECTION).Find(context.Background(), filter, listOptions(query))
+	return cursor, err
+}
+
+// CountByQuery is to count the files of a folder that match the filters of a query, on all pages.
+func (filestore *FileStore) CountByQuery(query models.ListQuery) (int64, error) {
+	return db.Collection(FILESCOLLECTION).CountDocuments(context.Background(), listFilter("folder", query))
+}
+
+// Search is to get a cursor with the files in the scopes of a search that match it, sorted.
+func (filestore *FileStore) Search(query models.SearchQuery) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(FILESCOLLECTION).Find(context.Background(), searchFilter(query, "ancestors"), searchOptions(query))
+	return cursor, err
+}
+
+// CountSearch is to count the files in the scopes of a search that match it, on all pages.
+func (filestore *FileStore) CountSearch(query models.SearchQuery) (int64, error) {
+	return db.Collection(FILESCOLLECTION).CountDocuments(context.Background(), searchFilter(query, "ancestors"))
+}
+
+// GetCursorByIDs is to get a cursor with the files of some IDs.
+func (filestore *FileStore) GetCursorByIDs(fileIDs []string) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(FILESCOLLECTION).Find(context.Background(), bson.M{"_id": bson.M{"$in": fileIDs}})
+	return cursor, err
+}
+
+// GetOneByPath is to get the file at a path. Should a folder hold the same title twice, the
+// first file created is returned.
+func (filestore *FileStore) GetOneByPath(path string) (models.File, error) {
+	var file models.File
+	opts := options.FindOne().SetSort(bson.D{{Key: "meta.date_creation", Value: 1}, {Key: "_id", Value: 1}})
+	err := db.Collection(FILESCOLLECTION).FindOne(context.Background(), bson.M{"path": path}, opts).Decode(&file)
+	return file, err
+}
+
+// SetPath is to set the path of a file. It is set apart from the other fields, as it follows
+// from the titles of the file and its ancestors.
+func (filestore *FileStore) SetPath(fileID string, path string) error {
+	_, err := db.Collection(FILESCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": fileID}, bson.M{"$set": bson.M{"path": path}})
+	return err
+}
+
+// ReplacePathPrefix is to change the paths of the files under a folder whose path changed.
+func (filestore *FileStore) ReplacePathPrefix(folderID string, oldPath string, newPath string) error {
+	return replacePathPrefix(FILESCOLLECTION, folderID, oldPath, newPath)
+}
+
+// GetOneByFolderTitle is to get the file that holds a title in a folder.
+func (filestore *FileStore) GetOneByFolderTitle(folderID string, title string) (models.File, error) {
+	var file models.File
+	err := db.Collection(FILESCOLLECTION).FindOne(context.Background(), bson.M{"folder": folderID, "title_key": TitleKey(title)}).Decode(&file)
+	return file, err
+}
+
+// GetTree is to get a cursor with the files under a folder that the caller can read, down to a
+// depth, sorted by path.
+func (filestore *FileStore) GetTree(query models.TreeQuery) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(FILESCOLLECTION).Find(context.Background(), treeFilter(query, "ancestors"), treeOptions("path"))
+	return cursor, err
+}
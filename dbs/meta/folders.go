@@ -8,6 +8,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -16,7 +17,9 @@ const (
 
 // InsertOne is to insert an folder in the folders collection
 func (folderstore *FolderStore) InsertOne(folder models.Folder) error {
-
+	if folder.Parent != "" {
+		folder.TitleKey = TitleKey(folder.Meta.Title)
+	}
 	_, err := db.Collection(FOLDERSSCOLLECTION).InsertOne(context.Background(), folder)
 	return err
 }
@@ -56,7 +59,7 @@ func (folderstore *FolderStore) GetCursorByParent(parentID string) (*mongo.Curso
 
 func (folderstore *FolderStore) UpdateFiles(fileId string, folderID string) error {
 	folderstore.mu.Lock()
-	_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folderID}, bson.D{{"$push", bson.M{"files": fileId}}})
+	_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folderID}, bson.D{{Key: "$push", Value: bson.M{"files": fileId}}})
 	folderstore.mu.Unlock()
 	return err
 }
@@ -79,6 +82,9 @@ func (folderstore *FolderStore) UpdateWithId(folder models.Folder) (folderUpdate
 			"size":      folder.Size,
 		},
 	}
+	if folder.Parent != "" {
+		update["$set"].(bson.M)["title_key"] = TitleKey(folder.Meta.Title)
+	}
 
 	_, erro := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.TODO(), filter, update)
 	folderstore.mu.Unlock()
@@ -126,7 +132,7 @@ func (folderstore *FolderStore) UpdateMetaAncestors(ancestors []string, userID s
 			newMeta.Update.User = userID
 			newMeta.Update.Date = time.Now()
 
-			_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folder.Id}, bson.D{{"$set", bson.M{"meta": newMeta}}})
+			_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folder.Id}, bson.D{{Key: "$set", Value: bson.M{"meta": newMeta}}})
 			if err != nil {
 				break
 			}
@@ -168,7 +174,7 @@ func (folderstore *FolderStore) UpdateAncestorSize(ancestors []string, size int6
 				newSize = folder.Size - size
 			}
 
-			_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folder.Id}, bson.D{{"$set", bson.M{"size": newSize}}})
+			_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folder.Id}, bson.D{{Key: "$set", Value: bson.M{"size": newSize}}})
 			if err != nil {
 				break
 			}
@@ -191,3 +197,72 @@ func (folderstore *FolderStore) GetCursorByUserID(userID string) (*mongo.Cursor,
 	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), bson.M{"meta.creator": userID})
 	return cursor, err
 }
+
+// GetCursorByQuery is to get a cursor with a page of the folders of a folder, filtered and sorted.
+func (folderstore *FolderStore) GetCursorByQuery(query models.ListQuery) (*mongo.Cursor, error) {
+	filter := pageFilter(listFilter("parent", query), query)
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), filter, listOptions(query))
+	return cursor, err
+}
+
+// CountByQuery is to count the folders of a folder that match the filters of a query, on all pages.
+func (folderstore *FolderStore) CountByQuery(query models.ListQuery) (int64, error) {
+	return db.Collection(FOLDERSSCOLLECTION).CountDocuments(context.Background(), listFilter("parent", query))
+}
+
+// GetCursorByIDs is to get a cursor with the folders of some IDs.
+func (folderstore *FolderStore) GetCursorByIDs(folderIDs []string) (*mongo.Cursor, error) {
+	return getAncestors(folderIDs)
+}
+
+// GetCursorRootsByNames is to get a cursor with the root folders (buckets) of some groups.
+func (folderstore *FolderStore) GetCursorRootsByNames(names []string) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), bson.M{"meta.title": bson.M{"$in": names}, "parent": "", "level": 0})
+	return cursor, err
+}
+
+// Search is to get a cursor with the folders in the scopes of a search that match it, sorted.
+// The scopes themselves match too.
+func (folderstore *FolderStore) Search(query models.SearchQuery) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), searchFilter(query, "_id", "ancestors"), searchOptions(query))
+	return cursor, err
+}
+
+// CountSearch is to count the folders in the scopes of a search that match it, on all pages.
+func (folderstore *FolderStore) CountSearch(query models.SearchQuery) (int64, error) {
+	return db.Collection(FOLDERSSCOLLECTION).CountDocuments(context.Background(), searchFilter(query, "_id", "ancestors"))
+}
+
+// GetCursorByPaths is to get a cursor with the folders at some paths, from the buckets down. Of
+// folders at the same path, the first created comes first.
+func (folderstore *FolderStore) GetCursorByPaths(paths []string) (*mongo.Cursor, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "level", Value: 1}, {Key: "meta.date_creation", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), bson.M{"path": bson.M{"$in": paths}}, opts)
+	return cursor, err
+}
+
+// SetPath is to set the path of a folder. It is set apart from the other fields, as it follows
+// from the titles of the folder and its ancestors.
+func (folderstore *FolderStore) SetPath(folderID string, path string) error {
+	_, err := db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folderID}, bson.M{"$set": bson.M{"path": path}})
+	return err
+}
+
+// ReplacePathPrefix is to change the paths of the folders under a folder whose path changed.
+func (folderstore *FolderStore) ReplacePathPrefix(folderID string, oldPath string, newPath string) error {
+	return replacePathPrefix(FOLDERSSCOLLECTION, folderID, oldPath, newPath)
+}
+
+// GetOneByParentTitle is to get the folder that holds a title in a parent folder.
+func (folderstore *FolderStore) GetOneByParentTitle(parentID string, title string) (models.Folder, error) {
+	var folder models.Folder
+	err := db.Collection(FOLDERSSCOLLECTION).FindOne(context.Background(), bson.M{"parent": parentID, "title_key": TitleKey(title)}).Decode(&folder)
+	return folder, err
+}
+
+// GetTree is to get a cursor with the folders under a folder that the caller can read, down to a
+// depth. They are sorted by level, so that each folder comes after the folder it is in.
+func (folderstore *FolderStore) GetTree(query models.TreeQuery) (*mongo.Cursor, error) {
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), treeFilter(query, "_id", "ancestors"), treeOptions("level", "path"))
+	return cursor, err
+}
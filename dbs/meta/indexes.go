@@ -0,0 +1,113 @@
+package metaDB
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// searchTextIndex weighs the words of titles over those of tags, and those of tags over those
+// of descriptions. Words are not stemmed, as titles are mostly file names.
+func searchTextIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meta.title", Value: "text"},
+			{Key: "meta.tags", Value: "text"},
+			{Key: "meta.description", Value: "text"},
+		},
+		Options: options.Index().
+			SetName("search_text").
+			SetWeights(bson.M{"meta.title": 10, "meta.tags": 5, "meta.description": 1}).
+			SetDefaultLanguage("none"),
+	}
+}
+
+// indexes are the indexes of each collection. Lookups by ancestor are served by the compound
+// indexes that start with the ancestors.
+var indexes = map[string][]mongo.IndexModel{
+	FILESCOLLECTION: {
+		searchTextIndex(),
+		{Keys: bson.D{{Key: "path", Value: 1}}},
+		{Keys: bson.D{{Key: "folder", Value: 1}}},
+		titleKeyIndex("folder"),
+		{Keys: bson.D{{Key: "folder", Value: 1}, {Key: "title_key", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "meta.title", Value: 1}}},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}, {Key: "meta.update.date", Value: -1}}},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}, {Key: "file_type", Value: 1}, {Key: "size", Value: 1}}},
+		{Keys: bson.D{{Key: "meta.creator", Value: 1}, {Key: "meta.date_creation", Value: -1}}},
+	},
+	FOLDERSSCOLLECTION: {
+		searchTextIndex(),
+		{Keys: bson.D{{Key: "path", Value: 1}, {Key: "level", Value: 1}}},
+		{Keys: bson.D{{Key: "parent", Value: 1}}},
+		titleKeyIndex("parent"),
+		{Keys: bson.D{{Key: "parent", Value: 1}, {Key: "title_key", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "meta.title", Value: 1}}},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}, {Key: "meta.update.date", Value: -1}}},
+		{Keys: bson.D{{Key: "meta.creator", Value: 1}, {Key: "meta.date_creation", Value: -1}}},
+	},
+	PARTSCOLLECTION: {
+		{Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "part_number", Value: 1}}},
+	},
+	CONTENTSCOLLECTION: {
+		{
+			Keys:    bson.D{{Key: "text", Value: "text"}},
+			Options: options.Index().SetName("content_text").SetDefaultLanguage("none"),
+		},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+	},
+	COPERNICUSSCHEDULESCOLLECTION: {
+		{Keys: bson.D{{Key: "subject", Value: 1}}},
+	},
+}
+
+// EnsureIndexes creates the indexes the API relies on, if they don't exist. Each index is created
+// on its own and its failure logged, so that the API still starts without the features that
+// need it. Titles can't be kept unique without their indexes: an error is returned if those
+// could not be built.
+func EnsureIndexes() error {
+	var missing []string
+	for collection, models := range indexes {
+		for _, model := range models {
+			name, err := db.Collection(collection).Indexes().CreateOne(context.Background(), model)
+			if err != nil {
+				log.Println("Could not create an index of "+collection+": ", err.Error())
+				if model.Options != nil && model.Options.Name != nil && *model.Options.Name == uniqueTitleIndex {
+					missing = append(missing, collection+"."+uniqueTitleIndex)
+				}
+				continue
+			}
+			log.Println("Index of "+collection+": ", name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("could not create the indexes %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// hasIndex tells if a collection has an index of the given name.
+func hasIndex(collection string, name string) (bool, error) {
+	cursor, err := db.Collection(collection).Indexes().List(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		var index struct {
+			Name string `bson:"name"`
+		}
+		if err = cursor.Decode(&index); err != nil {
+			return false, err
+		}
+		if index.Name == name {
+			return true, nil
+		}
+	}
+	return false, cursor.Err()
+}
@@ -0,0 +1,84 @@
+package metaDB
+
+import (
+	"time"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// listFilter is the filter of a folder listing, without its position. parentField is the field
+// that holds the parent folder of the items.
+func listFilter(parentField string, query models.ListQuery) bson.M {
+	filter := bson.M{parentField: query.Parent}
+	if query.FileType != "" {
+		filter["file_type"] = fileType(query.FileType)
+	}
+	if query.Tag != "" {
+		filter["meta.tags"] = query.Tag
+	}
+	if query.Creator != "" {
+		filter["meta.creator"] = query.Creator
+	}
+	if dates := dateRange(query.CreatedFrom, query.CreatedTo); dates != nil {
+		filter["meta.date_creation"] = dates
+	}
+	if dates := dateRange(query.UpdatedFrom, query.UpdatedTo); dates != nil {
+		filter["meta.update.date"] = dates
+	}
+	return filter
+}
+
+// pageFilter adds the position of a page to the filter of a listing: items after the position
+// in the sort order, with ties broken by _id.
+func pageFilter(filter bson.M, query models.ListQuery) bson.M {
+	if query.After == nil {
+		return filter
+	}
+	op := "$gt"
+	if query.Descending {
+		op = "$lt"
+	}
+	return bson.M{"$and": []bson.M{filter, {"$or": []bson.M{
+		{query.SortField: bson.M{op: query.After.Value}},
+		{query.SortField: query.After.Value, "_id": bson.M{op: query.After.Id}},
+	}}}}
+}
+
+// listOptions are the sort and limit of a listing. Names are sorted by their title keys, which
+// compare regardless of case, so that listings read naturally and no collation keeps the
+// queries off the indexes.
+func listOptions(query models.ListQuery) *options.FindOptions {
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+	opts := options.Find().SetSort(bson.D{{Key: query.SortField, Value: order}, {Key: "_id", Value: order}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+	return opts
+}
+
+// dateRange is a filter of dates in [from, to).
+func dateRange(from *time.Time, to *time.Time) bson.M {
+	if from == nil && to == nil {
+		return nil
+	}
+	dates := bson.M{}
+	if from != nil {
+		dates["$gte"] = *from
+	}
+	if to != nil {
+		dates["$lt"] = *to
+	}
+	return dates
+}
+
+// fileType matches a file extension given without its dot. Uploads keep the dot of the
+// extension, while Copernicus datasets are typed without it.
+func fileType(extension string) bson.M {
+	return bson.M{"$in": []string{extension, "." + extension}}
+}
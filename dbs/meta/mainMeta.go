@@ -38,8 +38,38 @@ type IFileStore interface {
 	// Add to the file size the parts size
 	UpdateFileSize(fileID string, size int) (objUpdated models.File, err error)
 
-	// Return Copernicus file by Fingerprint
-	GetOneByFingerprint(fingerprint string) (models.File, error)
+	// Record the origin of a file
+	SetProvenance(fileID string, provenance *models.CopernicusProvenance) error
+
+	// Get a page of the files of a folder
+	GetCursorByQuery(query models.ListQuery) (*mongo.Cursor, error)
+
+	// Count the files of a folder matching a query
+	CountByQuery(query models.ListQuery) (int64, error)
+
+	// Search the files of some folders
+	Search(query models.SearchQuery) (*mongo.Cursor, error)
+
+	// Count the files of some folders matching a search
+	CountSearch(query models.SearchQuery) (int64, error)
+
+	// Get the files of some IDs
+	GetCursorByIDs(fileIDs []string) (*mongo.Cursor, error)
+
+	// Get the file at a path
+	GetOneByPath(path string) (models.File, error)
+
+	// Set the path of a file
+	SetPath(fileID string, path string) error
+
+	// Change the paths of the files under a folder whose path changed
+	ReplacePathPrefix(folderID string, oldPath string, newPath string) error
+
+	// Get the file that holds a title in a folder
+	GetOneByFolderTitle(folderID string, title string) (models.File, error)
+
+	// Get the files under a folder, down to a depth
+	GetTree(query models.TreeQuery) (*mongo.Cursor, error)
 }
 
 // IFolderStore is a Database Interface for the Folders
@@ -79,6 +109,39 @@ type IFolderStore interface {
 
 	// GetCursorByUserID is to get a cursor with folders given the user ID of the creator.
 	GetCursorByUserID(userID string) (*mongo.Cursor, error)
+
+	// GetCursorByQuery is to get a page of the subfolders of a folder
+	GetCursorByQuery(query models.ListQuery) (*mongo.Cursor, error)
+
+	// CountByQuery is to count the subfolders of a folder matching a query
+	CountByQuery(query models.ListQuery) (int64, error)
+
+	// GetCursorByIDs is to get a cursor with the folders of some IDs
+	GetCursorByIDs(folderIDs []string) (*mongo.Cursor, error)
+
+	// GetCursorRootsByNames is to get a cursor with the root folders of some groups
+	GetCursorRootsByNames(names []string) (*mongo.Cursor, error)
+
+	// Search is to search the folders under some folders
+	Search(query models.SearchQuery) (*mongo.Cursor, error)
+
+	// CountSearch is to count the folders under some folders matching a search
+	CountSearch(query models.SearchQuery) (int64, error)
+
+	// GetCursorByPaths is to get the folders at some paths
+	GetCursorByPaths(paths []string) (*mongo.Cursor, error)
+
+	// SetPath is to set the path of a folder
+	SetPath(folderID string, path string) error
+
+	// ReplacePathPrefix is to change the paths of the folders under a folder whose path changed
+	ReplacePathPrefix(folderID string, oldPath string, newPath string) error
+
+	// GetOneByParentTitle is to get the folder that holds a title in a parent folder
+	GetOneByParentTitle(parentID string, title string) (models.Folder, error)
+
+	// GetTree is to get the folders under a folder, down to a depth
+	GetTree(query models.TreeQuery) (*mongo.Cursor, error)
 }
 
 // IPartStore is a Database Interface for the Sessions
@@ -101,6 +164,9 @@ type IPartStore interface {
 
 	// Delete parts related to certain bucket
 	DeleteManyWithBucket(bucketId string) error
+
+	// Count the parts uploaded for a file
+	CountByFileID(fileID string) (int64, error)
 }
 
 // ICopernicusStore is a Database Interface for Copernicus inputs
@@ -109,13 +175,46 @@ type ICopernicusStore interface {
 	// Insert a new stream
 	InsertOne(copenicus_input models.CopernicusRecord) error
 
+	// Insert or replace a record
+	ReplaceOne(copenicus_input models.CopernicusRecord) error
+
+	// Claim the submission of a request, unless another submission claimed it first
+	ClaimSubmission(record models.CopernicusRecord, previous *models.CopernicusRecord) (bool, error)
+
 	// Get a part by ID
 	GetOneByID(inputId string) (models.CopernicusRecord, error)
 
-	// Get one by reference file
+	// Get one by reference file or delivered file
 	GetOneByFileID(fileId string) (models.CopernicusRecord, error)
 
+	// Get a cursor of the records requested by a user
+	GetCursorByRequester(subject string) (*mongo.Cursor, error)
+
+	// Add a user to the requesters of a record
+	AddRequester(inputId string, subject string) error
+
+	// Add a user to the users notified when the job of a record ends
+	AddSubscriber(inputId string, subject string) error
+
+	// Add a delivery to a record
+	AddDelivery(inputId string, delivery models.CopernicusDelivery) error
+
+	// Mark a delivery as delivered
+	MarkDelivered(inputId string, fileId string) error
+
+	// Remove a delivery from a record
+	RemoveDelivery(inputId string, fileId string) error
+
+	// Remove a user from the requesters and subscribers of a record, with their pending deliveries
+	RemoveRequester(inputId string, subject string) error
+
+	// Save why the job of a record failed
+	SetError(inputId string, message string) error
+
 	// Delete by _id
+	DeleteOneByID(inputId string) error
+
+	// Delete by reference file
 	DeleteOneByFileID(inputId string) error
 
 	// Update with id
@@ -125,6 +224,171 @@ type ICopernicusStore interface {
 	GetCursorAll() (*mongo.Cursor, error)
 }
 
+// ICopernicusQueueStore is a Database Interface for the Copernicus task queue
+type ICopernicusQueueStore interface {
+
+	// Insert a task unless one with the same ID exists
+	Enqueue(task models.CopernicusTask) error
+
+	// Insert or replace a task
+	Requeue(task models.CopernicusTask) error
+
+	// Get a task by ID
+	GetOneByID(taskID string) (models.CopernicusTask, error)
+
+	// Claim the next due task whose lease is free
+	Claim(owner string, lease time.Duration) (models.CopernicusTask, error)
+
+	// Extend the lease of a claimed task
+	Heartbeat(taskID string, owner string, lease time.Duration) error
+
+	// Give a claimed task back to the queue
+	Release(task models.CopernicusTask, owner string) error
+
+	// Mark a claimed task as done or failed
+	Finish(taskID string, owner string, state string, lastError string) error
+
+	// Fail a task regardless of its lease
+	Cancel(taskID string, lastError string) error
+}
+
+// ICopernicusScheduleStore is a Database Interface for recurring Copernicus requests and their runs
+type ICopernicusScheduleStore interface {
+
+	// Insert a new schedule
+	InsertOne(schedule models.CopernicusSchedule) error
+
+	// Get a schedule by ID
+	GetOneByID(scheduleID string) (models.CopernicusSchedule, error)
+
+	// Get a cursor of the schedules of a user
+	GetCursorBySubject(subject string) (*mongo.Cursor, error)
+
+	// Save the access of a user on all of their schedules
+	UpdateAccess(subject string, access models.ScheduleAccess) error
+
+	// Pause or resume a schedule
+	SetPaused(scheduleID string, paused bool, reason string, nextRun time.Time) error
+
+	// Delete a schedule and its runs
+	DeleteOneByID(scheduleID string) error
+
+	// Claim the next due schedule whose lease is free
+	Claim(owner string, lease time.Duration) (models.CopernicusSchedule, error)
+
+	// Save the outcome of a run and release the lease
+	Finish(schedule models.CopernicusSchedule, owner string, pauseReason string) error
+
+	// Insert a run
+	InsertRun(run models.CopernicusScheduleRun) error
+
+	// Update the state of a run
+	UpdateRun(run models.CopernicusScheduleRun) error
+
+	// Get a cursor of the runs of a schedule
+	GetCursorRuns(scheduleID string, state string) (*mongo.Cursor, error)
+}
+
+// IAPIKeyStore is a Database Interface for the API keys of machine clients
+type IAPIKeyStore interface {
+
+	// Insert a new API key
+	InsertOne(apiKey models.APIKey) error
+
+	// Get an API key by ID
+	GetOneByID(keyID string) (models.APIKey, error)
+
+	// Get a non-revoked API key by the hash of the key
+	GetActiveByHash(hash string) (models.APIKey, error)
+
+	// Get a cursor of the API keys of a bucket
+	GetCursorByGroup(groupID string) (*mongo.Cursor, error)
+
+	// Revoke an API key
+	Revoke(keyID string) (models.APIKey, error)
+
+	// Record the last time an API key was used
+	UpdateLastUsed(keyID string, lastUsed time.Time) error
+}
+
+// ICopernicusCredentialStore is a Database Interface for the Copernicus API keys of users and groups
+type ICopernicusCredentialStore interface {
+
+	// Insert or replace a credential
+	ReplaceOne(credential models.CopernicusCredential) error
+
+	// Get a credential by ID
+	GetOneByID(credentialID string) (models.CopernicusCredential, error)
+
+	// Get a cursor of the credentials of a user, or of a bucket if subject is empty
+	GetCursorByOwner(subject string, groupID string) (*mongo.Cursor, error)
+
+	// Delete a credential by ID
+	DeleteOneByID(credentialID string) error
+}
+
+// ICopernicusCatalogueStore is a Database Interface for the cached catalogue of the Copernicus services
+type ICopernicusCatalogueStore interface {
+
+	// Insert or replace a dataset
+	ReplaceOne(entry models.CatalogueEntry) error
+
+	// Get a dataset by ID
+	GetOneByID(entryID string) (models.CatalogueEntry, error)
+
+	// Get a cursor of the datasets of a service
+	GetCursorByService(service string) (*mongo.Cursor, error)
+
+	// Get a cursor of the datasets matching a query
+	Search(query models.CatalogueQuery) (*mongo.Cursor, error)
+
+	// Delete the datasets of a service not refreshed since a time
+	DeleteStale(service string, refreshedBefore time.Time) error
+
+	// Get the outcome of the last refresh of a service
+	GetState(service string) (models.CatalogueState, error)
+
+	// Save the outcome of a refresh of a service
+	SetState(state models.CatalogueState) error
+}
+
+// INotificationStore is a Database Interface for the notification preferences of users
+type INotificationStore interface {
+
+	// Insert or replace the preferences of a user
+	ReplaceOne(preferences models.NotificationPreferences) error
+
+	// Get the preferences of a user
+	GetOneBySubject(subject string) (models.NotificationPreferences, error)
+
+	// Delete the preferences of a user
+	DeleteOneBySubject(subject string) error
+}
+
+// IContentStore is a Database Interface for the text extracted from files
+type IContentStore interface {
+	// ReplaceOne is to store the content of a file
+	ReplaceOne(content models.FileContent) error
+
+	// GetOneByID is to get the content of a file
+	GetOneByID(fileID string) (models.FileContent, error)
+
+	// DeleteOneByID is to delete the content of a file
+	DeleteOneByID(fileID string) error
+
+	// DeleteManyWithAncestore is to delete the content of the files under a folder
+	DeleteManyWithAncestore(ancestore string) error
+
+	// UpdatePlacement is to move the content of a file along with the file
+	UpdatePlacement(fileID string, folderID string, ancestors []string) error
+
+	// Search is to search the contents under some folders
+	Search(query models.ContentQuery) (*mongo.Cursor, error)
+
+	// CountSearch is to count the contents under some folders matching a search
+	CountSearch(query models.ContentQuery) (int64, error)
+}
+
 // FileStore ...
 type FileStore struct {
 	mu sync.RWMutex
@@ -141,10 +405,31 @@ type PartStore struct {
 }
 
 // FolderStore ...
+type CopernicusQueueStore struct {
+}
+
+type CopernicusScheduleStore struct {
+}
+
 type CopernicusStore struct {
 	mu sync.RWMutex
 }
 
+// APIKeyStore ...
+type APIKeyStore struct{}
+
+// CopernicusCredentialStore ...
+type CopernicusCredentialStore struct{}
+
+// CopernicusCatalogueStore ...
+type CopernicusCatalogueStore struct{}
+
+// ContentStore ...
+type ContentStore struct{}
+
+// NotificationStore ...
+type NotificationStore struct{}
+
 // db is a Client of mongoDB
 var db *mongo.Database
 
@@ -0,0 +1,33 @@
+package metaDB
+
+import (
+	"context"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	NOTIFICATIONSCOLLECTION = "notificationpreferences"
+)
+
+// ReplaceOne is to insert the notification preferences of a user, replacing their previous ones.
+func (notificationstore *NotificationStore) ReplaceOne(preferences models.NotificationPreferences) error {
+	_, err := db.Collection(NOTIFICATIONSCOLLECTION).ReplaceOne(context.Background(), bson.M{"_id": preferences.Subject}, preferences, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetOneBySubject is to get the notification preferences of a user.
+func (notificationstore *NotificationStore) GetOneBySubject(subject string) (models.NotificationPreferences, error) {
+	var preferences models.NotificationPreferences
+	err := db.Collection(NOTIFICATIONSCOLLECTION).FindOne(context.Background(), bson.M{"_id": subject}).Decode(&preferences)
+	return preferences, err
+}
+
+// DeleteOneBySubject is to delete the notification preferences of a user.
+func (notificationstore *NotificationStore) DeleteOneBySubject(subject string) error {
+	_, err := db.Collection(NOTIFICATIONSCOLLECTION).DeleteOne(context.Background(), bson.M{"_id": subject})
+	return err
+}
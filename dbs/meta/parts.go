@@ -63,3 +63,8 @@ func (partstore *PartStore) DeleteManyWithBucket(bucketId string) error {
 	_, err := db.Collection(PARTSCOLLECTION).DeleteMany(context.Background(), bson.M{"upload_info.bucket": bucketId})
 	return err
 }
+
+// CountByFileID is to count the parts uploaded for a file.
+func (partstore *PartStore) CountByFileID(fileID string) (int64, error) {
+	return db.Collection(PARTSCOLLECTION).CountDocuments(context.Background(), bson.M{"file_id": fileID})
+}
@@ -0,0 +1,103 @@
+package metaDB
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// replacePathPrefix changes the start of the paths of the items under a folder, from the old path
+// of the folder to its new one, in a single update.
+func replacePathPrefix(collection string, folderID string, oldPath string, newPath string) error {
+	// $substrCP counts code points, as RuneCountInString does
+	rest := bson.M{"$substrCP": bson.A{"$path", utf8.RuneCountInString(oldPath), bson.M{"$strLenCP": "$path"}}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"path": bson.M{"$concat": bson.A{newPath, rest}}}}},
+	}
+	_, err := db.Collection(collection).UpdateMany(context.Background(), bson.M{"ancestors": folderID, "path": bson.M{"$exists": true}}, update)
+	return err
+}
+
+// BackfillPaths sets the paths of the folders and files stored before they had one. Folders are
+// done from the buckets down, so that the path of each parent is known before its children's.
+// Items whose title contains "/" can't be named in a path: they, and the items under them, are
+// left without one until they are renamed and the API starts again.
+func BackfillPaths() {
+	paths := map[string]string{}
+	parentPath := func(folderID string) string {
+		if path, ok := paths[folderID]; ok {
+			return path
+		}
+		var folder models.Folder
+		db.Collection(FOLDERSSCOLLECTION).FindOne(context.Background(), bson.M{"_id": folderID}).Decode(&folder)
+		paths[folderID] = folder.Path
+		return folder.Path
+	}
+	missing := bson.M{"path": bson.M{"$exists": false}}
+
+	folders := 0
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), missing, options.Find().SetSort(bson.D{{Key: "level", Value: 1}}))
+	if err != nil {
+		log.Println("Could not backfill the paths of folders: ", err.Error())
+		return
+	}
+	for cursor.Next(context.Background()) {
+		var folder models.Folder
+		if err = cursor.Decode(&folder); err != nil {
+			continue
+		}
+		if strings.Contains(folder.Meta.Title, "/") {
+			log.Println("Could not backfill the path of folder " + folder.Id + `: its title contains "/"`)
+			continue
+		}
+		path := "/" + folder.Meta.Title
+		if folder.Parent != "" {
+			parent := parentPath(folder.Parent)
+			if parent == "" {
+				continue
+			}
+			path = parent + path
+		}
+		if _, err = db.Collection(FOLDERSSCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": folder.Id}, bson.M{"$set": bson.M{"path": path}}); err == nil {
+			paths[folder.Id] = path
+			folders++
+		}
+	}
+	cursor.Close(context.Background())
+
+	files := 0
+	cursor, err = db.Collection(FILESCOLLECTION).Find(context.Background(), missing)
+	if err != nil {
+		log.Println("Could not backfill the paths of files: ", err.Error())
+		return
+	}
+	for cursor.Next(context.Background()) {
+		var file models.File
+		if err = cursor.Decode(&file); err != nil {
+			continue
+		}
+		if strings.Contains(file.Meta.Title, "/") {
+			log.Println("Could not backfill the path of file " + file.Id + `: its title contains "/"`)
+			continue
+		}
+		parent := parentPath(file.FolderID)
+		if parent == "" {
+			continue
+		}
+		if _, err = db.Collection(FILESCOLLECTION).UpdateOne(context.Background(), bson.M{"_id": file.Id}, bson.M{"$set": bson.M{"path": parent + "/" + file.Meta.Title}}); err == nil {
+			files++
+		}
+	}
+	cursor.Close(context.Background())
+
+	if folders > 0 || files > 0 {
+		log.Printf("Backfilled the paths of %d folders and %d files", folders, files)
+	}
+}
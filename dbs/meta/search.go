@@ -0,0 +1,80 @@
+package metaDB
+
+import (
+	"regexp"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// searchFilter is the filter of a search. scopeFields are the fields that hold the folders an
+// item is in or under; items with any of the query's scopes in them match.
+func searchFilter(query models.SearchQuery, scopeFields ...string) bson.M {
+	scopes := query.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	var inScope []bson.M
+	for _, field := range scopeFields {
+		inScope = append(inScope, bson.M{field: bson.M{"$in": scopes}})
+	}
+	filter := bson.M{"$or": inScope}
+
+	if query.Text != "" {
+		filter["$text"] = bson.M{"$search": query.Text}
+	}
+	if query.Title != "" {
+		filter["meta.title"] = bson.M{"$regex": regexp.QuoteMeta(query.Title), "$options": "i"}
+	}
+	if len(query.Tags) > 0 {
+		filter["meta.tags"] = bson.M{"$all": query.Tags}
+	}
+	if query.FileType != "" {
+		filter["file_type"] = fileType(query.FileType)
+	}
+	if query.Creator != "" {
+		filter["meta.creator"] = query.Creator
+	}
+	if query.MinSize != nil || query.MaxSize != nil {
+		sizes := bson.M{}
+		if query.MinSize != nil {
+			sizes["$gte"] = *query.MinSize
+		}
+		if query.MaxSize != nil {
+			sizes["$lte"] = *query.MaxSize
+		}
+		filter["size"] = sizes
+	}
+	if dates := dateRange(query.CreatedFrom, query.CreatedTo); dates != nil {
+		filter["meta.date_creation"] = dates
+	}
+	if dates := dateRange(query.UpdatedFrom, query.UpdatedTo); dates != nil {
+		filter["meta.update.date"] = dates
+	}
+	return filter
+}
+
+// searchOptions are the projection, sort and limit of a search. Searches for words are sorted
+// by relevance unless another sort is asked for.
+func searchOptions(query models.SearchQuery) *options.FindOptions {
+	opts := options.Find()
+	if query.Text != "" {
+		opts.SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}})
+	}
+
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+	if query.SortField != "" {
+		opts.SetSort(bson.D{{Key: query.SortField, Value: order}, {Key: "_id", Value: order}})
+	} else if query.Text != "" {
+		opts.SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}})
+	}
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+	return opts
+}
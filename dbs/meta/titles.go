@@ -0,0 +1,161 @@
+package metaDB
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxNumberedTitles is how many numbered titles are tried for an item whose title is taken.
+const MaxNumberedTitles = 1000
+
+// TitleKey is the normalized title of an item, which is unique in its folder: composed, case
+// folded and trimmed, so that "Report.pdf" and "report.pdf " are the same title.
+func TitleKey(title string) string {
+	return cases.Fold().String(norm.NFC.String(strings.TrimSpace(title)))
+}
+
+// NumberedTitle is the nth title tried for an item whose title is taken: "name (n)", before the
+// extension of files. The 0th is the title itself.
+func NumberedTitle(title string, n int, file bool) string {
+	if n == 0 {
+		return title
+	}
+	ext := ""
+	if file {
+		ext = filepath.Ext(title)
+		// A title such as ".env" is all name
+		if ext == title {
+			ext = ""
+		}
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(title, ext), n, ext)
+}
+
+// IsTitleTaken tells if a write failed because the title of the item is taken in its folder.
+func IsTitleTaken(err error) bool {
+	return mongo.IsDuplicateKeyError(err)
+}
+
+// uniqueTitleIndex is the name of the index that keeps titles unique in a folder.
+const uniqueTitleIndex = "unique_title"
+
+// titleKeyIndex keeps titles unique among the items of a folder. Items without a key (buckets)
+// are left out. Files and folders are kept in their own collections, so a file and a folder
+// may share a title.
+func titleKeyIndex(parentField string) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: parentField, Value: 1}, {Key: "title_key", Value: 1}},
+		Options: options.Index().
+			SetName(uniqueTitleIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"title_key": bson.M{"$exists": true}}),
+	}
+}
+
+// MigrateTitleKeys sets the title keys of the folders and files stored before they had one. Items
+// whose title is taken in their folder are renamed as auto-renames are, the first created keeping
+// its title, and their paths follow. Each rename is logged.
+//
+// The migration is run once, by cmd/migrate-titles, rather than at each startup. It relies on the
+// unique title indexes to find the titles that are taken, and is refused if they don't exist.
+func MigrateTitleKeys() error {
+	for _, collection := range []string{FOLDERSSCOLLECTION, FILESCOLLECTION} {
+		exists, err := hasIndex(collection, uniqueTitleIndex)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%s has no %s index", collection, uniqueTitleIndex)
+		}
+	}
+
+	sort := options.Find().SetSort(bson.D{{Key: "level", Value: 1}, {Key: "meta.date_creation", Value: 1}, {Key: "_id", Value: 1}})
+	missing := bson.M{"$exists": false}
+
+	folders, renamed := 0, 0
+	cursor, err := db.Collection(FOLDERSSCOLLECTION).Find(context.Background(), bson.M{"title_key": missing, "parent": bson.M{"$ne": ""}}, sort)
+	if err != nil {
+		return err
+	}
+	for cursor.Next(context.Background()) {
+		var folder models.Folder
+		if err = cursor.Decode(&folder); err != nil {
+			log.Println("Could not read a folder: ", err.Error())
+			continue
+		}
+		title, err := backfillTitleKey(FOLDERSSCOLLECTION, folder.Id, folder.Meta.Title, folder.Path, false)
+		if err != nil {
+			log.Println("Could not set the title key of folder "+folder.Id+": ", err.Error())
+			continue
+		}
+		if title != folder.Meta.Title {
+			newPath := strings.TrimSuffix(folder.Path, folder.Meta.Title) + title
+			if folder.Path != "" {
+				replacePathPrefix(FOLDERSSCOLLECTION, folder.Id, folder.Path, newPath)
+				replacePathPrefix(FILESCOLLECTION, folder.Id, folder.Path, newPath)
+			}
+			log.Printf("Renamed folder %s in %s from %q to %q", folder.Id, folder.Parent, folder.Meta.Title, title)
+			renamed++
+		}
+		folders++
+	}
+	cursor.Close(context.Background())
+
+	files := 0
+	cursor, err = db.Collection(FILESCOLLECTION).Find(context.Background(), bson.M{"title_key": missing}, options.Find().SetSort(bson.D{{Key: "meta.date_creation", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	for cursor.Next(context.Background()) {
+		var file models.File
+		if err = cursor.Decode(&file); err != nil {
+			log.Println("Could not read a file: ", err.Error())
+			continue
+		}
+		title, err := backfillTitleKey(FILESCOLLECTION, file.Id, file.Meta.Title, file.Path, true)
+		if err != nil {
+			log.Println("Could not set the title key of file "+file.Id+": ", err.Error())
+			continue
+		}
+		if title != file.Meta.Title {
+			log.Printf("Renamed file %s in %s from %q to %q", file.Id, file.FolderID, file.Meta.Title, title)
+			renamed++
+		}
+		files++
+	}
+	cursor.Close(context.Background())
+
+	log.Printf("Set the title keys of %d folders and %d files, renaming %d", folders, files, renamed)
+	return nil
+}
+
+// backfillTitleKey sets the title key of an item, numbering its title (and the end of its path)
+// while the title is taken. It returns the title the item ends up with.
+func backfillTitleKey(collection string, id string, title string, path string, file bool) (string, error) {
+	for n := 0; n <= MaxNumberedTitles; n++ {
+		numbered := NumberedTitle(title, n, file)
+		set := bson.M{"title_key": TitleKey(numbered)}
+		if n > 0 {
+			set["meta.title"] = numbered
+			if path != "" {
+				set["path"] = strings.TrimSuffix(path, title) + numbered
+			}
+		}
+		_, err := db.Collection(collection).UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": set})
+		if !IsTitleTaken(err) {
+			return numbered, err
+		}
+	}
+	return title, fmt.Errorf("no free title for %q", title)
+}
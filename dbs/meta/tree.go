@@ -0,0 +1,34 @@
+package metaDB
+
+import (
+	"strconv"
+
+	"github.com/isotiropoulos/storage-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// treeFilter is the filter of the items of a tree that the caller can read. scopeFields are the
+// fields that hold the folders an item is in or under, as for searches. Items list their
+// ancestors from the bucket down, so an item within the depth has none past level+depth.
+func treeFilter(query models.TreeQuery, scopeFields ...string) bson.M {
+	var inScope []bson.M
+	for _, field := range scopeFields {
+		inScope = append(inScope, bson.M{field: bson.M{"$in": query.Scopes}})
+	}
+	return bson.M{
+		"ancestors": query.Root,
+		"ancestors." + strconv.Itoa(query.Level+query.Depth): bson.M{"$exists": false},
+		"$or": inScope,
+	}
+}
+
+// treeOptions sort the items of a tree by sortFields. Trees may be too large to sort in memory.
+func treeOptions(sortFields ...string) *options.FindOptions {
+	sort := bson.D{}
+	for _, field := range sortFields {
+		sort = append(sort, bson.E{Key: field, Value: 1})
+	}
+	return options.Find().SetSort(append(sort, bson.E{Key: "_id", Value: 1})).SetAllowDiskUse(true)
+}
@@ -2,7 +2,6 @@ package globals
 
 import (
 	"os"
-	"sync"
 	"time"
 
 	goCDS "github.com/SLG-European-Projects/cds-go"
@@ -12,7 +11,8 @@ import (
 
 const PartSize = 5 * 1024 * 1024
 
-const CheckTime = 5 * time.Second
+// CheckTime is how often the Copernicus workers look for due tasks and poll running jobs.
+var CheckTime = 5 * time.Second
 
 var Storage objectstorage.IFileStorage = &objectstorage.FileStorage{}
 
@@ -20,15 +20,49 @@ var FileDB db.IFileStore = &db.FileStore{}
 var FolderDB db.IFolderStore = &db.FolderStore{}
 var PartsDB db.IPartStore = &db.PartStore{}
 var CopernicusDB db.ICopernicusStore = &db.CopernicusStore{}
+var CopernicusQueueDB db.ICopernicusQueueStore = &db.CopernicusQueueStore{}
+var CopernicusScheduleDB db.ICopernicusScheduleStore = &db.CopernicusScheduleStore{}
+var APIKeyDB db.IAPIKeyStore = &db.APIKeyStore{}
+var CopernicusCredentialDB db.ICopernicusCredentialStore = &db.CopernicusCredentialStore{}
+var CopernicusCatalogueDB db.ICopernicusCatalogueStore = &db.CopernicusCatalogueStore{}
+var NotificationDB db.INotificationStore = &db.NotificationStore{}
+var ContentDB db.IContentStore = &db.ContentStore{}
 
 var COPERNICUS_BUCKET_ID = os.Getenv("COP_BUCKET_ID")
 
 var CDS_URL = os.Getenv("CDS_URL")
 var CDS_KEY = os.Getenv("CDS_KEY")
+var ADS_URL = os.Getenv("ADS_URL")
+var ADS_KEY = os.Getenv("ADS_KEY")
+var EWDS_URL = os.Getenv("EWDS_URL")
+var EWDS_KEY = os.Getenv("EWDS_KEY")
 
-var RunningGoroutines sync.Map // Map to track goroutine IDs
+// COP_CREDENTIALS_KEY encrypts the Copernicus API keys users and groups store. Without it they can't store keys.
+var COP_CREDENTIALS_KEY = os.Getenv("COP_CREDENTIALS_KEY")
 
-var CopernicusClient goCDS.Client
+// SMTP server of email notifications. Email notifications are off while SMTP_HOST is unset.
+var SMTP_HOST = os.Getenv("SMTP_HOST")
+var SMTP_PORT = os.Getenv("SMTP_PORT")
+var SMTP_USER = os.Getenv("SMTP_USER")
+var SMTP_PASSWORD = os.Getenv("SMTP_PASSWORD")
+var SMTP_FROM = os.Getenv("SMTP_FROM")
+
+// CONTENT_INDEXING turns on the extraction of text from uploaded documents, for content searches.
+var CONTENT_INDEXING = os.Getenv("CONTENT_INDEXING") == "true"
+
+// Copernicus services
+const (
+	ServiceCDS  = "cds"  // Climate Data Store
+	ServiceADS  = "ads"  // Atmosphere Data Store
+	ServiceEWDS = "ewds" // Early Warning Data Store
+)
+
+// DefaultCopernicusService serves requests that don't name a service.
+const DefaultCopernicusService = ServiceCDS
+
+// CopernicusServices holds a client per Copernicus service, with the shared key of the
+// service (if any). Users' and groups' own keys take precedence over it.
+var CopernicusServices = map[string]*goCDS.Client{}
 
 func Init() {
 
@@ -43,5 +77,21 @@ func Init() {
 		CDS_KEY = "b08c3645-2a03-4a3f-a9bb-00059dab9c98"
 	}
 
-	CopernicusClient = *goCDS.InitClient(CDS_URL, CDS_KEY)
+	if ADS_URL == "" {
+		ADS_URL = "https://ads.atmosphere.copernicus.eu/api"
+	}
+	if EWDS_URL == "" {
+		EWDS_URL = "https://ewds.climate.copernicus.eu/api"
+	}
+
+	if SMTP_PORT == "" {
+		SMTP_PORT = "25"
+	}
+	if SMTP_FROM == "" {
+		SMTP_FROM = "noreply@buildspaceproject.eu"
+	}
+
+	CopernicusServices[ServiceCDS] = goCDS.InitClient(CDS_URL, CDS_KEY)
+	CopernicusServices[ServiceADS] = goCDS.InitClient(ADS_URL, ADS_KEY)
+	CopernicusServices[ServiceEWDS] = goCDS.InitClient(EWDS_URL, EWDS_KEY)
 }
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/middleware"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CreateAPIKey handles the /apikey POST request.
+// @Summary Create an API key for a machine client.
+// @Description Creates an API key scoped to a bucket with "read" and/or "write" permissions. Only members of the bucket's group can create keys.
+// @Description The plain key is returned **only once** in the response. Machine clients pass it as "X-API-Key: <key>" or "Authorization: ApiKey <key>".
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param body body models.PostAPIKeyBody true "API key payload"
+// @Success 200 {object} models.APIKeyCreated "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /apikey [post]
+// @Security BearerAuth
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "KEY0001")
+		return
+	}
+
+	var req models.PostAPIKeyBody
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "KEY0002")
+		return
+	}
+
+	if req.Name == "" || len(req.Permissions) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", "Fields name and permissions are required.", "KEY0003")
+		return
+	}
+	for _, permission := range req.Permissions {
+		if permission != models.CapRead && permission != models.CapWrite {
+			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", "Permission "+string(permission)+" is not supported. Use read or write.", "KEY0004")
+			return
+		}
+	}
+
+	if err = checkGroupMember(r, claims, req.GroupID, "API keys"); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", err.Error(), "KEY0005")
+		return
+	}
+
+	keyID, err := utils.GenerateUUID()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error in creating key's ID.", err.Error(), "KEY0006")
+		return
+	}
+	key, err := utils.GenerateAPIKey()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error in creating key.", err.Error(), "KEY0007")
+		return
+	}
+
+	apiKey := models.APIKey{
+		Id:           keyID,
+		Name:         req.Name,
+		Prefix:       key[:len(utils.APIKeyPrefix)+8],
+		Hash:         utils.HashAPIKey(key),
+		GroupID:      req.GroupID,
+		Permissions:  req.Permissions,
+		Creator:      claims.Subject,
+		DateCreation: time.Now(),
+	}
+
+	err = globals.APIKeyDB.InsertOne(apiKey)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not store key.", err.Error(), "KEY0008")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.APIKeyCreated{APIKey: apiKey, Key: key})
+}
+
+// GetAPIKeys handles the /apikey?group_id={id} GET request.
+// @Summary List the API keys of a bucket.
+// @Description Lists the API keys (including revoked ones) of a bucket with their last use. Plain keys are never returned.
+// @Tags API Keys
+// @Produce json
+// @Param group_id query string true "Bucket ID"
+// @Success 200 {object} []models.APIKey "OK"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /apikey [get]
+// @Security BearerAuth
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "KEY0009")
+		return
+	}
+
+	groupID := r.URL.Query().Get("group_id")
+	if err = checkGroupMember(r, claims, groupID, "API keys"); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", err.Error(), "KEY0010")
+		return
+	}
+
+	keysCursor, err := globals.APIKeyDB.GetCursorByGroup(groupID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get keys.", err.Error(), "KEY0011")
+		return
+	}
+	defer keysCursor.Close(context.Background())
+
+	apiKeys := []models.APIKey{}
+	for keysCursor.Next(context.Background()) {
+		var result bson.M
+		var apiKey models.APIKey
+		if err = keysCursor.Decode(&result); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve cursor.", err.Error(), "KEY0012")
+			return
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &apiKey)
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiKeys)
+}
+
+// RevokeAPIKey handles the /apikey/{id} DELETE request.
+// @Summary Revoke an API key.
+// @Description Revokes an API key by its ID. Revoked keys are rejected at once by the replica that revokes them, and by the others within APIKEY_CACHE_TTL (30s by default).
+// @Tags API Keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKey "OK"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /apikey/{id} [delete]
+// @Security BearerAuth
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "KEY0013")
+		return
+	}
+
+	params := mux.Vars(r)
+	apiKey, err := globals.APIKeyDB.GetOneByID(params["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find key.", err.Error(), "KEY0014")
+		return
+	}
+
+	if err = checkGroupMember(r, claims, apiKey.GroupID, "API keys"); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", err.Error(), "KEY0015")
+		return
+	}
+
+	apiKey, err = globals.APIKeyDB.Revoke(apiKey.Id)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not revoke key.", err.Error(), "KEY0016")
+		return
+	}
+	middleware.InvalidateAPIKey(apiKey.Id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+// checkGroupMember checks that the caller is a user (not a machine client) and a member of the bucket's group,
+// and so may manage the group's secrets (e.g. "API keys").
+func checkGroupMember(r *http.Request, claims *models.OidcClaims, groupID string, secrets string) error {
+	principal, _ := utils.GetPrincipalFromContext(r.Context())
+	if principal.APIKeyID != "" {
+		return errors.New("API keys can't manage " + secrets)
+	}
+
+	bucket, err := globals.FolderDB.GetOneByID(groupID)
+	if err != nil || bucket.Level != 0 {
+		return errors.New("bucket not found")
+	}
+
+	if !utils.ItemInArray(claims.Groups, bucket.Meta.Title) {
+		return errors.New("only members of the bucket's group can manage its " + secrets)
+	}
+	return nil
+}
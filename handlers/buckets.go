@@ -10,6 +10,8 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/indexing"
+	"github.com/isotiropoulos/storage-api/middleware"
 	models "github.com/isotiropoulos/storage-api/models"
 	"github.com/isotiropoulos/storage-api/utils"
 	// "BUILDSPACE-api/utils"
@@ -61,6 +63,7 @@ func MakeBucket(w http.ResponseWriter, r *http.Request) {
 		}
 
 		postFolder := utils.CreateFolder(folderData, req.Id, []string{}, req.Id)
+		postFolder.Path = utils.ItemPath("", req.Name)
 
 		err = globals.FolderDB.InsertOne(postFolder)
 		if err != nil {
@@ -85,9 +88,7 @@ func MakeBucket(w http.ResponseWriter, r *http.Request) {
 // @Router /bucket/{id} [delete]
 // @Security BearerAuth
 func DeleteBucket(w http.ResponseWriter, r *http.Request) {
-	mode := r.Header.Get("X-Mode")
-	if mode != "normal" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with shared rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapDeleteBucket) {
 		return
 	}
 
@@ -117,6 +118,7 @@ func DeleteBucket(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete bucket's root folder.", err.Error(), "BUC0008")
 		return
 	}
+	middleware.InvalidateResolution(bucketId)
 
 	// Delete parts
 	err = globals.PartsDB.DeleteManyWithBucket(bucketId)
@@ -131,6 +133,7 @@ func DeleteBucket(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete bucket's files.", err.Error(), "BUC0007")
 		return
 	}
+	indexing.RemoveUnder(bucketId)
 
 	json.NewEncoder(w).Encode(models.Bucket{
 		Id: bucketId,
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/indexing"
+	"github.com/isotiropoulos/storage-api/middleware"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parseConflict reads the conflict policy of a request from its conflict query parameter.
+// Requests fail on conflicts unless they choose otherwise.
+func parseConflict(r *http.Request) (models.ConflictPolicy, error) {
+	switch policy := models.ConflictPolicy(r.URL.Query().Get("conflict")); policy {
+	case "":
+		return models.ConflictFail, nil
+	case models.ConflictFail, models.ConflictRename, models.ConflictOverwrite, models.ConflictSkip:
+		return policy, nil
+	}
+	return "", errors.New("conflict must be one of fail, rename, overwrite or skip")
+}
+
+// checkDestination tells if the caller may write to the destination of a copy or move. Copies
+// and moves are authorized on their source only, and an overwrite deletes what the destination
+// holds.
+func checkDestination(r *http.Request, folderID string) error {
+	principal, err := middleware.FolderPrincipal(r, folderID)
+	if err != nil {
+		return err
+	}
+	if !utils.HasCapability(principal, models.CapWrite) {
+		return errors.New("missing write permission on destination folder")
+	}
+	return nil
+}
+
+// fileHolder finds the file that holds a title in a folder.
+func fileHolder(folderID string) func(title string) (string, error) {
+	return func(title string) (string, error) {
+		file, err := globals.FileDB.GetOneByFolderTitle(folderID, title)
+		return file.Id, err
+	}
+}
+
+// folderHolder finds the folder that holds a title in a parent folder.
+func folderHolder(parentID string) func(title string) (string, error) {
+	return func(title string) (string, error) {
+		folder, err := globals.FolderDB.GetOneByParentTitle(parentID, title)
+		return folder.Id, err
+	}
+}
+
+// fileRemover deletes the files that overwrites replace, except for the file being written,
+// which a copy or move can't replace with itself.
+func fileRemover(writtenID string, subject string) func(id string) error {
+	return func(id string) error {
+		if id == writtenID {
+			return utils.ErrTitleTaken
+		}
+		return removeFile(id, subject)
+	}
+}
+
+// folderRemover deletes the folders that overwrites replace, except for the folders kept: the
+// folder being written and, for copies, the folders that hold the source.
+func folderRemover(subject string, keptIDs ...string) func(id string) error {
+	return func(id string) error {
+		if utils.ItemInArray(keptIDs, id) {
+			return utils.ErrTitleTaken
+		}
+		return removeFolder(id, subject)
+	}
+}
+
+// removeFile deletes a file and its parts, and updates its folder and ancestors, as DeleteFile
+// does.
+func removeFile(fileID string, subject string) error {
+	file, err := globals.FileDB.GetOneByID(fileID)
+	if err != nil {
+		return err
+	}
+	if err = globals.FileDB.DeleteOneByID(file.Id); err != nil {
+		return err
+	}
+	middleware.InvalidateResolution(file.Id)
+	indexing.Remove(file.Id)
+
+	parent, err := globals.FolderDB.GetOneByID(file.FolderID)
+	if err != nil {
+		return err
+	}
+	parent.Files = utils.RemoveFromSlice(parent.Files, file.Id)
+	if _, err = globals.FolderDB.UpdateWithId(parent); err != nil {
+		return err
+	}
+	if err = globals.FolderDB.UpdateMetaAncestors(file.Ancestors, subject); err != nil {
+		return err
+	}
+	if err = globals.FolderDB.UpdateAncestorSize(file.Ancestors, file.Size, false); err != nil {
+		return err
+	}
+	if err = removeParts(file); err != nil {
+		return err
+	}
+	return globals.CopernicusDB.DeleteOneByFileID(file.Id)
+}
+
+// removeFolder deletes a folder and everything under it, and updates its parent and ancestors,
+// as DeleteFolder does.
+func removeFolder(folderID string, subject string) error {
+	folder, err := globals.FolderDB.GetOneByID(folderID)
+	if err != nil {
+		return err
+	}
+	if err = globals.FolderDB.DeleteOneByID(folder.Id); err != nil {
+		return err
+	}
+	middleware.InvalidateResolution(folder.Id)
+	indexing.RemoveUnder(folder.Id)
+
+	parent, err := globals.FolderDB.GetOneByID(folder.Parent)
+	if err != nil {
+		return err
+	}
+	parent.Folders = utils.RemoveFromSlice(parent.Folders, folder.Id)
+	if _, err = globals.FolderDB.UpdateWithId(parent); err != nil {
+		return err
+	}
+	if err = globals.FolderDB.UpdateMetaAncestors(folder.Ancestors, subject); err != nil {
+		return err
+	}
+	if err = globals.FolderDB.UpdateAncestorSize(folder.Ancestors, folder.Size, false); err != nil {
+		return err
+	}
+	if err = globals.FolderDB.DeleteManyWithAncestore(folder.Id); err != nil {
+		return err
+	}
+
+	cursor, err := globals.FileDB.GetCursorByAncestors(folder.Id)
+	if err != nil {
+		return err
+	}
+	var files []models.File
+	err = decodeSearch(cursor, func(result bson.M) {
+		var file models.File
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &file)
+		files = append(files, file)
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		middleware.InvalidateResolution(file.Id)
+		if err = removeParts(file); err != nil {
+			return err
+		}
+	}
+	return globals.FileDB.DeleteManyWithAncestore(folder.Id)
+}
+
+// removeParts deletes the parts of a file from the storage and the parts collection.
+func removeParts(file models.File) error {
+	cursor, err := globals.PartsDB.GetCursorByFileID(file.Id)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var part models.Part
+		if err = cursor.Decode(&part); err != nil {
+			return err
+		}
+		if err = globals.Storage.DeleteFile(part.Id, file.Ancestors[0]); err != nil {
+			return err
+		}
+	}
+	return globals.PartsDB.DeleteManyWithFile(file.Id)
+}
+
+// respondSkippedFile responds with the file that holds the title of a skipped file. The skipped
+// header tells it apart from a file that was written.
+func respondSkippedFile(w http.ResponseWriter, fileID string, code string) {
+	file, err := globals.FileDB.GetOneByID(fileID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get the file that holds the title.", err.Error(), code)
+		return
+	}
+	setFilePath(&file)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("skipped", "true")
+	json.NewEncoder(w).Encode(file)
+}
+
+// respondSkippedFolder responds with the folder that holds the title of a skipped folder, with
+// the skipped header.
+func respondSkippedFolder(w http.ResponseWriter, folderID string, code string) {
+	folder, err := globals.FolderDB.GetOneByID(folderID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get the folder that holds the title.", err.Error(), code)
+		return
+	}
+	setFolderPath(&folder)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("skipped", "true")
+	json.NewEncoder(w).Encode(folder)
+}
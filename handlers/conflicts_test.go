@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/memstore"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"github.com/minio/minio-go/v7"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// TestOverwriteForeignDestination copies and moves items of a bucket the caller belongs to onto
+// the items of the same title in a bucket they have no access to.
+func TestOverwriteForeignDestination(t *testing.T) {
+	stores := memstore.New()
+	t.Cleanup(stores.Install())
+
+	own := models.Folder{Id: "dest-own", Meta: models.Meta{Title: "own"}, Path: utils.ItemPath("", "own")}
+	foreign := models.Folder{Id: "dest-foreign", Meta: models.Meta{Title: "foreign"}, Path: utils.ItemPath("", "foreign")}
+	source := models.Folder{Id: "dest-source", Meta: models.Meta{Title: "data"}, Parent: own.Id, Ancestors: []string{own.Id}, Level: 1, Path: utils.ItemPath(own.Path, "data")}
+	held := models.Folder{Id: "dest-held", Meta: models.Meta{Title: "data"}, Parent: foreign.Id, Ancestors: []string{foreign.Id}, Level: 1, Path: utils.ItemPath(foreign.Path, "data")}
+	own.Folders, foreign.Folders = []string{source.Id}, []string{held.Id}
+	for _, folder := range []models.Folder{own, foreign, source, held} {
+		stores.Folders.InsertOne(folder)
+	}
+	for _, file := range []models.File{
+		{Id: "dest-file", Meta: models.Meta{Title: "report.pdf"}, FolderID: own.Id, Ancestors: []string{own.Id}, Path: utils.ItemPath(own.Path, "report.pdf")},
+		{Id: "dest-held-file", Meta: models.Meta{Title: "report.pdf"}, FolderID: foreign.Id, Ancestors: []string{foreign.Id}, Path: utils.ItemPath(foreign.Path, "report.pdf")},
+	} {
+		stores.Files.InsertOne(file)
+	}
+
+	// The caller is a member of their bucket only; the middleware authorized the source
+	principal := models.Principal{
+		Subject:      "user",
+		Role:         models.RoleMember,
+		GroupID:      own.Id,
+		GroupName:    own.Meta.Title,
+		Capabilities: utils.RoleCapabilities[models.RoleMember],
+	}
+
+	for _, test := range []struct {
+		name    string
+		handler http.HandlerFunc
+		body    models.CopyMoveBody
+		code    string
+	}{
+		{"copy file", CopyFile, models.CopyMoveBody{Id: "dest-file", Destination: foreign.Id}, "FIL0099"},
+		{"move file", MoveFile, models.CopyMoveBody{Id: "dest-file", Destination: foreign.Id}, "FIL0100"},
+		{"copy folder", CopyFolder, models.CopyMoveBody{Id: source.Id, Destination: foreign.Id}, "FOL0081"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(test.body)
+			r := httptest.NewRequest(http.MethodPost, "/?conflict=overwrite", bytes.NewReader(body))
+			r = withClaims(r, "user")
+			r = r.WithContext(utils.ContextWithPrincipal(r.Context(), principal))
+			w := httptest.NewRecorder()
+			test.handler(w, r)
+
+			var report models.ErrorReport
+			json.NewDecoder(w.Body).Decode(&report)
+			if w.Code != http.StatusForbidden || report.InternalStatus != test.code {
+				t.Errorf("answered %d %+v, want 403 %s", w.Code, report, test.code)
+			}
+		})
+	}
+
+	// Nothing of the foreign bucket was replaced
+	if _, err := globals.FileDB.GetOneByID("dest-held-file"); err != nil {
+		t.Errorf("file of the destination: %v", err)
+	}
+	if folder, err := globals.FolderDB.GetOneByID(foreign.Id); err != nil || len(folder.Folders) != 1 || folder.Folders[0] != held.Id {
+		t.Errorf("destination is %+v (%v)", folder, err)
+	}
+	if _, err := globals.FolderDB.GetOneByID(held.Id); err != nil {
+		t.Errorf("folder of the destination: %v", err)
+	}
+}
+
+// failingStorage fails the copies of objects after the first few.
+type failingStorage struct {
+	*memstore.ObjectStorage
+	copies int
+}
+
+func (s *failingStorage) CopyFile(originalName string, newName string, bucketFrom string, bucketTo string) error {
+	if s.copies == 0 {
+		return errors.New("storage is unavailable")
+	}
+	s.copies--
+	return s.ObjectStorage.CopyFile(originalName, newName, bucketFrom, bucketTo)
+}
+
+// TestOverwriteCopiedFile copies a file of two parts onto a file of the same title, with a
+// storage that fails halfway through the copy and then with one that doesn't.
+func TestOverwriteCopiedFile(t *testing.T) {
+	stores := memstore.New()
+	t.Cleanup(stores.Install())
+
+	bucket := models.Folder{Id: "copy-bucket", Meta: models.Meta{Title: "team"}, Path: utils.ItemPath("", "team")}
+	target := models.Folder{Id: "copy-target", Meta: models.Meta{Title: "target"}, Parent: bucket.Id, Ancestors: []string{bucket.Id}, Level: 1, Path: utils.ItemPath(bucket.Path, "target")}
+	source := models.File{Id: "copy-source", Meta: models.Meta{Title: "report.pdf"}, FolderID: bucket.Id, Ancestors: []string{bucket.Id}, Path: utils.ItemPath(bucket.Path, "report.pdf"), Size: 6, Total: 2}
+	old := models.File{Id: "copy-old", Meta: models.Meta{Title: "report.pdf"}, FolderID: target.Id, Ancestors: []string{bucket.Id, target.Id}, Path: utils.ItemPath(target.Path, "report.pdf"), Size: 3, Total: 1}
+	bucket.Folders, bucket.Files, target.Files = []string{target.Id}, []string{source.Id}, []string{old.Id}
+	stores.Folders.InsertOne(bucket)
+	stores.Folders.InsertOne(target)
+	for _, file := range []models.File{source, old} {
+		stores.Files.InsertOne(file)
+	}
+	for _, part := range []struct {
+		models.Part
+		data string
+	}{
+		{models.Part{Id: "copy-source-0", FileID: source.Id, PartNumber: 0, Size: 3}, "abc"},
+		{models.Part{Id: "copy-source-1", FileID: source.Id, PartNumber: 1, Size: 3}, "def"},
+		{models.Part{Id: "copy-old-0", FileID: old.Id, PartNumber: 0, Size: 3}, "xyz"},
+	} {
+		stores.Parts.InsertOne(part.Part)
+		stores.Storage.PostPart(bucket.Id, part.Id, strings.NewReader(part.data), part.Size, minio.PutObjectOptions{})
+	}
+
+	principal := models.Principal{
+		Subject:      "user",
+		Role:         models.RoleMember,
+		GroupID:      bucket.Id,
+		GroupName:    bucket.Meta.Title,
+		Capabilities: utils.RoleCapabilities[models.RoleMember],
+	}
+	copyFile := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.CopyMoveBody{Id: source.Id, Destination: target.Id})
+		r := httptest.NewRequest(http.MethodPost, "/?conflict=overwrite", bytes.NewReader(body))
+		claims := models.OidcClaims{Claims: &jwt.Claims{Subject: "user"}, Groups: []string{bucket.Meta.Title}}
+		ctx := context.WithValue(r.Context(), "claims", claims)
+		r = r.WithContext(utils.ContextWithPrincipal(ctx, principal))
+		w := httptest.NewRecorder()
+		CopyFile(w, r)
+		return w
+	}
+	objects := []string{"copy-old-0", "copy-source-0", "copy-source-1"}
+
+	// The second part can't be copied: the file it would replace is kept, and nothing is left
+	// of the copy
+	globals.Storage = &failingStorage{ObjectStorage: stores.Storage, copies: 1}
+	w := copyFile()
+	var report models.ErrorReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusInternalServerError || report.InternalStatus != "FIL0050" {
+		t.Fatalf("failed copy answered %d %+v, want 500 FIL0050", w.Code, report)
+	}
+	if file, err := globals.FileDB.GetOneByFolderTitle(target.Id, "report.pdf"); err != nil || file.Id != old.Id {
+		t.Errorf("destination holds %+v (%v)", file, err)
+	}
+	if parts := stores.Parts.ByFile(old.Id); len(parts) != 1 {
+		t.Errorf("replaced file has %d parts", len(parts))
+	}
+	if folder, _ := globals.FolderDB.GetOneByID(target.Id); len(folder.Files) != 1 || folder.Files[0] != old.Id {
+		t.Errorf("destination has files %v", folder.Files)
+	}
+	if names := stores.Storage.Names(bucket.Id); strings.Join(names, ",") != strings.Join(objects, ",") {
+		t.Errorf("bucket has objects %v, want %v", names, objects)
+	}
+
+	// Once the parts are copied, the file is replaced
+	globals.Storage = stores.Storage
+	w = copyFile()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("copy answered %d: %s", w.Code, w.Body)
+	}
+	var file models.File
+	json.NewDecoder(w.Body).Decode(&file)
+	if _, err := globals.FileDB.GetOneByID(old.Id); err == nil {
+		t.Error("replaced file is kept")
+	}
+	if _, ok := stores.Storage.Object(bucket.Id, "copy-old-0"); ok {
+		t.Error("part of the replaced file is kept")
+	}
+	if folder, _ := globals.FolderDB.GetOneByID(target.Id); len(folder.Files) != 1 || folder.Files[0] != file.Id {
+		t.Errorf("destination has files %v, want %s", folder.Files, file.Id)
+	}
+	parts := stores.Parts.ByFile(file.Id)
+	if len(parts) != 2 {
+		t.Fatalf("copy has %d parts", len(parts))
+	}
+	for i, part := range parts {
+		data, ok := stores.Storage.Object(bucket.Id, part.Id)
+		if want := []string{"abc", "def"}[i]; !ok || string(data) != want {
+			t.Errorf("part %d holds %q, want %q", i, data, want)
+		}
+	}
+}
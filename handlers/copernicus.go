@@ -3,39 +3,60 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/isotiropoulos/storage-api/copernicus"
 	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/middleware"
 	"github.com/isotiropoulos/storage-api/models"
 	"github.com/isotiropoulos/storage-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
 
-	"time"
-
 	"github.com/gorilla/mux"
 )
 
 // GetList handles the /copernicus/collections GET request.
 // @Summary Get a list of all available datasets related to a specific service.
 // @Description This is the endopoint to get a list of all available datasets of a service.
-// @Description Currently supported services are "ads" and "cds"
+// @Description Currently supported services are "cds" (default), "ads" and "ewds"
+// @Description The list is served from the catalogue, which is refreshed periodically, so it doesn't depend on the service being up.
+// @Description While the catalogue of a service is fetched for the first time, the response is 503 with a Retry-After header.
+// @Description The response has an ETag; a request with a matching If-None-Match header gets 304 Not Modified.
 // @Tags Copernicus
 // @Produce json
-// @Param service path string true "Service (currently available 'ads' and 'cds')"
+// @Param service query string false "Service ('cds', 'ads' or 'ewds')"
 // @Success 202 {object} array "Accepted"
+// @Success 304 "Not Modified"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Failure 503 {object} models.ErrorReport "Service Anavailable"
-// @Router /copernicus/{service}/getall [get]
+// @Router /copernicus/collections [get]
 // @Security BearerAuth
 func GetList(w http.ResponseWriter, r *http.Request) {
 	// var collections CDSModels.CollectionList
 
-	collections, err := globals.CopernicusClient.GetCollections()
+	collections, etag, err := copernicus.Catalogue(r.URL.Query().Get("service"))
+	if errors.Is(err, copernicus.ErrUnknownService) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Unknown Copernicus service.", err.Error(), "COP0052")
+		return
+	}
+	if errors.Is(err, copernicus.ErrCatalogueNotReady) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(copernicus.CatalogueRetry.Seconds())))
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Catalogue not available yet.", err.Error(), "COP0059")
+		return
+	}
 	if err != nil {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not retrieve all collections", err.Error(), "COP0001")
 		return
 	}
+	if notModified(w, r, etag) {
+		return
+	}
 
 	response, err := json.Marshal(collections)
 	if err != nil {
@@ -52,26 +73,35 @@ func GetList(w http.ResponseWriter, r *http.Request) {
 // @Summary Get the form of a dataset that is related to a specific service.
 // @Description A form is a set of rules indicating which parameters are neccessary for the dataset to be retrieved.
 // @Description Please note that some parameters cannot be used with other. The selection rules are also included in the forms.
+// @Description Forms are served from the catalogue and have an ETag; a request with a matching If-None-Match header gets 304 Not Modified.
 // @Tags Copernicus
 // @Produce json
-// @Param service path string true "Service (currently available 'ads' and 'cds')"
+// @Param service query string false "Service ('cds', 'ads' or 'ewds')"
 // @Param id path string true "ID of the dataset of interest"
-// @Success 200 {object} formModel "OK"
+// @Success 200 {object} []models.FormField "OK"
+// @Success 304 "Not Modified"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Failure 503 {object} models.ErrorReport "Service Anavailable"
-// @Router /copernicus/{service}/getform/{id} [get]
+// @Router /copernicus/form/{id} [get]
 // @Security BearerAuth
 func GetForm(w http.ResponseWriter, r *http.Request) {
 
 	params := mux.Vars(r)
 	collectionID := params["id"]
 
-	form, err := globals.CopernicusClient.GetCollectionForm(collectionID)
+	form, etag, err := copernicus.GetForm(r.URL.Query().Get("service"), collectionID)
+	if errors.Is(err, copernicus.ErrUnknownService) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Unknown Copernicus service.", err.Error(), "COP0052")
+		return
+	}
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not retrieve form", err.Error(), "FORM003")
 		return
 	}
+	if notModified(w, r, etag) {
+		return
+	}
 
 	response, err := json.Marshal(form)
 	if err != nil {
@@ -85,20 +115,113 @@ func GetForm(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// SearchCatalogue handles the /copernicus/catalogue/search GET request.
+// @Summary Search the datasets of the Copernicus services.
+// @Description Searches the cached catalogue, so it doesn't depend on the services being up. All parameters are optional and combined.
+// @Description "q" is matched against the ID, title, description and keywords of datasets, and "variable" against the names and labels of their variables.
+// @Description "start" and "end" (YYYY-MM-DD) keep the datasets whose temporal coverage overlaps them, and "area" (North,West,South,East) the datasets whose extent intersects it.
+// @Tags Copernicus
+// @Produce json
+// @Param service query string false "Service ('cds', 'ads' or 'ewds'), all services if empty"
+// @Param q query string false "Keyword"
+// @Param variable query string false "Variable name or label"
+// @Param start query string false "Start of the period of interest (YYYY-MM-DD)"
+// @Param end query string false "End of the period of interest (YYYY-MM-DD)"
+// @Param area query string false "Area of interest: North,West,South,East"
+// @Success 200 {object} []models.CatalogueEntry "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/catalogue/search [get]
+// @Security BearerAuth
+func SearchCatalogue(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := models.CatalogueQuery{
+		Service:  params.Get("service"),
+		Keyword:  strings.TrimSpace(params.Get("q")),
+		Variable: strings.TrimSpace(params.Get("variable")),
+	}
+	for _, bound := range []struct {
+		name   string
+		target **time.Time
+	}{{"start", &query.Start}, {"end", &query.End}} {
+		if value := params.Get(bound.name); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, "Invalid "+bound.name+" date.", err.Error(), "COP0055")
+				return
+			}
+			*bound.target = &date
+		}
+	}
+	if value := params.Get("area"); value != "" {
+		bounds := strings.Split(value, ",")
+		var area [4]float64
+		var err error
+		if len(bounds) != 4 {
+			err = errors.New("area takes four values: North,West,South,East")
+		}
+		for i := 0; err == nil && i < 4; i++ {
+			area[i], err = strconv.ParseFloat(strings.TrimSpace(bounds[i]), 64)
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid area.", err.Error(), "COP0055")
+			return
+		}
+		query.Area = &models.SpatialExtent{North: area[0], West: area[1], South: area[2], East: area[3]}
+	}
+
+	entries, err := copernicus.SearchCatalogue(query)
+	if errors.Is(err, copernicus.ErrUnknownService) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Unknown Copernicus service.", err.Error(), "COP0052")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not search the catalogue.", err.Error(), "COP0056")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// notModified sets the ETag of a response and, if the request already has this version,
+// answers 304 Not Modified.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	quoted := `"` + etag + `"`
+	w.Header().Set("ETag", quoted)
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == quoted || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
 // PostDataset handles the /copernicus/dataset POST request.
 // @Summary Post a request for a specific dataset (create a Copernicus task that will make a dataset available for download).
 // @Description The request can be specified by the body of the request using the parameters of the dataset.
 // @Description Please note that some parameters cannot be used with other. For the dataset parameters' rules consider the dataset's form.
+// @Description The body is validated against the form first; invalid fields are reported one by one in the "errors" list.
+// @Description If a destination "folder" is given, the caller needs write permission on it. The response is then a file in that folder, filled in once the dataset is available.
+// @Description A large request can be split into one Copernicus job per year, month or variable with "split". Its file then holds a JSON manifest of the chunks, or with "merge" the NetCDF chunks concatenated along time.
 // @Tags Copernicus
 // @Accept json
 // @Produce json
-// @Param service path string true "Service (currently available 'ads' and 'cds')"
+// @Description The "service" of the dataset is "cds" (default), "ads" or "ewds". The job is submitted with the caller's own key for the service, the key of the destination folder's group, or the shared key, in that order.
 // @Param body body models.CopernicusInput  true "Request body"
 // @Success 200 {object} models.File "OK"
-// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 400 {object} models.ValidationReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Failure 503 {object} models.ErrorReport "Service Anavailable"
-// @Router /copernicus/{service}/dataset [post]
+// @Router /copernicus/dataset [post]
 // @Security BearerAuth
 func PostDataset(w http.ResponseWriter, r *http.Request) {
 
@@ -106,8 +229,6 @@ func PostDataset(w http.ResponseWriter, r *http.Request) {
 	// save any VALID requests to database as a file
 	// + faulty requests handling
 	// also task id is saved
-	var postFile models.File
-
 	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
 	if err != nil {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "COP0004")
@@ -121,105 +242,71 @@ func PostDataset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if already downloaded
-	fprint, err := utils.GenerateRequestFingerprint(reqBody)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Error in creating file's fingerprint.", err.Error(), "COP0011")
-		return
-	}
-
-	postFile, err = globals.FileDB.GetOneByFingerprint(fprint)
-	if err == nil && postFile.Id != "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(postFile)
+	if reqBody.DatasetName == "" {
+		utils.RespondWithFieldErrors(w, "Invalid request.", []models.FieldError{{Field: "dataset_name", Message: "is required"}}, "COP0050")
 		return
 	}
 
-	name := reqBody.DatasetName
-	process, err := globals.CopernicusClient.CreateProcess(name, reqBody.Body)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Could not create Process.", err.Error(), "COP0010")
+	if _, err = copernicus.ServiceName(reqBody.Service); err != nil {
+		utils.RespondWithFieldErrors(w, "Invalid request.", []models.FieldError{{Field: "service", Message: err.Error()}}, "COP0050")
 		return
 	}
 
-	postFile.FolderID = globals.COPERNICUS_BUCKET_ID
-	//get bucket
-	folder, err := globals.FolderDB.GetOneByID(postFile.FolderID)
-	if err != nil || folder.Id == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Could not find parent folder.", err.Error(), "COP0010")
-		return
-	}
-
-	//create file id
-	fileID, err := utils.GenerateUUID()
+	// Check the request against the dataset's form. If the form can't be fetched,
+	// the Copernicus service still validates the request on submission.
+	fieldErrors, err := copernicus.Validate(reqBody.Service, reqBody.DatasetName, reqBody.Body)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Error in creating file's ID.", err.Error(), "COP0011")
-		return
+		log.Println("Could not validate copernicus request: ", err.Error())
 	}
-	postFile.Id = fileID
-	//Create and post file
-	update := models.Updated{
-		Date: time.Now(),
-		User: claims.Subject,
-	}
-
-	title := name
-	postFile.FileType = reqBody.Body["format"].(string)
-	postFile.OriginalTitle = title
-	postFile.Size = 0
-	postFile.Ancestors = append(folder.Ancestors, postFile.FolderID)
-
-	meta := postFile.Meta
-	meta.DateCreation = time.Now()
-	meta.Creator = claims.Subject
-	meta.Read = folder.Meta.Read
-	meta.Write = folder.Meta.Write
-	meta.Update = update
-	meta.Title = title
-	// postFile.CopernicusDetails = copDetails
-	postFile.Meta = meta
-	err = globals.FileDB.InsertOne(postFile)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Error in insterting file.", err.Error(), "COP0012")
+	fieldErrors = append(fieldErrors, copernicus.ValidateSplit(reqBody)...)
+	if len(fieldErrors) > 0 {
+		utils.RespondWithFieldErrors(w, "Invalid request.", fieldErrors, "COP0050")
 		return
 	}
 
-	copInput := models.CopernicusRecord{
-		Id:            fprint,
-		FileId:        fileID,
-		DatasetName:   reqBody.DatasetName,
-		RequestParams: reqBody.Body,
-		Details:       process,
-	}
-
-	err = globals.CopernicusDB.InsertOne(copInput)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Error in storing copernicus input.", err.Error(), "COP0035")
-		return
-	}
-
-	// Update parent folder
-	err = globals.FolderDB.UpdateFiles(postFile.Id, postFile.FolderID)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "COP0013")
-		return
+	// Authorize the destination folder, if any
+	var destination *models.Folder
+	if reqBody.Folder != "" {
+		folder, ok := authorizeDestination(w, r, reqBody.Folder)
+		if !ok {
+			return
+		}
+		destination = &folder
 	}
 
-	// Update ancestore's meta
-	err = globals.FolderDB.UpdateMetaAncestors(postFile.Ancestors, claims.Subject)
+	postFile, err := copernicus.Submit(reqBody, claims.Subject, destination, "")
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not update ancestore's meta.", err.Error(), "COP0014")
+		submitErr := &copernicus.SubmitError{Status: http.StatusInternalServerError, Message: "Could not submit request.", Code: "COP0010", Err: err}
+		errors.As(err, &submitErr)
+		utils.RespondWithError(w, submitErr.Status, submitErr.Message, submitErr.Err.Error(), submitErr.Code)
 		return
 	}
 
-	go utils.CheckCopernicusStatus(copInput, claims.Subject)
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(postFile)
 }
 
+// authorizeDestination gets a folder datasets are delivered to, if the caller may write to it.
+// Otherwise it responds with an error and returns false.
+func authorizeDestination(w http.ResponseWriter, r *http.Request, folderID string) (models.Folder, bool) {
+	principal, err := middleware.FolderPrincipal(r, folderID)
+	if err != nil || !utils.HasCapability(principal, models.CapWrite) {
+		reason := "missing write permission on destination folder"
+		if err != nil {
+			reason = err.Error()
+		}
+		utils.RespondWithError(w, http.StatusForbidden, "Not allowed to deliver to folder.", reason, "COP0041")
+		return models.Folder{}, false
+	}
+	folder, err := globals.FolderDB.GetOneByID(folderID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find destination folder.", err.Error(), "COP0042")
+		return models.Folder{}, false
+	}
+	return folder, true
+}
+
 // GetStatus handles the/copernicus/status/dataset/{fileId} GET request.
 // @Summary Download dataset (if the Copernicus task is completed).
 // @Description This endpoint checks the status of a Copernicus task and if it is completed it downloads the file and stores it in MinIO (under the Copernicus public bucket).
@@ -229,43 +316,42 @@ func PostDataset(w http.ResponseWriter, r *http.Request) {
 // @Param fileId path string true "ID of the dataset to be downloaded"
 // @Success 200 {object} models.File "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Failure 503 {object} models.ErrorReport "Service Anavailable"
-// @Router /copernicus/{service}/dataset/{id} [get]
+// @Router /copernicus/dataset/{fileId} [get]
 // @Security BearerAuth
 func CheckStatus(w http.ResponseWriter, r *http.Request) {
 	// using task id of a request first checks if task in completed then proceeds to dowload data to db if so
-	//get claims
-	// claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
-	// if err != nil {
-	// 	utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "COP0015")
-	// 	return
-	// }
-
-	params := mux.Vars(r)
-	fileID := params["fileId"]
-
-	copRec, err := globals.CopernicusDB.GetOneByFileID(fileID)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "COP0038")
+	copRec, claims, ok := requesterRecord(w, r)
+	if !ok {
 		return
 	}
+	fileID := mux.Vars(r)["fileId"]
 
-	//get relevant file from db
-	postFile, err := globals.FileDB.GetOneByID(copRec.FileId)
+	//get relevant file from db (the reference file or a delivered copy)
+	postFile, err := globals.FileDB.GetOneByID(fileID)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error in retrieving file from database.", err.Error(), "COP0020")
 		return
 	}
 
+	// A delivered copy is in a user's folder, which the caller must still be able to read
+	if postFile.Id != copRec.FileId {
+		principal, err := middleware.FolderPrincipal(r, postFile.FolderID)
+		if err != nil || !utils.HasCapability(principal, models.CapRead) {
+			utils.RespondWithError(w, http.StatusForbidden, "Not allowed to read this dataset.", "missing read permission on the folder of the dataset", "COP0057")
+			return
+		}
+	}
+
 	if postFile.Size == 0 {
-		// Start downloading
-		claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
-		if err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "COP0004")
+		// The queue workers store the result; make sure the job is queued
+		if err = copernicus.EnsureQueued(copRec, claims.Subject); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not queue copernicus task.", err.Error(), "COP0040")
 			return
 		}
-		utils.CheckCopernicusStatus(copRec, claims.Subject)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -274,23 +360,37 @@ func CheckStatus(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// GetAvailable handles the /copernicus/{service}/available GET request.
+// GetAvailable handles the /copernicus/available GET request.
 // @Summary Get a list of available Copernicus datasets, based on services.
-// @Description This endpoint returns all available-for-download datasets
+// @Description This endpoint returns the available-for-download datasets requested by the caller
 // @Tags Copernicus
 // @Produce json
-// @Param service path string true "Service (currently available 'ads' and 'cds', or 'all' for no specific service)"
+// @Param service query string false "Service ('cds', 'ads' or 'ewds'; all services if empty or 'all')"
 // @Success 200 {object} []models.CopernicusRecord "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Failure 503 {object} models.ErrorReport "Service Anavailable"
-// @Router /copernicus/{service}/available [get]
+// @Router /copernicus/available [get]
 // @Security BearerAuth
 func GetAvailable(w http.ResponseWriter, r *http.Request) {
 
 	var response []models.CopernicusRecord
 
-	dataCursor, err := globals.CopernicusDB.GetCursorAll()
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "COP0004")
+		return
+	}
+
+	service := r.URL.Query().Get("service")
+	if service != "" && service != "all" {
+		if service, err = copernicus.ServiceName(service); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Unknown Copernicus service.", err.Error(), "COP0052")
+			return
+		}
+	}
+
+	dataCursor, err := globals.CopernicusDB.GetCursorByRequester(claims.Subject)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not open cursor.", err.Error(), "COP0036")
 		return
@@ -306,9 +406,144 @@ func GetAvailable(w http.ResponseWriter, r *http.Request) {
 		}
 		bsonBytes, _ := bson.Marshal(result)
 		bson.Unmarshal(bsonBytes, &record)
+
+		if !copernicus.IsRequester(record, claims.Subject) {
+			continue
+		}
+		if service != "" && service != "all" {
+			if recordService, _ := copernicus.ServiceName(record.Service); recordService != service {
+				continue
+			}
+		}
 		response = append(response, record)
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
+}
+
+// GetJob handles the /copernicus/dataset/{fileId}/job GET request.
+// @Summary Get the full status of the Copernicus job of a dataset.
+// @Description The status is polled from the Copernicus service, including the job's log. If the service can't be reached, the last known status is returned.
+// @Description The response also contains the queue entry of the job, with the reason of its last failure.
+// @Tags Copernicus
+// @Produce json
+// @Param fileId path string true "ID of the dataset (reference or delivered file)"
+// @Success 200 {object} models.CopernicusJobStatus "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Router /copernicus/dataset/{fileId}/job [get]
+// @Security BearerAuth
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	copRec, _, ok := requesterRecord(w, r)
+	if !ok {
+		return
+	}
+
+	if refreshed, err := copernicus.Refresh(copRec); err == nil {
+		copRec = refreshed
+	}
+
+	status := models.CopernicusJobStatus{Record: copRec}
+	if task, err := globals.CopernicusQueueDB.GetOneByID(copRec.Id); err == nil {
+		status.Task = &task
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// CancelJob handles the /copernicus/dataset/{fileId}/job DELETE request.
+// @Summary Cancel the Copernicus job of a dataset.
+// @Description The job is dismissed on the Copernicus service and removed from the queue. Identical requests share a job, so while other users wait for it only the caller's request and pending deliveries are withdrawn.
+// @Tags Copernicus
+// @Produce json
+// @Param fileId path string true "ID of the dataset (reference or delivered file)"
+// @Success 200 {object} models.CopernicusRecord "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 409 {object} models.ErrorReport "Conflict"
+// @Failure 502 {object} models.ErrorReport "Bad Gateway"
+// @Router /copernicus/dataset/{fileId}/job [delete]
+// @Security BearerAuth
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	copRec, claims, ok := requesterRecord(w, r)
+	if !ok {
+		return
+	}
+
+	copRec, err := copernicus.Dismiss(copRec, claims.Subject)
+	if errors.Is(err, copernicus.ErrJobCompleted) {
+		utils.RespondWithError(w, http.StatusConflict, "Dataset is already available.", err.Error(), "COP0046")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadGateway, "Could not cancel copernicus job.", err.Error(), "COP0047")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(copRec)
+}
+
+// RetryJob handles the /copernicus/dataset/{fileId}/retry POST request.
+// @Summary Retry the failed or dismissed Copernicus job of a dataset.
+// @Description The request is submitted again with the same parameters. The dataset keeps its file ID, and pending deliveries are filled in once the new job completes.
+// @Tags Copernicus
+// @Produce json
+// @Param fileId path string true "ID of the dataset (reference or delivered file)"
+// @Success 200 {object} models.CopernicusRecord "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 409 {object} models.ErrorReport "Conflict"
+// @Failure 502 {object} models.ErrorReport "Bad Gateway"
+// @Router /copernicus/dataset/{fileId}/retry [post]
+// @Security BearerAuth
+func RetryJob(w http.ResponseWriter, r *http.Request) {
+	copRec, claims, ok := requesterRecord(w, r)
+	if !ok {
+		return
+	}
+
+	copRec, err := copernicus.Retry(copRec, claims.Subject)
+	if errors.Is(err, copernicus.ErrJobNotFailed) {
+		utils.RespondWithError(w, http.StatusConflict, "Only failed or dismissed jobs can be retried.", err.Error(), "COP0048")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadGateway, "Could not retry copernicus job.", err.Error(), "COP0049")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(copRec)
+}
+
+// requesterRecord gets the record of the dataset in the path, if the caller requested it.
+// Otherwise it responds with an error and returns false.
+func requesterRecord(w http.ResponseWriter, r *http.Request) (models.CopernicusRecord, *models.OidcClaims, bool) {
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "COP0004")
+		return models.CopernicusRecord{}, nil, false
+	}
+
+	copRec, err := globals.CopernicusDB.GetOneByFileID(mux.Vars(r)["fileId"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find copernicus record.", err.Error(), "COP0044")
+		return models.CopernicusRecord{}, nil, false
+	}
+
+	if !copernicus.IsRequester(copRec, claims.Subject) {
+		utils.RespondWithError(w, http.StatusForbidden, "Not allowed to manage this dataset.", "dataset was not requested by user", "COP0045")
+		return models.CopernicusRecord{}, nil, false
+	}
+	return copRec, claims, true
 }
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	goCDS "github.com/SLG-European-Projects/cds-go"
+	"github.com/gorilla/mux"
+	"github.com/isotiropoulos/storage-api/cdsmock"
+	"github.com/isotiropoulos/storage-api/copernicus"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/memstore"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// startCopernicus installs in-memory stores with the Copernicus bucket, points the Copernicus
+// services at mock and starts the queue workers until the test ends.
+func startCopernicus(t *testing.T, mock *cdsmock.Server) *memstore.Stores {
+	stores := memstore.New()
+	t.Cleanup(stores.Install())
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	urls := []*string{&globals.CDS_URL, &globals.ADS_URL, &globals.EWDS_URL}
+	previousURLs := []string{globals.CDS_URL, globals.ADS_URL, globals.EWDS_URL}
+	previousServices := map[string]*goCDS.Client{}
+	for name, client := range globals.CopernicusServices {
+		previousServices[name] = client
+	}
+	checkTime := globals.CheckTime
+	t.Cleanup(func() {
+		for i, url := range urls {
+			*url = previousURLs[i]
+		}
+		for name, client := range previousServices {
+			globals.CopernicusServices[name] = client
+		}
+		globals.CheckTime = checkTime
+	})
+	for _, url := range urls {
+		*url = server.URL
+	}
+	globals.Init()
+	globals.CheckTime = time.Millisecond
+
+	stores.Folders.InsertOne(models.Folder{
+		Id:   globals.COPERNICUS_BUCKET_ID,
+		Meta: models.Meta{Title: "copernicus"},
+		Path: utils.ItemPath("", "copernicus"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := copernicus.Start(ctx)
+	t.Cleanup(func() {
+		// The workers stop before the stores are taken away
+		cancel()
+		<-stopped
+	})
+
+	// Wait for the first refresh of the catalogues, which runs on its own once started
+	waitFor(t, func() bool {
+		for _, service := range copernicus.Services() {
+			if state, err := stores.Catalogue.GetState(service.Name); err != nil || state.DateRefreshed.IsZero() {
+				return false
+			}
+		}
+		return true
+	})
+	return stores
+}
+
+// waitFor polls done until it is true, or fails the test after a while.
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// withClaims returns r as sent by subject.
+func withClaims(r *http.Request, subject string) *http.Request {
+	claims := models.OidcClaims{Claims: &jwt.Claims{Subject: subject}}
+	return r.WithContext(context.WithValue(r.Context(), "claims", claims))
+}
+
+func postDataset(t *testing.T, subject string, input models.CopernicusInput) *httptest.ResponseRecorder {
+	body, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := withClaims(httptest.NewRequest(http.MethodPost, "/copernicus/dataset", bytes.NewReader(body)), subject)
+	w := httptest.NewRecorder()
+	PostDataset(w, r)
+	return w
+}
+
+func checkStatus(subject string, fileID string) *httptest.ResponseRecorder {
+	r := withClaims(httptest.NewRequest(http.MethodGet, "/copernicus/status/dataset/"+fileID, nil), subject)
+	r = mux.SetURLVars(r, map[string]string{"fileId": fileID})
+	w := httptest.NewRecorder()
+	CheckStatus(w, r)
+	return w
+}
+
+// era5Request covers 2 years of 3-hourly steps, which the mock answers with about 6 MB of GRIB.
+func era5Request() models.CopernicusInput {
+	days := make([]interface{}, 31)
+	for i := range days {
+		days[i] = fmt.Sprintf("%02d", i+1)
+	}
+	return models.CopernicusInput{
+		DatasetName: "reanalysis-era5-single-levels",
+		Body: map[string]interface{}{
+			"product_type": []interface{}{"reanalysis"},
+			"variable":     []interface{}{"2m_temperature"},
+			"year":         []interface{}{"2023", "2024"},
+			"month":        []interface{}{"01", "02", "03", "04", "05", "06", "07", "08", "09", "10", "11", "12"},
+			"day":          days,
+			"time":         []interface{}{"00:00", "03:00", "06:00", "09:00", "12:00", "15:00", "18:00", "21:00"},
+			"data_format":  "grib",
+		},
+	}
+}
+
+func TestPostDatasetSuccessful(t *testing.T) {
+	mock := cdsmock.New()
+	stores := startCopernicus(t, mock)
+
+	w := postDataset(t, "user", era5Request())
+	if w.Code != http.StatusOK {
+		t.Fatalf("PostDataset answered %d: %s", w.Code, w.Body)
+	}
+	var file models.File
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatal(err)
+	}
+	if file.FolderID != globals.COPERNICUS_BUCKET_ID || file.Size != 0 {
+		t.Fatalf("reference file is %+v", file)
+	}
+	if file.Path != "/copernicus/"+file.Meta.Title {
+		t.Errorf("reference file is at %s", file.Path)
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+	if record.Details.Status != cdsmock.StatusAccepted || len(record.Requesters) != 1 || record.Requesters[0] != "user" {
+		t.Errorf("record is %s, requested by %v", record.Details.Status, record.Requesters)
+	}
+
+	// Only the requester may check the dataset
+	if w := checkStatus("other", file.Id); w.Code != http.StatusForbidden {
+		t.Errorf("CheckStatus of another user answered %d", w.Code)
+	}
+	if w := checkStatus("user", "unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("CheckStatus of an unknown file answered %d", w.Code)
+	}
+
+	// The workers poll the job until it succeeds and store its result
+	waitFor(t, func() bool {
+		w := checkStatus("user", file.Id)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("CheckStatus answered %d: %s", w.Code, w.Body)
+		}
+		json.NewDecoder(w.Body).Decode(&file)
+		return file.Size > 0
+	})
+
+	record, _ = globals.CopernicusDB.GetOneByID(record.Id)
+	if record.Details.Status != cdsmock.StatusSuccessful {
+		t.Errorf("record is %s", record.Details.Status)
+	}
+	jobs := mock.Jobs()
+	if len(jobs) != 1 || jobs[0].Polls < 3 || jobs[0].Downloads == 0 {
+		t.Errorf("mock jobs are %+v", jobs)
+	}
+	waitFor(t, func() bool {
+		task, err := globals.CopernicusQueueDB.GetOneByID(record.Id)
+		return err == nil && task.State == models.TaskDone
+	})
+
+	parts := stores.Parts.ByFile(file.Id)
+	var size int64
+	for _, part := range parts {
+		data, ok := stores.Storage.Object(globals.COPERNICUS_BUCKET_ID, part.Id)
+		if !ok || int64(len(data)) != part.Size {
+			t.Errorf("part %d: object of %d bytes, recorded %d", part.PartNumber, len(data), part.Size)
+		}
+		size += part.Size
+	}
+	if len(parts) != 2 || file.Total != 2 || size != file.Size {
+		t.Errorf("file of %d bytes in %d parts has %d parts of %d bytes", file.Size, file.Total, len(parts), size)
+	}
+}
+
+func TestPostDatasetConcurrent(t *testing.T) {
+	mock := cdsmock.New()
+	startCopernicus(t, mock)
+
+	// Identical requests arrive together: one job is created, which they all share
+	const requests = 8
+	responses := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = postDataset(t, fmt.Sprintf("user%d", i), era5Request())
+		}(i)
+	}
+	wg.Wait()
+
+	var fileID string
+	for i, w := range responses {
+		if w.Code != http.StatusOK {
+			t.Fatalf("PostDataset of user%d answered %d: %s", i, w.Code, w.Body)
+		}
+		var file models.File
+		json.NewDecoder(w.Body).Decode(&file)
+		if fileID == "" {
+			fileID = file.Id
+		}
+		if file.Id != fileID {
+			t.Errorf("user%d got file %s, want %s", i, file.Id, fileID)
+		}
+	}
+	if jobs := mock.Jobs(); len(jobs) != 1 {
+		t.Errorf("mock has %d jobs", len(jobs))
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(fileID)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+	if len(record.Requesters) != requests {
+		t.Errorf("record is requested by %v", record.Requesters)
+	}
+	if _, err = globals.CopernicusQueueDB.GetOneByID(record.Id); err != nil {
+		t.Errorf("task of record: %v", err)
+	}
+}
+
+func TestPostDatasetFailed(t *testing.T) {
+	mock := cdsmock.New(cdsmock.WithScript(cdsmock.StatusAccepted, cdsmock.StatusRunning, cdsmock.StatusFailed))
+	stores := startCopernicus(t, mock)
+
+	w := postDataset(t, "user", era5Request())
+	if w.Code != http.StatusOK {
+		t.Fatalf("PostDataset answered %d: %s", w.Code, w.Body)
+	}
+	var file models.File
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatal(err)
+	}
+	record, err := globals.CopernicusDB.GetOneByFileID(file.Id)
+	if err != nil {
+		t.Fatalf("record of reference file: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		task, err := globals.CopernicusQueueDB.GetOneByID(record.Id)
+		return err == nil && task.State == models.TaskFailed
+	})
+
+	record, _ = globals.CopernicusDB.GetOneByID(record.Id)
+	if record.Details.Status != cdsmock.StatusFailed || record.Error == "" {
+		t.Errorf("record is %s: %q", record.Details.Status, record.Error)
+	}
+	w = checkStatus("user", file.Id)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("CheckStatus answered %d: %s", w.Code, w.Body)
+	}
+	json.NewDecoder(w.Body).Decode(&file)
+	if file.Size != 0 || len(stores.Parts.ByFile(file.Id)) != 0 {
+		t.Errorf("failed job stored %d bytes", file.Size)
+	}
+}
+
+func TestPostDatasetInvalid(t *testing.T) {
+	startCopernicus(t, cdsmock.New())
+
+	input := era5Request()
+	input.Body["data_format"] = "zarr"
+	w := postDataset(t, "user", input)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PostDataset answered %d: %s", w.Code, w.Body)
+	}
+	var report models.ErrorReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if report.InternalStatus != "COP0050" {
+		t.Errorf("error report is %+v", report)
+	}
+}
+
+func TestGetAvailable(t *testing.T) {
+	startCopernicus(t, cdsmock.New())
+
+	if w := postDataset(t, "user", era5Request()); w.Code != http.StatusOK {
+		t.Fatalf("PostDataset answered %d: %s", w.Code, w.Body)
+	}
+	r := withClaims(httptest.NewRequest(http.MethodGet, "/copernicus/available", nil), "user")
+	w := httptest.NewRecorder()
+	GetAvailable(w, r)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GetAvailable answered %d with %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var records []models.CopernicusRecord
+	if err := json.NewDecoder(w.Body).Decode(&records); err != nil || len(records) != 1 {
+		t.Errorf("GetAvailable listed %d records (%v)", len(records), err)
+	}
+}
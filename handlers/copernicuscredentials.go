@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/isotiropoulos/storage-api/copernicus"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetServices handles the /copernicus/services GET request.
+// @Summary List the Copernicus services.
+// @Description Lists the Copernicus services requests can be sent to, and whether a shared key is configured for each. Without a shared key, callers need a key of their own.
+// @Tags Copernicus
+// @Produce json
+// @Success 200 {object} []models.CopernicusService "OK"
+// @Router /copernicus/services [get]
+// @Security BearerAuth
+func GetServices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(copernicus.Services())
+}
+
+// PutCopernicusCredential handles the /copernicus/credentials PUT request.
+// @Summary Store a Copernicus API key.
+// @Description Stores the caller's own API key for a Copernicus service, or the key of a bucket if "group_id" is given (members of the bucket's group only). An existing key of the same owner and service is replaced.
+// @Description Requests are then submitted with that key, so they count against its quota and licences. The key is stored encrypted and never returned.
+// @Tags Copernicus
+// @Accept json
+// @Produce json
+// @Param body body models.PutCopernicusCredentialBody true "Key payload"
+// @Success 200 {object} models.CopernicusCredential "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Failure 503 {object} models.ErrorReport "Service Unavailable"
+// @Router /copernicus/credentials [put]
+// @Security BearerAuth
+func PutCopernicusCredential(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "CRD0001")
+		return
+	}
+
+	var req models.PutCopernicusCredentialBody
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "CRD0002")
+		return
+	}
+
+	req.Key = strings.TrimSpace(req.Key)
+	service, err := copernicus.ServiceName(req.Service)
+	if err != nil || req.Service == "" || req.Key == "" {
+		reason := "Fields service and key are required."
+		if err != nil {
+			reason = err.Error()
+		}
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", reason, "CRD0003")
+		return
+	}
+
+	if err = checkCredentialOwner(r, claims, req.GroupID); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", err.Error(), "CRD0004")
+		return
+	}
+
+	encrypted, err := utils.EncryptSecret(req.Key)
+	if errors.Is(err, utils.ErrNoSecretKey) {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Storing keys is not enabled.", err.Error(), "CRD0005")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not encrypt key.", err.Error(), "CRD0006")
+		return
+	}
+
+	subject := claims.Subject
+	if req.GroupID != "" {
+		subject = ""
+	}
+	hint := req.Key
+	if len(hint) > 4 {
+		hint = hint[len(hint)-4:]
+	}
+	credential := models.CopernicusCredential{
+		Id:           copernicus.CredentialID(service, subject, req.GroupID),
+		Service:      service,
+		Subject:      subject,
+		GroupID:      req.GroupID,
+		Key:          encrypted,
+		Hint:         "..." + hint,
+		Creator:      claims.Subject,
+		DateCreation: time.Now(),
+	}
+
+	err = globals.CopernicusCredentialDB.ReplaceOne(credential)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not store key.", err.Error(), "CRD0007")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credential)
+}
+
+// GetCopernicusCredentials handles the /copernicus/credentials GET request.
+// @Summary List stored Copernicus API keys.
+// @Description Lists the caller's own Copernicus keys, or the keys of a bucket if "group_id" is given (members of the bucket's group only). Keys themselves are never returned.
+// @Tags Copernicus
+// @Produce json
+// @Param group_id query string false "Bucket ID"
+// @Success 200 {object} []models.CopernicusCredential "OK"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/credentials [get]
+// @Security BearerAuth
+func GetCopernicusCredentials(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "CRD0008")
+		return
+	}
+
+	groupID := r.URL.Query().Get("group_id")
+	if err = checkCredentialOwner(r, claims, groupID); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", err.Error(), "CRD0009")
+		return
+	}
+
+	subject := claims.Subject
+	if groupID != "" {
+		subject = ""
+	}
+	credentialsCursor, err := globals.CopernicusCredentialDB.GetCursorByOwner(subject, groupID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get keys.", err.Error(), "CRD0010")
+		return
+	}
+	defer credentialsCursor.Close(context.Background())
+
+	credentials := []models.CopernicusCredential{}
+	for credentialsCursor.Next(context.Background()) {
+		var result bson.M
+		var credential models.CopernicusCredential
+		if err = credentialsCursor.Decode(&result); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve cursor.", err.Error(), "CRD0011")
+			return
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &credential)
+		credentials = append(credentials, credential)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// DeleteCopernicusCredential handles the /copernicus/credentials/{id} DELETE request.
+// @Summary Delete a stored Copernicus API key.
+// @Description Deletes a Copernicus key of the caller, or of a bucket of the caller's group. Later requests fall back to the next key available.
+// @Description Jobs already submitted with the key can no longer be followed, and fail.
+// @Tags Copernicus
+// @Produce json
+// @Param id path string true "Key ID"
+// @Success 200 {object} models.CopernicusCredential "OK"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/credentials/{id} [delete]
+// @Security BearerAuth
+func DeleteCopernicusCredential(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "CRD0012")
+		return
+	}
+
+	params := mux.Vars(r)
+	credential, err := globals.CopernicusCredentialDB.GetOneByID(params["id"])
+	if err != nil || (credential.Subject != "" && credential.Subject != claims.Subject) {
+		reason := "key not found"
+		if err != nil {
+			reason = err.Error()
+		}
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find key.", reason, "CRD0013")
+		return
+	}
+
+	if err = checkCredentialOwner(r, claims, credential.GroupID); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", err.Error(), "CRD0014")
+		return
+	}
+
+	err = globals.CopernicusCredentialDB.DeleteOneByID(credential.Id)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete key.", err.Error(), "CRD0015")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credential)
+}
+
+// checkCredentialOwner checks that the caller may manage the Copernicus keys of a bucket, or their own if groupID is empty.
+func checkCredentialOwner(r *http.Request, claims *models.OidcClaims, groupID string) error {
+	if groupID != "" {
+		return checkGroupMember(r, claims, groupID, "Copernicus keys")
+	}
+	principal, _ := utils.GetPrincipalFromContext(r.Context())
+	if principal.APIKeyID != "" {
+		return errors.New("API keys can't manage Copernicus keys")
+	}
+	return nil
+}
@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/indexing"
+	"github.com/isotiropoulos/storage-api/middleware"
 	"github.com/isotiropoulos/storage-api/models"
 	"github.com/isotiropoulos/storage-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,6 +27,7 @@ import (
 // @Produce json
 // @Tags Folders
 // @Param body body models.Folder true "Folder payload"
+// @Param conflict query string false "What to do if the title is taken: fail (default), rename, overwrite or skip"
 // @Success 200 {object} models.Bucket "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 409 {object} models.ErrorReport "Conflict"
@@ -31,9 +36,7 @@ import (
 // @Security BearerAuth
 func PostFolder(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -53,29 +56,11 @@ func PostFolder(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FOL0002")
 		return
 	}
-
-	// Check if name already exists
-	foldersCursor, err := globals.FolderDB.GetCursorByParent(folder.Parent)
+	policy, err := parseConflict(r)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FOL0003")
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FOL0063")
 		return
 	}
-	defer foldersCursor.Close(context.Background())
-
-	for foldersCursor.Next(context.Background()) {
-		var result bson.M
-		var inFolder models.Folder
-		if err := foldersCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FOL0004")
-			return
-		}
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &inFolder)
-		if inFolder.Meta.Title == folder.Meta.Title {
-			utils.RespondWithError(w, http.StatusConflict, "Folder Exists.", "Folders in the same path must have different names.", "FOL0005")
-			return
-		}
-	}
 
 	// Create folder body
 	folder.Files = make([]string, 0)
@@ -95,6 +80,7 @@ func PostFolder(w http.ResponseWriter, r *http.Request) {
 	folder.Meta.Update.Date = time.Now()
 
 	var ancestors []string
+	parentPath := ""
 
 	if folder.Parent != "" {
 		//Get the folder
@@ -111,6 +97,7 @@ func PostFolder(w http.ResponseWriter, r *http.Request) {
 		folder.Meta.Write = object.Meta.Write
 
 		ancestors = append(ancestors, folder.Parent)
+		parentPath = parentFolder.Path
 
 	} else {
 		ancestors = nil
@@ -121,11 +108,35 @@ func PostFolder(w http.ResponseWriter, r *http.Request) {
 	folder.Size = 0
 	folder.Folders = make([]string, 0)
 
-	err = globals.FolderDB.InsertOne(folder)
+	// The title is kept unique in the parent by the database
+	_, skippedID, err := utils.Place(policy, folder.Meta.Title, false, func(title string) error {
+		folder.Meta.Title = title
+		folder.Path = utils.ItemPath(parentPath, title)
+		return globals.FolderDB.InsertOne(folder)
+	}, folderHolder(folder.Parent), folderRemover(claims.Subject, folder.Id))
+	if errors.Is(err, utils.ErrInvalidTitle) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FOL0078")
+		return
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "Folder Exists.", "Folders in the same path must have different names.", "FOL0005")
+		return
+	}
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could post folder.", err.Error(), "FOL0008")
 		return
 	}
+	if skippedID != "" {
+		respondSkippedFolder(w, skippedID, "FOL0064")
+		return
+	}
+	// An overwrite has updated the parent
+	if folder.Parent != "" && policy == models.ConflictOverwrite {
+		if parentFolder, err = globals.FolderDB.GetOneByID(folder.Parent); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "FOL0067")
+			return
+		}
+	}
 
 	if folder.Parent != "" {
 
@@ -154,6 +165,7 @@ func PostFolder(w http.ResponseWriter, r *http.Request) {
 		}
 
 	}
+	setFolderPath(&folder)
 	json.NewEncoder(w).Encode(folder)
 }
 
@@ -173,9 +185,7 @@ func PostFolder(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -208,6 +218,8 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete folder.", err.Error(), "FOL0013")
 		return
 	}
+	middleware.InvalidateResolution(params["id"])
+	indexing.RemoveUnder(params["id"])
 
 	// Update Parent Folder
 	// Get the Parent folder
@@ -284,7 +296,7 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 
 		defer partCursor.Close(context.Background())
 		for partCursor.Next(context.Background()) {
-			groupId := r.Header.Get("X-Group-Id")
+			groupId := utils.GetGroupIDFromContext(r.Context())
 
 			var result2 bson.M
 			var part models.Part
@@ -315,11 +327,12 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 
 // GetFolder handles the /folder?id={id} get request.
 // @Summary Get folder by id.
-// @Description Get a folders meta data by the ID. Pass the ID in a query parameter.
+// @Description Get a folders meta data by the ID, or by its path from the bucket down (such as /group/a/b). Pass either in a query parameter.
 // @Accept json
 // @Produce json
 // @Tags Folders
-// @Param id query string true "Folder ID"
+// @Param id query string false "Folder ID"
+// @Param path query string false "Folder path"
 // @Success 200 {object} models.Folder "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 404 {object} models.ErrorReport "Not Found"
@@ -329,6 +342,10 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func GetFolder(w http.ResponseWriter, r *http.Request) {
 
+	if !utils.Authorize(w, r, models.CapRead) {
+		return
+	}
+
 	// folderPath := r.FormValue("folderPath")
 	queryValues := r.URL.Query()
 	folderID := queryValues.Get("id")
@@ -351,61 +368,23 @@ func GetFolder(w http.ResponseWriter, r *http.Request) {
 		}
 
 	} else {
-		folderPath = strings.TrimSuffix(folderPath, "/")
-		folderPath := strings.TrimPrefix(folderPath, "/")
-
-		groupId := r.Header.Get("X-Group-Id")
-
-		path := strings.Split(folderPath, "/")
-
-		if len(path) == 1 {
-			folder, err = globals.FolderDB.GetOneByID(groupId)
-		} else {
-			folderName := path[len(path)-1]
-
-			foldersCursor, err := globals.FolderDB.GetCursorByNameLevel(folderName, groupId, len(path)-1)
-			defer foldersCursor.Close(context.Background())
-
-			if err != nil {
-				utils.RespondWithError(w, http.StatusNotFound, "Could not get folder.", err.Error(), "FOL0023")
-				return
-			}
-
-			// Create a context that we can cancel
-			ctx2, cancel := context.WithCancel(context.Background())
-			defer cancel()
-
-			// Channel to receive the result
-			resultChan := make(chan models.Folder, 1)
-
-			// WaitGroup to wait for all goroutines to finish
-			var wg sync.WaitGroup
-
-			// Start a goroutine for each folder
-			for foldersCursor.Next(context.Background()) {
-				var result bson.M
-				var inFolder models.Folder
-				if err := foldersCursor.Decode(&result); err != nil {
-					utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FOL0028")
-					return
-				}
-				bsonBytes, _ := bson.Marshal(result)
-				bson.Unmarshal(bsonBytes, &inFolder)
-
-				wg.Add(1)
-				go utils.GrainFolders(ctx2, inFolder, path[len(path)-2], resultChan, &wg)
-			}
-
-			// Wait for a result from one of the goroutines
-			select {
-			case folder = <-resultChan:
-				// Condition met, cancel all other goroutines
-				// cancel()
-				close(resultChan)
-			case <-time.After(30 * time.Second): // Timeout to prevent infinite waiting
-				fmt.Println("Timeout reached, no condition met")
-			}
+		// Get folder by path, a name at a time from the bucket down
+		names := utils.SplitPath(folderPath)
+		folders, err := utils.WalkPath(names)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve path.", err.Error(), "FOL0059")
+			return
+		}
+		if len(names) == 0 || len(folders) < len(names) {
+			utils.RespondWithError(w, http.StatusNotFound, "Could not get folder.", "No folder at path "+folderPath+".", "FOL0060")
+			return
 		}
+		folder = folders[len(folders)-1]
+	}
+
+	if err = setFolderPath(&folder); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve path.", err.Error(), "FOL0059")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -416,10 +395,12 @@ func GetFolder(w http.ResponseWriter, r *http.Request) {
 // UpdateFolder handles the /folder put request.
 // @Summary Update folder by ID.
 // @Description Update a folders meta data by the ID. Pass the Folder model with the updates that are needed.
+// @Description Only "meta" is applied; the parent, ancestors, level and contents of the folder are kept.
 // @Accept json
 // @Produce json
 // @Tags Folders
 // @Param body body models.Folder true "Update body"
+// @Param conflict query string false "What to do if the title is taken: fail (default), rename, overwrite or skip"
 // @Success 200 {object} models.Folder "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 404 {object} models.ErrorReport "Not Found"
@@ -429,9 +410,7 @@ func GetFolder(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func UpdateFolder(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -450,47 +429,22 @@ func UpdateFolder(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FOL0025")
 		return
 	}
-
-	// Check title
-	parentID := ""
-	if updateFolder.Parent != "" {
-		parentID = updateFolder.Parent
-	} else {
-		parentID = r.Header.Get("X-Group-Id")
+	policy, err := parseConflict(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FOL0065")
+		return
 	}
 
-	// Check if title already exists
-	// First get current title
 	currentDoc, err := globals.FolderDB.GetOneByID(updateFolder.Id)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Folder trying to get updated doesn't exist.", err.Error(), "FOL0026")
 		return
 	}
 
-	if updateFolder.Meta.Title != currentDoc.Meta.Title {
-		// Check if title is illegal
-		foldersCursor, err := globals.FolderDB.GetCursorByParent(parentID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FOL0027")
-			return
-		}
-		defer foldersCursor.Close(context.Background())
-
-		for foldersCursor.Next(context.Background()) {
-			var result bson.M
-			var inFolder models.Folder
-			if err := foldersCursor.Decode(&result); err != nil {
-				utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FOL0028")
-				return
-			}
-			bsonBytes, _ := bson.Marshal(result)
-			bson.Unmarshal(bsonBytes, &inFolder)
-			if inFolder.Meta.Title == updateFolder.Meta.Title {
-				utils.RespondWithError(w, http.StatusConflict, "Folder Exists.", "Cannot rename folder to this name, since it is already taken.", "FOL0029")
-				return
-			}
-		}
-	}
+	// Only the metadata comes from the request; the folder stays where it is
+	meta := updateFolder.Meta
+	updateFolder = currentDoc
+	updateFolder.Meta = meta
 
 	// Update folder
 	// updateFolder.Meta.Update = append(currentDoc.Meta.Update, models.Updated{
@@ -500,11 +454,39 @@ func UpdateFolder(w http.ResponseWriter, r *http.Request) {
 	updateFolder.Meta.Update.User = claims.Subject
 	updateFolder.Meta.Update.Date = time.Now()
 
-	folder, err = globals.FolderDB.UpdateWithId(updateFolder)
+	// The title is kept unique in the parent by the database
+	_, skippedID, err := utils.Place(policy, updateFolder.Meta.Title, false, func(title string) error {
+		updateFolder.Meta.Title = title
+		folder, err = globals.FolderDB.UpdateWithId(updateFolder)
+		return err
+	}, folderHolder(updateFolder.Parent), folderRemover(claims.Subject, updateFolder.Id))
+	if errors.Is(err, utils.ErrInvalidTitle) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FOL0079")
+		return
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "Folder Exists.", "Cannot rename folder to this name, since it is already taken.", "FOL0029")
+		return
+	}
 	if err != nil {
 		utils.RespondWithError(w, http.StatusConflict, "Could not update folder.", err.Error(), "FOL0030")
 		return
 	}
+	if skippedID != "" {
+		respondSkippedFolder(w, skippedID, "FOL0066")
+		return
+	}
+	middleware.InvalidateResolution(folder.Id)
+
+	// Keep the paths of the folder and its items in step with the title
+	folder.Path, err = utils.PathInFolder(folder.Parent, folder.Meta.Title)
+	if err == nil {
+		err = utils.RepathFolder(folder.Id, currentDoc.Path, folder.Path)
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not update folder's path.", err.Error(), "FOL0062")
+		return
+	}
 
 	// Update ancestores meta
 	err = globals.FolderDB.UpdateMetaAncestors(folder.Ancestors, claims.Subject)
@@ -513,6 +495,7 @@ func UpdateFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setFolderPath(&folder)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(folder)
 }
@@ -520,22 +503,49 @@ func UpdateFolder(w http.ResponseWriter, r *http.Request) {
 // GetFolderItems handles the /folder/list?id={id} get request.
 // @Summary List folder's items.
 // @Description Get lists of files and folders in a specific folder, by id. Result is a FolderList model
+// @Description Items are sorted by "sort" (name, size, created or updated; name by default) in "order" (asc or desc), folders first.
+// @Description With "limit", a page of at most limit items (up to 1000) is returned, with a "next_cursor" to pass as "cursor" for the next page. The other parameters must stay the same between pages.
+// @Description Filters are combined: "type" (file extension, files only), "tag", "creator", creation and update date ranges (RFC 3339 or YYYY-MM-DD, from inclusive, to exclusive) and "kind" (files or folders).
+// @Description "total_files" and "total_folders" count the items matching the filters on all pages.
 // @Accept json
 // @Produce json
 // @Tags Folders
 // @Param id query string true "Folder ID"
+// @Param limit query int false "Page size (1 to 1000); all items if empty"
+// @Param cursor query string false "Cursor of the page, from next_cursor"
+// @Param sort query string false "name, size, created or updated"
+// @Param order query string false "asc or desc"
+// @Param type query string false "File extension"
+// @Param tag query string false "Tag"
+// @Param creator query string false "User ID of the creator"
+// @Param kind query string false "files or folders"
+// @Param created_from query string false "Created at or after"
+// @Param created_to query string false "Created before"
+// @Param updated_from query string false "Updated at or after"
+// @Param updated_to query string false "Updated before"
 // @Success 200 {object} models.FolderList "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 409 {object} models.ErrorReport "Conflict"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Router /folder/list [get]
 // @Security BearerAuth
 func GetFolderItems(w http.ResponseWriter, r *http.Request) {
 
+	if !utils.Authorize(w, r, models.CapRead) {
+		return
+	}
+
 	folderID := r.FormValue("id")
 
 	if folderID == "" {
 		// Get root
-		folderID = r.Header.Get("X-Group-Id")
+		folderID = utils.GetGroupIDFromContext(r.Context())
+	}
+
+	listing, err := parseListing(r, folderID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid listing parameters.", err.Error(), "FOL0056")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -544,52 +554,272 @@ func GetFolderItems(w http.ResponseWriter, r *http.Request) {
 	retObject.Files = []models.File{}
 	retObject.Folders = []models.Folder{}
 
-	// Retrieve files from DB
-	fileCursor, err := globals.FileDB.GetCursorByFolderID(folderID)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get files in folder.", err.Error(), "FOL0032")
-		return
+	// Counts cover all pages
+	if listing.folders {
+		if retObject.TotalFolders, err = globals.FolderDB.CountByQuery(listing.query); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not count children folders in folder.", err.Error(), "FOL0057")
+			return
+		}
+	}
+	if listing.files {
+		if retObject.TotalFiles, err = globals.FileDB.CountByQuery(listing.query); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not count files in folder.", err.Error(), "FOL0058")
+			return
+		}
 	}
-	defer fileCursor.Close(context.Background())
 
-	for fileCursor.Next(context.Background()) {
+	// A page holds folders, then files. One more item than the page size is read to tell
+	// whether there is a next page.
+	remaining := listing.limit
+	if listing.folders && listing.start == "folders" {
+		query := listing.query
+		if remaining > 0 {
+			query.Limit = remaining + 1
+		}
 
-		var result bson.M
-		var file models.File
-		if err = fileCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "FOL0033")
+		// Retrieve folders from DB
+		folderCursor, err := globals.FolderDB.GetCursorByQuery(query)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not get children folders in folder.", err.Error(), "FOL0034")
 			return
 		}
+		defer folderCursor.Close(context.Background())
 
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &file)
-		retObject.Files = append(retObject.Files, file)
+		for folderCursor.Next(context.Background()) {
+
+			var result bson.M
+			var folder models.Folder
+			if err = folderCursor.Decode(&result); err != nil {
+				utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "FOL0035")
+				return
+			}
+
+			bsonBytes, _ := bson.Marshal(result)
+			bson.Unmarshal(bsonBytes, &folder)
+
+			if listing.limit > 0 && int64(len(retObject.Folders)) == listing.limit {
+				last := retObject.Folders[len(retObject.Folders)-1]
+				retObject.NextCursor = listing.next("folders", &models.ListPosition{Value: folderSortValue(last, listing.sort), Id: last.Id})
+				break
+			}
+			retObject.Folders = append(retObject.Folders, folder)
+		}
+		remaining -= int64(len(retObject.Folders))
+		// Files start from the beginning
+		listing.query.After = nil
 	}
 
-	// Retrieve folders from DB
-	folderCursor, err := globals.FolderDB.GetCursorByParent(folderID)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get children folders in folder.", err.Error(), "FOL0034")
+	if listing.files && retObject.NextCursor == "" {
+		query := listing.query
+		if listing.limit > 0 {
+			query.Limit = remaining + 1
+		}
+
+		// Retrieve files from DB
+		fileCursor, err := globals.FileDB.GetCursorByQuery(query)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not get files in folder.", err.Error(), "FOL0032")
+			return
+		}
+		defer fileCursor.Close(context.Background())
+
+		for fileCursor.Next(context.Background()) {
+
+			var result bson.M
+			var file models.File
+			if err = fileCursor.Decode(&result); err != nil {
+				utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "FOL0033")
+				return
+			}
+
+			bsonBytes, _ := bson.Marshal(result)
+			bson.Unmarshal(bsonBytes, &file)
+
+			if listing.limit > 0 && int64(len(retObject.Files)) == remaining {
+				var after *models.ListPosition
+				if len(retObject.Files) > 0 {
+					last := retObject.Files[len(retObject.Files)-1]
+					after = &models.ListPosition{Value: fileSortValue(last, listing.sort), Id: last.Id}
+				}
+				retObject.NextCursor = listing.next("files", after)
+				break
+			}
+			retObject.Files = append(retObject.Files, file)
+		}
+	}
+
+	if err := setPaths(retObject.Files, retObject.Folders); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve paths.", err.Error(), "FOL0061")
 		return
 	}
+	json.NewEncoder(w).Encode(retObject)
+}
 
-	defer folderCursor.Close(context.Background())
+// maxListLimit is the largest page of a folder listing.
+const maxListLimit = 1000
 
-	for folderCursor.Next(context.Background()) {
+// listSortFields are the fields folder listings can be sorted by.
+var listSortFields = map[string]string{
+	"name":    "title_key",
+	"size":    "size",
+	"created": "meta.date_creation",
+	"updated": "meta.update.date",
+}
 
-		var result bson.M
-		var folder models.Folder
-		if err = folderCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "FOL0035")
-			return
+// folderListing is a parsed /folder/list request.
+type folderListing struct {
+	query   models.ListQuery
+	sort    string // Sort parameter: name, size, created or updated
+	limit   int64  // Page size; all items if 0
+	files   bool   // Whether files are listed
+	folders bool   // Whether folders are listed
+	start   string // Collection the page starts in: "folders" or "files"
+}
+
+// listCursor is the position of a page of a folder listing, encoded in next_cursor.
+type listCursor struct {
+	Start string               `bson:"start"`           // "folders" or "files"
+	Sort  string               `bson:"sort"`            // Sort parameter the cursor was made with
+	Desc  bool                 `bson:"desc"`            // Order the cursor was made with
+	After *models.ListPosition `bson:"after,omitempty"` // Last item of the previous page in Start; from the beginning if empty
+}
+
+// parseListing reads the sort, filters and page of a folder listing.
+func parseListing(r *http.Request, folderID string) (folderListing, error) {
+	params := r.URL.Query()
+	listing := folderListing{
+		query: models.ListQuery{
+			Parent:   folderID,
+			FileType: strings.TrimPrefix(params.Get("type"), "."),
+			Tag:      params.Get("tag"),
+			Creator:  params.Get("creator"),
+		},
+		sort:    "name",
+		files:   true,
+		folders: true,
+		start:   "folders",
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		if _, ok := listSortFields[sort]; !ok {
+			return listing, fmt.Errorf("sort must be name, size, created or updated")
+		}
+		listing.sort = sort
+	}
+	listing.query.SortField = listSortFields[listing.sort]
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		listing.query.Descending = true
+	default:
+		return listing, fmt.Errorf("order must be asc or desc")
+	}
+
+	switch params.Get("kind") {
+	case "":
+	case "files":
+		listing.folders = false
+	case "folders":
+		listing.files = false
+	default:
+		return listing, fmt.Errorf("kind must be files or folders")
+	}
+	// Folders have no file type
+	if listing.query.FileType != "" {
+		listing.folders = false
+	}
+	if !listing.folders {
+		listing.start = "files"
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_from": &listing.query.CreatedFrom,
+		"created_to":   &listing.query.CreatedTo,
+		"updated_from": &listing.query.UpdatedFrom,
+		"updated_to":   &listing.query.UpdatedTo,
+	} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if date, err = time.Parse("2006-01-02", value); err != nil {
+				return listing, fmt.Errorf("%s must be a date (RFC 3339 or YYYY-MM-DD)", name)
+			}
 		}
+		*target = &date
+	}
 
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &folder)
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value < 1 || value > maxListLimit {
+			return listing, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		listing.limit = value
+	}
 
-		retObject.Folders = append(retObject.Folders, folder)
+	if encoded := params.Get("cursor"); encoded != "" {
+		if listing.limit == 0 {
+			listing.limit = 100
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(encoded)
+		var cursor listCursor
+		if err == nil {
+			err = bson.Unmarshal(raw, &cursor)
+		}
+		if err != nil {
+			return listing, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Sort != listing.sort || cursor.Desc != listing.query.Descending {
+			return listing, fmt.Errorf("cursor was made with another sort order")
+		}
+		if cursor.Start != "folders" && cursor.Start != "files" {
+			return listing, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Start == "files" {
+			listing.start = "files"
+		}
+		listing.query.After = cursor.After
+	}
+	return listing, nil
+}
+
+// next encodes the cursor of the page that starts after a position in a collection.
+func (listing folderListing) next(start string, after *models.ListPosition) string {
+	raw, err := bson.Marshal(listCursor{Start: start, Sort: listing.sort, Desc: listing.query.Descending, After: after})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// folderSortValue is the value a folder is sorted by.
+func folderSortValue(folder models.Folder, sort string) interface{} {
+	switch sort {
+	case "size":
+		return folder.Size
+	case "created":
+		return folder.Meta.DateCreation
+	case "updated":
+		return folder.Meta.Update.Date
+	default:
+		return folder.TitleKey
+	}
+}
+
+// fileSortValue is the value a file is sorted by.
+func fileSortValue(file models.File, sort string) interface{} {
+	switch sort {
+	case "size":
+		return file.Size
+	case "created":
+		return file.Meta.DateCreation
+	case "updated":
+		return file.Meta.Update.Date
+	default:
+		return file.TitleKey
 	}
-	json.NewEncoder(w).Encode(retObject)
 }
 
 func copySubFile(fileID string, newDest string, bucketFrom string, bucketTo string, nName string, user string) {
@@ -620,35 +850,35 @@ func copySubFile(fileID string, newDest string, bucketFrom string, bucketTo stri
 		return
 	}
 
-	// Check if title is illegal
-	filesCursor, err := globals.FileDB.GetCursorByFolderID(cmBody.Destination)
+	//bucketID := r.Header.Get("X-Group-Id")
+	newFileId, err := utils.GenerateUUID()
 	if err != nil {
-		//utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FIL0045")
+		//utils.RespondWithError(w, http.StatusInternalServerError, "Error in generating file's ID.", err.Error(), "FIL0048")
 		return
 	}
-	defer filesCursor.Close(context.Background())
 
-	for filesCursor.Next(context.Background()) {
-		var result bson.M
-		var inFile models.File
-		if err := filesCursor.Decode(&result); err != nil {
-			//utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FIL0046")
-			return
-		}
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &inFile)
-		if inFile.Meta.Title == newName {
-			//utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot copy file to destination with this name since it is already taken.", "FIL0047")
-			return
-		}
+	// Insert file
+	file.FolderID = cmBody.Destination
+	// Create a new `Updated` struct
+	updated := models.Updated{
+		User: user,
+		Date: time.Now(),
 	}
+	// file.Meta.Update = []models.Updated{updated}
+	file.Meta.Update.User = updated.User
+	file.Meta.Update.Date = updated.Date
+	file.Id = newFileId
+	file.Meta.Title = newName
+	file.Path = utils.ItemPath(newParent.Path, newName)
 
-	//bucketID := r.Header.Get("X-Group-Id")
-	newFileId, err := utils.GenerateUUID()
+	ancestors := append(newParent.Ancestors, file.FolderID)
+	file.Ancestors = ancestors
+	err = globals.FileDB.InsertOne(file)
 	if err != nil {
-		//utils.RespondWithError(w, http.StatusInternalServerError, "Error in generating file's ID.", err.Error(), "FIL0048")
+		//utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy file.", err.Error(), "FIL0049")
 		return
 	}
+
 	partsCursor, err := globals.PartsDB.GetCursorByFileID(cmBody.Id)
 	if err != nil {
 		//utils.RespondWithError(w, http.StatusInternalServerError, "Error in retrieving parts.", err.Error(), "FIL0017")
@@ -687,26 +917,7 @@ func copySubFile(fileID string, newDest string, bucketFrom string, bucketTo stri
 		}
 	}
 
-	// Insert file
-	file.FolderID = cmBody.Destination
-	// Create a new `Updated` struct
-	updated := models.Updated{
-		User: user,
-		Date: time.Now(),
-	}
-	// file.Meta.Update = []models.Updated{updated}
-	file.Meta.Update.User = updated.User
-	file.Meta.Update.Date = updated.Date
-	file.Id = newFileId
-	file.Meta.Title = newName
-
-	ancestors := append(newParent.Ancestors, file.FolderID)
-	file.Ancestors = ancestors
-	err = globals.FileDB.InsertOne(file)
-	if err != nil {
-		//utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy file.", err.Error(), "FIL0049")
-		return
-	}
+	indexing.Copy(cmBody.Id, file)
 
 	// Update New Parent Folder
 	newParent.Files = append(newParent.Files, file.Id)
@@ -729,7 +940,7 @@ func copySubFile(fileID string, newDest string, bucketFrom string, bucketTo stri
 
 }
 
-func copySubFolder(folderID string, newDest string, bucketID string, nName string, user string) string {
+func copySubFolder(folderID string, newDest string, bucketID string, nName string, user string, policy models.ConflictPolicy) (newID string, skippedID string, err error) {
 
 	cmBody := models.CopyMoveBody{
 
@@ -741,9 +952,10 @@ func copySubFolder(folderID string, newDest string, bucketID string, nName strin
 	folder, err := globals.FolderDB.GetOneByID(cmBody.Id)
 	if err != nil {
 		//utils.RespondWithError(w, http.StatusBadRequest, "File doesn't exist.", err.Error(), "FIL0043")
-		return ""
+		return "", "", err
 	}
 
+	source := folder
 	subFolders := folder.Folders
 	subFiles := folder.Files
 
@@ -762,13 +974,13 @@ func copySubFolder(folderID string, newDest string, bucketID string, nName strin
 	newParent, err := globals.FolderDB.GetOneByID(cmBody.Destination)
 	if err != nil {
 		//fmt.Errorf("Destination doesn't exist.: %v", err, "FOL0036")
-		return ""
+		return "", "", err
 	}
 
 	newFolderId, err := utils.GenerateUUID()
 	if err != nil {
 		//utils.RespondWithError(w, http.StatusInternalServerError, "Error in generating file's ID.", err.Error(), "FIL0048")
-		return ""
+		return "", "", err
 	}
 
 	folder.Ancestors = newParent.Ancestors
@@ -784,14 +996,26 @@ func copySubFolder(folderID string, newDest string, bucketID string, nName strin
 	folder.Meta.Update.User = updated.User
 	folder.Meta.Update.Date = updated.Date
 	folder.Id = newFolderId
-	folder.Meta.Title = newName
 
 	ancestors := append(newParent.Ancestors, folder.Parent)
 	folder.Ancestors = ancestors
-	err = globals.FolderDB.InsertOne(folder)
-	if err != nil {
-		//utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy file.", err.Error(), "FIL0049")
-		return ""
+
+	// Only the copied folder itself can meet a taken title, as its items go in a new folder.
+	// An overwrite keeps the folders that hold the source, which it would delete.
+	kept := append([]string{cmBody.Id}, source.Ancestors...)
+	_, skippedID, err = utils.Place(policy, newName, false, func(title string) error {
+		folder.Meta.Title = title
+		folder.Path = utils.ItemPath(newParent.Path, title)
+		return globals.FolderDB.InsertOne(folder)
+	}, folderHolder(newParent.Id), folderRemover(user, kept...))
+	if err != nil || skippedID != "" {
+		return "", skippedID, err
+	}
+	// An overwrite has updated the destination
+	if policy == models.ConflictOverwrite {
+		if newParent, err = globals.FolderDB.GetOneByID(newParent.Id); err != nil {
+			return "", "", err
+		}
 	}
 
 	// Update New Parent Folder
@@ -803,14 +1027,14 @@ func copySubFolder(folderID string, newDest string, bucketID string, nName strin
 	_, err = globals.FolderDB.UpdateWithId(newParent)
 	if err != nil {
 		//utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "FIL0051")
-		return ""
+		return "", "", err
 	}
 
 	// Update Ancestores Size
 	err = globals.FolderDB.UpdateAncestorSize(ancestors, folder.Size, true)
 	if err != nil {
 		//utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "FIL0074")
-		return ""
+		return "", "", err
 	}
 
 	//COPY SUBFILES
@@ -823,10 +1047,10 @@ func copySubFolder(folderID string, newDest string, bucketID string, nName strin
 	}
 	//COPY SUBFOLDERS
 	for _, element := range subFolders {
-		copySubFolder(element, newFolderId, bucketID, "", user)
+		copySubFolder(element, newFolderId, bucketID, "", user, models.ConflictFail)
 	}
 
-	return newFolderId
+	return newFolderId, "", nil
 }
 
 // CopyFolder handles the /folder/copy post request.
@@ -837,17 +1061,17 @@ func copySubFolder(folderID string, newDest string, bucketID string, nName strin
 // @Produce json
 // @Tags Folders
 // @Param body body models.CopyMoveBody true "Body with Copy details"
+// @Param conflict query string false "What to do if the title is taken: fail (default), rename, overwrite or skip"
 // @Success 202 {object} models.FolderList "Accepted"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
 // @Failure 409 {object} models.ErrorReport "Conflict"
 // @Failure 500 {object} models.ErrorReport "Internal Server Error"
 // @Router /folder/copy [post]
 // @Security BearerAuth
 func CopyFolder(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -865,33 +1089,36 @@ func CopyFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Chech if title is legal
-
-	folderCursor, err := globals.FolderDB.GetCursorByParent(cmBody.Destination)
+	policy, err := parseConflict(r)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FIL0039")
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FOL0068")
 		return
 	}
-	defer folderCursor.Close(context.Background())
 
-	for folderCursor.Next(context.Background()) {
-		var result bson.M
-		var inFile models.File
-		if err := folderCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve cursor.", err.Error(), "FOL0040")
-			return
-		}
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &inFile)
-		if inFile.Meta.Title == cmBody.NewName {
-			utils.RespondWithError(w, http.StatusConflict, "Folder Exists.", "Cannot copy file to destination with this name since it is already taken.", "FOL0041")
-			return
-		}
+	if err = checkDestination(r, cmBody.Destination); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "Not allowed to write to destination.", err.Error(), "FOL0081")
+		return
 	}
 
 	// Call helper function to recursivelly copy target folder and all sub files and folders
 
-	var newFLID = copySubFolder(cmBody.Id, cmBody.Destination, r.Header.Get("X-Group-Id"), cmBody.NewName, claims.Subject)
+	newFLID, skippedID, err := copySubFolder(cmBody.Id, cmBody.Destination, utils.GetGroupIDFromContext(r.Context()), cmBody.NewName, claims.Subject, policy)
+	if errors.Is(err, utils.ErrInvalidTitle) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FOL0080")
+		return
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "Folder Exists.", "Cannot copy folder to destination with this name since it is already taken.", "FOL0041")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy folder.", err.Error(), "FOL0069")
+		return
+	}
+	if skippedID != "" {
+		respondSkippedFolder(w, skippedID, "FOL0070")
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
@@ -903,6 +1130,7 @@ func CopyFolder(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not locate copied folder.", err.Error(), "FOL0042")
 		return
 	}
+	setFolderPath(&folder)
 	json.NewEncoder(w).Encode(folder) //response should be mongo obj or resp w/ error
 }
 
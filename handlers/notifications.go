@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/notify"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// minWebhookSecret is the least length of a webhook secret.
+const minWebhookSecret = 16
+
+// GetNotificationPreferences handles the /notifications/preferences GET request.
+// @Summary Get the caller's notification preferences.
+// @Description Returns how the caller is notified when their Copernicus jobs end. Webhook secrets are never returned.
+// @Tags Notifications
+// @Produce json
+// @Success 200 {object} models.NotificationPreferences "OK"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /notifications/preferences [get]
+// @Security BearerAuth
+func GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "NTF0001")
+		return
+	}
+
+	preferences, err := globals.NotificationDB.GetOneBySubject(claims.Subject)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		utils.RespondWithError(w, http.StatusNotFound, "No notification preferences.", err.Error(), "NTF0002")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get notification preferences.", err.Error(), "NTF0003")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// PutNotificationPreferences handles the /notifications/preferences PUT request.
+// @Summary Set the caller's notification preferences.
+// @Description Sets how the caller is notified when their Copernicus jobs end: "successful", "failed" or "dismissed" (all events if "events" is empty).
+// @Description A "webhook" gets the notification POSTed as JSON, with an "X-Signature-256: sha256=<hex>" header holding the HMAC-SHA256 of the body keyed with the webhook's "secret".
+// @Description An "email" gets it over SMTP, at the address of the caller's token unless another address is given. Channels left out are turned off.
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Param body body models.PutNotificationPreferencesBody true "Preferences"
+// @Success 200 {object} models.NotificationPreferences "OK"
+// @Failure 400 {object} models.ValidationReport "Bad Request"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /notifications/preferences [put]
+// @Security BearerAuth
+func PutNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "NTF0004")
+		return
+	}
+
+	var req models.PutNotificationPreferencesBody
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "NTF0005")
+		return
+	}
+
+	preferences := models.NotificationPreferences{
+		Subject:     claims.Subject,
+		Events:      []string{},
+		DateUpdated: time.Now(),
+	}
+	var fieldErrors []models.FieldError
+	for _, event := range req.Events {
+		if !utils.ItemInArray(notify.Events, event) {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "events", Message: "unknown event " + event + ", expected one of " + strings.Join(notify.Events, ", ")})
+			continue
+		}
+		if !utils.ItemInArray(preferences.Events, event) {
+			preferences.Events = append(preferences.Events, event)
+		}
+	}
+
+	if req.Webhook != nil {
+		webhookURL, err := url.Parse(strings.TrimSpace(req.Webhook.URL))
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "webhook.url", Message: "must be an http or https URL"})
+		}
+		if len(req.Webhook.Secret) < minWebhookSecret {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "webhook.secret", Message: "must be at least 16 characters"})
+		}
+		if err == nil {
+			preferences.Webhook = &models.WebhookChannel{URL: webhookURL.String(), Secret: req.Webhook.Secret}
+		}
+	}
+
+	if req.Email != nil {
+		address := strings.TrimSpace(req.Email.Address)
+		if address == "" {
+			address = claims.Email
+		}
+		parsed, err := mail.ParseAddress(address)
+		switch {
+		case !notify.EmailEnabled():
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "email", Message: notify.ErrEmailDisabled.Error()})
+		case err != nil:
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "email.address", Message: "invalid email address"})
+		default:
+			preferences.Email = &models.EmailChannel{Address: parsed.Address}
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		utils.RespondWithFieldErrors(w, "Invalid notification preferences.", fieldErrors, "NTF0006")
+		return
+	}
+
+	err = globals.NotificationDB.ReplaceOne(preferences)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not store notification preferences.", err.Error(), "NTF0007")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// DeleteNotificationPreferences handles the /notifications/preferences DELETE request.
+// @Summary Turn off the caller's notifications.
+// @Description Deletes the caller's notification preferences, so that they are no longer notified.
+// @Tags Notifications
+// @Success 204 "No Content"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /notifications/preferences [delete]
+// @Security BearerAuth
+func DeleteNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "NTF0008")
+		return
+	}
+
+	err = globals.NotificationDB.DeleteOneBySubject(claims.Subject)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete notification preferences.", err.Error(), "NTF0009")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostTestNotification handles the /notifications/test POST request.
+// @Summary Send a test notification.
+// @Description Sends a notification of a made-up successful job over each of the caller's channels right away, and reports the channels that failed.
+// @Tags Notifications
+// @Produce json
+// @Success 200 {object} models.Notification "OK"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 502 {object} models.ErrorReport "Bad Gateway"
+// @Router /notifications/test [post]
+// @Security BearerAuth
+func PostTestNotification(w http.ResponseWriter, r *http.Request) {
+
+	// Resolve Claims
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "NTF0010")
+		return
+	}
+
+	preferences, err := globals.NotificationDB.GetOneBySubject(claims.Subject)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		utils.RespondWithError(w, http.StatusNotFound, "No notification preferences.", err.Error(), "NTF0011")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not get notification preferences.", err.Error(), "NTF0012")
+		return
+	}
+
+	id, err := utils.GenerateUUID()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not create notification.", err.Error(), "NTF0013")
+		return
+	}
+	notification := models.Notification{
+		Id:          id,
+		Event:       models.EventJobSuccessful,
+		Subject:     claims.Subject,
+		DatasetName: "test-notification",
+		Date:        time.Now(),
+	}
+	if err = notify.Deliver(preferences, notification); err != nil {
+		utils.RespondWithError(w, http.StatusBadGateway, "Could not deliver notification.", err.Error(), "NTF0014")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notification)
+}
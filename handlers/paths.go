@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// folderTitles looks up the titles of some folders at once.
+func folderTitles(ids []string) (map[string]string, error) {
+	titles := map[string]string{}
+	if len(ids) == 0 {
+		return titles, nil
+	}
+	cursor, err := globals.FolderDB.GetCursorByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	err = decodeSearch(cursor, func(result bson.M) {
+		var folder models.Folder
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &folder)
+		titles[folder.Id] = folder.Meta.Title
+	})
+	return titles, err
+}
+
+// pathFits tells if the stored path of an item has a name for each of its ancestors, so that
+// its breadcrumbs can be read from it.
+func pathFits(path string, ancestors []string) bool {
+	return path != "" && len(utils.SplitPath(path)) == len(ancestors)+1
+}
+
+// itemCrumbs returns the breadcrumbs and path of an item: from its stored path if it fits, or
+// else from the titles of its ancestors.
+func itemCrumbs(path string, ancestors []string, title string, titles map[string]string) ([]models.Breadcrumb, string) {
+	crumbs := []models.Breadcrumb{}
+	if pathFits(path, ancestors) {
+		names := utils.SplitPath(path)
+		for i, id := range ancestors {
+			crumbs = append(crumbs, models.Breadcrumb{Id: id, Title: names[i]})
+		}
+		return crumbs, path
+	}
+
+	path = ""
+	for _, id := range ancestors {
+		crumbs = append(crumbs, models.Breadcrumb{Id: id, Title: titles[id]})
+		path += "/" + titles[id]
+	}
+	return crumbs, path + "/" + title
+}
+
+// ancestorIds gathers the ancestors of some items, once each.
+func ancestorIds(ancestors ...[]string) []string {
+	var ids []string
+	for _, list := range ancestors {
+		for _, id := range list {
+			if !utils.ItemInArray(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// setPaths sets the path and breadcrumbs of files and folders, looking up at once the titles
+// their stored paths don't give.
+func setPaths(files []models.File, folders []models.Folder) error {
+	var missing [][]string
+	for _, file := range files {
+		if !pathFits(file.Path, file.Ancestors) {
+			missing = append(missing, file.Ancestors)
+		}
+	}
+	for _, folder := range folders {
+		if !pathFits(folder.Path, folder.Ancestors) {
+			missing = append(missing, folder.Ancestors)
+		}
+	}
+	titles, err := folderTitles(ancestorIds(missing...))
+	if err != nil {
+		return err
+	}
+
+	for i := range files {
+		files[i].Breadcrumbs, files[i].Path = itemCrumbs(files[i].Path, files[i].Ancestors, files[i].Meta.Title, titles)
+	}
+	for i := range folders {
+		folders[i].Breadcrumbs, folders[i].Path = itemCrumbs(folders[i].Path, folders[i].Ancestors, folders[i].Meta.Title, titles)
+	}
+	return nil
+}
+
+// setFilePath sets the path and breadcrumbs of a file.
+func setFilePath(file *models.File) error {
+	files := []models.File{*file}
+	if err := setPaths(files, nil); err != nil {
+		return err
+	}
+	*file = files[0]
+	return nil
+}
+
+// setFolderPath sets the path and breadcrumbs of a folder.
+func setFolderPath(folder *models.Folder) error {
+	folders := []models.Folder{*folder}
+	if err := setPaths(nil, folders); err != nil {
+		return err
+	}
+	*folder = folders[0]
+	return nil
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"path/filepath"
@@ -13,13 +14,18 @@ import (
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/isotiropoulos/storage-api/copernicus"
 	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/indexing"
+	"github.com/isotiropoulos/storage-api/middleware"
 	"github.com/isotiropoulos/storage-api/models"
 	"github.com/isotiropoulos/storage-api/utils"
 	"github.com/minio/minio-go/v7"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"encoding/json"
+	"log"
 	"net/http"
 )
 
@@ -37,6 +43,7 @@ import (
 // @Param total header string false "Total parts of multipart upload"
 // @Param file path string false "File ID"
 // @Param part query string false "Number of part"
+// @Param conflict query string false "What to do if the title is taken: fail (default), rename, overwrite or skip"
 // @Success 200 {object} models.File "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 404 {object} models.ErrorReport "Not Found"
@@ -46,9 +53,7 @@ import (
 // @Security BearerAuth
 func PostFile(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -83,6 +88,13 @@ func handleJSON(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not decode request body.", err.Error(), "FIL0005")
 		return
 	}
+	// Provenance is only recorded by the API
+	postFile.Provenance = nil
+	policy, err := parseConflict(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not decode request body.", err.Error(), "FIL0085")
+		return
+	}
 
 	// Get new file's ID
 	fileID, err := utils.GenerateUUID()
@@ -106,29 +118,6 @@ func handleJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if title is illegal
-	filesCursor, err := globals.FileDB.GetCursorByFolderID(postFile.FolderID)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FIL0036")
-		return
-	}
-	defer filesCursor.Close(context.Background())
-
-	for filesCursor.Next(context.Background()) {
-		var result bson.M
-		var inFile models.File
-		if err := filesCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FIL0037")
-			return
-		}
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &inFile)
-		if inFile.Meta.Title == postFile.Meta.Title {
-			utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot assign name to this file, since it is already taken.", "FIL0038")
-			return
-		}
-	}
-
 	update := models.Updated{
 		Date: time.Now(),
 		User: claims.Subject,
@@ -152,11 +141,28 @@ func handleJSON(w http.ResponseWriter, r *http.Request) {
 	meta.Update = update
 	postFile.Meta = meta
 
-	err = globals.FileDB.InsertOne(postFile)
+	// The title is kept unique in the folder by the database
+	_, skippedID, err := utils.Place(policy, postFile.Meta.Title, true, func(title string) error {
+		postFile.Meta.Title = title
+		postFile.Path = utils.ItemPath(folder.Path, title)
+		return globals.FileDB.InsertOne(postFile)
+	}, fileHolder(postFile.FolderID), fileRemover(postFile.Id, claims.Subject))
+	if errors.Is(err, utils.ErrInvalidTitle) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FIL0095")
+		return
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot assign name to this file, since it is already taken.", "FIL0038")
+		return
+	}
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error in creating stream.", err.Error(), "FIL0009")
 		return
 	}
+	if skippedID != "" {
+		respondSkippedFile(w, skippedID, "FIL0086")
+		return
+	}
 
 	// Update parent folder
 	err = globals.FolderDB.UpdateFiles(postFile.Id, postFile.FolderID)
@@ -172,12 +178,13 @@ func handleJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setFilePath(&postFile)
 	json.NewEncoder(w).Encode(postFile)
 }
 
 func handleOCTET(w http.ResponseWriter, r *http.Request) {
-	// Get bucket ID from headers
-	bucketID := r.Header.Get("X-Group-Id")
+	// Get bucket ID resolved by the middleware
+	bucketID := utils.GetGroupIDFromContext(r.Context())
 
 	// Read the request body
 	partBytes, err := ioutil.ReadAll(r.Body)
@@ -250,7 +257,7 @@ func handleOCTET(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update file size
-	_, err = globals.FileDB.UpdateFileSize(file.Id, size)
+	uploaded, err := globals.FileDB.UpdateFileSize(file.Id, size)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not update file's size.", err.Error(), "FIL0006")
 		return
@@ -263,12 +270,21 @@ func handleOCTET(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Index the document once its last part is in
+	if indexing.Enabled() {
+		if parts, err := globals.PartsDB.CountByFileID(file.Id); err == nil && parts >= int64(file.Total) {
+			indexing.Index(uploaded)
+		}
+	}
+
+	setFilePath(&file)
 	json.NewEncoder(w).Encode(file)
 }
 
 // GetFileInfo handles the /info/file/ get request.
 // @Summary Get metadata of file.
 // @Description Returns the metadata of a file by it's ID.
+// @Description Copernicus datasets also carry their "provenance": the dataset, request parameters, job ID, licences and download time.
 // @Tags Files
 // @Produce json
 // @Param id query string true "File ID"
@@ -278,6 +294,10 @@ func handleOCTET(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func GetFileInfo(w http.ResponseWriter, r *http.Request) {
 
+	if !utils.Authorize(w, r, models.CapRead) {
+		return
+	}
+
 	// fileId := r.FormValue("id")
 	params := mux.Vars(r) // Gets params
 	fileId := params["id"]
@@ -287,11 +307,92 @@ func GetFileInfo(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusNotFound, "Could not find file.", err.Error(), "FIL0019")
 		return
 	}
+	if err = setFilePath(&file); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve path.", err.Error(), "FIL0081")
+		return
+	}
+
+	w.Header().Set("parts", strconv.FormatInt(int64(file.Total), 10))
+	json.NewEncoder(w).Encode(file)
+}
+
+// GetFileByPath handles the /file?path={path} get request.
+// @Summary Get metadata of file by path.
+// @Description Returns the metadata of a file by its path from the bucket down, such as /group/a/b/file.tif.
+// @Description Should a folder hold the same title twice, the first one created is returned.
+// @Tags Files
+// @Produce json
+// @Param path query string true "File path"
+// @Success 200 {object} models.File "OK"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /file [get]
+// @Security BearerAuth
+func GetFileByPath(w http.ResponseWriter, r *http.Request) {
+
+	if !utils.Authorize(w, r, models.CapRead) {
+		return
+	}
+
+	filePath := r.URL.Query().Get("path")
+	file, err := utils.ResolveFilePath(utils.SplitPath(filePath))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find file.", "No file at path "+filePath+".", "FIL0082")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve path.", err.Error(), "FIL0083")
+		return
+	}
+	if err = setFilePath(&file); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve path.", err.Error(), "FIL0083")
+		return
+	}
 
 	w.Header().Set("parts", strconv.FormatInt(int64(file.Total), 10))
 	json.NewEncoder(w).Encode(file)
 }
 
+// GetFileProvenance handles the /file/provenance/{id} get request.
+// @Summary Export the provenance of a file.
+// @Description Returns where a Copernicus dataset comes from: the service and dataset, the request parameters, the job ID, the licences and the download time, with a suggested citation.
+// @Description Copies of the dataset keep its provenance.
+// @Tags Files
+// @Produce json
+// @Param id path string true "File ID"
+// @Success 200 {object} models.ProvenanceExport "OK"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Router /file/provenance/{id} [get]
+// @Security BearerAuth
+func GetFileProvenance(w http.ResponseWriter, r *http.Request) {
+
+	if !utils.Authorize(w, r, models.CapRead) {
+		return
+	}
+
+	params := mux.Vars(r) // Gets params
+	file, err := globals.FileDB.GetOneByID(params["id"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find file.", err.Error(), "FIL0079")
+		return
+	}
+
+	provenance, err := copernicus.Provenance(file)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "File has no provenance.", err.Error(), "FIL0080")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ProvenanceExport{
+		FileId:     file.Id,
+		Title:      file.Meta.Title,
+		Size:       file.Size,
+		Provenance: provenance,
+		Citation:   copernicus.Citation(provenance),
+	})
+}
+
 // GetFile handles the /file/{id} get request.
 // @Summary Download a file.
 // @Description This is the endopoint to get files. The files are downloaded using a **multipart streaming download**.
@@ -306,8 +407,12 @@ func GetFileInfo(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func GetFile(w http.ResponseWriter, r *http.Request) {
 
+	if !utils.Authorize(w, r, models.CapRead) {
+		return
+	}
+
 	// Retrieve group
-	groupId := r.Header.Get("X-Group-Id")
+	groupId := utils.GetGroupIDFromContext(r.Context())
 
 	// Get parameters
 	params := mux.Vars(r) // Gets params
@@ -372,9 +477,7 @@ func GetFile(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func DeleteFile(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -404,6 +507,8 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete file.", err.Error(), "FIL0027")
 		return
 	}
+	middleware.InvalidateResolution(file.Id)
+	indexing.Remove(file.Id)
 
 	// Update folder containing the file
 	// Get the Parent folder
@@ -441,7 +546,7 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Remove Object from MINIO
-	groupId := r.Header.Get("X-Group-Id")
+	groupId := utils.GetGroupIDFromContext(r.Context())
 	partsCursor, err := globals.PartsDB.GetCursorByFileID(file.Id)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error in retrieving parts.", err.Error(), "FIL0070")
@@ -487,6 +592,7 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param body body models.File  true "Request body"
+// @Param conflict query string false "What to do if the title is taken: fail (default), rename, overwrite or skip"
 // @Success 200 {object} models.File "OK"
 // @Failure 400 {object} models.ErrorReport "Bad Request"
 // @Failure 404 {object} models.ErrorReport "Not Found"
@@ -496,9 +602,7 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 func UpdateFile(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -517,48 +621,18 @@ func UpdateFile(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FIL0034")
 		return
 	}
-
-	// Check title
-	parentID := ""
-	if updateFile.FolderID != "" {
-		parentID = updateFile.FolderID
-	} else {
-		parentID = r.Header.Get("X-Group-Id")
+	policy, err := parseConflict(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FIL0087")
+		return
 	}
 
-	// Check if title already exists
-	// First get current title
 	currentDoc, err := globals.FileDB.GetOneByID(updateFile.Id)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "File trying to get updated doesn't exist.", err.Error(), "FIL0035")
 		return
 	}
 
-	if updateFile.Meta.Title != currentDoc.Meta.Title {
-		// Check if title is illegal
-		filesCursor, err := globals.FileDB.GetCursorByFolderID(parentID)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FIL0036")
-			return
-		}
-		defer filesCursor.Close(context.Background())
-
-		for filesCursor.Next(context.Background()) {
-			var result bson.M
-			var inFile models.File
-			if err := filesCursor.Decode(&result); err != nil {
-				utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FIL0037")
-				return
-			}
-			bsonBytes, _ := bson.Marshal(result)
-			bson.Unmarshal(bsonBytes, &inFile)
-			if inFile.Meta.Title == updateFile.Meta.Title {
-				utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot rename file to this name, since it is already taken.", "FIL0038")
-				return
-			}
-		}
-	}
-
 	// Update folder
 	// updateFile.Meta.Update = append(currentDoc.Meta.Update, models.Updated{
 	// 	User: claims.Subject,
@@ -567,11 +641,43 @@ func UpdateFile(w http.ResponseWriter, r *http.Request) {
 	updateFile.Meta.Update.Date = time.Now()
 	updateFile.Meta.Update.User = claims.Subject
 
-	file, err = globals.FileDB.UpdateWithId(updateFile)
+	// The title is kept unique in the folder by the database
+	_, skippedID, err := utils.Place(policy, updateFile.Meta.Title, true, func(title string) error {
+		updateFile.Meta.Title = title
+		file, err = globals.FileDB.UpdateWithId(updateFile)
+		return err
+	}, fileHolder(updateFile.FolderID), fileRemover(updateFile.Id, claims.Subject))
+	if errors.Is(err, utils.ErrInvalidTitle) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FIL0096")
+		return
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot rename file to this name, since it is already taken.", "FIL0038")
+		return
+	}
 	if err != nil {
 		utils.RespondWithError(w, http.StatusConflict, "Could not update folder.", err.Error(), "FIL0039")
 		return
 	}
+	if skippedID != "" {
+		respondSkippedFile(w, skippedID, "FIL0088")
+		return
+	}
+	middleware.InvalidateResolution(file.Id)
+
+	// Keep the path in step with the title
+	folderID := file.FolderID
+	if folderID == "" {
+		folderID = currentDoc.FolderID
+	}
+	file.Path, err = utils.PathInFolder(folderID, file.Meta.Title)
+	if err == nil && file.Path != currentDoc.Path {
+		err = globals.FileDB.SetPath(file.Id, file.Path)
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not update file's path.", err.Error(), "FIL0084")
+		return
+	}
 
 	// Update ancestores meta
 	err = globals.FolderDB.UpdateMetaAncestors(file.Ancestors, claims.Subject)
@@ -580,6 +686,7 @@ func UpdateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setFilePath(&file)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(file)
 }
@@ -587,9 +694,7 @@ func UpdateFile(w http.ResponseWriter, r *http.Request) {
 // CopyFile is to copy a file.
 func CopyFile(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -606,6 +711,11 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FIL0042")
 		return
 	}
+	policy, err := parseConflict(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FIL0089")
+		return
+	}
 
 	file, err := globals.FileDB.GetOneByID(cmBody.Id)
 	if err != nil {
@@ -625,36 +735,47 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Destination doesn't exist.", err.Error(), "FIL0044")
 		return
 	}
+	if err = checkDestination(r, newParent.Id); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "Not allowed to write to destination.", err.Error(), "FIL0099")
+		return
+	}
 
-	// Check if title is illegal
-	filesCursor, err := globals.FileDB.GetCursorByFolderID(cmBody.Destination)
+	bucketID := utils.GetGroupIDFromContext(r.Context())
+	newFileId, err := utils.GenerateUUID()
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FIL0045")
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error in generating file's ID.", err.Error(), "FIL0048")
 		return
 	}
-	defer filesCursor.Close(context.Background())
 
-	for filesCursor.Next(context.Background()) {
-		var result bson.M
-		var inFile models.File
-		if err := filesCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FIL0046")
-			return
-		}
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &inFile)
-		if inFile.Meta.Title == newName {
-			utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot copy file to destination with this name since it is already taken.", "FIL0047")
-			return
-		}
+	// Insert file
+	file.FolderID = cmBody.Destination
+	// Create a new `Updated` struct
+	updated := models.Updated{
+		User: claims.Subject,
+		Date: time.Now(),
 	}
+	// file.Meta.Update = []models.Updated{updated}
+	file.Meta.Update.User = updated.User
+	file.Meta.Update.Date = updated.Date
+	file.Id = newFileId
 
-	bucketID := r.Header.Get("X-Group-Id")
-	newFileId, err := utils.GenerateUUID()
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Error in generating file's ID.", err.Error(), "FIL0048")
+	ancestors := append(newParent.Ancestors, file.FolderID)
+	file.Ancestors = ancestors
+
+	if err = utils.CheckTitle(newName); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FIL0097")
 		return
 	}
+
+	// The parts are copied before the file is written, so that a file it overwrites is only
+	// deleted once the copy is complete. The copied parts are deleted if the copy fails or the
+	// file isn't written.
+	discardCopy := func() {
+		if err := removeParts(file); err != nil {
+			log.Println("Could not delete parts of failed copy "+file.Id+": ", err.Error())
+		}
+	}
+
 	partsCursor, err := globals.PartsDB.GetCursorByFileID(cmBody.Id)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error in retrieving parts.", err.Error(), "FIL0017")
@@ -666,6 +787,7 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 		var result bson.M
 		var part models.Part
 		if err := partsCursor.Decode(&result); err != nil {
+			discardCopy()
 			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FIL0068")
 			return
 		}
@@ -674,12 +796,14 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 
 		newPartID, err := utils.GenerateUUID()
 		if err != nil {
+			discardCopy()
 			utils.RespondWithError(w, http.StatusInternalServerError, "Error in generating part's ID.", err.Error(), "FIL0073")
 			return
 		}
 
-		err = globals.Storage.CopyFile(part.Id, newPartID, bucketID, newParent.Ancestors[0])
+		err = globals.Storage.CopyFile(part.Id, newPartID, bucketID, ancestors[0])
 		if err != nil {
+			discardCopy()
 			utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy file.", err.Error(), "FIL0050")
 			return
 		}
@@ -688,31 +812,44 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 		part.FileID = newFileId
 		err = globals.PartsDB.InsertOne(part)
 		if err != nil {
+			// The object is not recorded as a part yet
+			globals.Storage.DeleteFile(newPartID, ancestors[0])
+			discardCopy()
 			utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy file.", err.Error(), "FIL0072")
 			return
 		}
 	}
 
-	// Insert file
-	file.FolderID = cmBody.Destination
-	// Create a new `Updated` struct
-	updated := models.Updated{
-		User: claims.Subject,
-		Date: time.Now(),
+	// The title is kept unique in the folder by the database
+	_, skippedID, err := utils.Place(policy, newName, true, func(title string) error {
+		file.Meta.Title = title
+		file.Path = utils.ItemPath(newParent.Path, title)
+		return globals.FileDB.InsertOne(file)
+	}, fileHolder(newParent.Id), fileRemover(cmBody.Id, claims.Subject))
+	if err != nil || skippedID != "" {
+		discardCopy()
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot copy file to destination with this name since it is already taken.", "FIL0047")
+		return
 	}
-	// file.Meta.Update = []models.Updated{updated}
-	file.Meta.Update.User = updated.User
-	file.Meta.Update.Date = updated.Date
-	file.Id = newFileId
-	file.Meta.Title = newName
-
-	ancestors := append(newParent.Ancestors, file.FolderID)
-	file.Ancestors = ancestors
-	err = globals.FileDB.InsertOne(file)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Could not copy file.", err.Error(), "FIL0049")
 		return
 	}
+	if skippedID != "" {
+		respondSkippedFile(w, skippedID, "FIL0090")
+		return
+	}
+	// An overwrite has updated the destination
+	if policy == models.ConflictOverwrite {
+		if newParent, err = globals.FolderDB.GetOneByID(newParent.Id); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "FIL0091")
+			return
+		}
+	}
+
+	indexing.Copy(cmBody.Id, file)
 
 	// Update New Parent Folder
 	newParent.Files = append(newParent.Files, file.Id)
@@ -733,6 +870,7 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setFilePath(&file)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(file)
@@ -742,9 +880,7 @@ func CopyFile(w http.ResponseWriter, r *http.Request) {
 // MoveFile is to move a folder.
 func MoveFile(w http.ResponseWriter, r *http.Request) {
 
-	mode := r.Header.Get("X-Mode")
-	if mode == "viewer" {
-		utils.RespondWithError(w, http.StatusForbidden, "User not allowed", "User with viewer rights can't perform this action", "BUC0001")
+	if !utils.Authorize(w, r, models.CapWrite) {
 		return
 	}
 
@@ -762,6 +898,11 @@ func MoveFile(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FIL0053")
 		return
 	}
+	policy, err := parseConflict(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "FIL0094")
+		return
+	}
 
 	// Get file document
 	file, err := globals.FileDB.GetOneByID(cmBody.Id)
@@ -776,6 +917,10 @@ func MoveFile(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Destination doesn't exist.", err.Error(), "FIL0055")
 		return
 	}
+	if err = checkDestination(r, newParent.Id); err != nil {
+		utils.RespondWithError(w, http.StatusForbidden, "Not allowed to write to destination.", err.Error(), "FIL0100")
+		return
+	}
 
 	// Get old folder document
 	oldParent, err := globals.FolderDB.GetOneByID(file.FolderID)
@@ -792,33 +937,66 @@ func MoveFile(w http.ResponseWriter, r *http.Request) {
 		newName = cmBody.NewName
 	}
 
-	filesCursor, err := globals.FileDB.GetCursorByFolderID(cmBody.Destination)
+	oldAncestores := file.Ancestors
+
+	file.FolderID = cmBody.Destination
+	// Create a new `Updated` struct
+	updated := models.Updated{
+		User: claims.Subject,
+		Date: time.Now(),
+	}
+	// file.Meta.Update = []models.Updated{updated}
+	file.Meta.Update.User = updated.User
+	file.Meta.Update.Date = updated.Date
+
+	ancestors := append(newParent.Ancestors, file.FolderID)
+	file.Ancestors = ancestors
+
+	// The title is kept unique in the folder by the database, so the file is moved before its
+	// old parent lets go of it
+	var updatedFile models.File
+	_, skippedID, err := utils.Place(policy, newName, true, func(title string) error {
+		file.Meta.Title = title
+		if updatedFile, err = globals.FileDB.UpdateWithId(file); err != nil {
+			return err
+		}
+		file.Path = utils.ItemPath(newParent.Path, title)
+		updatedFile.Path = file.Path
+		return globals.FileDB.SetPath(file.Id, file.Path)
+	}, fileHolder(newParent.Id), fileRemover(file.Id, claims.Subject))
+	if errors.Is(err, utils.ErrInvalidTitle) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid name.", err.Error(), "FIL0098")
+		return
+	}
+	if errors.Is(err, utils.ErrTitleTaken) {
+		utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot move file to destination with this name since it is already taken.", "FIL0058")
+		return
+	}
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not obtain siblings.", err.Error(), "FIL0056")
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not file folder.", err.Error(), "FIL0062")
+		return
+	}
+	if skippedID != "" {
+		respondSkippedFile(w, skippedID, "FIL0092")
 		return
 	}
-	defer filesCursor.Close(context.Background())
+	middleware.InvalidateResolution(file.Id)
+	indexing.Move(file)
 
-	for filesCursor.Next(context.Background()) {
-		var result bson.M
-		var inFile models.File
-		if err := filesCursor.Decode(&result); err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve cursor.", err.Error(), "FIL0057")
+	// An overwrite has updated the destination, which may also be the old parent
+	if policy == models.ConflictOverwrite {
+		if newParent, err = globals.FolderDB.GetOneByID(newParent.Id); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "FIL0093")
 			return
 		}
-		bsonBytes, _ := bson.Marshal(result)
-		bson.Unmarshal(bsonBytes, &inFile)
-		if inFile.Meta.Title == newName {
-			utils.RespondWithError(w, http.StatusConflict, "File Exists.", "Cannot move file to destination with this name since it is already taken.", "FIL0058")
+		if oldParent, err = globals.FolderDB.GetOneByID(oldParent.Id); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not update parent folder.", err.Error(), "FIL0093")
 			return
 		}
 	}
 
 	// Remove the deleted file (but keep the order)
-	newFiles := utils.RemoveFromSlice(oldParent.Files, file.Id)
-	oldAncestores := file.Ancestors
-
-	oldParent.Files = newFiles
+	oldParent.Files = utils.RemoveFromSlice(oldParent.Files, file.Id)
 
 	// Update old Parent
 	oldParent, err = globals.FolderDB.UpdateWithId(oldParent)
@@ -828,31 +1006,12 @@ func MoveFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update OLD Parent Ancestore's Meta
-	err = globals.FolderDB.UpdateMetaAncestors(file.Ancestors, claims.Subject)
+	err = globals.FolderDB.UpdateMetaAncestors(oldAncestores, claims.Subject)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error in updating ancestore's meta.", err.Error(), "FIL0061")
 		return
 	}
 
-	file.FolderID = cmBody.Destination
-	// Create a new `Updated` struct
-	updated := models.Updated{
-		User: claims.Subject,
-		Date: time.Now(),
-	}
-	// file.Meta.Update = []models.Updated{updated}
-	file.Meta.Update.User = updated.User
-	file.Meta.Update.Date = updated.Date
-
-	file.Meta.Title = newName
-	ancestors := append(newParent.Ancestors, file.FolderID)
-	file.Ancestors = ancestors
-	updatedFile, err := globals.FileDB.UpdateWithId(file)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Could not file folder.", err.Error(), "FIL0062")
-		return
-	}
-
 	// Update New Parent Folder
 	newParent.Files = append(newParent.Files, file.Id)
 	// newParent.Meta.Update = append(newParent.Meta.Update, updated)
@@ -918,6 +1077,7 @@ func MoveFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	setFilePath(&updatedFile)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(updatedFile)
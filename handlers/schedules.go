@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/isotiropoulos/storage-api/copernicus"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/middleware"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PostSchedule handles the /copernicus/schedules POST request.
+// @Summary Register a recurring Copernicus request.
+// @Description The request runs on a cron schedule (five fields, UTC, or @daily, @hourly etc.). Its body is a template that may use relative dates:
+// @Description "{{today}}", "{{yesterday}}", "{{today-N}}", "{{N days ago}}" and "{{last N days}}", optionally formatted as ":year", ":month", ":day" or ":dates".
+// @Description Each run delivers the dataset to the folder as a new file named after the schedule and the run's date. The caller needs write permission on the folder.
+// @Description A schedule whose runs keep failing, or whose owner can no longer write to the folder, pauses itself.
+// @Tags Copernicus
+// @Accept json
+// @Produce json
+// @Param body body models.PostScheduleBody true "Schedule"
+// @Success 200 {object} models.CopernicusSchedule "OK"
+// @Failure 400 {object} models.ValidationReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/schedules [post]
+// @Security BearerAuth
+func PostSchedule(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "SCH0001")
+		return
+	}
+
+	var req models.PostScheduleBody
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve request.", err.Error(), "SCH0002")
+		return
+	}
+
+	var missing []models.FieldError
+	for field, value := range map[string]string{"dataset_name": req.DatasetName, "cron": req.Cron, "folder": req.Folder} {
+		if value == "" {
+			missing = append(missing, models.FieldError{Field: field, Message: "is required"})
+		}
+	}
+	if len(missing) > 0 {
+		utils.RespondWithFieldErrors(w, "Invalid schedule.", missing, "SCH0003")
+		return
+	}
+
+	if _, ok := authorizeDestination(w, r, req.Folder); !ok {
+		return
+	}
+
+	principal, _ := utils.GetPrincipalFromContext(r.Context())
+	schedule, fieldErrors, err := copernicus.NewSchedule(req, claims.Subject, middleware.AccessOf(*claims, principal))
+	if errors.Is(err, copernicus.ErrInvalidSchedule) {
+		utils.RespondWithFieldErrors(w, "Invalid schedule.", fieldErrors, "SCH0003")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not store schedule.", err.Error(), "SCH0004")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// GetSchedules handles the /copernicus/schedules GET request.
+// @Summary List the caller's recurring Copernicus requests.
+// @Tags Copernicus
+// @Produce json
+// @Success 200 {object} []models.CopernicusSchedule "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/schedules [get]
+// @Security BearerAuth
+func GetSchedules(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "SCH0001")
+		return
+	}
+
+	cursor, err := globals.CopernicusScheduleDB.GetCursorBySubject(claims.Subject)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not open cursor.", err.Error(), "SCH0005")
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	schedules := []models.CopernicusSchedule{}
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		var schedule models.CopernicusSchedule
+		if err := cursor.Decode(&result); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve cursor.", err.Error(), "SCH0006")
+			return
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &schedule)
+		schedules = append(schedules, schedule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// GetSchedule handles the /copernicus/schedules/{id} GET request.
+// @Summary Get a recurring Copernicus request.
+// @Tags Copernicus
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} models.CopernicusSchedule "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Router /copernicus/schedules/{id} [get]
+// @Security BearerAuth
+func GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// DeleteSchedule handles the /copernicus/schedules/{id} DELETE request.
+// @Summary Delete a recurring Copernicus request and its run history.
+// @Description Files already delivered by the schedule are kept.
+// @Tags Copernicus
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/schedules/{id} [delete]
+// @Security BearerAuth
+func DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := globals.CopernicusScheduleDB.DeleteOneByID(schedule.Id); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not delete schedule.", err.Error(), "SCH0008")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PauseSchedule handles the /copernicus/schedules/{id}/pause POST request.
+// @Summary Pause a recurring Copernicus request.
+// @Tags Copernicus
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} models.CopernicusSchedule "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/schedules/{id}/pause [post]
+// @Security BearerAuth
+func PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	schedule.Paused = true
+	schedule.PauseReason = "paused by user"
+	if err := globals.CopernicusScheduleDB.SetPaused(schedule.Id, true, schedule.PauseReason, schedule.NextRun); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not pause schedule.", err.Error(), "SCH0009")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// ResumeSchedule handles the /copernicus/schedules/{id}/resume POST request.
+// @Summary Resume a paused recurring Copernicus request.
+// @Description The schedule runs again from its next matching time; missed runs are not made up for. Its failure count is reset.
+// @Description The caller needs write permission on the folder, and their current access is saved for the next runs.
+// @Tags Copernicus
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} models.CopernicusSchedule "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/schedules/{id}/resume [post]
+// @Security BearerAuth
+func ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := ownSchedule(w, r)
+	if !ok {
+		return
+	}
+	if _, ok = authorizeDestination(w, r, schedule.Folder); !ok {
+		return
+	}
+
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "SCH0001")
+		return
+	}
+	principal, _ := utils.GetPrincipalFromContext(r.Context())
+	schedule, err = copernicus.Resume(schedule, middleware.AccessOf(*claims, principal))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resume schedule.", err.Error(), "SCH0010")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// GetScheduleRuns handles the /copernicus/schedules/{id}/runs GET request.
+// @Summary Get the run history of a recurring Copernicus request, oldest first.
+// @Description Runs are "submitted" until their dataset is delivered ("completed") or their job fails ("failed"). States are updated when the schedule runs next.
+// @Tags Copernicus
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} []models.CopernicusScheduleRun "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /copernicus/schedules/{id}/runs [get]
+// @Security BearerAuth
+func GetScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := ownSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := globals.CopernicusScheduleDB.GetCursorRuns(schedule.Id, "")
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not open cursor.", err.Error(), "SCH0005")
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	runs := []models.CopernicusScheduleRun{}
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		var run models.CopernicusScheduleRun
+		if err := cursor.Decode(&result); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve cursor.", err.Error(), "SCH0006")
+			return
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &run)
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(runs)
+}
+
+// ownSchedule gets the schedule in the path, if the caller created it.
+// Otherwise it responds with an error and returns false.
+func ownSchedule(w http.ResponseWriter, r *http.Request) (models.CopernicusSchedule, bool) {
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Could not resolve claims.", err.Error(), "SCH0001")
+		return models.CopernicusSchedule{}, false
+	}
+
+	schedule, err := globals.CopernicusScheduleDB.GetOneByID(mux.Vars(r)["id"])
+	// Other users' schedules are reported as missing
+	if err != nil || schedule.Subject != claims.Subject {
+		reason := "schedule not found"
+		if err != nil {
+			reason = err.Error()
+		}
+		utils.RespondWithError(w, http.StatusNotFound, "Could not find schedule.", reason, "SCH0007")
+		return models.CopernicusSchedule{}, false
+	}
+	return schedule, true
+}
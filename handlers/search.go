@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/indexing"
+	"github.com/isotiropoulos/storage-api/middleware"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxSearchLimit is the largest page of a search.
+const maxSearchLimit = 200
+
+// maxSearchOffset is the furthest a search can be paged.
+const maxSearchOffset = 10000
+
+// search is a parsed /search request.
+type search struct {
+	query   models.SearchQuery
+	sort    string // Sort parameter: relevance, name, size, created or updated
+	offset  int64  // Results to skip
+	limit   int64  // Page size
+	files   bool   // Whether files are searched
+	folders bool   // Whether folders are searched
+}
+
+// Search handles the /search GET request.
+// @Summary Search files and folders.
+// @Description Searches the files and folders of the caller's buckets and of the folders shared with them, or the bucket of an API key.
+// @Description "q" looks for words in titles, tags and descriptions, and results are then sorted by relevance. The other parameters filter the results; several "tag" must all match.
+// @Description Every result has its path and the folders on it (breadcrumbs). Pages are read with "limit" and "offset".
+// @Tags Search
+// @Produce json
+// @Param q query string false "Words to look for"
+// @Param title query string false "Part of the title"
+// @Param tag query []string false "Tags" collectionFormat(multi)
+// @Param type query string false "File type (files only)"
+// @Param creator query string false "Creator's user ID"
+// @Param kind query string false "files or folders"
+// @Param min_size query int false "Least size in bytes"
+// @Param max_size query int false "Largest size in bytes"
+// @Param created_from query string false "Created at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_to query string false "Created before"
+// @Param updated_from query string false "Last updated at or after"
+// @Param updated_to query string false "Last updated before"
+// @Param within query string false "Folder ID to search under"
+// @Param sort query string false "relevance (with q), name, size, created or updated; by last update if empty"
+// @Param order query string false "asc or desc; desc if sort is empty"
+// @Param limit query int false "Page size (1-200, default 50)"
+// @Param offset query int false "Results to skip"
+// @Success 200 {object} models.SearchResults "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /search [get]
+// @Security BearerAuth
+func Search(w http.ResponseWriter, r *http.Request) {
+
+	s, err := parseSearch(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid search.", err.Error(), "SRC0001")
+		return
+	}
+
+	scopes, ok := searchScopes(w, r, [2]string{"SRC0002", "SRC0003"})
+	if !ok {
+		return
+	}
+	s.query.Scopes = scopes
+
+	retObject := models.SearchResults{Results: []models.SearchResult{}}
+	if len(scopes) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(retObject)
+		return
+	}
+
+	// Each collection gives its first offset+limit results, and the page is cut from both
+	s.query.Limit = s.offset + s.limit
+	var results []models.SearchResult
+
+	if s.folders {
+		cursor, err := globals.FolderDB.Search(s.query)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not search folders.", err.Error(), "SRC0004")
+			return
+		}
+		err = decodeSearch(cursor, func(result bson.M) {
+			var folder models.Folder
+			bsonBytes, _ := bson.Marshal(result)
+			bson.Unmarshal(bsonBytes, &folder)
+			results = append(results, models.SearchResult{
+				Kind:        "folder",
+				Id:          folder.Id,
+				Meta:        folder.Meta,
+				Parent:      folder.Parent,
+				Size:        folder.Size,
+				Score:       searchScore(result),
+				Path:        folder.Path,
+				Breadcrumbs: breadcrumbIds(folder.Ancestors),
+			})
+		})
+		if err != nil {
+			utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "SRC0005")
+			return
+		}
+		count, err := globals.FolderDB.CountSearch(s.query)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not count folders.", err.Error(), "SRC0006")
+			return
+		}
+		retObject.Total += count
+	}
+
+	if s.files {
+		cursor, err := globals.FileDB.Search(s.query)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not search files.", err.Error(), "SRC0007")
+			return
+		}
+		err = decodeSearch(cursor, func(result bson.M) {
+			var file models.File
+			bsonBytes, _ := bson.Marshal(result)
+			bson.Unmarshal(bsonBytes, &file)
+			results = append(results, models.SearchResult{
+				Kind:        "file",
+				Id:          file.Id,
+				Meta:        file.Meta,
+				Parent:      file.FolderID,
+				FileType:    file.FileType,
+				Size:        file.Size,
+				Score:       searchScore(result),
+				Path:        file.Path,
+				Breadcrumbs: breadcrumbIds(file.Ancestors),
+			})
+		})
+		if err != nil {
+			utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "SRC0008")
+			return
+		}
+		count, err := globals.FileDB.CountSearch(s.query)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not count files.", err.Error(), "SRC0009")
+			return
+		}
+		retObject.Total += count
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return s.before(results[i], results[j]) })
+	if int64(len(results)) > s.offset {
+		results = results[s.offset:]
+	} else {
+		results = nil
+	}
+	if int64(len(results)) > s.limit {
+		results = results[:s.limit]
+	}
+	if next := s.offset + s.limit; next < retObject.Total && next <= maxSearchOffset {
+		retObject.NextOffset = next
+	}
+
+	if err = fillBreadcrumbs(results); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve paths.", err.Error(), "SRC0010")
+		return
+	}
+	retObject.Results = append(retObject.Results, results...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retObject)
+}
+
+// maxContentLimit is the largest page of a content search.
+const maxContentLimit = 50
+
+// contentHighlights is the most passages highlighted per file.
+const contentHighlights = 3
+
+// SearchContent handles the /search/content GET request.
+// @Summary Search inside documents.
+// @Description Searches the text of the PDF, text, CSV and IFC files of the caller's buckets and of the folders shared with them, or the bucket of an API key.
+// @Description Text is extracted when an upload completes, if the API runs with CONTENT_INDEXING=true; of CSV files only the column names are kept, and of IFC models the property sets.
+// @Description Results are the most relevant files first, with passages of their text where the words are highlighted in <em>.
+// @Tags Search
+// @Produce json
+// @Param q query string true "Words to look for"
+// @Param type query string false "File type"
+// @Param within query string false "Folder ID to search under"
+// @Param limit query int false "Page size (1-50, default 20)"
+// @Param offset query int false "Results to skip"
+// @Success 200 {object} models.SearchResults "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Failure 501 {object} models.ErrorReport "Not Implemented"
+// @Router /search/content [get]
+// @Security BearerAuth
+func SearchContent(w http.ResponseWriter, r *http.Request) {
+
+	if !indexing.Enabled() {
+		utils.RespondWithError(w, http.StatusNotImplemented, "Content search is off.", "Documents are not indexed (CONTENT_INDEXING).", "SRC0011")
+		return
+	}
+
+	params := r.URL.Query()
+	query := models.ContentQuery{
+		Text:     strings.TrimSpace(params.Get("q")),
+		FileType: strings.TrimPrefix(params.Get("type"), "."),
+		Limit:    20,
+	}
+	if query.Text == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid search.", "q is required", "SRC0012")
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value < 1 || value > maxContentLimit {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid search.", fmt.Sprintf("limit must be between 1 and %d", maxContentLimit), "SRC0012")
+			return
+		}
+		query.Limit = value
+	}
+	if offset := params.Get("offset"); offset != "" {
+		value, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || value < 0 || value > maxSearchOffset {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid search.", fmt.Sprintf("offset must be between 0 and %d", maxSearchOffset), "SRC0012")
+			return
+		}
+		query.Skip = value
+	}
+
+	scopes, ok := searchScopes(w, r, [2]string{"SRC0013", "SRC0014"})
+	if !ok {
+		return
+	}
+	query.Scopes = scopes
+
+	retObject := models.SearchResults{Results: []models.SearchResult{}}
+	if len(scopes) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(retObject)
+		return
+	}
+
+	cursor, err := globals.ContentDB.Search(query)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not search contents.", err.Error(), "SRC0015")
+		return
+	}
+	var contents []models.FileContent
+	var scores []float64
+	var fileIds []string
+	err = decodeSearch(cursor, func(result bson.M) {
+		var content models.FileContent
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &content)
+		contents = append(contents, content)
+		scores = append(scores, searchScore(result))
+		fileIds = append(fileIds, content.Id)
+	})
+	if err != nil {
+		utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "SRC0016")
+		return
+	}
+
+	retObject.Total, err = globals.ContentDB.CountSearch(query)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not count contents.", err.Error(), "SRC0017")
+		return
+	}
+	if next := query.Skip + query.Limit; next < retObject.Total && next <= maxSearchOffset {
+		retObject.NextOffset = next
+	}
+
+	files := map[string]models.File{}
+	if len(fileIds) > 0 {
+		cursor, err = globals.FileDB.GetCursorByIDs(fileIds)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not get files.", err.Error(), "SRC0018")
+			return
+		}
+		err = decodeSearch(cursor, func(result bson.M) {
+			var file models.File
+			bsonBytes, _ := bson.Marshal(result)
+			bson.Unmarshal(bsonBytes, &file)
+			files[file.Id] = file
+		})
+		if err != nil {
+			utils.RespondWithError(w, http.StatusConflict, "Could not resolve cursor.", err.Error(), "SRC0019")
+			return
+		}
+	}
+
+	var results []models.SearchResult
+	for i, content := range contents {
+		file, ok := files[content.Id]
+		if !ok {
+			continue
+		}
+		results = append(results, models.SearchResult{
+			Kind:        "file",
+			Id:          file.Id,
+			Meta:        file.Meta,
+			Parent:      file.FolderID,
+			FileType:    file.FileType,
+			Size:        file.Size,
+			Score:       scores[i],
+			Highlights:  indexing.Highlights(content.Text, query.Text, contentHighlights),
+			Path:        file.Path,
+			Breadcrumbs: breadcrumbIds(file.Ancestors),
+		})
+	}
+	if err = fillBreadcrumbs(results); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve paths.", err.Error(), "SRC0020")
+		return
+	}
+	retObject.Results = append(retObject.Results, results...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retObject)
+}
+
+// searchScopes resolves the folders a search looks under: those the caller can read, or the
+// folder of the "within" parameter if the caller can read it. On failure it responds with the
+// matching error code and returns false.
+func searchScopes(w http.ResponseWriter, r *http.Request, codes [2]string) ([]string, bool) {
+	scopes, err := middleware.ReadScopes(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve readable folders.", err.Error(), codes[0])
+		return nil, false
+	}
+
+	// Narrow the search to a folder, if the caller can read it
+	if within := r.URL.Query().Get("within"); within != "" {
+		principal, err := middleware.FolderPrincipal(r, within)
+		if err != nil || !utils.HasCapability(principal, models.CapRead) {
+			utils.RespondWithError(w, http.StatusForbidden, "Forbidden.", "Cannot read folder "+within+".", codes[1])
+			return nil, false
+		}
+		scopes = []string{within}
+	}
+	return scopes, true
+}
+
+// parseSearch reads the filters, sort and page of a search.
+func parseSearch(r *http.Request) (search, error) {
+	params := r.URL.Query()
+	s := search{
+		query: models.SearchQuery{
+			Text:     strings.TrimSpace(params.Get("q")),
+			Title:    strings.TrimSpace(params.Get("title")),
+			Tags:     params["tag"],
+			FileType: strings.TrimPrefix(params.Get("type"), "."),
+			Creator:  params.Get("creator"),
+		},
+		limit:   50,
+		files:   true,
+		folders: true,
+	}
+
+	s.sort = params.Get("sort")
+	switch s.sort {
+	case "":
+		if s.query.Text != "" {
+			s.sort = "relevance"
+			break
+		}
+		// Most recently updated first
+		s.sort = "updated"
+		s.query.Descending = params.Get("order") != "asc"
+	case "relevance":
+		if s.query.Text == "" {
+			return s, fmt.Errorf("sort by relevance needs q")
+		}
+	default:
+		if _, ok := listSortFields[s.sort]; !ok {
+			return s, fmt.Errorf("sort must be relevance, name, size, created or updated")
+		}
+	}
+	s.query.SortField = listSortFields[s.sort]
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		s.query.Descending = true
+	default:
+		return s, fmt.Errorf("order must be asc or desc")
+	}
+
+	switch params.Get("kind") {
+	case "":
+	case "files":
+		s.folders = false
+	case "folders":
+		s.files = false
+	default:
+		return s, fmt.Errorf("kind must be files or folders")
+	}
+	// Folders have no file type
+	if s.query.FileType != "" {
+		s.folders = false
+	}
+
+	for name, target := range map[string]**int64{
+		"min_size": &s.query.MinSize,
+		"max_size": &s.query.MaxSize,
+	} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return s, fmt.Errorf("%s must be a number of bytes", name)
+		}
+		*target = &size
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_from": &s.query.CreatedFrom,
+		"created_to":   &s.query.CreatedTo,
+		"updated_from": &s.query.UpdatedFrom,
+		"updated_to":   &s.query.UpdatedTo,
+	} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if date, err = time.Parse("2006-01-02", value); err != nil {
+				return s, fmt.Errorf("%s must be a date (RFC 3339 or YYYY-MM-DD)", name)
+			}
+		}
+		*target = &date
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value < 1 || value > maxSearchLimit {
+			return s, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+		s.limit = value
+	}
+	if offset := params.Get("offset"); offset != "" {
+		value, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || value < 0 || value > maxSearchOffset {
+			return s, fmt.Errorf("offset must be between 0 and %d", maxSearchOffset)
+		}
+		s.offset = value
+	}
+	return s, nil
+}
+
+// before reports whether a result comes before another, in the order the collections were
+// sorted in: by the sort field, then by _id.
+func (s search) before(a models.SearchResult, b models.SearchResult) bool {
+	if s.sort == "relevance" {
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Id < b.Id
+	}
+
+	var cmp int
+	switch s.sort {
+	case "name":
+		x, y := a.Meta.Title, b.Meta.Title
+		// Text searches sort names by their bytes, the others regardless of case
+		if s.query.Text == "" {
+			x, y = strings.ToLower(x), strings.ToLower(y)
+		}
+		cmp = strings.Compare(x, y)
+	case "size":
+		cmp = compareInt64(a.Size, b.Size)
+	case "created":
+		cmp = a.Meta.DateCreation.Compare(b.Meta.DateCreation)
+	case "updated":
+		cmp = a.Meta.Update.Date.Compare(b.Meta.Update.Date)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.Id, b.Id)
+	}
+	if s.query.Descending {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func compareInt64(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// decodeSearch passes each document of a search cursor to add.
+func decodeSearch(cursor *mongo.Cursor, add func(result bson.M)) error {
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		if err := cursor.Decode(&result); err != nil {
+			return err
+		}
+		add(result)
+	}
+	return cursor.Err()
+}
+
+// searchScore is the relevance of a document of a text search.
+func searchScore(result bson.M) float64 {
+	score, _ := result["score"].(float64)
+	return score
+}
+
+// breadcrumbIds are the breadcrumbs of the ancestors of an item, without their titles.
+func breadcrumbIds(ancestors []string) []models.Breadcrumb {
+	crumbs := []models.Breadcrumb{}
+	for _, id := range ancestors {
+		crumbs = append(crumbs, models.Breadcrumb{Id: id})
+	}
+	return crumbs
+}
+
+// fillBreadcrumbs sets the titles of the breadcrumbs of the results and their paths, looking up
+// at once the titles their stored paths don't give.
+func fillBreadcrumbs(results []models.SearchResult) error {
+	var ancestors [][]string
+	var missing [][]string
+	for _, result := range results {
+		var ids []string
+		for _, crumb := range result.Breadcrumbs {
+			ids = append(ids, crumb.Id)
+		}
+		ancestors = append(ancestors, ids)
+		if !pathFits(result.Path, ids) {
+			missing = append(missing, ids)
+		}
+	}
+	titles, err := folderTitles(ancestorIds(missing...))
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		results[i].Breadcrumbs, results[i].Path = itemCrumbs(results[i].Path, ancestors[i], results[i].Meta.Title, titles)
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/middleware"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxTreeDepth is the deepest a tree listing goes.
+const maxTreeDepth = 32
+
+// maxTreeItems is the most items a nested tree listing holds. Larger trees are streamed.
+const maxTreeItems = 10000
+
+// treeFlushLines is how many lines of a streamed tree are sent at a time.
+const treeFlushLines = 100
+
+// errTreeTooLarge is returned when a tree holds more items than a nested listing can.
+var errTreeTooLarge = fmt.Errorf("the tree holds more than %d items; stream it or ask for a smaller depth", maxTreeItems)
+
+// treeListing is a parsed /folder/tree request.
+type treeListing struct {
+	query  models.TreeQuery
+	files  bool // Whether files are listed
+	stream bool // Whether items are streamed as NDJSON
+}
+
+// GetFolderTree handles the /folder/tree GET request.
+// @Summary List the tree under a folder.
+// @Description Lists the folders under a folder and, with "files", their files, down to a depth, in one request.
+// @Description By default the folder is returned with the folders and files in it nested. With "stream" the items are sent as NDJSON instead, one per line, each folder before the items in it, so that trees of any size can be read as they come.
+// @Description Only what the caller can read is listed. Folders they can't read are only listed on the way to the folders shared with them, as restricted and without their metadata.
+// @Tags Folders
+// @Produce json
+// @Produce x-ndjson
+// @Param id query string true "Folder ID"
+// @Param depth query int false "Levels to go down (1-32, default 1)"
+// @Param files query bool false "Whether files are listed"
+// @Param stream query bool false "Whether items are streamed as NDJSON"
+// @Success 200 {object} models.TreeItem "OK"
+// @Failure 400 {object} models.ErrorReport "Bad Request"
+// @Failure 403 {object} models.ErrorReport "Forbidden"
+// @Failure 404 {object} models.ErrorReport "Not Found"
+// @Failure 500 {object} models.ErrorReport "Internal Server Error"
+// @Router /folder/tree [get]
+// @Security BearerAuth
+func GetFolderTree(w http.ResponseWriter, r *http.Request) {
+
+	t, err := parseTree(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid tree listing.", err.Error(), "FOL0071")
+		return
+	}
+
+	root, err := globals.FolderDB.GetOneByID(t.query.Root)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Folder doesn't exist.", err.Error(), "FOL0072")
+		return
+	}
+	setFolderPath(&root)
+	t.query.Level = root.Level
+
+	rootItem := folderTreeItem(root, root.Level)
+	stubs, ok := treeScopes(w, r, &t, root, [2]string{"FOL0073", "FOL0074"})
+	if !ok {
+		return
+	}
+	if stubs != nil {
+		rootItem = restrictedTreeItem(rootItem)
+	}
+
+	if t.stream {
+		streamTree(w, t, rootItem, stubs)
+		return
+	}
+
+	// Nest each item in its folder, which comes before it
+	nodes := map[string]*models.TreeItem{}
+	items := 0
+	err = walkTree(t, rootItem, stubs, func(item models.TreeItem) error {
+		if items++; items > maxTreeItems {
+			return errTreeTooLarge
+		}
+		node := &item
+		if parent, ok := nodes[item.Parent]; ok && item.Depth > 0 {
+			if item.Kind == "file" {
+				parent.Files = append(parent.Files, node)
+				return nil
+			}
+			parent.Folders = append(parent.Folders, node)
+		}
+		nodes[item.Id] = node
+		return nil
+	})
+	if errors.Is(err, errTreeTooLarge) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Tree too large.", err.Error(), "FOL0075")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not list tree.", err.Error(), "FOL0076")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes[root.Id])
+}
+
+// parseTree reads the folder, depth and mode of a tree listing.
+func parseTree(r *http.Request) (treeListing, error) {
+	params := r.URL.Query()
+	t := treeListing{query: models.TreeQuery{Root: params.Get("id"), Depth: 1}}
+	if t.query.Root == "" {
+		return t, errors.New("id is required")
+	}
+
+	if depth := params.Get("depth"); depth != "" {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 1 || n > maxTreeDepth {
+			return t, fmt.Errorf("depth must be between 1 and %d", maxTreeDepth)
+		}
+		t.query.Depth = n
+	}
+
+	var err error
+	if files := params.Get("files"); files != "" {
+		if t.files, err = strconv.ParseBool(files); err != nil {
+			return t, errors.New("files must be true or false")
+		}
+	}
+	if stream := params.Get("stream"); stream != "" {
+		if t.stream, err = strconv.ParseBool(stream); err != nil {
+			return t, errors.New("stream must be true or false")
+		}
+	}
+	return t, nil
+}
+
+// treeScopes narrows a tree listing to what the caller can read: all of it if they can read
+// its folder, or else the folders shared with them under it. In the latter case it returns the
+// folders on the way to those, to be listed as restricted; it responds with a 403 if nothing
+// under the folder is shared with the caller.
+func treeScopes(w http.ResponseWriter, r *http.Request, t *treeListing, root models.Folder, codes [2]string) ([]models.TreeItem, bool) {
+	scopes, err := middleware.ReadScopes(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve readable folders.", err.Error(), codes[0])
+		return nil, false
+	}
+
+	// Access to a folder carries to everything under it
+	if checkAny(scopes, append([]string{root.Id}, root.Ancestors...)) {
+		t.query.Scopes = []string{root.Id}
+		return nil, true
+	}
+
+	shared, err := sharedUnder(root, scopes)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Could not resolve readable folders.", err.Error(), codes[0])
+		return nil, false
+	}
+	if len(shared) == 0 {
+		utils.RespondWithError(w, http.StatusForbidden, "Forbidden.", "Cannot read folder "+root.Id+".", codes[1])
+		return nil, false
+	}
+
+	stubs := []models.TreeItem{}
+	listed := map[string]bool{}
+	for _, folder := range shared {
+		t.query.Scopes = append(t.query.Scopes, folder.Id)
+
+		// The folders between the tree's and the shared one, unless one of them is shared too
+		crumbs := folder.Breadcrumbs
+		var chain []models.TreeItem
+		for i := root.Level + 1; i < len(crumbs); i++ {
+			if utils.ItemInArray(scopes, crumbs[i].Id) {
+				chain = nil
+				break
+			}
+			names := make([]string, 0, i+1)
+			for _, crumb := range crumbs[:i+1] {
+				names = append(names, crumb.Title)
+			}
+			chain = append(chain, models.TreeItem{
+				Kind:       "folder",
+				Id:         crumbs[i].Id,
+				Parent:     crumbs[i-1].Id,
+				Title:      crumbs[i].Title,
+				Path:       utils.JoinPath(names),
+				Depth:      i - root.Level,
+				Restricted: true,
+			})
+		}
+		for _, stub := range chain {
+			if !listed[stub.Id] && stub.Depth <= t.query.Depth {
+				listed[stub.Id] = true
+				stubs = append(stubs, stub)
+			}
+		}
+	}
+	return stubs, true
+}
+
+// sharedUnder returns the folders shared with the caller that are under a folder, with their
+// breadcrumbs.
+func sharedUnder(root models.Folder, scopes []string) ([]models.Folder, error) {
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	cursor, err := globals.FolderDB.GetCursorByIDs(scopes)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var shared []models.Folder
+	for cursor.Next(context.Background()) {
+		var folder models.Folder
+		if err = cursor.Decode(&folder); err != nil {
+			return nil, err
+		}
+		if utils.ItemInArray(folder.Ancestors, root.Id) {
+			shared = append(shared, folder)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+	return shared, setPaths(nil, shared)
+}
+
+// checkAny tells if any of some folders is in a list.
+func checkAny(list []string, folderIDs []string) bool {
+	for _, id := range folderIDs {
+		if utils.ItemInArray(list, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkTree passes the items of a tree to add, each folder before the items in it: the folder the
+// tree starts at, the restricted folders, the folders by level and then the files.
+func walkTree(t treeListing, rootItem models.TreeItem, stubs []models.TreeItem, add func(item models.TreeItem) error) error {
+	if err := add(rootItem); err != nil {
+		return err
+	}
+	for _, stub := range stubs {
+		if err := add(stub); err != nil {
+			return err
+		}
+	}
+
+	cursor, err := globals.FolderDB.GetTree(t.query)
+	if err != nil {
+		return err
+	}
+	err = eachTreeItem(cursor, func(cursor *mongo.Cursor) error {
+		var folder models.Folder
+		if err := cursor.Decode(&folder); err != nil {
+			return err
+		}
+		return add(folderTreeItem(folder, t.query.Level))
+	})
+	if err != nil || !t.files {
+		return err
+	}
+
+	cursor, err = globals.FileDB.GetTree(t.query)
+	if err != nil {
+		return err
+	}
+	return eachTreeItem(cursor, func(cursor *mongo.Cursor) error {
+		var file models.File
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+		return add(fileTreeItem(file, t.query.Level))
+	})
+}
+
+// eachTreeItem calls decode for each document of a cursor, and closes it.
+func eachTreeItem(cursor *mongo.Cursor, decode func(cursor *mongo.Cursor) error) error {
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		if err := decode(cursor); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// streamTree writes the items of a tree as NDJSON, a few lines at a time. Once the first lines
+// are sent the status can't change, so an error ends the stream with an error report line.
+func streamTree(w http.ResponseWriter, t treeListing, rootItem models.TreeItem, stubs []models.TreeItem) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	lines := 0
+	err := walkTree(t, rootItem, stubs, func(item models.TreeItem) error {
+		if err := encoder.Encode(item); err != nil {
+			return err
+		}
+		lines++
+		if flusher != nil && lines%treeFlushLines == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		encoder.Encode(models.ErrorReport{
+			Message:        "Could not list tree. Please contact the Core Platform Support Team.",
+			Reason:         err.Error(),
+			Status:         http.StatusInternalServerError,
+			InternalStatus: "FOL0077",
+		})
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// folderTreeItem is the tree item of a folder, under a tree that starts at a level.
+func folderTreeItem(folder models.Folder, rootLevel int) models.TreeItem {
+	meta := folder.Meta
+	return models.TreeItem{
+		Kind:   "folder",
+		Id:     folder.Id,
+		Parent: folder.Parent,
+		Title:  folder.Meta.Title,
+		Meta:   &meta,
+		Size:   folder.Size,
+		Path:   folder.Path,
+		Depth:  folder.Level - rootLevel,
+	}
+}
+
+// fileTreeItem is the tree item of a file. Its ancestors run down to its folder, one per level.
+func fileTreeItem(file models.File, rootLevel int) models.TreeItem {
+	meta := file.Meta
+	return models.TreeItem{
+		Kind:     "file",
+		Id:       file.Id,
+		Parent:   file.FolderID,
+		Title:    file.Meta.Title,
+		Meta:     &meta,
+		FileType: file.FileType,
+		Size:     file.Size,
+		Path:     file.Path,
+		Depth:    len(file.Ancestors) - rootLevel,
+	}
+}
+
+// restrictedTreeItem leaves out what the caller can't see of a folder they can't read.
+func restrictedTreeItem(item models.TreeItem) models.TreeItem {
+	item.Meta = nil
+	item.Size = 0
+	item.Restricted = true
+	return item
+}
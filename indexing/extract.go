@@ -0,0 +1,86 @@
+package indexing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupported is returned for files whose type has no extractor.
+var ErrUnsupported = errors.New("unsupported file type")
+
+// extractors extract the text of each supported file type.
+var extractors = map[string]func(data []byte) (string, error){
+	"pdf": extractPDF,
+	"txt": extractText,
+	"md":  extractText,
+	"log": extractText,
+	"csv": func(data []byte) (string, error) { return extractHeader(data, ',') },
+	"tsv": func(data []byte) (string, error) { return extractHeader(data, '\t') },
+	"ifc": extractIFC,
+}
+
+// Supported reports whether the text of a file type can be extracted. File types are
+// extensions, with or without their dot.
+func Supported(fileType string) bool {
+	_, ok := extractors[normalizeType(fileType)]
+	return ok
+}
+
+// Extract returns the text of a file of a type.
+func Extract(fileType string, data []byte) (string, error) {
+	extract, ok := extractors[normalizeType(fileType)]
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return extract(data)
+}
+
+func normalizeType(fileType string) string {
+	return strings.ToLower(strings.TrimPrefix(fileType, "."))
+}
+
+// extractText keeps a text file as it is, without the bytes that aren't UTF-8.
+func extractText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	return strings.ToValidUTF8(string(data), ""), nil
+}
+
+// extractHeader returns the column names of a delimited file, one per line. Their values are
+// left out, as they are rarely worth searching for and would crowd the index.
+func extractHeader(data []byte, comma rune) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, name := range header {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.ToValidUTF8(name, ""))
+		}
+	}
+	return strings.Join(names, "\n"), nil
+}
+
+// truncate cuts a text to at most n bytes, without splitting a character.
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
@@ -0,0 +1,108 @@
+package indexing
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// highlightContext is how many bytes of text are kept on each side of a highlighted word.
+const highlightContext = 80
+
+// Highlights returns up to max passages of a text around the words of a search, HTML-escaped,
+// with the words in <em>. Words are matched whole and regardless of case, as the text index
+// matches them; excluded words ("-word") are not highlighted.
+func Highlights(text string, search string, max int) []string {
+	terms := map[string]bool{}
+	for _, field := range strings.Fields(search) {
+		if strings.HasPrefix(field, "-") {
+			continue
+		}
+		for _, word := range words(field) {
+			terms[strings.ToLower(field[word[0]:word[1]])] = true
+		}
+	}
+
+	// Matches, as byte ranges of the text
+	var matches [][2]int
+	for _, word := range words(text) {
+		if terms[strings.ToLower(text[word[0]:word[1]])] {
+			matches = append(matches, word)
+		}
+	}
+
+	var passages []string
+	for i := 0; i < len(matches) && len(passages) < max; {
+		start := boundary(text, matches[i][0]-highlightContext, false)
+		end := boundary(text, matches[i][1]+highlightContext, true)
+
+		var passage strings.Builder
+		if start > 0 {
+			passage.WriteString("…")
+		}
+		at := start
+		// Every match in the passage is highlighted
+		for ; i < len(matches) && matches[i][0] < end; i++ {
+			if matches[i][1] > end {
+				end = boundary(text, matches[i][1], true)
+			}
+			passage.WriteString(html.EscapeString(text[at:matches[i][0]]))
+			passage.WriteString("<em>" + html.EscapeString(text[matches[i][0]:matches[i][1]]) + "</em>")
+			at = matches[i][1]
+		}
+		passage.WriteString(html.EscapeString(text[at:end]))
+		if end < len(text) {
+			passage.WriteString("…")
+		}
+		passages = append(passages, strings.Join(strings.Fields(passage.String()), " "))
+	}
+	return passages
+}
+
+// words returns the byte ranges of the words of a text: runs of letters and digits.
+func words(text string) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			ranges = append(ranges, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ranges = append(ranges, [2]int{start, len(text)})
+	}
+	return ranges
+}
+
+// boundary moves an offset of a text to a space nearby, so that passages don't cut words, or
+// to the start or end of the text.
+func boundary(text string, at int, forward bool) int {
+	if at <= 0 {
+		return 0
+	}
+	if at >= len(text) {
+		return len(text)
+	}
+	for k := 0; k < 20; k++ {
+		i := at + k
+		if !forward {
+			i = at - k
+		}
+		if i <= 0 || i >= len(text) {
+			break
+		}
+		if text[i] == ' ' || text[i] == '\n' {
+			return i
+		}
+	}
+	for at < len(text) && !utf8.RuneStart(text[at]) {
+		at++
+	}
+	return at
+}
@@ -0,0 +1,177 @@
+package indexing
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// extractIFC returns the property sets of an IFC model (STEP physical file): the name of each
+// property set, and each of its properties as "name: value", a line each. Lines are not
+// repeated, as models hold the same properties on many elements.
+func extractIFC(data []byte) (string, error) {
+	text := string(data)
+	if !strings.Contains(text[:min(len(text), 1024)], "ISO-10303-21") {
+		return "", errors.New("not an IFC (STEP) file")
+	}
+
+	seen := map[string]bool{}
+	var lines []string
+	add := func(line string) {
+		if line = strings.TrimSpace(line); line != "" && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+
+	for _, statement := range stepStatements(text) {
+		// #12=IFCPROPERTYSET('guid',#5,'Pset_WallCommon',$,(#13,#14))
+		eq := strings.IndexByte(statement, '=')
+		open := strings.IndexByte(statement, '(')
+		if !strings.HasPrefix(statement, "#") || eq < 0 || open < eq || !strings.HasSuffix(statement, ")") {
+			continue
+		}
+		entity := strings.ToUpper(strings.TrimSpace(statement[eq+1 : open]))
+		args := stepArguments(statement[open+1 : len(statement)-1])
+
+		switch entity {
+		case "IFCPROPERTYSET", "IFCELEMENTQUANTITY":
+			if len(args) > 2 {
+				add(stepValue(args[2]))
+			}
+		case "IFCPROPERTYSINGLEVALUE", "IFCPROPERTYENUMERATEDVALUE":
+			if len(args) > 2 {
+				name, value := stepValue(args[0]), stepValue(args[2])
+				if value != "" {
+					add(name + ": " + value)
+				} else {
+					add(name)
+				}
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// stepStatements splits the data section of a STEP file into its statements, without their
+// ending semicolons and with whitespace outside strings removed.
+func stepStatements(text string) []string {
+	if data := strings.Index(text, "DATA;"); data >= 0 {
+		text = text[data+len("DATA;"):]
+	}
+
+	var statements []string
+	var statement strings.Builder
+	inString := false
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '\'':
+			// Quotes in strings are doubled, which toggles twice
+			inString = !inString
+			statement.WriteByte(ch)
+		case inString:
+			statement.WriteByte(ch)
+		case ch == ';':
+			statements = append(statements, statement.String())
+			statement.Reset()
+		case ch == '/' && i+1 < len(text) && text[i+1] == '*':
+			if end := strings.Index(text[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(text)
+			}
+		case ch == ' ' || ch == '\n' || ch == '\r' || ch == '\t':
+		default:
+			statement.WriteByte(ch)
+		}
+	}
+	return statements
+}
+
+// stepArguments splits the arguments of an entity at the commas outside strings and lists.
+func stepArguments(args string) []string {
+	var parts []string
+	depth := 0
+	inString := false
+	start := 0
+	for i := 0; i < len(args); i++ {
+		switch ch := args[i]; {
+		case ch == '\'':
+			inString = !inString
+		case inString:
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			parts = append(parts, args[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, args[start:])
+}
+
+// stepValue is the text of an argument: a string, a typed value such as IFCLABEL('x') or
+// IFCBOOLEAN(.T.), an enumeration or a number. Unset values and references have no text.
+func stepValue(arg string) string {
+	if open := strings.IndexByte(arg, '('); open > 0 && strings.HasSuffix(arg, ")") && !strings.HasPrefix(arg, "'") {
+		arg = arg[open+1 : len(arg)-1]
+	}
+	switch {
+	case arg == "" || arg == "$" || arg == "*" || strings.HasPrefix(arg, "#"):
+		return ""
+	case strings.HasPrefix(arg, "'") && strings.HasSuffix(arg, "'") && len(arg) >= 2:
+		return decodeStepString(arg[1 : len(arg)-1])
+	case arg == ".T.":
+		return "true"
+	case arg == ".F.":
+		return "false"
+	case strings.HasPrefix(arg, ".") && strings.HasSuffix(arg, "."):
+		return strings.Trim(arg, ".")
+	}
+	return arg
+}
+
+// decodeStepString decodes the escapes of a STEP string: doubled quotes and backslashes,
+// \X\hh (ISO 8859-1), \S\c (upper half of ISO 8859-1) and \X2\hhhh...\X0\ (UTF-16).
+func decodeStepString(s string) string {
+	var text strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "''"):
+			text.WriteByte('\'')
+			i++
+		case strings.HasPrefix(s[i:], `\\`):
+			text.WriteByte('\\')
+			i++
+		case strings.HasPrefix(s[i:], `\X2\`):
+			end := strings.Index(s[i+4:], `\X0\`)
+			if end < 0 {
+				return text.String()
+			}
+			hex := s[i+4 : i+4+end]
+			var units []uint16
+			for k := 0; k+4 <= len(hex); k += 4 {
+				unit, err := strconv.ParseUint(hex[k:k+4], 16, 16)
+				if err == nil {
+					units = append(units, uint16(unit))
+				}
+			}
+			text.WriteString(string(utf16.Decode(units)))
+			i += 4 + end + 3
+		case strings.HasPrefix(s[i:], `\X\`) && i+5 <= len(s):
+			if value, err := strconv.ParseUint(s[i+3:i+5], 16, 8); err == nil {
+				text.WriteRune(rune(value))
+			}
+			i += 4
+		case strings.HasPrefix(s[i:], `\S\`) && i+4 <= len(s):
+			text.WriteRune(rune(s[i+3]) + 128)
+			i += 3
+		default:
+			text.WriteByte(s[i])
+		}
+	}
+	return text.String()
+}
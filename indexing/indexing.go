@@ -0,0 +1,166 @@
+// Package indexing extracts the text of uploaded documents (PDF, plain text, the headers of CSV
+// files and the property sets of IFC models) into a Mongo text index, and keeps the index in
+// step with the files as they are deleted, moved and copied. Extraction is off unless
+// CONTENT_INDEXING is "true".
+package indexing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxTextLength is the most text kept of a file. Longer texts are cut, and only their start
+// can be found.
+const maxTextLength = 1 << 20
+
+// maxFileSize is the largest file that is indexed (CONTENT_INDEX_MAX_SIZE, in bytes).
+var maxFileSize = envInt64("CONTENT_INDEX_MAX_SIZE", 50<<20)
+
+// extractions bounds the files read and extracted at once, so that indexing doesn't compete
+// with uploads.
+var extractions = make(chan struct{}, 2)
+
+// Enabled reports whether uploaded documents are indexed.
+func Enabled() bool {
+	return globals.CONTENT_INDEXING
+}
+
+// Index extracts the text of a file in the background, once all of its parts are uploaded.
+// Files of unsupported types, or too large, are skipped.
+func Index(file models.File) {
+	if !Enabled() || !Supported(file.FileType) || file.Size > maxFileSize || len(file.Ancestors) == 0 {
+		return
+	}
+	go func() {
+		extractions <- struct{}{}
+		defer func() { <-extractions }()
+
+		if err := index(file); err != nil {
+			log.Println("Could not index file "+file.Id+": ", err.Error())
+		}
+	}()
+}
+
+// index reads a file from the object storage, extracts its text and stores it.
+func index(file models.File) error {
+	data, err := read(file)
+	if err != nil {
+		return err
+	}
+	text, err := Extract(file.FileType, data)
+	if err != nil {
+		return err
+	}
+
+	content := models.FileContent{
+		Id:          file.Id,
+		Folder:      file.FolderID,
+		Ancestors:   file.Ancestors,
+		FileType:    file.FileType,
+		Text:        text,
+		DateIndexed: time.Now(),
+	}
+	if len(content.Text) > maxTextLength {
+		content.Text = truncate(content.Text, maxTextLength)
+		content.Truncated = true
+	}
+	return globals.ContentDB.ReplaceOne(content)
+}
+
+// read joins the parts of a file, in order. Parts are kept in the bucket of the file.
+func read(file models.File) ([]byte, error) {
+	cursor, err := globals.PartsDB.GetCursorByFileID(file.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var parts []models.Part
+	for cursor.Next(context.Background()) {
+		var result bson.M
+		var part models.Part
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		bsonBytes, _ := bson.Marshal(result)
+		bson.Unmarshal(bsonBytes, &part)
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	var data bytes.Buffer
+	for _, part := range parts {
+		reader, _, _, err := globals.Storage.GetFile(part.Id, file.Ancestors[0], minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(&data, io.LimitReader(reader, maxFileSize+1-int64(data.Len())))
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if int64(data.Len()) > maxFileSize {
+			return nil, errors.New("file is larger than " + strconv.FormatInt(maxFileSize, 10) + " bytes")
+		}
+	}
+	return data.Bytes(), nil
+}
+
+// Remove drops the content of a deleted file.
+func Remove(fileID string) {
+	if err := globals.ContentDB.DeleteOneByID(fileID); err != nil {
+		log.Println("Could not remove content of file "+fileID+": ", err.Error())
+	}
+}
+
+// RemoveUnder drops the content of the files under a deleted folder or bucket.
+func RemoveUnder(folderID string) {
+	if err := globals.ContentDB.DeleteManyWithAncestore(folderID); err != nil {
+		log.Println("Could not remove content under folder "+folderID+": ", err.Error())
+	}
+}
+
+// Move places the content of a moved file where the file now is, so that searches follow it.
+func Move(file models.File) {
+	if err := globals.ContentDB.UpdatePlacement(file.Id, file.FolderID, file.Ancestors); err != nil {
+		log.Println("Could not move content of file "+file.Id+": ", err.Error())
+	}
+}
+
+// Copy gives a copy of a file the content of its source, without extracting it again.
+func Copy(sourceID string, file models.File) {
+	content, err := globals.ContentDB.GetOneByID(sourceID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	if err == nil {
+		content.Id = file.Id
+		content.Folder = file.FolderID
+		content.Ancestors = file.Ancestors
+		err = globals.ContentDB.ReplaceOne(content)
+	}
+	if err != nil {
+		log.Println("Could not copy content of file "+sourceID+": ", err.Error())
+	}
+}
+
+func envInt64(name string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
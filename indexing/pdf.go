@@ -0,0 +1,345 @@
+package indexing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// maxStreamSize is the most a compressed stream of a PDF is inflated to.
+const maxStreamSize = 64 << 20
+
+// skippedStreams mark the streams of a PDF that hold no page content, by their dictionary.
+var skippedStreams = []string{"/Subtype/Image", "/FontFile", "/Length1", "/Type/XRef", "/Type/ObjStm", "/Type/Metadata", "/Subtype/XML"}
+
+// otherFilters are the stream filters other than FlateDecode. Streams with them are skipped.
+var otherFilters = []string{"/DCTDecode", "/JPXDecode", "/CCITTFaxDecode", "/JBIG2Decode", "/LZWDecode", "/ASCII85Decode", "/ASCIIHexDecode", "/RunLengthDecode"}
+
+// extractPDF returns the text shown by the content streams of a PDF, a line per text line. It
+// reads streams compressed with FlateDecode or not compressed, and decodes strings as
+// PDFDocEncoding or UTF-16. Text in fonts with their own encodings (as in many CJK documents)
+// comes out garbled, and scanned pages have no text at all.
+func extractPDF(data []byte) (string, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return "", errors.New("not a PDF file")
+	}
+
+	var text pdfText
+	rest := data
+	for {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		// "endstream" ends with "stream" too
+		if start >= 3 && string(rest[start-3:start]) == "end" {
+			rest = rest[start+len("stream"):]
+			continue
+		}
+		dict := streamDict(rest[:start])
+		body := rest[start+len("stream"):]
+		switch {
+		case bytes.HasPrefix(body, []byte("\r\n")):
+			body = body[2:]
+		case bytes.HasPrefix(body, []byte("\n")), bytes.HasPrefix(body, []byte("\r")):
+			body = body[1:]
+		default:
+			rest = body
+			continue
+		}
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		rest = body[end+len("endstream"):]
+
+		if content, ok := decodeStream(dict, body[:end]); ok {
+			text.read(content)
+		}
+	}
+	return text.String(), nil
+}
+
+// streamDict is the dictionary of the stream that starts after before, without whitespace.
+func streamDict(before []byte) string {
+	if obj := bytes.LastIndex(before, []byte("obj")); obj >= 0 {
+		before = before[obj:]
+	}
+	if len(before) > 4096 {
+		before = before[len(before)-4096:]
+	}
+	return strings.Join(strings.Fields(string(before)), "")
+}
+
+// decodeStream returns the content of a stream, if it may show text.
+func decodeStream(dict string, raw []byte) ([]byte, bool) {
+	for _, skipped := range skippedStreams {
+		if strings.Contains(dict, skipped) {
+			return nil, false
+		}
+	}
+	for _, filter := range otherFilters {
+		if strings.Contains(dict, filter) {
+			return nil, false
+		}
+	}
+
+	content := raw
+	if strings.Contains(dict, "/FlateDecode") {
+		reader, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, false
+		}
+		// Keep what was inflated of streams that are cut short
+		content, _ = io.ReadAll(io.LimitReader(reader, maxStreamSize))
+		reader.Close()
+	}
+	return content, bytes.Contains(content, []byte("BT"))
+}
+
+// pdfText gathers the text of content streams.
+type pdfText struct {
+	lines []string
+	line  strings.Builder
+}
+
+// read adds the text that a content stream shows, between its BT and ET operators.
+func (t *pdfText) read(c []byte) {
+	inText := false
+	inArray := false
+	var strs []string  // Strings among the operands of the next operator
+	var nums []float64 // Numbers among the operands of the next operator
+	var array []byte   // Text of the array operand of TJ
+	for i := 0; i < len(c); {
+		ch := c[i]
+		switch {
+		case isPDFSpace(ch):
+			i++
+		case ch == '%':
+			for i < len(c) && c[i] != '\n' && c[i] != '\r' {
+				i++
+			}
+		case ch == '<' && i+1 < len(c) && c[i+1] == '<':
+			i += 2
+		case ch == '(' || ch == '<':
+			var s []byte
+			var n int
+			if ch == '(' {
+				s, n = literalString(c[i:])
+			} else {
+				s, n = hexString(c[i:])
+			}
+			if inArray {
+				array = append(array, decodePDFString(s)...)
+			} else {
+				strs = append(strs, decodePDFString(s))
+			}
+			i += n
+		case ch == '>' || ch == '{' || ch == '}':
+			i++
+		case ch == '[':
+			inArray = true
+			array = array[:0]
+			i++
+		case ch == ']':
+			inArray = false
+			strs = append(strs, string(array))
+			i++
+		case ch == '/':
+			i++
+			for i < len(c) && !isPDFSpace(c[i]) && !isPDFDelimiter(c[i]) {
+				i++
+			}
+		default:
+			j := i + 1
+			for j < len(c) && !isPDFSpace(c[j]) && !isPDFDelimiter(c[j]) {
+				j++
+			}
+			word := string(c[i:j])
+			i = j
+			if number, err := strconv.ParseFloat(word, 64); err == nil {
+				// Large negative kerning in TJ arrays stands for a space
+				if inArray && number < -200 {
+					array = append(array, ' ')
+				} else if !inArray {
+					nums = append(nums, number)
+				}
+				continue
+			}
+
+			switch word {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				t.newLine()
+			case "Tj", "TJ":
+				if inText {
+					t.write(strs)
+				}
+			case "'", "\"":
+				if inText {
+					t.newLine()
+					t.write(strs)
+				}
+			case "T*", "Tm":
+				t.newLine()
+			case "Td", "TD":
+				if len(nums) == 2 && nums[1] != 0 {
+					t.newLine()
+				} else {
+					t.line.WriteByte(' ')
+				}
+			case "BI":
+				// Inline images end with EI
+				if end := bytes.Index(c[i:], []byte("EI")); end >= 0 {
+					i += end + 2
+				} else {
+					i = len(c)
+				}
+			}
+			strs = strs[:0]
+			nums = nums[:0]
+		}
+	}
+	t.newLine()
+}
+
+func (t *pdfText) write(strs []string) {
+	for _, s := range strs {
+		t.line.WriteString(s)
+	}
+}
+
+func (t *pdfText) newLine() {
+	if line := strings.Join(strings.Fields(t.line.String()), " "); line != "" {
+		t.lines = append(t.lines, line)
+	}
+	t.line.Reset()
+}
+
+func (t *pdfText) String() string {
+	return strings.Join(t.lines, "\n")
+}
+
+// literalString reads a (string) with its escapes, and returns it with the bytes it took.
+func literalString(c []byte) ([]byte, int) {
+	var s []byte
+	depth := 0
+	i := 1
+	for ; i < len(c); i++ {
+		ch := c[i]
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return s, i + 1
+			}
+			depth--
+		case '\\':
+			i++
+			if i == len(c) {
+				return s, i
+			}
+			switch esc := c[i]; esc {
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'b':
+				s = append(s, '\b')
+			case 'f':
+				s = append(s, '\f')
+			case '\r':
+				// Line continuation
+				if i+1 < len(c) && c[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if esc >= '0' && esc <= '7' {
+					value := 0
+					for k := 0; k < 3 && i < len(c) && c[i] >= '0' && c[i] <= '7'; k++ {
+						value = value*8 + int(c[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, byte(value))
+				} else {
+					s = append(s, esc)
+				}
+			}
+			continue
+		}
+		s = append(s, ch)
+	}
+	return s, i
+}
+
+// hexString reads a <hex string>, and returns it with the bytes it took.
+func hexString(c []byte) ([]byte, int) {
+	var digits []byte
+	i := 1
+	for ; i < len(c) && c[i] != '>'; i++ {
+		if isHex(c[i]) {
+			digits = append(digits, c[i])
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for k := range s {
+		value, _ := strconv.ParseUint(string(digits[2*k:2*k+2]), 16, 8)
+		s[k] = byte(value)
+	}
+	return s, min(i+1, len(c))
+}
+
+// decodePDFString decodes a string as UTF-16 if it starts with a byte order mark, and as
+// PDFDocEncoding (close to Latin-1) otherwise. Characters that can't be printed are dropped.
+func decodePDFString(s []byte) string {
+	var runes []rune
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for k := 2; k+1 < len(s); k += 2 {
+			units = append(units, uint16(s[k])<<8|uint16(s[k+1]))
+		}
+		runes = utf16.Decode(units)
+	} else {
+		for _, b := range s {
+			runes = append(runes, rune(b))
+		}
+	}
+
+	var text strings.Builder
+	for _, r := range runes {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			text.WriteByte(' ')
+		case unicode.IsPrint(r):
+			text.WriteRune(r)
+		}
+	}
+	return text.String()
+}
+
+func isPDFSpace(ch byte) bool {
+	return ch == ' ' || ch == '\n' || ch == '\r' || ch == '\t' || ch == '\f' || ch == 0
+}
+
+func isPDFDelimiter(ch byte) bool {
+	return strings.IndexByte("()<>[]{}/%", ch) >= 0
+}
+
+func isHex(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
@@ -3,6 +3,7 @@ package main
 // swag init --> For Swagger docs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/isotiropoulos/storage-api/copernicus"
 	db "github.com/isotiropoulos/storage-api/dbs/meta"
 	objectstorage "github.com/isotiropoulos/storage-api/dbs/objectStorage"
 	_ "github.com/isotiropoulos/storage-api/docs"
@@ -39,7 +41,7 @@ func optionsHandler(w http.ResponseWriter, r *http.Request) {
 	// headers.Add("Vary", "Origin")
 	headers.Add("Vary", "Access-Control-Request-Method")
 	headers.Add("Vary", "Access-Control-Request-Headers")
-	headers.Add("Access-Control-Allow-Headers", "Content-Type, Origin, Accept, token, Authorization, Total, total") // X-Group-Id
+	headers.Add("Access-Control-Allow-Headers", "Content-Type, Origin, Accept, token, Authorization, X-API-Key, Total, total") // X-Group-Id
 	headers.Add("Access-Control-Allow-Methods", "GET, PUT, DELETE, POST, OPTIONS")
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusNoContent)
@@ -70,8 +72,13 @@ func main() {
 	fmt.Println("Starting the application...")
 	objectstorage.Init()
 	db.NewDB()
+	if err := db.EnsureIndexes(); err != nil {
+		log.Fatalln("Titles can't be kept unique: ", err.Error())
+	}
+	db.BackfillPaths()
 	auth.Init()
 	globals.Init()
+	copernicus.Start(context.Background())
 	r := mux.NewRouter()
 	r.Methods("OPTIONS").HandlerFunc(optionsHandler)
 
@@ -82,20 +89,22 @@ func main() {
 	// Route handles & endpoints
 	var mid middleware.IAuth = &middleware.AuthImplementation{}
 
-	r.HandleFunc("/bucket", mid.NaiveAuthMiddleware(handle.MakeBucket)).Methods("POST")
-	r.HandleFunc("/bucket/{id}", mid.AuthMiddleware(handle.DeleteBucket)).Methods("DELETE")
+	r.HandleFunc("/bucket", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.MakeBucket))).Methods("POST")
+	r.HandleFunc("/bucket/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.DeleteBucket))).Methods("DELETE")
 
 	// File-wise
 	if deployment == "PROD" {
-		r.HandleFunc("/file", mid.AuthMiddleware(handle.PostFile)).Methods("POST")
-
-		r.HandleFunc("/file/copy", mid.AuthMiddleware(handle.CopyFile)).Methods("POST")
-		r.HandleFunc("/file/move", mid.AuthMiddleware(handle.MoveFile)).Methods("PUT")
-		r.HandleFunc("/file/info/{id}", mid.AuthMiddleware(handle.GetFileInfo)).Methods("GET")
-		r.HandleFunc("/file/{id}", mid.AuthMiddleware(handle.PostFile)).Queries("part", "{partNum}").Methods("POST")
-		r.HandleFunc("/file/{id}", mid.AuthMiddleware(handle.GetFile)).Queries("part", "{partNum}").Methods("GET")
-		r.HandleFunc("/file/{id}", mid.AuthMiddleware(handle.DeleteFile)).Methods("DELETE")
-		r.HandleFunc("/file", mid.AuthMiddleware(handle.UpdateFile)).Methods("PUT")
+		r.HandleFunc("/file", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PostFile))).Methods("POST")
+
+		r.HandleFunc("/file/copy", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.CopyFile))).Methods("POST")
+		r.HandleFunc("/file/move", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.MoveFile))).Methods("PUT")
+		r.HandleFunc("/file/info/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFileInfo))).Methods("GET")
+		r.HandleFunc("/file", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFileByPath))).Queries("path", "{filePath}").Methods("GET")
+		r.HandleFunc("/file/provenance/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFileProvenance))).Methods("GET")
+		r.HandleFunc("/file/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassUpload, handle.PostFile))).Queries("part", "{partNum}").Methods("POST")
+		r.HandleFunc("/file/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassDownload, handle.GetFile))).Queries("part", "{partNum}").Methods("GET")
+		r.HandleFunc("/file/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.DeleteFile))).Methods("DELETE")
+		r.HandleFunc("/file", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.UpdateFile))).Methods("PUT")
 	} else if deployment == "LOCAL" {
 		// r.HandleFunc("/file", mid.AuthMiddleware(handle.PostFileLocal)).Methods("POST")
 		// r.HandleFunc("/file/{id}", mid.AuthMiddleware(handle.GetFileLocal)).Methods("GET")
@@ -109,21 +118,52 @@ func main() {
 	}
 
 	// Folder-wise
-	r.HandleFunc("/folder/copy", mid.AuthMiddleware(handle.CopyFolder)).Methods("POST")
-	r.HandleFunc("/folder", mid.AuthMiddleware(handle.PostFolder)).Methods("POST")
-	r.HandleFunc("/folder/{id}", mid.AuthMiddleware(handle.DeleteFolder)).Methods("DELETE")
-	r.HandleFunc("/folder", mid.AuthMiddleware(handle.UpdateFolder)).Methods("PUT")
-	r.HandleFunc("/folder", mid.AuthMiddleware(handle.GetFolder)).Queries("id", "{folderId}").Methods("GET")
-	r.HandleFunc("/folder", mid.AuthMiddleware(handle.GetFolder)).Queries("path", "{folderPath}").Methods("GET")
-	r.HandleFunc("/folder/list", mid.AuthMiddleware(handle.GetFolderItems)).Queries("id", "{folderId}").Methods("GET")
+	r.HandleFunc("/folder/copy", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.CopyFolder))).Methods("POST")
+	r.HandleFunc("/folder", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PostFolder))).Methods("POST")
+	r.HandleFunc("/folder/{id}", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.DeleteFolder))).Methods("DELETE")
+	r.HandleFunc("/folder", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.UpdateFolder))).Methods("PUT")
+	r.HandleFunc("/folder", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFolder))).Queries("id", "{folderId}").Methods("GET")
+	r.HandleFunc("/folder", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFolder))).Queries("path", "{folderPath}").Methods("GET")
+	r.HandleFunc("/folder/list", mid.AuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFolderItems))).Queries("id", "{folderId}").Methods("GET")
+	r.HandleFunc("/folder/tree", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetFolderTree))).Queries("id", "{folderId}").Methods("GET")
 	// r.HandleFunc("/folder/mine", mid.NaiveAuthMiddleware(handle.GetMyFolders)).Methods("GET")
 
+	// Search
+	r.HandleFunc("/search", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.Search))).Methods("GET")
+	r.HandleFunc("/search/content", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.SearchContent))).Methods("GET")
+
+	// API keys
+	r.HandleFunc("/apikey", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.CreateAPIKey))).Methods("POST")
+	r.HandleFunc("/apikey", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetAPIKeys))).Queries("group_id", "{groupId}").Methods("GET")
+	r.HandleFunc("/apikey/{id}", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.RevokeAPIKey))).Methods("DELETE")
+
 	// Copernicus
-	r.HandleFunc("/copernicus/collections", mid.NaiveAuthMiddleware(handle.GetList)).Methods("GET")
-	r.HandleFunc("/copernicus/form/{id}", mid.NaiveAuthMiddleware(handle.GetForm)).Methods("GET")
-	r.HandleFunc("/copernicus/dataset", mid.NaiveAuthMiddleware(handle.PostDataset)).Methods("POST")
-	r.HandleFunc("/copernicus/dataset/{fileId}", mid.NaiveAuthMiddleware(handle.CheckStatus)).Methods("GET")
-	r.HandleFunc("/copernicus/available", mid.NaiveAuthMiddleware(handle.GetAvailable)).Methods("GET")
+	r.HandleFunc("/copernicus/services", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetServices))).Methods("GET")
+	r.HandleFunc("/copernicus/credentials", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PutCopernicusCredential))).Methods("PUT")
+	r.HandleFunc("/copernicus/credentials", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetCopernicusCredentials))).Methods("GET")
+	r.HandleFunc("/copernicus/credentials/{id}", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.DeleteCopernicusCredential))).Methods("DELETE")
+	r.HandleFunc("/copernicus/collections", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.GetList))).Methods("GET")
+	r.HandleFunc("/copernicus/catalogue/search", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.SearchCatalogue))).Methods("GET")
+	r.HandleFunc("/copernicus/form/{id}", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.GetForm))).Methods("GET")
+	r.HandleFunc("/copernicus/dataset", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.PostDataset))).Methods("POST")
+	r.HandleFunc("/copernicus/dataset/{fileId}", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.CheckStatus))).Methods("GET")
+	r.HandleFunc("/copernicus/dataset/{fileId}/job", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.GetJob))).Methods("GET")
+	r.HandleFunc("/copernicus/dataset/{fileId}/job", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.CancelJob))).Methods("DELETE")
+	r.HandleFunc("/copernicus/dataset/{fileId}/retry", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.RetryJob))).Methods("POST")
+	r.HandleFunc("/copernicus/available", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassCopernicus, handle.GetAvailable))).Methods("GET")
+	r.HandleFunc("/copernicus/schedules", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PostSchedule))).Methods("POST")
+	r.HandleFunc("/copernicus/schedules", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetSchedules))).Methods("GET")
+	r.HandleFunc("/copernicus/schedules/{id}", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetSchedule))).Methods("GET")
+	r.HandleFunc("/copernicus/schedules/{id}", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.DeleteSchedule))).Methods("DELETE")
+	r.HandleFunc("/copernicus/schedules/{id}/pause", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PauseSchedule))).Methods("POST")
+	r.HandleFunc("/copernicus/schedules/{id}/resume", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.ResumeSchedule))).Methods("POST")
+	r.HandleFunc("/copernicus/schedules/{id}/runs", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetScheduleRuns))).Methods("GET")
+
+	// Notifications
+	r.HandleFunc("/notifications/preferences", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.GetNotificationPreferences))).Methods("GET")
+	r.HandleFunc("/notifications/preferences", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PutNotificationPreferences))).Methods("PUT")
+	r.HandleFunc("/notifications/preferences", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.DeleteNotificationPreferences))).Methods("DELETE")
+	r.HandleFunc("/notifications/test", mid.NaiveAuthMiddleware(middleware.Limit(middleware.ClassMetadata, handle.PostTestNotification))).Methods("POST")
 
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
@@ -0,0 +1,695 @@
+// Package memstore keeps the stores of globals in memory, for tests. Each store embeds the
+// interface it stands in for and implements what the Copernicus requests, their queue and the
+// copies of files use; the other methods panic.
+//
+// In a test, install a fresh set of stores and put the previous ones back when it ends:
+//
+//	stores := memstore.New()
+//	t.Cleanup(stores.Install())
+package memstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/isotiropoulos/storage-api/dbs/meta"
+	objectstorage "github.com/isotiropoulos/storage-api/dbs/objectStorage"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/minio/minio-go/v7"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Stores is a set of stores to install in globals.
+type Stores struct {
+	Files       *FileStore
+	Folders     *FolderStore
+	Parts       *PartStore
+	Records     *CopernicusStore
+	Queue       *QueueStore
+	Schedules   *ScheduleStore
+	Credentials *CredentialStore
+	Catalogue   *CatalogueStore
+	Preferences *NotificationStore
+	Contents    *ContentStore
+	Storage     *ObjectStorage
+}
+
+// New returns a set of empty stores.
+func New() *Stores {
+	return &Stores{
+		Files:       &FileStore{files: map[string]models.File{}},
+		Folders:     &FolderStore{folders: map[string]models.Folder{}},
+		Parts:       &PartStore{parts: map[string]models.Part{}},
+		Records:     &CopernicusStore{records: map[string]models.CopernicusRecord{}},
+		Queue:       &QueueStore{tasks: map[string]models.CopernicusTask{}},
+		Schedules:   &ScheduleStore{},
+		Credentials: &CredentialStore{},
+		Catalogue:   &CatalogueStore{entries: map[string]models.CatalogueEntry{}, states: map[string]models.CatalogueState{}},
+		Preferences: &NotificationStore{},
+		Contents:    &ContentStore{},
+		Storage:     &ObjectStorage{objects: map[string][]byte{}},
+	}
+}
+
+// Install swaps the stores of globals for s. The returned function puts the previous ones back.
+func (s *Stores) Install() (restore func()) {
+	fileDB, folderDB, partsDB := globals.FileDB, globals.FolderDB, globals.PartsDB
+	copernicusDB, queueDB, scheduleDB := globals.CopernicusDB, globals.CopernicusQueueDB, globals.CopernicusScheduleDB
+	credentialDB, catalogueDB, notificationDB := globals.CopernicusCredentialDB, globals.CopernicusCatalogueDB, globals.NotificationDB
+	contentDB, storage := globals.ContentDB, globals.Storage
+
+	globals.FileDB, globals.FolderDB, globals.PartsDB = s.Files, s.Folders, s.Parts
+	globals.CopernicusDB, globals.CopernicusQueueDB, globals.CopernicusScheduleDB = s.Records, s.Queue, s.Schedules
+	globals.CopernicusCredentialDB, globals.CopernicusCatalogueDB, globals.NotificationDB = s.Credentials, s.Catalogue, s.Preferences
+	globals.ContentDB, globals.Storage = s.Contents, s.Storage
+
+	return func() {
+		globals.FileDB, globals.FolderDB, globals.PartsDB = fileDB, folderDB, partsDB
+		globals.CopernicusDB, globals.CopernicusQueueDB, globals.CopernicusScheduleDB = copernicusDB, queueDB, scheduleDB
+		globals.CopernicusCredentialDB, globals.CopernicusCatalogueDB, globals.NotificationDB = credentialDB, catalogueDB, notificationDB
+		globals.ContentDB, globals.Storage = contentDB, storage
+	}
+}
+
+// duplicateKey is the error of a write that breaks a unique index.
+var duplicateKey = mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}
+
+// cursor returns a cursor over documents.
+func cursor[T any](documents []T) (*mongo.Cursor, error) {
+	docs := make([]interface{}, len(documents))
+	for i, document := range documents {
+		docs[i] = document
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+// FileStore keeps files.
+type FileStore struct {
+	db.IFileStore
+	mu    sync.Mutex
+	files map[string]models.File
+}
+
+// InsertOne keeps titles unique in a folder, as the index of the Mongo store does.
+func (s *FileStore) InsertOne(file models.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file.TitleKey = db.TitleKey(file.Meta.Title)
+	for _, existing := range s.files {
+		if existing.Id == file.Id || (existing.FolderID == file.FolderID && existing.TitleKey == file.TitleKey) {
+			return duplicateKey
+		}
+	}
+	s.files[file.Id] = file
+	return nil
+}
+
+func (s *FileStore) GetOneByFolderTitle(folderID string, title string) (models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := db.TitleKey(title)
+	for _, file := range s.files {
+		if file.FolderID == folderID && file.TitleKey == key {
+			return file, nil
+		}
+	}
+	return models.File{}, mongo.ErrNoDocuments
+}
+
+func (s *FileStore) GetOneByID(fileID string) (models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[fileID]
+	if !ok {
+		return models.File{}, mongo.ErrNoDocuments
+	}
+	return file, nil
+}
+
+func (s *FileStore) UpdateWithId(file models.File) (models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[file.Id]; !ok {
+		return models.File{}, mongo.ErrNoDocuments
+	}
+	s.files[file.Id] = file
+	return file, nil
+}
+
+func (s *FileStore) SetProvenance(fileID string, provenance *models.CopernicusProvenance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[fileID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	file.Provenance = provenance
+	s.files[fileID] = file
+	return nil
+}
+
+func (s *FileStore) DeleteOneByID(fileID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, fileID)
+	return nil
+}
+
+// FolderStore keeps folders.
+type FolderStore struct {
+	db.IFolderStore
+	mu      sync.Mutex
+	folders map[string]models.Folder
+}
+
+func (s *FolderStore) InsertOne(folder models.Folder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.folders[folder.Id] = folder
+	return nil
+}
+
+func (s *FolderStore) GetOneByID(folderID string) (models.Folder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	folder, ok := s.folders[folderID]
+	if !ok {
+		return models.Folder{}, mongo.ErrNoDocuments
+	}
+	return folder, nil
+}
+
+func (s *FolderStore) UpdateWithId(folder models.Folder) (models.Folder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.folders[folder.Id]; !ok {
+		return models.Folder{}, mongo.ErrNoDocuments
+	}
+	s.folders[folder.Id] = folder
+	return folder, nil
+}
+
+func (s *FolderStore) UpdateFiles(fileId string, folderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	folder, ok := s.folders[folderID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	folder.Files = append(folder.Files, fileId)
+	s.folders[folderID] = folder
+	return nil
+}
+
+func (s *FolderStore) UpdateMetaAncestors(ancestors []string, userID string) error {
+	return nil
+}
+
+func (s *FolderStore) UpdateAncestorSize(ancestors []string, size int64, add bool) error {
+	return nil
+}
+
+// PartStore keeps the parts of files.
+type PartStore struct {
+	db.IPartStore
+	mu    sync.Mutex
+	parts map[string]models.Part
+}
+
+func (s *PartStore) InsertOne(part models.Part) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parts[part.Id] = part
+	return nil
+}
+
+// ByFile returns the parts of a file in order.
+func (s *PartStore) ByFile(fileID string) []models.Part {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []models.Part
+	for _, part := range s.parts {
+		if part.FileID == fileID {
+			parts = append(parts, part)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts
+}
+
+func (s *PartStore) GetCursorByFileID(fileID string) (*mongo.Cursor, error) {
+	return cursor(s.ByFile(fileID))
+}
+
+func (s *PartStore) CountByFileID(fileID string) (int64, error) {
+	return int64(len(s.ByFile(fileID))), nil
+}
+
+func (s *PartStore) DeleteManyWithFile(fileId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, part := range s.parts {
+		if part.FileID == fileId {
+			delete(s.parts, id)
+		}
+	}
+	return nil
+}
+
+// CopernicusStore keeps Copernicus records.
+type CopernicusStore struct {
+	db.ICopernicusStore
+	mu      sync.Mutex
+	records map[string]models.CopernicusRecord
+}
+
+func (s *CopernicusStore) InsertOne(record models.CopernicusRecord) error {
+	return s.ReplaceOne(record)
+}
+
+func (s *CopernicusStore) ReplaceOne(record models.CopernicusRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Id] = record
+	return nil
+}
+
+func (s *CopernicusStore) ClaimSubmission(record models.CopernicusRecord, previous *models.CopernicusRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.records[record.Id]
+	if previous == nil {
+		if ok {
+			return false, nil
+		}
+		s.records[record.Id] = record
+		return true, nil
+	}
+	if !ok || stored.Details.Status != previous.Details.Status || !stored.DateCreation.Equal(previous.DateCreation) {
+		return false, nil
+	}
+	stored.Details.Status = record.Details.Status
+	stored.DateCreation = record.DateCreation
+	s.records[record.Id] = stored
+	return true, nil
+}
+
+func (s *CopernicusStore) GetOneByID(inputId string) (models.CopernicusRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[inputId]
+	if !ok {
+		return models.CopernicusRecord{}, mongo.ErrNoDocuments
+	}
+	return record, nil
+}
+
+func (s *CopernicusStore) GetOneByFileID(fileId string) (models.CopernicusRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.records {
+		if record.FileId == fileId {
+			return record, nil
+		}
+		for _, delivery := range record.Deliveries {
+			if delivery.FileId == fileId {
+				return record, nil
+			}
+		}
+	}
+	return models.CopernicusRecord{}, mongo.ErrNoDocuments
+}
+
+// GetCursorByRequester lists the records of a requester, of one of their deliveries, and those
+// without requesters, as the Mongo store does.
+func (s *CopernicusStore) GetCursorByRequester(subject string) (*mongo.Cursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []models.CopernicusRecord
+	for _, record := range s.records {
+		requested := record.Requesters == nil
+		for _, requester := range record.Requesters {
+			requested = requested || requester == subject
+		}
+		for _, delivery := range record.Deliveries {
+			requested = requested || delivery.Subject == subject
+		}
+		if requested {
+			records = append(records, record)
+		}
+	}
+	return cursor(records)
+}
+
+func (s *CopernicusStore) GetCursorAll() (*mongo.Cursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]models.CopernicusRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return cursor(records)
+}
+
+func (s *CopernicusStore) UpdateWithId(record models.CopernicusRecord) (models.CopernicusRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[record.Id]; !ok {
+		return models.CopernicusRecord{}, mongo.ErrNoDocuments
+	}
+	s.records[record.Id] = record
+	return record, nil
+}
+
+// update applies fn to a stored record.
+func (s *CopernicusStore) update(inputId string, fn func(record *models.CopernicusRecord)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[inputId]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	fn(&record)
+	s.records[inputId] = record
+	return nil
+}
+
+func (s *CopernicusStore) AddRequester(inputId string, subject string) error {
+	return s.update(inputId, func(record *models.CopernicusRecord) {
+		record.Requesters = appendOnce(record.Requesters, subject)
+	})
+}
+
+func (s *CopernicusStore) AddSubscriber(inputId string, subject string) error {
+	return s.update(inputId, func(record *models.CopernicusRecord) {
+		record.Subscribers = appendOnce(record.Subscribers, subject)
+	})
+}
+
+func (s *CopernicusStore) SetError(inputId string, message string) error {
+	return s.update(inputId, func(record *models.CopernicusRecord) {
+		record.Error = message
+	})
+}
+
+func (s *CopernicusStore) DeleteOneByID(inputId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, inputId)
+	return nil
+}
+
+func (s *CopernicusStore) DeleteOneByFileID(fileId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, record := range s.records {
+		if record.FileId == fileId {
+			delete(s.records, id)
+		}
+	}
+	return nil
+}
+
+func appendOnce(items []string, item string) []string {
+	for _, existing := range items {
+		if existing == item {
+			return items
+		}
+	}
+	return append(items, item)
+}
+
+// QueueStore keeps the Copernicus task queue, with the leases of the Mongo store.
+type QueueStore struct {
+	db.ICopernicusQueueStore
+	mu    sync.Mutex
+	tasks map[string]models.CopernicusTask
+}
+
+func (s *QueueStore) Enqueue(task models.CopernicusTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tasks[task.Id]; !ok {
+		s.tasks[task.Id] = task
+	}
+	return nil
+}
+
+func (s *QueueStore) Requeue(task models.CopernicusTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[task.Id] = task
+	return nil
+}
+
+func (s *QueueStore) GetOneByID(taskID string) (models.CopernicusTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[taskID]
+	if !ok {
+		return models.CopernicusTask{}, mongo.ErrNoDocuments
+	}
+	return task, nil
+}
+
+func (s *QueueStore) Claim(owner string, lease time.Duration) (models.CopernicusTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var due *models.CopernicusTask
+	for _, task := range s.tasks {
+		if task.State != models.TaskQueued || task.NextPoll.After(now) || !task.LeaseUntil.Before(now) {
+			continue
+		}
+		if due == nil || task.NextPoll.Before(due.NextPoll) {
+			task := task
+			due = &task
+		}
+	}
+	if due == nil {
+		return models.CopernicusTask{}, mongo.ErrNoDocuments
+	}
+	due.LeaseOwner = owner
+	due.LeaseUntil = now.Add(lease)
+	due.Heartbeat = now
+	s.tasks[due.Id] = *due
+	return *due, nil
+}
+
+// held applies fn to a task leased by owner.
+func (s *QueueStore) held(taskID string, owner string, fn func(task *models.CopernicusTask)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[taskID]
+	if !ok || task.LeaseOwner != owner {
+		return db.ErrLeaseLost
+	}
+	fn(&task)
+	s.tasks[taskID] = task
+	return nil
+}
+
+func (s *QueueStore) Heartbeat(taskID string, owner string, lease time.Duration) error {
+	return s.held(taskID, owner, func(task *models.CopernicusTask) {
+		task.LeaseUntil = time.Now().Add(lease)
+		task.Heartbeat = time.Now()
+	})
+}
+
+func (s *QueueStore) Release(released models.CopernicusTask, owner string) error {
+	return s.held(released.Id, owner, func(task *models.CopernicusTask) {
+		task.LeaseOwner = ""
+		task.LeaseUntil = time.Time{}
+		task.NextPoll = released.NextPoll
+		task.Attempts = released.Attempts
+		task.LastError = released.LastError
+	})
+}
+
+func (s *QueueStore) Finish(taskID string, owner string, state string, lastError string) error {
+	return s.held(taskID, owner, func(task *models.CopernicusTask) {
+		task.State = state
+		task.LeaseOwner = ""
+		task.LeaseUntil = time.Time{}
+		task.LastError = lastError
+		task.DateUpdate = time.Now()
+	})
+}
+
+func (s *QueueStore) Cancel(taskID string, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[taskID]
+	if !ok {
+		return nil
+	}
+	task.State = models.TaskFailed
+	task.LeaseOwner = ""
+	task.LeaseUntil = time.Time{}
+	task.LastError = lastError
+	task.DateUpdate = time.Now()
+	s.tasks[taskID] = task
+	return nil
+}
+
+// ScheduleStore has no schedules.
+type ScheduleStore struct {
+	db.ICopernicusScheduleStore
+}
+
+func (s *ScheduleStore) Claim(owner string, lease time.Duration) (models.CopernicusSchedule, error) {
+	return models.CopernicusSchedule{}, mongo.ErrNoDocuments
+}
+
+// CredentialStore has no stored keys, so that requests use the shared key of their service.
+type CredentialStore struct {
+	db.ICopernicusCredentialStore
+}
+
+func (s *CredentialStore) GetOneByID(credentialID string) (models.CopernicusCredential, error) {
+	return models.CopernicusCredential{}, mongo.ErrNoDocuments
+}
+
+// CatalogueStore keeps the catalogue of the Copernicus services.
+type CatalogueStore struct {
+	db.ICopernicusCatalogueStore
+	mu      sync.Mutex
+	entries map[string]models.CatalogueEntry
+	states  map[string]models.CatalogueState
+}
+
+func (s *CatalogueStore) ReplaceOne(entry models.CatalogueEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.Id] = entry
+	return nil
+}
+
+func (s *CatalogueStore) GetOneByID(entryID string) (models.CatalogueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[entryID]
+	if !ok {
+		return models.CatalogueEntry{}, mongo.ErrNoDocuments
+	}
+	return entry, nil
+}
+
+func (s *CatalogueStore) DeleteStale(service string, refreshedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.entries {
+		if entry.Service == service && entry.DateRefreshed.Before(refreshedBefore) {
+			delete(s.entries, id)
+		}
+	}
+	return nil
+}
+
+func (s *CatalogueStore) GetState(service string) (models.CatalogueState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[service]
+	if !ok {
+		return models.CatalogueState{}, mongo.ErrNoDocuments
+	}
+	return state, nil
+}
+
+func (s *CatalogueStore) SetState(state models.CatalogueState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[state.Service] = state
+	return nil
+}
+
+// NotificationStore has no preferences, so that nobody is notified.
+type NotificationStore struct {
+	db.INotificationStore
+}
+
+func (s *NotificationStore) GetOneBySubject(subject string) (models.NotificationPreferences, error) {
+	return models.NotificationPreferences{}, mongo.ErrNoDocuments
+}
+
+// ContentStore has no extracted text.
+type ContentStore struct {
+	db.IContentStore
+}
+
+func (s *ContentStore) GetOneByID(fileID string) (models.FileContent, error) {
+	return models.FileContent{}, mongo.ErrNoDocuments
+}
+
+func (s *ContentStore) DeleteOneByID(fileID string) error {
+	return nil
+}
+
+func (s *ContentStore) DeleteManyWithAncestore(ancestore string) error {
+	return nil
+}
+
+// ObjectStorage keeps objects by bucket and name.
+type ObjectStorage struct {
+	objectstorage.IFileStorage
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// Object returns the content of an object, if it exists.
+func (s *ObjectStorage) Object(bucket string, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[bucket+"/"+name]
+	return data, ok
+}
+
+// Names returns the names of the objects of a bucket, sorted.
+func (s *ObjectStorage) Names(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, bucket+"/") {
+			names = append(names, strings.TrimPrefix(key, bucket+"/"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *ObjectStorage) PostPart(bucket string, fileID string, data io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, data)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+fileID] = buf.Bytes()
+	return minio.UploadInfo{Bucket: bucket, Key: fileID, Size: n}, nil
+}
+
+func (s *ObjectStorage) DeleteFile(fileID string, bucket string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+fileID)
+	return nil
+}
+
+func (s *ObjectStorage) CopyFile(originalName string, newName string, bucketFrom string, bucketTo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[bucketFrom+"/"+originalName]
+	if !ok {
+		return errors.New("object " + originalName + " not found")
+	}
+	s.objects[bucketTo+"/"+newName] = data
+	return nil
+}
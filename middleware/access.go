@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/cache"
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// accessSeen keeps the users whose access was saved lately, so that it is saved at most once
+// per minute per user.
+var accessSeen = cache.New[string, time.Time](envInt("ACCESS_CACHE_SIZE", 10000), time.Minute)
+
+// AccessOf returns the access of a caller, to be checked by work done later on their behalf.
+func AccessOf(claims models.OidcClaims, principal models.Principal) models.ScheduleAccess {
+	return models.ScheduleAccess{
+		Groups:     claims.Groups,
+		EditorIn:   claims.EditorIn,
+		ViewerIn:   claims.ViewerIn,
+		APIKeyID:   principal.APIKeyID,
+		DateUpdate: time.Now(),
+	}
+}
+
+// AccessPrincipal resolves the principal of a user on the group of a folder from their saved
+// access. API keys are looked up again, so that revoked keys lose their access at once.
+func AccessPrincipal(subject string, access models.ScheduleAccess, folderID string) (models.Principal, error) {
+	claims := models.OidcClaims{
+		Claims:   &jwt.Claims{Subject: subject},
+		Groups:   access.Groups,
+		EditorIn: access.EditorIn,
+		ViewerIn: access.ViewerIn,
+	}
+
+	var apiKey *models.APIKey
+	if access.APIKeyID != "" {
+		key, err := globals.APIKeyDB.GetOneByID(access.APIKeyID)
+		if err != nil {
+			return models.Principal{}, err
+		}
+		if key.Revoked {
+			return models.Principal{}, errors.New("API key revoked")
+		}
+		apiKey = &key
+	}
+
+	groupID, groupName, folderIds, err := resolveGroup(map[string]string{"_id": folderID}, "folder")
+	if err != nil {
+		return models.Principal{}, err
+	}
+
+	principal, ok := newPrincipal(claims, apiKey, groupID, groupName, folderIds)
+	if !ok {
+		return principal, errors.New("no permission rights for user in group")
+	}
+	return principal, nil
+}
+
+// recordAccess saves the access of a user on their schedules in the background, so that
+// the schedules follow changes of their groups and shares.
+func recordAccess(claims models.OidcClaims) {
+	if claims.Claims == nil || claims.Subject == "" || strings.HasPrefix(claims.Subject, "apikey:") {
+		return
+	}
+	if _, seen := accessSeen.Get(claims.Subject); seen {
+		return
+	}
+	accessSeen.Set(claims.Subject, time.Now())
+
+	go func(access models.ScheduleAccess) {
+		if err := globals.CopernicusScheduleDB.UpdateAccess(claims.Subject, access); err != nil {
+			log.Println("failed to update schedule access: ", err.Error())
+		}
+	}(AccessOf(claims, models.Principal{}))
+}
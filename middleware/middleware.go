@@ -4,22 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	db "github.com/isotiropoulos/storage-api/dbs/meta"
+	"github.com/isotiropoulos/storage-api/globals"
 	"github.com/isotiropoulos/storage-api/models"
 	auth "github.com/isotiropoulos/storage-api/oauth"
 	"github.com/isotiropoulos/storage-api/utils"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
-var fileDB db.IFileStore = &db.FileStore{}
-var folderDB db.IFolderStore = &db.FolderStore{}
-
 type IAuth interface {
 	AuthMiddleware(h http.HandlerFunc) http.HandlerFunc
 	NaiveAuthMiddleware(h http.HandlerFunc) http.HandlerFunc
@@ -31,33 +31,16 @@ type AuthImplementation struct {
 func (a *AuthImplementation) AuthMiddleware(h http.HandlerFunc) http.HandlerFunc {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiAll := r.Header.Get("Authorization")
-		if apiAll == "" {
-			utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "No Authorization header.", "MID0001")
-			return
-		}
-		apiKeyAr := strings.Split(apiAll, " ")
-		authType := apiKeyAr[0]
-		if authType != "Bearer" {
-			utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "No Bearer token.", "MID0002")
-			return
-		}
+		stripIdentityHeaders(r)
 
-		apiKey := apiKeyAr[1]
-		// log.Println(apiKey)
-		claims, err := auth.GetClaims(apiKey)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Unable to resolve claims.", err.Error(), "MID0003")
-			return
-		}
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
-		ctx = context.WithValue(ctx, "claims", claims)
-		_, err = auth.Verifier.Verify(ctx, apiKey)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Unable to initialize verifier.", err.Error(), "MID0004")
+
+		claims, apiKey, ok := authenticate(ctx, w, r, [3]string{"MID0001", "MID0002", "MID0003"})
+		if !ok {
 			return
 		}
+		ctx = context.WithValue(ctx, "claims", claims)
 
 		// Find GROUP ID
 		// Extract collection
@@ -97,9 +80,7 @@ func (a *AuthImplementation) AuthMiddleware(h http.HandlerFunc) http.HandlerFunc
 					}
 					q = extractKeysAndValues(data)
 				} else {
-					pathSegments := strings.Split(pathQuery, "/")
-					q = map[string]string{"name": pathSegments[0]}
-					collection = "folder"
+					q, collection = pathQueryOf(utils.SplitPath(pathQuery), collection)
 				}
 			} else {
 				q = map[string]string{"_id": id}
@@ -108,41 +89,18 @@ func (a *AuthImplementation) AuthMiddleware(h http.HandlerFunc) http.HandlerFunc
 			q = map[string]string{"_id": id}
 		}
 
-		groupID, groupName, folderIds, err := grabGroupId(q, collection)
+		groupID, groupName, folderIds, err := resolveGroup(q, collection)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusUnauthorized, "Unable to resolve Group.", err.Error(), "MID0011")
 			return
 		}
 
-		allow := false
-
-		if utils.ItemInArray(claims.Groups, groupName) {
-			r.Header.Add("X-Mode", "normal")
-			allow = true
-		}
-
-		// Second chance: Check if user has access to shared content
-		if !allow {
-			if folderIds != nil {
-				if checkSharedContent(claims.EditorIn, folderIds) {
-					r.Header.Add("X-Mode", "editor")
-					allow = true
-				}
-
-				if checkSharedContent(claims.ViewerIn, folderIds) {
-					r.Header.Add("X-Mode", "viewer")
-					allow = true
-				}
-
-			}
-		}
-
-		if !allow {
+		principal, ok := newPrincipal(claims, apiKey, groupID, groupName, folderIds)
+		if !ok {
 			utils.RespondWithError(w, http.StatusForbidden, "Permission Denied.", "No permission rights for user in group.", "MID0006")
 			return
 		}
-
-		r.Header.Add("X-Group-Id", groupID)
+		ctx = utils.ContextWithPrincipal(ctx, principal)
 
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -151,35 +109,211 @@ func (a *AuthImplementation) AuthMiddleware(h http.HandlerFunc) http.HandlerFunc
 func (a *AuthImplementation) NaiveAuthMiddleware(h http.HandlerFunc) http.HandlerFunc {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiAll := r.Header.Get("Authorization")
-		if apiAll == "" {
-			utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "No Authorization header.", "MID0007")
+		stripIdentityHeaders(r)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		claims, apiKey, ok := authenticate(ctx, w, r, [3]string{"MID0007", "MID0008", "MID0009"})
+		if !ok {
 			return
 		}
-		apiKeyAr := strings.Split(apiAll, " ")
-		authType := apiKeyAr[0]
-		if authType != "Bearer" {
-			utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "No Bearer token.", "MID0008")
-			return
+		ctx = context.WithValue(ctx, "claims", claims)
+
+		// No group is resolved here, so the principal carries no capabilities
+		principal := models.Principal{Subject: claims.Subject}
+		if apiKey != nil {
+			principal.APIKeyID = apiKey.Id
 		}
+		ctx = utils.ContextWithPrincipal(ctx, principal)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		apiKey := apiKeyAr[1]
-		claims, err := auth.GetClaims(apiKey)
+// FolderPrincipal resolves the principal of the caller on the group of a folder. It lets
+// handlers behind NaiveAuthMiddleware authorize a folder given in the request body.
+func FolderPrincipal(r *http.Request, folderID string) (models.Principal, error) {
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		return models.Principal{}, err
+	}
+
+	var apiKey *models.APIKey
+	if caller, _ := utils.GetPrincipalFromContext(r.Context()); caller.APIKeyID != "" {
+		key, err := globals.APIKeyDB.GetOneByID(caller.APIKeyID)
 		if err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Unable to resolve claims.", err.Error(), "MID0009")
-			return
+			return models.Principal{}, err
 		}
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-		ctx = context.WithValue(ctx, "claims", claims)
-		_, err = auth.Verifier.Verify(ctx, apiKey)
+		apiKey = &key
+	}
+
+	groupID, groupName, folderIds, err := resolveGroup(map[string]string{"_id": folderID}, "folder")
+	if err != nil {
+		return models.Principal{}, err
+	}
+
+	principal, ok := newPrincipal(*claims, apiKey, groupID, groupName, folderIds)
+	if !ok {
+		return principal, errors.New("no permission rights for user in group")
+	}
+	return principal, nil
+}
+
+// newPrincipal builds the principal of a user or machine client on a resolved group.
+// It returns false if the caller has no role on the group.
+func newPrincipal(claims models.OidcClaims, apiKey *models.APIKey, groupID string, groupName string, folderIds []string) (models.Principal, bool) {
+	var role models.Role
+	if apiKey != nil {
+		role = resolveAPIKeyRole(*apiKey, groupID)
+	} else {
+		role = resolveRole(claims, groupName, folderIds)
+	}
+	if role == "" {
+		return models.Principal{}, false
+	}
+
+	principal := models.Principal{
+		Subject:      claims.Subject,
+		Role:         role,
+		GroupID:      groupID,
+		GroupName:    groupName,
+		Capabilities: utils.RoleCapabilities[role],
+	}
+	if apiKey != nil {
+		principal.APIKeyID = apiKey.Id
+	}
+	return principal, true
+}
+
+// authenticate resolves the caller either from an API key or from a Bearer JWT.
+// On failure it responds with the matching error code and returns false.
+func authenticate(ctx context.Context, w http.ResponseWriter, r *http.Request, codes [3]string) (models.OidcClaims, *models.APIKey, bool) {
+
+	// Machine clients authenticate with an API key
+	if key := apiKeyFromRequest(r); key != "" {
+		apiKey, err := resolveAPIKey(key)
 		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Unable to initialize verifier.", err.Error(), "MID0010")
-			return
+			utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "Invalid or revoked API key.", "MID0012")
+			return models.OidcClaims{}, nil, false
 		}
-		// r.Header.Add("X-Mode", "normal")
-		h.ServeHTTP(w, r.WithContext(ctx))
-	})
+		return apiKeyClaims(apiKey), &apiKey, true
+	}
+
+	apiAll := r.Header.Get("Authorization")
+	if apiAll == "" {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "No Authorization header.", codes[0])
+		return models.OidcClaims{}, nil, false
+	}
+	apiKeyAr := strings.Split(apiAll, " ")
+	authType := apiKeyAr[0]
+	if authType != "Bearer" || len(apiKeyAr) < 2 {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized.", "No Bearer token.", codes[1])
+		return models.OidcClaims{}, nil, false
+	}
+
+	// Verified once; repeated calls with the same token are served from the token cache
+	claims, err := auth.Verifier.Verify(ctx, apiKeyAr[1])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Unable to resolve claims.", err.Error(), codes[2])
+		return models.OidcClaims{}, nil, false
+	}
+	recordAccess(claims)
+	return claims, nil, true
+}
+
+// apiKeyFromRequest returns the API key of the request, passed either as
+// "X-API-Key: <key>" or as "Authorization: ApiKey <key>".
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	apiAll := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(apiAll) == 2 && apiAll[0] == "ApiKey" {
+		return apiAll[1]
+	}
+	return ""
+}
+
+// resolveAPIKey looks up an active API key and records its use.
+func resolveAPIKey(key string) (models.APIKey, error) {
+	hash := utils.HashAPIKey(key)
+	apiKey, cached := apiKeys.Get(hash)
+	if !cached {
+		var err error
+		apiKey, err = globals.APIKeyDB.GetActiveByHash(hash)
+		if err != nil {
+			return apiKey, err
+		}
+	}
+
+	// Keep last-used tracking cheap: at most one write per key per minute
+	now := time.Now()
+	touched := now.Sub(apiKey.LastUsed) > time.Minute
+	if touched {
+		apiKey.LastUsed = now
+		go func(keyID string) {
+			if err := globals.APIKeyDB.UpdateLastUsed(keyID, now); err != nil {
+				log.Println("failed to update API key last use: ", err.Error())
+			}
+		}(apiKey.Id)
+	}
+	if !cached || touched {
+		apiKeys.Set(hash, apiKey)
+	}
+	return apiKey, nil
+}
+
+// apiKeyClaims builds the claims that handlers see for a machine client.
+func apiKeyClaims(apiKey models.APIKey) models.OidcClaims {
+	return models.OidcClaims{
+		Claims:           &jwt.Claims{Subject: "apikey:" + apiKey.Id},
+		Name:             apiKey.Name,
+		PreferedUsername: apiKey.Name,
+	}
+}
+
+// resolveAPIKeyRole returns the role an API key holds on the resolved group.
+// Keys only work inside their own bucket; write keys act as editors and read keys as viewers.
+func resolveAPIKeyRole(apiKey models.APIKey, groupID string) models.Role {
+	if apiKey.GroupID != groupID {
+		return ""
+	}
+	for _, permission := range apiKey.Permissions {
+		if permission == models.CapWrite {
+			return models.RoleEditor
+		}
+	}
+	for _, permission := range apiKey.Permissions {
+		if permission == models.CapRead {
+			return models.RoleViewer
+		}
+	}
+	return ""
+}
+
+// stripIdentityHeaders removes identity headers that only the API may set,
+// so that clients cannot forge their role or group.
+func stripIdentityHeaders(r *http.Request) {
+	r.Header.Del("X-Mode")
+	r.Header.Del("X-Group-Id")
+}
+
+// resolveRole returns the role of the user on the resolved group, or an empty role if the user has no access.
+func resolveRole(claims models.OidcClaims, groupName string, folderIds []string) models.Role {
+	if utils.ItemInArray(claims.Groups, groupName) {
+		return models.RoleMember
+	}
+
+	// Second chance: Check if user has access to shared content
+	if folderIds != nil {
+		if checkSharedContent(claims.EditorIn, folderIds) {
+			return models.RoleEditor
+		}
+		if checkSharedContent(claims.ViewerIn, folderIds) {
+			return models.RoleViewer
+		}
+	}
+	return ""
 }
 
 func readRequestBody(r *http.Request, v interface{}) error {
@@ -240,6 +374,23 @@ func extractKeysAndValues(data interface{}) map[string]string {
 	return result
 }
 
+// pathQueryOf is the query of the item a path leads to: the file at it for file requests, or
+// else the deepest folder on it, so that access is checked on that folder and handlers answer
+// for what is missing. A path whose bucket is unknown is looked up by the bucket's name.
+func pathQueryOf(names []string, collection string) (map[string]string, string) {
+	folders, err := utils.WalkPath(names)
+	if err != nil || len(folders) == 0 {
+		return map[string]string{"name": names[0]}, "folder"
+	}
+	last := folders[len(folders)-1]
+	if collection == "file" && len(folders) == len(names)-1 {
+		if file, err := globals.FileDB.GetOneByPath(utils.JoinPath(names)); err == nil {
+			return map[string]string{"_id": file.Id}, "file"
+		}
+	}
+	return map[string]string{"_id": last.Id}, "folder"
+}
+
 func grabGroupId(q map[string]string, collection string) (string, string, []string, error) {
 
 	var groupID string
@@ -262,11 +413,11 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 		if key == "_id" {
 			var dbResult models.File
 			var dbResult2 models.Folder
-			dbResult, err = fileDB.GetOneByID(value)
+			dbResult, err = globals.FileDB.GetOneByID(value)
 			if err != nil {
 				return "", "", nil, err
 			}
-			dbResult2, err = folderDB.GetOneByID(dbResult.Ancestors[0])
+			dbResult2, err = globals.FolderDB.GetOneByID(dbResult.Ancestors[0])
 			if err != nil {
 				return "", "", nil, err
 			}
@@ -275,7 +426,7 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 			folderIds = append(dbResult.Ancestors, dbResult.FolderID)
 		} else {
 			var dbResult models.Folder
-			dbResult, err = folderDB.GetOneByID(value)
+			dbResult, err = globals.FolderDB.GetOneByID(value)
 			if err != nil {
 				return "", "", nil, err
 			}
@@ -286,7 +437,7 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 			} else {
 				folderIds = append(dbResult.Ancestors, dbResult.Id)
 				id := dbResult.Ancestors[0]
-				dbResult, err = folderDB.GetOneByID(id)
+				dbResult, err = globals.FolderDB.GetOneByID(id)
 				if err != nil {
 					return "", "", nil, err
 				}
@@ -298,7 +449,7 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 
 	case "folder":
 		if key == "name" {
-			dbResult, err := folderDB.GetRootByName(value)
+			dbResult, err := globals.FolderDB.GetRootByName(value)
 			if err != nil {
 				return "", "", nil, err
 			}
@@ -306,7 +457,7 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 			groupName = dbResult.Meta.Title
 			folderIds = []string{dbResult.Id}
 		} else {
-			dbResult, err := folderDB.GetOneByID(value)
+			dbResult, err := globals.FolderDB.GetOneByID(value)
 			if err != nil {
 				return "", "", nil, err
 			}
@@ -318,7 +469,7 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 			} else {
 				folderIds = append(dbResult.Ancestors, dbResult.Id)
 				id := dbResult.Ancestors[0]
-				dbResult, err = folderDB.GetOneByID(id)
+				dbResult, err = globals.FolderDB.GetOneByID(id)
 				if err != nil {
 					return "", "", nil, err
 				}
@@ -328,7 +479,7 @@ func grabGroupId(q map[string]string, collection string) (string, string, []stri
 		}
 
 	case "bucket":
-		dbResult, err := folderDB.GetOneByID(value)
+		dbResult, err := globals.FolderDB.GetOneByID(value)
 		if err != nil {
 			return "", "", nil, err
 		}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/cache"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// RouteClass groups routes that share the same rate and concurrency limits.
+type RouteClass string
+
+const (
+	ClassMetadata   RouteClass = "metadata"
+	ClassUpload     RouteClass = "upload"
+	ClassDownload   RouteClass = "download"
+	ClassCopernicus RouteClass = "copernicus"
+)
+
+// rateLimit is a token bucket configuration: rate tokens per second, up to burst tokens.
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+// classLimits are the limits of a route class. The caller (OIDC subject or API key) and
+// its group are limited separately; a zero value means no limit.
+type classLimits struct {
+	caller           rateLimit
+	group            rateLimit
+	callerConcurrent int
+	groupConcurrent  int
+}
+
+// defaultLimits are overridden by RATE_LIMIT_<CLASS>_<USER|GROUP>="rate:burst"
+// and CONCURRENCY_LIMIT_<CLASS>_<USER|GROUP>="n" environment variables.
+var defaultLimits = map[RouteClass]classLimits{
+	ClassMetadata:   {caller: rateLimit{20, 40}, group: rateLimit{100, 200}},
+	ClassUpload:     {caller: rateLimit{10, 20}, group: rateLimit{40, 80}, callerConcurrent: 4, groupConcurrent: 16},
+	ClassDownload:   {caller: rateLimit{20, 40}, group: rateLimit{80, 160}, callerConcurrent: 8, groupConcurrent: 32},
+	ClassCopernicus: {caller: rateLimit{1, 5}, group: rateLimit{5, 10}},
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter holds the token buckets and in-flight counters of all callers and groups.
+type limiter struct {
+	mu       sync.Mutex
+	limits   map[RouteClass]classLimits
+	buckets  *cache.Cache[string, *tokenBucket]
+	inFlight map[string]int
+}
+
+var limits = newLimiter()
+
+func newLimiter() *limiter {
+	l := &limiter{
+		limits:   map[RouteClass]classLimits{},
+		buckets:  cache.New[string, *tokenBucket](envInt("RATE_LIMIT_CACHE_SIZE", 100000), 10*time.Minute),
+		inFlight: map[string]int{},
+	}
+	for class, def := range defaultLimits {
+		name := strings.ToUpper(string(class))
+		l.limits[class] = classLimits{
+			caller:           envRateLimit("RATE_LIMIT_"+name+"_USER", def.caller),
+			group:            envRateLimit("RATE_LIMIT_"+name+"_GROUP", def.group),
+			callerConcurrent: envInt("CONCURRENCY_LIMIT_"+name+"_USER", def.callerConcurrent),
+			groupConcurrent:  envInt("CONCURRENCY_LIMIT_"+name+"_GROUP", def.groupConcurrent),
+		}
+	}
+	return l
+}
+
+// Limit applies the rate and concurrency limits of class to h. It must run inside
+// AuthMiddleware or NaiveAuthMiddleware, since callers are identified by their principal:
+// API keys by key ID, users by OIDC subject, and both by the resolved group, if any.
+func Limit(class RouteClass, h http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := utils.GetPrincipalFromContext(r.Context())
+
+		caller := "sub:" + principal.Subject
+		if principal.APIKeyID != "" {
+			caller = "key:" + principal.APIKeyID
+		}
+		group := ""
+		if principal.GroupID != "" {
+			group = "group:" + principal.GroupID
+		}
+
+		if wait, ok := limits.allow(class, caller, group); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(wait))
+			utils.RespondWithError(w, http.StatusTooManyRequests, "Too many requests.", fmt.Sprintf("Rate limit of %s requests exceeded, retry in %d seconds.", class, wait), "MID0013")
+			return
+		}
+
+		release, ok := limits.acquire(class, caller, group)
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			utils.RespondWithError(w, http.StatusTooManyRequests, "Too many requests.", fmt.Sprintf("Too many concurrent %s requests.", class), "MID0014")
+			return
+		}
+		defer release()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the caller's and the group's buckets. If either is empty, no token
+// is taken and the number of seconds until one is available is returned.
+func (l *limiter) allow(class RouteClass, caller string, group string) (int, bool) {
+	classLimit := l.limits[class]
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	type check struct {
+		bucket *tokenBucket
+		limit  rateLimit
+	}
+	var checks []check
+	if classLimit.caller.rate > 0 {
+		checks = append(checks, check{l.bucket(string(class)+"|"+caller, classLimit.caller, now), classLimit.caller})
+	}
+	if group != "" && classLimit.group.rate > 0 {
+		checks = append(checks, check{l.bucket(string(class)+"|"+group, classLimit.group, now), classLimit.group})
+	}
+
+	wait := 0
+	for _, c := range checks {
+		if c.bucket.tokens < 1 {
+			seconds := int(math.Ceil((1 - c.bucket.tokens) / c.limit.rate))
+			if seconds > wait {
+				wait = seconds
+			}
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, c := range checks {
+		c.bucket.tokens--
+	}
+	return 0, true
+}
+
+// bucket returns the refilled token bucket of key, creating a full one if needed. Callers hold l.mu.
+func (l *limiter) bucket(key string, limit rateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets.Get(key)
+	if !ok {
+		b = &tokenBucket{tokens: limit.burst, last: now}
+		l.buckets.Set(key, b)
+		return b
+	}
+	b.tokens = math.Min(limit.burst, b.tokens+now.Sub(b.last).Seconds()*limit.rate)
+	b.last = now
+	l.buckets.Set(key, b) // Keep active buckets from expiring
+	return b
+}
+
+// acquire reserves a concurrency slot for the caller and the group, and returns the function releasing it.
+func (l *limiter) acquire(class RouteClass, caller string, group string) (func(), bool) {
+	classLimit := l.limits[class]
+
+	caps := map[string]int{}
+	if classLimit.callerConcurrent > 0 {
+		caps[string(class)+"|"+caller] = classLimit.callerConcurrent
+	}
+	if group != "" && classLimit.groupConcurrent > 0 {
+		caps[string(class)+"|"+group] = classLimit.groupConcurrent
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, max := range caps {
+		if l.inFlight[key] >= max {
+			return nil, false
+		}
+	}
+	for key := range caps {
+		l.inFlight[key]++
+	}
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for key := range caps {
+			if l.inFlight[key]--; l.inFlight[key] <= 0 {
+				delete(l.inFlight, key)
+			}
+		}
+	}, true
+}
+
+// envRateLimit parses a "rate:burst" environment variable. A rate of 0 disables the limit.
+func envRateLimit(name string, def rateLimit) rateLimit {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parts := strings.SplitN(value, ":", 2)
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		log.Println("invalid " + name + ", using default: " + err.Error())
+		return def
+	}
+	burst := math.Max(rate, 1)
+	if len(parts) == 2 {
+		if burst, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			log.Println("invalid " + name + ", using default: " + err.Error())
+			return def
+		}
+	}
+	return rateLimit{rate: rate, burst: math.Max(burst, 1)}
+}
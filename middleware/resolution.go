@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/cache"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+)
+
+// groupResolution is the bucket and folder chain a resource belongs to.
+type groupResolution struct {
+	groupID   string
+	groupName string
+	folderIds []string
+}
+
+// resolutions caches grabGroupId results, keyed by collection and lookup pair.
+// Entries live for a few seconds, so per-part uploads and downloads don't hit Mongo every time.
+var resolutions = cache.New[string, groupResolution](
+	envInt("RESOLUTION_CACHE_SIZE", 10000),
+	envDuration("RESOLUTION_CACHE_TTL", 30*time.Second),
+)
+
+// apiKeys caches active API keys by the hash of the key.
+var apiKeys = cache.New[string, models.APIKey](
+	envInt("APIKEY_CACHE_SIZE", 1000),
+	envDuration("APIKEY_CACHE_TTL", 30*time.Second),
+)
+
+// resolveGroup is grabGroupId behind the resolution cache.
+func resolveGroup(q map[string]string, collection string) (string, string, []string, error) {
+	for key, value := range q {
+		q = map[string]string{key: value} // Only process the first pair
+		cacheKey := collection + "|" + key + "=" + value
+		if res, ok := resolutions.Get(cacheKey); ok {
+			return res.groupID, res.groupName, res.folderIds, nil
+		}
+
+		groupID, groupName, folderIds, err := grabGroupId(q, collection)
+		if err == nil && groupID != "" {
+			resolutions.Set(cacheKey, groupResolution{groupID: groupID, groupName: groupName, folderIds: folderIds})
+		}
+		return groupID, groupName, folderIds, err
+	}
+	return grabGroupId(q, collection)
+}
+
+// InvalidateResolution drops the cached group resolutions of the given files, folders or buckets,
+// as well as those of everything inside them. Call it after a move, delete or rename.
+func InvalidateResolution(ids ...string) {
+	resolutions.DeleteFunc(func(key string, res groupResolution) bool {
+		for _, id := range ids {
+			if strings.HasSuffix(key, "="+id) || res.groupID == id || utils.ItemInArray(res.folderIds, id) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// InvalidateAPIKey drops a cached API key, so that its revocation applies at once on this
+// replica. The cache is kept per process, so other replicas keep accepting the key until their
+// entry expires (APIKEY_CACHE_TTL).
+func InvalidateAPIKey(keyID string) {
+	apiKeys.DeleteFunc(func(hash string, apiKey models.APIKey) bool {
+		return apiKey.Id == keyID
+	})
+}
+
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ReadScopes returns the folders the caller can read everything under: the buckets of the
+// groups of a user and the folders shared with them, or the bucket of an API key. It follows
+// the same rules as AuthMiddleware, for handlers behind NaiveAuthMiddleware that look across
+// buckets.
+func ReadScopes(r *http.Request) ([]string, error) {
+	claims, err := utils.GetClaimsFromContext(r.Context().Value("claims"))
+	if err != nil {
+		return nil, err
+	}
+
+	if caller, _ := utils.GetPrincipalFromContext(r.Context()); caller.APIKeyID != "" {
+		apiKey, err := globals.APIKeyDB.GetOneByID(caller.APIKeyID)
+		if err != nil {
+			return nil, err
+		}
+		if resolveAPIKeyRole(apiKey, apiKey.GroupID) == "" {
+			return []string{}, nil
+		}
+		return []string{apiKey.GroupID}, nil
+	}
+
+	scopes := []string{}
+	if len(claims.Groups) > 0 {
+		cursor, err := globals.FolderDB.GetCursorRootsByNames(claims.Groups)
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(context.Background())
+
+		for cursor.Next(context.Background()) {
+			var result bson.M
+			var folder models.Folder
+			if err = cursor.Decode(&result); err != nil {
+				return nil, err
+			}
+			bsonBytes, _ := bson.Marshal(result)
+			bson.Unmarshal(bsonBytes, &folder)
+			scopes = append(scopes, folder.Id)
+		}
+	}
+
+	// Shared folders, whatever the role
+	for _, shared := range [][]string{claims.EditorIn, claims.ViewerIn} {
+		for _, folderID := range shared {
+			if !utils.ItemInArray(scopes, folderID) {
+				scopes = append(scopes, folderID)
+			}
+		}
+	}
+	return scopes, nil
+}
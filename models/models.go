@@ -48,14 +48,18 @@ type Updated struct {
 
 // File contains information about a file.
 type File struct {
-	Id            string   `json:"_id" bson:"_id"`                       // File's id
-	Meta          Meta     `json:"meta" bson:"meta"`                     // File's Metadata
-	FolderID      string   `json:"folder" bson:"folder"`                 // Parent folder of the file
-	Ancestors     []string `json:"ancestors" bson:"ancestors"`           // All ancestor folders
-	OriginalTitle string   `json:"original_title" bson:"original_title"` // The file's title before uploading
-	FileType      string   `json:"file_type" bson:"file_type"`           // The file's extention
-	Size          int64    `json:"size" bson:"size"`
-	Total         int      `json:"total" bson:"total"`
+	Id            string                `json:"_id" bson:"_id"`                       // File's id
+	Meta          Meta                  `json:"meta" bson:"meta"`                     // File's Metadata
+	FolderID      string                `json:"folder" bson:"folder"`                 // Parent folder of the file
+	Ancestors     []string              `json:"ancestors" bson:"ancestors"`           // All ancestor folders
+	OriginalTitle string                `json:"original_title" bson:"original_title"` // The file's title before uploading
+	FileType      string                `json:"file_type" bson:"file_type"`           // The file's extention
+	Size          int64                 `json:"size" bson:"size"`
+	Total         int                   `json:"total" bson:"total"`
+	Provenance    *CopernicusProvenance `json:"provenance,omitempty" bson:"provenance,omitempty"` // Origin of a Copernicus dataset, kept by copies
+	Path          string                `json:"path,omitempty" bson:"path,omitempty"`             // Path of the file, starting with its bucket
+	TitleKey      string                `json:"-" bson:"title_key,omitempty"`                     // Normalized title, unique in the folder
+	Breadcrumbs   []Breadcrumb          `json:"breadcrumbs,omitempty" bson:"-"`                   // Folders from the bucket down to the file's folder
 }
 
 //	CopernicusDetails CopernicusDetails `json:"copernicus_details,omitempty" bson:"copernicus_details"` // Details related to Copernicus datasets
@@ -77,14 +81,17 @@ type UpdateFileBody struct {
 
 // Folder contains information about a file.
 type Folder struct {
-	Id        string   `json:"_id" bson:"_id"`             // Folder's id
-	Meta      Meta     `json:"meta" bson:"meta"`           // Folder's Metadata
-	Parent    string   `json:"parent" bson:"parent"`       // Parent's folder id
-	Ancestors []string `json:"ancestors" bson:"ancestors"` // Array of ancestors' ids
-	Files     []string `json:"files" bson:"files"`         // Array of files' ids included
-	Folders   []string `json:"folders" bson:"folders"`     // Array of folders' ids included
-	Level     int      `json:"level" bson:"level"`         // Level of the folder (root is level 0 etc..)
-	Size      int64    `json:"size" bson:"size"`           // Size of a folder (cumulative size of folder's items)
+	Id          string       `json:"_id" bson:"_id"`                       // Folder's id
+	Meta        Meta         `json:"meta" bson:"meta"`                     // Folder's Metadata
+	Parent      string       `json:"parent" bson:"parent"`                 // Parent's folder id
+	Ancestors   []string     `json:"ancestors" bson:"ancestors"`           // Array of ancestors' ids
+	Files       []string     `json:"files" bson:"files"`                   // Array of files' ids included
+	Folders     []string     `json:"folders" bson:"folders"`               // Array of folders' ids included
+	Level       int          `json:"level" bson:"level"`                   // Level of the folder (root is level 0 etc..)
+	Size        int64        `json:"size" bson:"size"`                     // Size of a folder (cumulative size of folder's items)
+	Path        string       `json:"path,omitempty" bson:"path,omitempty"` // Path of the folder, starting with its bucket
+	TitleKey    string       `json:"-" bson:"title_key,omitempty"`         // Normalized title, unique in the parent (buckets have none)
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs,omitempty" bson:"-"`       // Folders from the bucket down to the folder's parent
 }
 
 // PostFolderBody is the body of a postFolder request.
@@ -96,8 +103,125 @@ type PostFolderBody struct {
 
 // FolderList is a list of items in folder.
 type FolderList struct {
-	Files   []File   `json:"files"`   // Keys are file ids and values are the files' metadata
-	Folders []Folder `json:"folders"` // Keys are folder ids and values are the folders' metadata
+	Files        []File   `json:"files"`                 // Keys are file ids and values are the files' metadata
+	Folders      []Folder `json:"folders"`               // Keys are folder ids and values are the folders' metadata
+	TotalFiles   int64    `json:"total_files"`           // Number of files matching the filters, on all pages
+	TotalFolders int64    `json:"total_folders"`         // Number of folders matching the filters, on all pages
+	NextCursor   string   `json:"next_cursor,omitempty"` // Cursor of the next page; empty on the last page
+}
+
+// ListQuery selects and orders the items of a folder. Empty filters don't filter.
+type ListQuery struct {
+	Parent      string        // Folder the items are in
+	FileType    string        // File extension (files only)
+	Tag         string        // Tag the item has
+	Creator     string        // User that created the item
+	CreatedFrom *time.Time    // Created at or after
+	CreatedTo   *time.Time    // Created before
+	UpdatedFrom *time.Time    // Last updated at or after
+	UpdatedTo   *time.Time    // Last updated before
+	SortField   string        // Field to sort by (bson path), then by _id
+	Descending  bool          // Sort in descending order
+	After       *ListPosition // Only items after this position in the sort order
+	Limit       int64         // Most items to return; all if 0
+}
+
+// ListPosition is the position of an item in a sorted listing: its sort value and ID.
+type ListPosition struct {
+	Value interface{} `bson:"value"` // Value of the sort field
+	Id    string      `bson:"id"`    // ID of the item
+}
+
+// SearchQuery selects the files or folders of a search. Empty filters don't filter.
+type SearchQuery struct {
+	Scopes      []string   // Folders the caller may read; items in or under them match
+	Text        string     // Words to look for in the title, description and tags
+	Title       string     // Part of the title, regardless of case
+	Tags        []string   // Tags the item has, all of them
+	FileType    string     // File extension (files only)
+	Creator     string     // User that created the item
+	MinSize     *int64     // Size at least
+	MaxSize     *int64     // Size at most
+	CreatedFrom *time.Time // Created at or after
+	CreatedTo   *time.Time // Created before
+	UpdatedFrom *time.Time // Last updated at or after
+	UpdatedTo   *time.Time // Last updated before
+	SortField   string     // Field to sort by (bson path), then by _id; by relevance if empty
+	Descending  bool       // Sort in descending order
+	Limit       int64      // Most items to return
+}
+
+// SearchResults is a page of search results.
+type SearchResults struct {
+	Results    []SearchResult `json:"results"`               // Matching files and folders, in the sort order
+	Total      int64          `json:"total"`                 // Number of items matching the search, on all pages
+	NextOffset int64          `json:"next_offset,omitempty"` // Offset of the next page; empty on the last page
+}
+
+// SearchResult is a file or folder found by a search.
+type SearchResult struct {
+	Kind        string       `json:"kind"`                 // "file" or "folder"
+	Id          string       `json:"_id"`                  // Item's id
+	Meta        Meta         `json:"meta"`                 // Item's Metadata
+	Parent      string       `json:"parent"`               // Folder the item is in
+	FileType    string       `json:"file_type,omitempty"`  // File's extention
+	Size        int64        `json:"size"`                 // Size of the item
+	Score       float64      `json:"score,omitempty"`      // Relevance of the item to the searched words
+	Highlights  []string     `json:"highlights,omitempty"` // Passages of the file's content with the searched words in <em>
+	Path        string       `json:"path"`                 // Path of the item, starting with its bucket
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs"`          // Folders from the bucket down to the item's parent
+}
+
+// TreeQuery selects the folders and files under a folder, down to a depth.
+type TreeQuery struct {
+	Root   string   // Folder the tree starts at
+	Level  int      // Level of the folder the tree starts at
+	Depth  int      // Levels below the folder to go down
+	Scopes []string // Folders the caller may read; items in or under them match
+}
+
+// TreeItem is a folder or file of a tree listing. Folders the caller can't read are only listed
+// on the way to the folders shared with them, as restricted and without their metadata.
+type TreeItem struct {
+	Kind       string      `json:"kind"`                 // "file" or "folder"
+	Id         string      `json:"_id"`                  // Item's id
+	Parent     string      `json:"parent"`               // Folder the item is in
+	Title      string      `json:"title"`                // Item's title
+	Meta       *Meta       `json:"meta,omitempty"`       // Item's Metadata; missing if restricted
+	FileType   string      `json:"file_type,omitempty"`  // File's extention
+	Size       int64       `json:"size"`                 // Size of the item
+	Path       string      `json:"path"`                 // Path of the item, starting with its bucket
+	Depth      int         `json:"depth"`                // Levels below the folder the tree starts at
+	Restricted bool        `json:"restricted,omitempty"` // Whether the caller can't read the folder
+	Folders    []*TreeItem `json:"folders,omitempty"`    // Folders in the folder (nested listings only)
+	Files      []*TreeItem `json:"files,omitempty"`      // Files in the folder (nested listings only)
+}
+
+// FileContent is the text extracted from a file, for content searches. It keeps the placement
+// of the file, so that searches are limited to what the caller can read.
+type FileContent struct {
+	Id          string    `json:"_id" bson:"_id"`                   // File's id
+	Folder      string    `json:"folder" bson:"folder"`             // Parent folder of the file
+	Ancestors   []string  `json:"ancestors" bson:"ancestors"`       // All ancestor folders of the file
+	FileType    string    `json:"file_type" bson:"file_type"`       // The file's extention
+	Text        string    `json:"text" bson:"text"`                 // Extracted text
+	Truncated   bool      `json:"truncated" bson:"truncated"`       // Whether the text was cut to the largest indexed length
+	DateIndexed time.Time `json:"date_indexed" bson:"date_indexed"` // Date and time of extraction
+}
+
+// ContentQuery selects the files of a content search, by relevance. Empty filters don't filter.
+type ContentQuery struct {
+	Scopes   []string // Folders the caller may read; files under them match
+	Text     string   // Words to look for in the content
+	FileType string   // File extension
+	Skip     int64    // Results to skip
+	Limit    int64    // Most results to return
+}
+
+// Breadcrumb is a folder on the path of an item.
+type Breadcrumb struct {
+	Id    string `json:"_id"`   // Folder's id
+	Title string `json:"title"` // Folder's title
 }
 
 // CopyMoveBody is the body of an copy or move request
@@ -117,15 +241,390 @@ type ErrorReport struct {
 
 // Input for the request to send to Copericus API
 type CopernicusInput struct {
-	DatasetName string                 `json:"dataset_name"` //Name of specific Copernicus API
-	Body        map[string]interface{} `json:"body"`         // Request body
+	DatasetName string                 `json:"dataset_name"`      //Name of specific Copernicus API
+	Service     string                 `json:"service,omitempty"` // Copernicus service: "cds" (default), "ads" or "ewds"
+	Body        map[string]interface{} `json:"body"`              // Request body
+	Folder      string                 `json:"folder,omitempty"`  // Destination folder ID (optional). The dataset is delivered there as a regular file
+	Split       *SplitOptions          `json:"split,omitempty"`   // Split the request into several Copernicus jobs (optional)
+}
+
+// SplitOptions tell how to split a large Copernicus request into chunks.
+type SplitOptions struct {
+	By    string `json:"by" bson:"by"`       // "year", "month" or "variable"
+	Merge bool   `json:"merge" bson:"merge"` // Concatenate NetCDF chunks along time instead of keeping them as separate files
+}
+
+// CopernicusChunk is a part of a split Copernicus request, with a record of its own.
+type CopernicusChunk struct {
+	Label    string `json:"label" bson:"label"`         // Year, month or variable of the chunk
+	RecordID string `json:"record_id" bson:"record_id"` // Copernicus record of the chunk
+	FileId   string `json:"file_id" bson:"file_id"`     // File of the chunk (delivered file if the chunks are delivered to a folder)
+}
+
+// FormField is a widget of a Copernicus dataset form. Children lists the widgets of an
+// exclusive group, of which a request may only use one.
+type FormField struct {
+	CDSModels.Form
+	Children []string `json:"children,omitempty"` // Names of the mutually exclusive widgets of an ExclusiveGroupWidget
+}
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`   // Name of the field
+	Message string `json:"message"` // What is wrong with the field
+}
+
+// ValidationReport is to report a request with invalid fields
+type ValidationReport struct {
+	ErrorReport
+	Errors []FieldError `json:"errors"` // One entry per invalid field
 }
 
 // Input for the request to send to Copericus API
 type CopernicusRecord struct {
-	Id            string                         `json:"_id" bson:"_id"`                   // Copernicus body fingerprint as id
-	FileId        string                         `json:"file_id" bson:"file_id"`           // Reference File ID
-	DatasetName   string                         `json:"dataset_name"`                     //Name of specific Copernicus API
-	RequestParams map[string]interface{}         `json:"parameters" bson:"parameters"`     // Request body
-	Details       CDSModels.PostProcessExecution `json:"details,omitempty" bson:"details"` // Details related to Copernicus datasets
+	Id            string                         `json:"_id" bson:"_id"`                                         // Copernicus body fingerprint as id
+	FileId        string                         `json:"file_id" bson:"file_id"`                                 // Reference File ID
+	DatasetName   string                         `json:"dataset_name"`                                           //Name of specific Copernicus API
+	Service       string                         `json:"service,omitempty" bson:"service,omitempty"`             // Copernicus service of the job (CDS if empty)
+	CredentialID  string                         `json:"credential_id,omitempty" bson:"credential_id,omitempty"` // Stored key the job was submitted with (the shared key if empty)
+	RequestParams map[string]interface{}         `json:"parameters" bson:"parameters"`                           // Request body
+	Details       CDSModels.PostProcessExecution `json:"details,omitempty" bson:"details"`                       // Details related to Copernicus datasets
+	Requesters    []string                       `json:"requesters,omitempty" bson:"requesters,omitempty"`       // Subjects of the users that requested the dataset
+	Subscribers   []string                       `json:"subscribers,omitempty" bson:"subscribers,omitempty"`     // Subjects of the users notified when the job ends
+	Deliveries    []CopernicusDelivery           `json:"deliveries,omitempty" bson:"deliveries,omitempty"`       // Copies of the dataset in users' folders
+	DateCreation  time.Time                      `json:"date_creation" bson:"date_creation"`                     // Date the Copernicus job was submitted
+	Log           []string                       `json:"log,omitempty" bson:"log,omitempty"`                     // Log of the Copernicus job
+	Error         string                         `json:"error,omitempty" bson:"error,omitempty"`                 // Why the job failed, was dismissed or could not be stored
+	Split         *SplitOptions                  `json:"split,omitempty" bson:"split,omitempty"`                 // How a split request was split; its chunks have records of their own
+	Children      []CopernicusChunk              `json:"children,omitempty" bson:"children,omitempty"`           // Chunks of a split request, in order
+}
+
+// CopernicusProvenance is where a Copernicus dataset comes from: what was requested, from which
+// service and under which licences. It is recorded on the file when the dataset is stored.
+type CopernicusProvenance struct {
+	Service        string                 `json:"service" bson:"service"`                                 // Copernicus service ("cds", "ads" or "ewds")
+	ServiceURL     string                 `json:"service_url" bson:"service_url"`                         // API of the service
+	DatasetName    string                 `json:"dataset_name" bson:"dataset_name"`                       // Name of the dataset (collection)
+	DatasetTitle   string                 `json:"dataset_title,omitempty" bson:"dataset_title"`           // Title of the dataset
+	DOI            string                 `json:"doi,omitempty" bson:"doi,omitempty"`                     // DOI of the dataset
+	Licences       []CopernicusLicence    `json:"licences,omitempty" bson:"licences,omitempty"`           // Licences of the dataset
+	Parameters     map[string]interface{} `json:"parameters" bson:"parameters"`                           // Request parameters
+	JobID          string                 `json:"job_id,omitempty" bson:"job_id,omitempty"`               // Copernicus job ID
+	ChunkJobIDs    []string               `json:"chunk_job_ids,omitempty" bson:"chunk_job_ids,omitempty"` // Copernicus job IDs of the chunks of a split request
+	RecordID       string                 `json:"record_id" bson:"record_id"`                             // Fingerprint of the request
+	DateSubmitted  time.Time              `json:"date_submitted" bson:"date_submitted"`                   // Date the job was submitted
+	DateDownloaded time.Time              `json:"date_downloaded" bson:"date_downloaded"`                 // Date the dataset was downloaded
+}
+
+// CopernicusLicence is a licence of a Copernicus dataset.
+type CopernicusLicence struct {
+	Id    string `json:"id" bson:"id"`                       // Licence identifier
+	Title string `json:"title,omitempty" bson:"title"`       // Title of the licence
+	URL   string `json:"url,omitempty" bson:"url,omitempty"` // Text of the licence
+}
+
+// ProvenanceExport is the provenance of a file, with a citation of its source.
+type ProvenanceExport struct {
+	FileId     string               `json:"file_id"`    // File ID
+	Title      string               `json:"title"`      // File's title
+	Size       int64                `json:"size"`       // File's size
+	Provenance CopernicusProvenance `json:"provenance"` // Origin of the file
+	Citation   string               `json:"citation"`   // Suggested citation of the source
+}
+
+// CopernicusJobStatus is the full status of a Copernicus job.
+type CopernicusJobStatus struct {
+	Record CopernicusRecord `json:"record"`         // Record of the request, with the job details and log
+	Task   *CopernicusTask  `json:"task,omitempty"` // Queue entry of the job, if any
+}
+
+// CopernicusDelivery is a copy of a Copernicus dataset in a user-chosen folder.
+type CopernicusDelivery struct {
+	FileId    string `json:"file_id" bson:"file_id"`     // Delivered File ID
+	FolderID  string `json:"folder" bson:"folder"`       // Destination folder ID
+	Subject   string `json:"subject" bson:"subject"`     // User that requested the delivery
+	Delivered bool   `json:"delivered" bson:"delivered"` // Whether the dataset has been copied into the file
+}
+
+// Copernicus task states
+const (
+	TaskQueued = "queued" // Waiting for the Copernicus job or for its result to be stored
+	TaskDone   = "done"   // Result stored in the reference file
+	TaskFailed = "failed" // Job failed, was dismissed or could not be stored
+)
+
+// CopernicusTask is a queue entry that tracks a Copernicus job until its result is stored.
+// Workers claim a task by taking its lease and extend the lease with heartbeats while working on it.
+type CopernicusTask struct {
+	Id           string    `json:"_id" bson:"_id"`                                   // Copernicus record ID
+	FileId       string    `json:"file_id" bson:"file_id"`                           // Reference File ID
+	JobID        string    `json:"job_id" bson:"job_id"`                             // Copernicus job ID
+	Subject      string    `json:"subject" bson:"subject"`                           // User that requested the dataset
+	State        string    `json:"state" bson:"state"`                               // queued, done or failed
+	Attempts     int       `json:"attempts" bson:"attempts"`                         // Consecutive failed attempts
+	LastError    string    `json:"last_error,omitempty" bson:"last_error,omitempty"` // Error of the last failed attempt
+	NextPoll     time.Time `json:"next_poll" bson:"next_poll"`                       // Task can't be claimed before this time
+	LeaseOwner   string    `json:"lease_owner,omitempty" bson:"lease_owner"`         // Worker holding the lease
+	LeaseUntil   time.Time `json:"lease_until" bson:"lease_until"`                   // Lease expiry, extended by heartbeats
+	Heartbeat    time.Time `json:"heartbeat" bson:"heartbeat"`                       // Last heartbeat of the lease owner
+	DateCreation time.Time `json:"date_creation" bson:"date_creation"`               // Date of enqueueing
+	DateUpdate   time.Time `json:"date_update" bson:"date_update"`                   // Date of last state change
+}
+
+// CopernicusSchedule is a recurring Copernicus request. Each run resolves the relative dates
+// of the template and delivers the dataset to the folder as a new dated file.
+type CopernicusSchedule struct {
+	Id           string                 `json:"_id" bson:"_id"`                                       // Schedule's id
+	Name         string                 `json:"name" bson:"name"`                                     // Name of the schedule, used in the titles of the delivered files
+	DatasetName  string                 `json:"dataset_name" bson:"dataset_name"`                     // Name of specific Copernicus API
+	Service      string                 `json:"service,omitempty" bson:"service,omitempty"`           // Copernicus service (CDS if empty)
+	Template     map[string]interface{} `json:"template" bson:"template"`                             // Request body, with relative dates such as "{{yesterday}}"
+	Cron         string                 `json:"cron" bson:"cron"`                                     // Cron expression (UTC)
+	Folder       string                 `json:"folder" bson:"folder"`                                 // Destination folder ID
+	Subject      string                 `json:"subject" bson:"subject"`                               // User that created the schedule, on whose behalf it runs
+	Access       ScheduleAccess         `json:"-" bson:"access"`                                      // Access of the user, checked before each run
+	Paused       bool                   `json:"paused" bson:"paused"`                                 // Paused schedules don't run
+	PauseReason  string                 `json:"pause_reason,omitempty" bson:"pause_reason,omitempty"` // Why the schedule paused itself
+	Failures     int                    `json:"failures" bson:"failures"`                             // Consecutive failed runs
+	NextRun      time.Time              `json:"next_run" bson:"next_run"`                             // Time of the next run
+	LastRun      time.Time              `json:"last_run" bson:"last_run"`                             // Time of the last run
+	LeaseOwner   string                 `json:"-" bson:"lease_owner"`                                 // Replica running the schedule
+	LeaseUntil   time.Time              `json:"-" bson:"lease_until"`                                 // Lease expiry
+	DateCreation time.Time              `json:"date_creation" bson:"date_creation"`                   // Date and time of creation
+}
+
+// ScheduleAccess is the access of a schedule's owner, as of their last request. Runs have no
+// token of their own, so their role on the destination folder is resolved from it.
+type ScheduleAccess struct {
+	Groups     []string  `bson:"groups"`               // Groups of the user
+	EditorIn   []string  `bson:"editor_in"`            // Folders shared with the user as editor
+	ViewerIn   []string  `bson:"viewer_in"`            // Folders shared with the user as viewer
+	APIKeyID   string    `bson:"api_key_id,omitempty"` // API key of a machine client
+	DateUpdate time.Time `bson:"date_update"`          // Date of the request the access was taken from
+}
+
+// Schedule run states
+const (
+	RunSubmitted = "submitted" // Request submitted, dataset not available yet
+	RunCompleted = "completed" // Dataset delivered
+	RunFailed    = "failed"    // Request could not be submitted, or its job failed
+)
+
+// CopernicusScheduleRun is a run of a schedule.
+type CopernicusScheduleRun struct {
+	Id         string                 `json:"_id" bson:"_id"`                         // Run's id
+	ScheduleID string                 `json:"schedule_id" bson:"schedule_id"`         // Schedule's id
+	Scheduled  time.Time              `json:"scheduled" bson:"scheduled"`             // Time the run was due, which relative dates refer to
+	Started    time.Time              `json:"started" bson:"started"`                 // Time the run started
+	Parameters map[string]interface{} `json:"parameters" bson:"parameters"`           // Request body with the dates resolved
+	FileId     string                 `json:"file_id,omitempty" bson:"file_id"`       // Delivered File ID
+	State      string                 `json:"state" bson:"state"`                     // submitted, completed or failed
+	Error      string                 `json:"error,omitempty" bson:"error,omitempty"` // Why the run failed
+	DateUpdate time.Time              `json:"date_update" bson:"date_update"`         // Date of last state change
+}
+
+// PostScheduleBody is the body of a postSchedule request.
+type PostScheduleBody struct {
+	Name        string                 `json:"name"`              // Name of the schedule
+	DatasetName string                 `json:"dataset_name"`      // Name of specific Copernicus API
+	Service     string                 `json:"service,omitempty"` // Copernicus service: "cds" (default), "ads" or "ewds"
+	Body        map[string]interface{} `json:"body"`              // Request body, with relative dates such as "{{yesterday}}"
+	Cron        string                 `json:"cron"`              // Cron expression (UTC)
+	Folder      string                 `json:"folder"`            // Destination folder ID
+}
+
+// Role is the access level a principal holds on the resolved group
+type Role string
+
+const (
+	RoleMember Role = "member" // Member of the group that owns the resource
+	RoleEditor Role = "editor" // Editor of a shared folder
+	RoleViewer Role = "viewer" // Viewer of a shared folder
+)
+
+// Capability is an action a handler requires the principal to be allowed to perform
+type Capability string
+
+const (
+	CapRead         Capability = "read"          // Read metadata and download contents
+	CapWrite        Capability = "write"         // Create, update, copy, move and delete items
+	CapDeleteBucket Capability = "delete-bucket" // Delete a whole bucket
+)
+
+// ConflictPolicy is what to do when the title of an item is taken in its folder
+type ConflictPolicy string
+
+const (
+	ConflictFail      ConflictPolicy = "fail"      // Leave things as they are and fail
+	ConflictRename    ConflictPolicy = "rename"    // Take the first free title of "name (1)", "name (2)" and on
+	ConflictOverwrite ConflictPolicy = "overwrite" // Delete the item that holds the title
+	ConflictSkip      ConflictPolicy = "skip"      // Leave things as they are and return the item that holds the title
+)
+
+// Principal is the authenticated caller as resolved by the middleware
+type Principal struct {
+	Subject      string       `json:"subject"`              // OIDC subject of the caller
+	Role         Role         `json:"role"`                 // Role on the resolved group (empty if no group was resolved)
+	GroupID      string       `json:"group_id"`             // Resolved group (bucket) ID
+	GroupName    string       `json:"group_name"`           // Resolved group (bucket) name
+	Capabilities []Capability `json:"capabilities"`         // Capabilities granted to the caller on the resolved group
+	APIKeyID     string       `json:"api_key_id,omitempty"` // ID of the API key used, if the caller is a machine client
+}
+
+// APIKey is a credential of a machine client (service account), scoped to a bucket
+type APIKey struct {
+	Id           string       `json:"_id" bson:"_id"`                     // API key's id
+	Name         string       `json:"name" bson:"name"`                   // Name of the service account using the key
+	Prefix       string       `json:"prefix" bson:"prefix"`               // First characters of the key, to tell keys apart
+	Hash         string       `json:"-" bson:"hash"`                      // SHA-256 hash of the key (the key itself is never stored)
+	GroupID      string       `json:"group_id" bson:"group_id"`           // Bucket (group root folder) the key is scoped to
+	Permissions  []Capability `json:"permissions" bson:"permissions"`     // Granted permissions ("read" and/or "write")
+	Creator      string       `json:"creator" bson:"creator"`             // User's ID that created the key
+	DateCreation time.Time    `json:"date_creation" bson:"date_creation"` // Date and time of creation
+	LastUsed     time.Time    `json:"last_used" bson:"last_used"`         // Date and time the key was last used
+	Revoked      bool         `json:"revoked" bson:"revoked"`             // Whether the key has been revoked
+	DateRevoked  time.Time    `json:"date_revoked" bson:"date_revoked"`   // Date and time of revocation
+}
+
+// PostAPIKeyBody is the body of a postAPIKey request.
+type PostAPIKeyBody struct {
+	Name        string       `json:"name"`        // Name of the service account
+	GroupID     string       `json:"group_id"`    // Bucket the key is scoped to
+	Permissions []Capability `json:"permissions"` // Requested permissions ("read" and/or "write")
+}
+
+// CopernicusCredential is a Copernicus API key of a user or of a group (bucket), so that their
+// jobs count against their own quota and licences. The key is stored encrypted.
+type CopernicusCredential struct {
+	Id           string    `json:"_id" bson:"_id"`                               // Credential's id
+	Service      string    `json:"service" bson:"service"`                       // Copernicus service ("cds", "ads" or "ewds")
+	Subject      string    `json:"subject,omitempty" bson:"subject,omitempty"`   // User the key belongs to, for a user's key
+	GroupID      string    `json:"group_id,omitempty" bson:"group_id,omitempty"` // Bucket the key belongs to, for a group's key
+	Key          string    `json:"-" bson:"key"`                                 // Encrypted key (never returned)
+	Hint         string    `json:"hint" bson:"hint"`                             // Last characters of the key, to tell keys apart
+	Creator      string    `json:"creator" bson:"creator"`                       // User's ID that stored the key
+	DateCreation time.Time `json:"date_creation" bson:"date_creation"`           // Date and time the key was stored
+}
+
+// PutCopernicusCredentialBody is the body of a putCopernicusCredential request.
+type PutCopernicusCredentialBody struct {
+	Service string `json:"service"`            // Copernicus service ("cds", "ads" or "ewds")
+	Key     string `json:"key"`                // The API key of the service
+	GroupID string `json:"group_id,omitempty"` // Bucket to store the key for (the caller's own key if empty)
+}
+
+// Notification events
+const (
+	EventJobSuccessful = "successful" // Copernicus job successful and dataset stored
+	EventJobFailed     = "failed"     // Copernicus job failed, or its dataset could not be stored
+	EventJobDismissed  = "dismissed"  // Copernicus job dismissed
+)
+
+// NotificationPreferences are how a user wants to be notified. Channels that are not set are off.
+type NotificationPreferences struct {
+	Subject     string          `json:"subject" bson:"_id"`                         // OIDC subject of the user
+	Events      []string        `json:"events" bson:"events"`                       // Events to be notified of; all events if empty
+	Webhook     *WebhookChannel `json:"webhook,omitempty" bson:"webhook,omitempty"` // Outgoing webhook
+	Email       *EmailChannel   `json:"email,omitempty" bson:"email,omitempty"`     // Email over SMTP
+	DateUpdated time.Time       `json:"date_updated" bson:"date_updated"`           // Last change of the preferences
+}
+
+// WebhookChannel is an URL notifications are posted to, signed with a secret.
+type WebhookChannel struct {
+	URL    string `json:"url" bson:"url"`  // URL the notification is POSTed to
+	Secret string `json:"-" bson:"secret"` // HMAC-SHA256 key of the X-Signature-256 header; never returned
+}
+
+// EmailChannel is an address notifications are mailed to.
+type EmailChannel struct {
+	Address string `json:"address" bson:"address"` // Email address
+}
+
+// PutNotificationPreferencesBody is the body of a putNotificationPreferences request.
+type PutNotificationPreferencesBody struct {
+	Events  []string `json:"events,omitempty"` // "successful", "failed" and/or "dismissed"; all events if empty
+	Webhook *struct {
+		URL    string `json:"url"`    // http(s) URL the notification is POSTed to
+		Secret string `json:"secret"` // Key to sign notifications with (at least 16 characters)
+	} `json:"webhook,omitempty"` // Outgoing webhook (off if empty)
+	Email *struct {
+		Address string `json:"address"` // Email address (the address of the caller's token if empty)
+	} `json:"email,omitempty"` // Email over SMTP (off if empty)
+}
+
+// Notification is what is sent to a user when something happens, e.g. a Copernicus job ends.
+type Notification struct {
+	Id          string    `json:"id"`                     // Unique ID of the notification
+	Event       string    `json:"event"`                  // What happened: "successful", "failed" or "dismissed"
+	Subject     string    `json:"subject"`                // User notified
+	RecordID    string    `json:"record_id,omitempty"`    // Copernicus record of the job
+	JobID       string    `json:"job_id,omitempty"`       // Copernicus job ID
+	Service     string    `json:"service,omitempty"`      // Copernicus service
+	DatasetName string    `json:"dataset_name,omitempty"` // Name of the dataset
+	FileIds     []string  `json:"file_ids,omitempty"`     // Files of the user holding the dataset: the reference file and their deliveries
+	Error       string    `json:"error,omitempty"`        // Why the job failed or was dismissed
+	Date        time.Time `json:"date"`                   // When it happened
+}
+
+// CopernicusService is a Copernicus service requests can be sent to.
+type CopernicusService struct {
+	Name      string `json:"name"`       // Name used in requests ("cds", "ads" or "ewds")
+	URL       string `json:"url"`        // API of the service
+	SharedKey bool   `json:"shared_key"` // Whether a shared key is configured; without it, callers need a key of their own
+}
+
+// CatalogueEntry is a dataset of a Copernicus service as cached in the catalogue, with what it
+// can be searched by.
+type CatalogueEntry struct {
+	Id            string         `json:"_id" bson:"_id"`                                           // Service and collection ID, as "service:collection"
+	Service       string         `json:"service" bson:"service"`                                   // Copernicus service of the dataset
+	CollectionID  string         `json:"collection_id" bson:"collection_id"`                       // ID of the dataset in its service
+	Position      int            `json:"-" bson:"position"`                                        // Position of the dataset in the service's list
+	Title         string         `json:"title" bson:"title"`                                       // Title of the dataset
+	Description   string         `json:"description" bson:"description"`                           // Description of the dataset
+	Keywords      []string       `json:"keywords" bson:"keywords"`                                 // Keywords of the dataset
+	Variables     []string       `json:"variables" bson:"variables"`                               // Names and labels of the variables of the dataset's form
+	TemporalStart *time.Time     `json:"temporal_start,omitempty" bson:"temporal_start,omitempty"` // Start of the temporal coverage
+	TemporalEnd   *time.Time     `json:"temporal_end,omitempty" bson:"temporal_end,omitempty"`     // End of the temporal coverage, empty if ongoing
+	Extent        *SpatialExtent `json:"extent,omitempty" bson:"extent,omitempty"`                 // Spatial extent
+	Collection    string         `json:"-" bson:"collection"`                                      // The collection as returned by the service, in JSON
+	Form          string         `json:"-" bson:"form,omitempty"`                                  // The form of the dataset as returned by the service, in JSON
+	FormETag      string         `json:"-" bson:"form_etag,omitempty"`                             // ETag of Form
+	UpstreamETag  string         `json:"-" bson:"upstream_etag,omitempty"`                         // ETag the service sent with the form
+	FormError     string         `json:"form_error,omitempty" bson:"form_error,omitempty"`         // Why the form couldn't be refreshed last time
+	DateRefreshed time.Time      `json:"date_refreshed" bson:"date_refreshed"`                     // Last time the dataset was refreshed
+}
+
+// SpatialExtent is a bounding box in degrees.
+type SpatialExtent struct {
+	West  float64 `json:"west" bson:"west"`
+	South float64 `json:"south" bson:"south"`
+	East  float64 `json:"east" bson:"east"`
+	North float64 `json:"north" bson:"north"`
+}
+
+// CatalogueState is the outcome of the last refresh of the catalogue of a service.
+type CatalogueState struct {
+	Service       string    `json:"_id" bson:"_id"`                         // Copernicus service
+	ETag          string    `json:"etag" bson:"etag"`                       // ETag of the list of collections
+	Count         int       `json:"count" bson:"count"`                     // Number of datasets
+	DateRefreshed time.Time `json:"date_refreshed" bson:"date_refreshed"`   // Last successful refresh
+	DateAttempted time.Time `json:"date_attempted" bson:"date_attempted"`   // Last refresh, successful or not
+	Error         string    `json:"error,omitempty" bson:"error,omitempty"` // Why the last refresh failed
+}
+
+// CatalogueQuery is a search of the cached catalogue. Empty fields don't filter.
+type CatalogueQuery struct {
+	Service  string         // Copernicus service, all services if empty
+	Keyword  string         // Matched against the ID, title, description and keywords
+	Variable string         // Matched against the names and labels of the variables
+	Start    *time.Time     // Datasets covering time after Start
+	End      *time.Time     // Datasets covering time before End
+	Area     *SpatialExtent // Datasets whose extent intersects Area
+}
+
+// APIKeyCreated is returned once, on creation, and is the only response that carries the plain key.
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"` // The plain API key
 }
@@ -0,0 +1,187 @@
+// Package notify sends notifications to users over the channels of their preferences: an
+// outgoing webhook signed with HMAC-SHA256, and email over SMTP.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+	"github.com/isotiropoulos/storage-api/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrEmailDisabled is returned for email notifications while no SMTP server is configured.
+var ErrEmailDisabled = errors.New("email notifications are not configured (SMTP_HOST)")
+
+// SignatureHeader carries the signature of a webhook notification, as "sha256=<hex>".
+const SignatureHeader = "X-Signature-256"
+
+// webhookAttempts is how many times a webhook is tried before the notification is dropped.
+const webhookAttempts = 3
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Events lists the events users can be notified of.
+var Events = []string{models.EventJobSuccessful, models.EventJobFailed, models.EventJobDismissed}
+
+// EmailEnabled reports whether email notifications can be sent.
+func EmailEnabled() bool {
+	return globals.SMTP_HOST != ""
+}
+
+// Send notifies a user of an event in the background, over the channels of their preferences.
+// Users without preferences, or who left the event out, are not notified.
+func Send(notification models.Notification) {
+	preferences, err := globals.NotificationDB.GetOneBySubject(notification.Subject)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println("Could not get notification preferences of "+notification.Subject+": ", err.Error())
+		}
+		return
+	}
+	if len(preferences.Events) > 0 && !utils.ItemInArray(preferences.Events, notification.Event) {
+		return
+	}
+	go func() {
+		if err := Deliver(preferences, notification); err != nil {
+			log.Println("Could not deliver notification "+notification.Id+": ", err.Error())
+		}
+	}()
+}
+
+// Deliver sends a notification over every channel of the preferences, and returns the errors of
+// the channels that failed.
+func Deliver(preferences models.NotificationPreferences, notification models.Notification) error {
+	var errs []error
+	if preferences.Webhook != nil {
+		if err := postWebhook(*preferences.Webhook, notification); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if preferences.Email != nil {
+		if err := sendEmail(*preferences.Email, notification); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sign returns the signature of a webhook body: the hex HMAC-SHA256 of the body with the
+// webhook's secret, prefixed with "sha256=". Receivers compute it again to check the sender.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook POSTs a notification as JSON, retrying server errors and failed connections.
+func postWebhook(webhook models.WebhookChannel, notification models.Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = func() error {
+			req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", "BUILDSPACE-Storage-API")
+			req.Header.Set("X-Notification-Id", notification.Id)
+			req.Header.Set("X-Notification-Event", notification.Event)
+			req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+
+			resp, err := webhookClient.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				return fmt.Errorf("webhook answered %s", resp.Status)
+			}
+			return nil
+		}()
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+// sendEmail mails a notification in plain text.
+func sendEmail(email models.EmailChannel, notification models.Notification) error {
+	if !EmailEnabled() {
+		return ErrEmailDisabled
+	}
+
+	var auth smtp.Auth
+	if globals.SMTP_USER != "" {
+		auth = smtp.PlainAuth("", globals.SMTP_USER, globals.SMTP_PASSWORD, globals.SMTP_HOST)
+	}
+	subject, text := emailContent(notification)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", globals.SMTP_FROM)
+	fmt.Fprintf(&msg, "To: %s\r\n", email.Address)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", headerValue(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", notification.Date.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@storage-api>\r\n", notification.Id)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(globals.SMTP_HOST, globals.SMTP_PORT)
+	return smtp.SendMail(addr, auth, globals.SMTP_FROM, []string{email.Address}, msg.Bytes())
+}
+
+// emailContent is the subject and text of the email of a notification.
+func emailContent(n models.Notification) (string, string) {
+	var subject string
+	switch n.Event {
+	case models.EventJobSuccessful:
+		subject = "Copernicus dataset ready: " + n.DatasetName
+	case models.EventJobFailed:
+		subject = "Copernicus request failed: " + n.DatasetName
+	case models.EventJobDismissed:
+		subject = "Copernicus request dismissed: " + n.DatasetName
+	default:
+		subject = "Notification: " + n.Event
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n\n", subject)
+	if n.Service != "" {
+		fmt.Fprintf(&text, "Service: %s\n", n.Service)
+	}
+	if n.JobID != "" {
+		fmt.Fprintf(&text, "Job: %s\n", n.JobID)
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&text, "Reason: %s\n", n.Error)
+	}
+	if len(n.FileIds) > 0 {
+		fmt.Fprintf(&text, "Files: %s\n", strings.Join(n.FileIds, ", "))
+	}
+	fmt.Fprintf(&text, "\nThe request can be checked with GET /copernicus/dataset/{fileId}.\n")
+	return "[BUILDSPACE] " + subject, text.String()
+}
+
+// headerValue keeps user-supplied text such as dataset names from adding mail headers.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,214 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isotiropoulos/storage-api/globals"
+	"github.com/isotiropoulos/storage-api/models"
+)
+
+// mail is a message received by smtpServer.
+type mail struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer listens on a local port and answers just enough SMTP for smtp.SendMail, without
+// TLS or authentication. Each message it receives is sent to the returned channel.
+func smtpServer(t *testing.T) (string, <-chan mail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan mail, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, mails)
+		}
+	}()
+	return listener.Addr().String(), mails
+}
+
+func serveSMTP(conn net.Conn, mails chan<- mail) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var m mail
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			m.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			m.data = data.String()
+			mails <- m
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// setGlobal sets a global for the duration of a test.
+func setGlobal(t *testing.T, global *string, value string) {
+	previous := *global
+	*global = value
+	t.Cleanup(func() { *global = previous })
+}
+
+func TestSendEmail(t *testing.T) {
+	addr, mails := smtpServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	setGlobal(t, &globals.SMTP_HOST, host)
+	setGlobal(t, &globals.SMTP_PORT, port)
+	setGlobal(t, &globals.SMTP_USER, "")
+	setGlobal(t, &globals.SMTP_FROM, "noreply@example.com")
+
+	notification := models.Notification{
+		Id:          "n1",
+		Event:       models.EventJobFailed,
+		Subject:     "user",
+		JobID:       "job-1",
+		Service:     "cds",
+		DatasetName: "era5\r\nBcc: someone@example.com",
+		Error:       "out of quota",
+		Date:        time.Now(),
+	}
+	if err := sendEmail(models.EmailChannel{Address: "user@example.com"}, notification); err != nil {
+		t.Fatalf("sendEmail: %v", err)
+	}
+
+	var m mail
+	select {
+	case m = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	if m.from != "noreply@example.com" {
+		t.Errorf("sender is %q", m.from)
+	}
+	if len(m.to) != 1 || m.to[0] != "user@example.com" {
+		t.Errorf("recipients are %q", m.to)
+	}
+	for _, want := range []string{
+		"To: user@example.com\r\n",
+		"Subject: [BUILDSPACE] Copernicus request failed: era5  Bcc: someone@example.com\r\n",
+		"Message-ID: <n1@storage-api>\r\n",
+		"Job: job-1\r\n",
+		"Reason: out of quota\r\n",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("mail lacks %q:\n%s", want, m.data)
+		}
+	}
+	headers, _, _ := strings.Cut(m.data, "\r\n\r\n")
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf("dataset name added a header:\n%s", headers)
+	}
+}
+
+func TestSendEmailDisabled(t *testing.T) {
+	setGlobal(t, &globals.SMTP_HOST, "")
+	if err := sendEmail(models.EmailChannel{Address: "user@example.com"}, models.Notification{}); err != ErrEmailDisabled {
+		t.Fatalf("got %v, want ErrEmailDisabled", err)
+	}
+}
+
+func TestPostWebhookSignature(t *testing.T) {
+	const secret = "0123456789abcdef"
+	received := make(chan models.Notification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Check the signature as a receiver would
+		if !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(Sign(secret, body))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Notification-Id") != "n1" || r.Header.Get("X-Notification-Event") != models.EventJobSuccessful {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var notification models.Notification
+		if err = json.Unmarshal(body, &notification); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- notification
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notification := models.Notification{Id: "n1", Event: models.EventJobSuccessful, Subject: "user", Date: time.Now()}
+	if err := postWebhook(models.WebhookChannel{URL: server.URL, Secret: secret}, notification); err != nil {
+		t.Fatalf("postWebhook: %v", err)
+	}
+	select {
+	case n := <-received:
+		if n.Id != "n1" || n.Event != models.EventJobSuccessful || n.Subject != "user" {
+			t.Errorf("received %+v", n)
+		}
+	default:
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 of "The quick brown fox jumps over the lazy dog" with the key "key"
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got := Sign("key", []byte("The quick brown fox jumps over the lazy dog")); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if Sign("other", []byte("body")) == Sign("key", []byte("body")) {
+		t.Error("signatures of different secrets match")
+	}
+}
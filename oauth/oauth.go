@@ -2,19 +2,33 @@ package oauth
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	oidc "github.com/coreos/go-oidc"
 	"github.com/isotiropoulos/storage-api/models"
-	"golang.org/x/oauth2"
-	// "gopkg.in/square/go-jose.v2/jwt"
 )
 
-var Verifier *oidc.IDTokenVerifier
-var oauth2Config oauth2.Config
-var keyset oidc.KeySet
+// Authenticator verifies a raw JWT and returns its claims.
+type Authenticator interface {
+	Verify(ctx context.Context, token string) (models.OidcClaims, error)
+}
+
+// Verifier is the Authenticator selected by Init.
+var Verifier Authenticator
 
+// Init selects the Authenticator from the AUTH_PROVIDER environment variable:
+//
+//	oidc (default): remote OpenID Connect discovery, resolved lazily on first use
+//	jwks:           static JSON Web Key Set read from JWKS_FILE, no network needed
+//	hmac:           shared HMAC_SECRET, for development and integration tests
+//
+// Issuer and audience are checked unless OIDC_SKIP_ISSUER_CHECK or OIDC_SKIP_AUDIENCE_CHECK is "true".
+// Verified tokens are cached (TOKEN_CACHE_SIZE entries, at most TOKEN_CACHE_TTL each); a size of 0 disables the cache.
 func Init() {
 
 	log.Println("Starting oidc configuration")
@@ -22,50 +36,65 @@ func Init() {
 	if oidcProvider == "" {
 		oidcProvider = "https://keycloak-buildspace.euinno.eu/realms/buildspace"
 	}
-	clientID := os.Getenv("CLIENT_ID")
-	if clientID == "" {
-		clientID = "minioapi"
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		issuer = oidcProvider
 	}
-	clientSecret := os.Getenv("CLIENT_SECRET")
-	if clientSecret == "" {
-		clientSecret = "u7BxGumGaJ9Cq9XC8T10uBrNBy5A22qE"
+	audience := os.Getenv("OIDC_AUDIENCE")
+	if audience == "" {
+		audience = os.Getenv("CLIENT_ID")
 	}
-
-	ctx := context.Background()
-	provider, err := oidc.NewProvider(ctx, oidcProvider)
-
-	if err != nil {
-		panic(err)
+	if audience == "" {
+		audience = "minioapi"
 	}
 
-	oauth2Config = oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		Endpoint:     provider.Endpoint(),
-		Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "openid"},
+	config := &oidc.Config{
+		ClientID:          audience,
+		SkipClientIDCheck: os.Getenv("OIDC_SKIP_AUDIENCE_CHECK") == "true",
+		SkipIssuerCheck:   os.Getenv("OIDC_SKIP_ISSUER_CHECK") == "true",
 	}
-
-	oidcConfig := &oidc.Config{
-		ClientID:          clientID,
-		SkipClientIDCheck: true,
-		SkipIssuerCheck:   true,
+	if algs := os.Getenv("OIDC_SIGNING_ALGS"); algs != "" {
+		config.SupportedSigningAlgs = strings.Split(algs, ",")
 	}
-	keyset = oidc.NewRemoteKeySet(ctx, oidcProvider)
 
-	Verifier = provider.Verifier(oidcConfig)
-
-}
+	authProvider := os.Getenv("AUTH_PROVIDER")
+	if authProvider == "" {
+		authProvider = "oidc"
+	}
+	switch authProvider {
+	case "oidc":
+		Verifier = NewOIDCAuthenticator(oidcProvider, os.Getenv("OIDC_JWKS_URL"), issuer, config)
+	case "jwks":
+		keySet, err := LoadJWKSFile(os.Getenv("JWKS_FILE"))
+		if err != nil {
+			panic(err)
+		}
+		if len(config.SupportedSigningAlgs) == 0 {
+			config.SupportedSigningAlgs = keySet.Algorithms()
+		}
+		Verifier = NewStaticAuthenticator(issuer, keySet, config)
+	case "hmac":
+		secret := os.Getenv("HMAC_SECRET")
+		if secret == "" {
+			panic(errors.New("HMAC_SECRET is required when AUTH_PROVIDER is hmac"))
+		}
+		log.Println("WARNING: tokens are verified with a shared HMAC secret. Do not use in production.")
+		config.SupportedSigningAlgs = []string{HMACAlgorithm}
+		Verifier = NewStaticAuthenticator(issuer, NewHMACKeySet([]byte(secret)), config)
+	default:
+		panic(errors.New("unknown AUTH_PROVIDER " + authProvider))
+	}
+	log.Println("Token verification provider: ", authProvider)
 
-func GetClaims(token string) (claims models.OidcClaims, err error) {
-	resultCl := models.OidcClaims{}
-	tokenVer, err := Verifier.Verify(context.Background(), token)
+	cacheSize, err := strconv.Atoi(os.Getenv("TOKEN_CACHE_SIZE"))
 	if err != nil {
-		log.Println("failed to parse Claims: ", err.Error())
-		return resultCl, err
+		cacheSize = 10000
 	}
-	err = tokenVer.Claims(&resultCl)
+	cacheTTL, err := time.ParseDuration(os.Getenv("TOKEN_CACHE_TTL"))
 	if err != nil {
-		log.Println("failed to parse Claims: ", err.Error())
+		cacheTTL = 5 * time.Minute
+	}
+	if cacheSize > 0 && cacheTTL > 0 {
+		Verifier = NewCachedAuthenticator(Verifier, cacheSize, cacheTTL)
 	}
-	return resultCl, err
 }
@@ -0,0 +1,243 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/isotiropoulos/storage-api/cache"
+	"github.com/isotiropoulos/storage-api/models"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// HMACAlgorithm is the signing algorithm of the HMAC dev mode.
+const HMACAlgorithm = string(jose.HS256)
+
+// discoveryRetryInterval limits how often an unreachable OIDC provider is contacted again.
+const discoveryRetryInterval = 30 * time.Second
+
+// OIDCAuthenticator verifies tokens against a remote OpenID Connect provider.
+// Discovery happens on first use and is retried if the provider is unreachable,
+// so the API starts even when the provider is down.
+type OIDCAuthenticator struct {
+	discoveryURL string
+	jwksURL      string
+	issuer       string
+	config       *oidc.Config
+
+	mu          sync.Mutex
+	verifier    *oidc.IDTokenVerifier
+	lastAttempt time.Time
+}
+
+// NewOIDCAuthenticator creates an OIDCAuthenticator. When jwksURL is set, discovery is skipped
+// and the keys are fetched from jwksURL directly.
+func NewOIDCAuthenticator(discoveryURL, jwksURL, issuer string, config *oidc.Config) *OIDCAuthenticator {
+	return &OIDCAuthenticator{discoveryURL: discoveryURL, jwksURL: jwksURL, issuer: issuer, config: config}
+}
+
+// Verify implements Authenticator.
+func (a *OIDCAuthenticator) Verify(ctx context.Context, token string) (models.OidcClaims, error) {
+	verifier, err := a.getVerifier()
+	if err != nil {
+		return models.OidcClaims{}, err
+	}
+	return verifyClaims(ctx, verifier, token)
+}
+
+func (a *OIDCAuthenticator) getVerifier() (*oidc.IDTokenVerifier, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.verifier != nil {
+		return a.verifier, nil
+	}
+	if time.Since(a.lastAttempt) < discoveryRetryInterval {
+		return nil, errors.New("oidc provider unavailable, discovery will be retried")
+	}
+	a.lastAttempt = time.Now()
+
+	// The key set keeps this context for refreshing keys, so it must outlive the request
+	ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: 10 * time.Second})
+
+	config := *a.config
+	jwksURL := a.jwksURL
+	if jwksURL == "" {
+		provider, err := oidc.NewProvider(ctx, a.discoveryURL)
+		if err != nil {
+			return nil, fmt.Errorf("oidc discovery failed: %v", err)
+		}
+		var metadata struct {
+			JWKSURL    string   `json:"jwks_uri"`
+			Algorithms []string `json:"id_token_signing_alg_values_supported"`
+		}
+		if err := provider.Claims(&metadata); err != nil {
+			return nil, fmt.Errorf("oidc discovery failed: %v", err)
+		}
+		jwksURL = metadata.JWKSURL
+		if len(config.SupportedSigningAlgs) == 0 {
+			config.SupportedSigningAlgs = metadata.Algorithms
+		}
+	}
+
+	a.verifier = oidc.NewVerifier(a.issuer, oidc.NewRemoteKeySet(ctx, jwksURL), &config)
+	return a.verifier, nil
+}
+
+// StaticAuthenticator verifies tokens against keys known in advance, without any network access.
+type StaticAuthenticator struct {
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewStaticAuthenticator creates a StaticAuthenticator.
+func NewStaticAuthenticator(issuer string, keySet oidc.KeySet, config *oidc.Config) *StaticAuthenticator {
+	return &StaticAuthenticator{verifier: oidc.NewVerifier(issuer, keySet, config)}
+}
+
+// Verify implements Authenticator.
+func (a *StaticAuthenticator) Verify(ctx context.Context, token string) (models.OidcClaims, error) {
+	return verifyClaims(ctx, a.verifier, token)
+}
+
+func verifyClaims(ctx context.Context, verifier *oidc.IDTokenVerifier, token string) (models.OidcClaims, error) {
+	claims := models.OidcClaims{}
+	idToken, err := verifier.Verify(ctx, token)
+	if err != nil {
+		return claims, err
+	}
+	err = idToken.Claims(&claims)
+	return claims, err
+}
+
+// StaticKeySet is an in-memory oidc.KeySet.
+type StaticKeySet struct {
+	keys []jose.JSONWebKey
+}
+
+// LoadJWKSFile reads a JSON Web Key Set file. Private keys are reduced to their public part.
+func LoadJWKSFile(path string) (*StaticKeySet, error) {
+	if path == "" {
+		return nil, errors.New("JWKS_FILE is required when AUTH_PROVIDER is jwks")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks jose.JSONWebKeySet
+	if err = json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file: %v", err)
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, errors.New("JWKS file has no keys")
+	}
+
+	keySet := &StaticKeySet{}
+	for _, key := range jwks.Keys {
+		if _, symmetric := key.Key.([]byte); !symmetric && !key.IsPublic() {
+			key = key.Public()
+		}
+		keySet.keys = append(keySet.keys, key)
+	}
+	return keySet, nil
+}
+
+// NewHMACKeySet creates a key set holding a single HMAC secret.
+func NewHMACKeySet(secret []byte) *StaticKeySet {
+	return &StaticKeySet{keys: []jose.JSONWebKey{{Key: secret, Algorithm: HMACAlgorithm}}}
+}
+
+// Algorithms returns the signing algorithms declared by the keys, or RS256 if none is declared.
+func (s *StaticKeySet) Algorithms() []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, key := range s.keys {
+		if key.Algorithm != "" && !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algs = append(algs, key.Algorithm)
+		}
+	}
+	if len(algs) == 0 {
+		algs = []string{oidc.RS256}
+	}
+	return algs
+}
+
+// VerifySignature implements oidc.KeySet.
+func (s *StaticKeySet) VerifySignature(ctx context.Context, token string) ([]byte, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %v", err)
+	}
+	keyID := ""
+	if len(jws.Signatures) > 0 {
+		keyID = jws.Signatures[0].Header.KeyID
+	}
+	for _, key := range s.keys {
+		if keyID == "" || key.KeyID == "" || key.KeyID == keyID {
+			if payload, err := jws.Verify(key.Key); err == nil {
+				return payload, nil
+			}
+		}
+	}
+	return nil, errors.New("failed to verify token signature")
+}
+
+// MintHMACToken signs claims with an HMAC secret, for use with AUTH_PROVIDER=hmac in
+// development and integration tests. Tokens without an expiry expire after one hour.
+func MintHMACToken(secret []byte, claims models.OidcClaims) (string, error) {
+	if claims.Claims == nil {
+		claims.Claims = &jwt.Claims{}
+	}
+	if claims.Expiry == nil {
+		registered := *claims.Claims
+		registered.Expiry = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		claims.Claims = &registered
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: secret}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// CachedAuthenticator remembers verified tokens, keyed by their SHA-256 hash, until they
+// expire or the cache TTL elapses, so that repeated calls skip signature verification.
+type CachedAuthenticator struct {
+	next   Authenticator
+	claims *cache.Cache[string, models.OidcClaims]
+}
+
+// NewCachedAuthenticator wraps next with a cache of at most size verified tokens.
+func NewCachedAuthenticator(next Authenticator, size int, ttl time.Duration) *CachedAuthenticator {
+	return &CachedAuthenticator{next: next, claims: cache.New[string, models.OidcClaims](size, ttl)}
+}
+
+// Verify implements Authenticator.
+func (a *CachedAuthenticator) Verify(ctx context.Context, token string) (models.OidcClaims, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	if claims, ok := a.claims.Get(key); ok && claims.Expiry.Time().After(now) {
+		return claims, nil
+	}
+
+	claims, err := a.next.Verify(ctx, token)
+	if err != nil {
+		return claims, err
+	}
+	if claims.Claims != nil && claims.Expiry != nil {
+		a.claims.SetUntil(key, claims, claims.Expiry.Time())
+	}
+	return claims, nil
+}
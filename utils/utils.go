@@ -1,25 +1,27 @@
 package utils
 
 import (
-	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"math"
 	"net/http"
 	"sort"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/mitchellh/mapstructure"
+	"go.mongodb.org/mongo-driver/mongo"
 
-	CDSUtils "github.com/SLG-European-Projects/cds-go/utils"
+	db "github.com/isotiropoulos/storage-api/dbs/meta"
 	"github.com/isotiropoulos/storage-api/globals"
 	models "github.com/isotiropoulos/storage-api/models"
 )
@@ -39,6 +41,23 @@ func RespondWithError(w http.ResponseWriter, code int, message string, reason st
 	return
 }
 
+// RespondWithFieldErrors reports a request with invalid fields, one entry per field.
+func RespondWithFieldErrors(w http.ResponseWriter, message string, fieldErrors []models.FieldError, internalCode string) {
+
+	report := models.ValidationReport{
+		ErrorReport: models.ErrorReport{
+			Message:        message,
+			Reason:         fmt.Sprintf("%d invalid field(s)", len(fieldErrors)),
+			Status:         http.StatusBadRequest,
+			InternalStatus: internalCode,
+		},
+		Errors: fieldErrors,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(report)
+}
+
 func init() {}
 
 func GetPartFromChan(ch <-chan minio.ObjectPart) <-chan minio.ObjectPart {
@@ -65,6 +84,74 @@ func GenerateUUID() (string, error) {
 	return hex.EncodeToString(uuid[:4]) + "-" + hex.EncodeToString(uuid[4:6]) + "-" + hex.EncodeToString(uuid[6:8]) + "-" + hex.EncodeToString(uuid[8:10]) + "-" + hex.EncodeToString(uuid[10:]), nil
 }
 
+// APIKeyPrefix marks the keys issued to machine clients
+const APIKeyPrefix = "bsk_"
+
+// GenerateAPIKey returns a new random API key
+func GenerateAPIKey() (string, error) {
+	var secret [32]byte
+	_, err := rand.Read(secret[:])
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(secret[:]), nil
+}
+
+// HashAPIKey returns the hash under which an API key is stored
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ErrNoSecretKey is returned when secrets can't be encrypted because COP_CREDENTIALS_KEY is not set
+var ErrNoSecretKey = errors.New("no key to encrypt secrets with (COP_CREDENTIALS_KEY)")
+
+// EncryptSecret encrypts a secret with AES-GCM under COP_CREDENTIALS_KEY
+func EncryptSecret(secret string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// DecryptSecret decrypts a secret encrypted by EncryptSecret
+func DecryptSecret(encrypted string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// secretCipher derives the AES-256 key of secrets from COP_CREDENTIALS_KEY
+func secretCipher() (cipher.AEAD, error) {
+	if globals.COP_CREDENTIALS_KEY == "" {
+		return nil, ErrNoSecretKey
+	}
+	key := sha256.Sum256([]byte(globals.COP_CREDENTIALS_KEY))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // CreateFolder is a function to format an item of the folders collection.
 func CreateFolder(r models.PostFolderBody, folderID string, ancestors []string, userID string) models.Folder {
 
@@ -119,6 +206,58 @@ func GetClaimsFromContext(ctxClaims interface{}) (*models.OidcClaims, error) {
 	return &claims, nil
 }
 
+// principalKey is the context key under which the middleware stores the Principal.
+type principalKey struct{}
+
+// RoleCapabilities maps each role to the capabilities it grants.
+var RoleCapabilities = map[models.Role][]models.Capability{
+	models.RoleMember: {models.CapRead, models.CapWrite, models.CapDeleteBucket},
+	models.RoleEditor: {models.CapRead, models.CapWrite},
+	models.RoleViewer: {models.CapRead},
+}
+
+// ContextWithPrincipal returns a copy of the context carrying the principal.
+func ContextWithPrincipal(ctx context.Context, principal models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// GetPrincipalFromContext returns the principal stored in the context by the middleware.
+func GetPrincipalFromContext(ctx context.Context) (models.Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(models.Principal)
+	return principal, ok
+}
+
+// GetGroupIDFromContext returns the group (bucket) ID resolved by the middleware.
+func GetGroupIDFromContext(ctx context.Context) string {
+	principal, _ := GetPrincipalFromContext(ctx)
+	return principal.GroupID
+}
+
+// HasCapability is a function to check if a principal was granted a capability
+func HasCapability(principal models.Principal, capability models.Capability) bool {
+	for _, c := range principal.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize enforces that the caller holds the capability required by a handler.
+// It responds with 403 and returns false if the capability is missing.
+func Authorize(w http.ResponseWriter, r *http.Request, capability models.Capability) bool {
+	principal, ok := GetPrincipalFromContext(r.Context())
+	if !ok {
+		RespondWithError(w, http.StatusForbidden, "User not allowed", "No principal resolved for the request.", "AUT0001")
+		return false
+	}
+	if !HasCapability(principal, capability) {
+		RespondWithError(w, http.StatusForbidden, "User not allowed", "User with "+string(principal.Role)+" rights can't perform this action ("+string(capability)+").", "AUT0002")
+		return false
+	}
+	return true
+}
+
 // ItemInArray is a function to check if an array contains an item
 func ItemInArray(array []string, item string) bool {
 	for _, i := range array {
@@ -129,255 +268,275 @@ func ItemInArray(array []string, item string) bool {
 	return false
 }
 
-// RemoveFromSlice is a function to remove an item from a slice (Keeps order)
+// RemoveFromSlice is a function to remove an item from a slice (Keeps order). A slice without
+// the item is returned as it is.
 func RemoveFromSlice(slice []string, item string) []string {
-	var s int
-
 	for pos, val := range slice {
 		if val == item {
-			s = pos
-			break
+			return append(slice[:pos], slice[pos+1:]...)
 		}
 	}
-	return append(slice[:s], slice[s+1:]...)
+	return slice
 }
 
-// GenerateRequestFingerprint generates a SHA-256 hash of the JSON-encoded Copernicus request body
+// OrderedRequestParams are Copernicus request parameters whose list order is meaningful
+// (e.g. the North/West/South/East bounds of an area), so they are not sorted when fingerprinting.
+var OrderedRequestParams = []string{"area", "grid"}
+
+// GenerateRequestFingerprint generates a SHA-256 hash of the canonical form of a Copernicus request.
+// Only the service, the dataset name and its parameters take part: parameter values are turned into lists of
+// strings, lists are sorted and de-duplicated, numbers lose leading zeros and dates are written as
+// YYYY-MM-DD, so that equivalent requests share a fingerprint.
 func GenerateRequestFingerprint(body models.CopernicusInput) (string, error) {
 
-	// Convert request body to JSON
-	bodyJSON, err := json.Marshal(body)
-	if err != nil {
-		return "", err
+	params := make(map[string]interface{}, len(body.Body))
+	for key, value := range body.Body {
+		params[strings.ToLower(strings.TrimSpace(key))] = canonicalParam(value, !ItemInArray(OrderedRequestParams, strings.ToLower(key)))
 	}
 
-	// Sort keys to ensure consistent order
-	var m map[string]interface{}
-	if err := json.Unmarshal(bodyJSON, &m); err != nil {
-		return "", err
+	canonical := map[string]interface{}{
+		"dataset_name": strings.TrimSpace(body.DatasetName),
+		"body":         params,
 	}
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
+	// Requests to the default service keep the fingerprints they had before services were added
+	if service := strings.ToLower(strings.TrimSpace(body.Service)); service != "" && service != globals.DefaultCopernicusService {
+		canonical["service"] = service
 	}
-	sort.Strings(keys)
-	sortedBodyJSON, err := json.Marshal(m)
+
+	// encoding/json writes map keys in sorted order
+	canonicalJSON, err := json.Marshal(canonical)
 	if err != nil {
 		return "", err
 	}
 
 	// Calculate SHA-256 hash
-	hash := sha256.Sum256(sortedBodyJSON)
+	hash := sha256.Sum256(canonicalJSON)
 
 	// Convert hash to hexadecimal string
 	fingerprint := fmt.Sprintf("%x", hash)
 
-	return fingerprint, err
+	return fingerprint, nil
 }
 
-// CheckCopernicusStatus
-func CheckCopernicusStatus(dataset models.CopernicusRecord, subject string) {
-
-	// mark goroutine as running!
-
-	if _, exists := globals.RunningGoroutines.Load(dataset.Id); exists {
-		fmt.Printf("Task %s is already running. Skipping...\n", dataset.Id)
-		return // Avoid starting a new goroutine if the task is already running
-	} else {
-		fmt.Printf("No existing task with ID %s. Starting a new one...\n", dataset.Id)
-		globals.RunningGoroutines.Store(dataset.Id, true)
-
-	}
-
-	params := map[string]interface{}{
-		"log":     true,
-		"request": true,
-	}
-	var dataReader io.ReadCloser
-	var size int
-
-InfiniteLoop:
-	for {
-		task, err := globals.CopernicusClient.GetOneJob(dataset.Details.JobID, params)
-		if err != nil {
-			// Could not get the task for some reason. Break loop!
-			fmt.Println(err.Error())
-			break InfiniteLoop
+// canonicalParam normalizes the value of a request parameter. Nested objects keep their keys,
+// anything else becomes a list of strings (a single value is the same as a list of one).
+func canonicalParam(value interface{}, sortList bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		nested := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			nested[key] = canonicalParam(item, sortList)
 		}
-
-		// Status changed => Update DB Document
-		if task.Status != dataset.Details.Status {
-			dataset.Details = task
-			_, err = globals.CopernicusDB.UpdateWithId(dataset)
-			if err != nil {
-				fmt.Println(err.Error())
-				break InfiniteLoop
+		return nested
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, canonicalValue(item))
+		}
+		if !sortList {
+			return list
+		}
+		sort.Strings(list)
+		unique := list[:0]
+		for i, item := range list {
+			if i == 0 || item != list[i-1] {
+				unique = append(unique, item)
 			}
+		}
+		return unique
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+		return canonicalParam(list, sortList)
+	default:
+		return []string{canonicalValue(v)}
+	}
+}
 
-			if task.Status == "failed" || task.Status == "dismissed" {
-				break InfiniteLoop
-			} else if task.Status == "successful" {
-				//here we have to save the dataset via the download link to our database
-				//split data into parts go routines etc.
-				//then we will deal with copernicus data as a normal file in our db
-				result, err := globals.CopernicusClient.GetJobResult(dataset.Details.JobID)
-				if err != nil {
-					fmt.Println(err.Error())
-					break InfiniteLoop
-				}
-
-				dataReader, size, err = CDSUtils.DownloadFileReader(result.Asset.Value.Href)
-				if err != nil {
-					dataReader = nil
-					fmt.Println(err.Error())
-				}
-				break InfiniteLoop
-
-			} else {
-				time.Sleep(globals.CheckTime)
+// requestDateLayouts are the date formats recognized in request parameters.
+var requestDateLayouts = []string{"2006-01-02", "2006-1-2", "20060102", time.RFC3339, "2006-01-02T15:04:05"}
+
+// canonicalValue writes a scalar parameter value as a string.
+func canonicalValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		v = strings.TrimSpace(v)
+		// Date ranges are written as start/end
+		if strings.Contains(v, "/") {
+			bounds := strings.Split(v, "/")
+			for i, bound := range bounds {
+				bounds[i] = canonicalValue(bound)
 			}
-
+			return strings.Join(bounds, "/")
+		}
+		for _, layout := range requestDateLayouts {
+			if date, err := time.Parse(layout, v); err == nil {
+				return date.Format("2006-01-02")
+			}
+		}
+		if number, err := strconv.ParseFloat(v, 64); err == nil && strings.Trim(v, "0123456789.-") == "" {
+			return strconv.FormatFloat(number, 'f', -1, 64)
 		}
+		return v
+	default:
+		return fmt.Sprint(v)
 	}
+}
 
-	if dataReader != nil {
-
-		// Grab reference file
-		file, err := globals.FileDB.GetOneByID(dataset.FileId)
-		if err != nil {
-			fmt.Println("Can't retrieve file document:", err.Error())
-			return
+// SplitPath splits a path such as "/group/a/b/file.tif" into its names, from the bucket down.
+// Leading, trailing and repeated slashes are ignored.
+func SplitPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
 		}
+	}
+	return names
+}
 
-		// Calculate the number of parts and the optimal part size
-		totalPartsCount := int(math.Ceil(float64(size) / float64(globals.PartSize)))
-
-		// Initialize a slice to hold io.Reader instances for each chunk
-		var readers []io.Reader
-		var sizes []int64
-
-		checkSize := size
+// JoinPath is the path of a path's names, from the bucket down.
+func JoinPath(names []string) string {
+	return "/" + strings.Join(names, "/")
+}
 
-		for checkSize > 0 {
-			// Create a buffer for the current chunk
-			partBuffer := make([]byte, globals.PartSize)
-			n, err := io.ReadFull(dataReader, partBuffer)
-			// n, err := downresp.Body.Read(partBuffer)
-			if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF)) {
-				fmt.Println("Error reading response body:", err)
-				break
-			}
+// ItemPath is the path of an item of a title in a folder of a path.
+func ItemPath(folderPath string, title string) string {
+	return folderPath + "/" + title
+}
 
-			// Create a new slice containing only the data read in this iteration
-			buf := partBuffer[:n]
+// PathInFolder is the path of an item of a title in a folder. Items without a folder are buckets.
+func PathInFolder(folderID string, title string) (string, error) {
+	if folderID == "" {
+		return ItemPath("", title), nil
+	}
+	folder, err := globals.FolderDB.GetOneByID(folderID)
+	if err != nil {
+		return "", err
+	}
+	return ItemPath(folder.Path, title), nil
+}
 
-			readers = append(readers, bytes.NewReader(buf))
-			sizes = append(sizes, int64(n))
+// RepathFolder sets the path of a folder that was renamed or moved, and the paths of the folders
+// and files under it.
+func RepathFolder(folderID string, oldPath string, newPath string) error {
+	if err := globals.FolderDB.SetPath(folderID, newPath); err != nil {
+		return err
+	}
+	if oldPath == "" || oldPath == newPath {
+		return nil
+	}
+	if err := globals.FolderDB.ReplacePathPrefix(folderID, oldPath, newPath); err != nil {
+		return err
+	}
+	return globals.FileDB.ReplacePathPrefix(folderID, oldPath, newPath)
+}
 
-			checkSize -= n
-		}
+// WalkPath returns the folders on a path, from the bucket down, looking them up at once by their
+// paths. It stops at the first name that is not a folder, so fewer folders than names are
+// returned when the path doesn't lead to a folder. Should a folder hold the same title twice,
+// the first folder created is followed.
+func WalkPath(names []string) ([]models.Folder, error) {
+	var paths []string
+	for i := range names {
+		paths = append(paths, JoinPath(names[:i+1]))
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	cursor, err := globals.FolderDB.GetCursorByPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
 
-		defer dataReader.Close()
-
-		// Create a slice of channels to hold upload results
-		partsCh := make(chan models.Part, totalPartsCount)
-		errorCh := make(chan error, totalPartsCount)
-
-		// Create a waitgroup to synchronize uploads
-		var wg sync.WaitGroup
-
-		for i, reader := range readers {
-			wg.Add(1)
-			go func(partReader io.Reader, item int) {
-
-				defer wg.Done()
-
-				// Make updates in document
-				var filePart models.Part
-				partId, err := GenerateUUID()
-				if err != nil {
-					errorCh <- err
-					return
-				}
-
-				//get part info
-				filePart.Id = partId
-				filePart.FileID = dataset.FileId
-				filePart.Size = sizes[item]
-				filePart.PartNumber = item
-
-				// Upload part
-				uploadInfo, err := globals.Storage.PostPart(globals.COPERNICUS_BUCKET_ID, partId, partReader, sizes[item], minio.PutObjectOptions{})
-				if err != nil {
-					fmt.Println(err.Error())
-					errorCh <- err
-					return
-				}
-
-				filePart.UploadInfo = uploadInfo
-
-				// insert part to db
-				err = globals.PartsDB.InsertOne(filePart)
-				if err != nil {
-					fmt.Println("Error:", err)
-					errorCh <- err
-					return
-				}
-				partsCh <- filePart
-			}(reader, i)
+	var folders []models.Folder
+	for cursor.Next(context.Background()) {
+		var folder models.Folder
+		if err = cursor.Decode(&folder); err != nil {
+			return folders, err
 		}
-
-		// Wait for all parts to finish uploading
-		wg.Wait()
-		close(partsCh)
-		close(errorCh)
-
-		// Collect errors from the error channel
-		var hasError bool
-		for err := range errorCh {
-			fmt.Println("Error:", err)
-			hasError = true
+		// Folders come by level, the first created first
+		if folder.Level == len(folders) && (len(folders) == 0 || folder.Parent == folders[len(folders)-1].Id) {
+			folders = append(folders, folder)
 		}
+	}
+	return folders, cursor.Err()
+}
 
-		if !hasError {
-			//update dataset info
-			update := models.Updated{
-				Date: time.Now(),
-				User: subject,
-			}
-
-			file.Total = totalPartsCount
-			meta := file.Meta
-			meta.Update = update
-			file.Meta = meta
+// ResolveFilePath returns the file at a path, whose last name is the file's title.
+func ResolveFilePath(names []string) (models.File, error) {
+	if len(names) < 2 {
+		return models.File{}, mongo.ErrNoDocuments
+	}
+	return globals.FileDB.GetOneByPath(JoinPath(names))
+}
 
-			file.Size = int64(size)
+// ErrTitleTaken is returned when the title of an item is taken in its folder, and the conflict
+// policy is to fail.
+var ErrTitleTaken = errors.New("the title is taken in the folder")
 
-			//update file
-			file, err = globals.FileDB.UpdateWithId(file)
-			if err != nil {
-				fmt.Println(err.Error())
-			}
-		}
-		// Unmark goroutine from running!
-		globals.RunningGoroutines.Delete(dataset.Id)
+// ErrInvalidTitle is returned for titles that can't be a name of a path.
+var ErrInvalidTitle = errors.New(`titles can't contain "/"`)
 
+// CheckTitle checks that a title can be a name of a path.
+func CheckTitle(title string) error {
+	if strings.Contains(title, "/") {
+		return ErrInvalidTitle
 	}
+	return nil
 }
 
-// GrainFolders helps grab the correct folder from a cursor.
-func GrainFolders(ctx context.Context, candidateFolder models.Folder, parentName string, resultChan chan<- models.Folder, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Place writes an item under a title and, if the database finds the title taken in the item's
+// folder, applies a conflict policy: write stores the item under a title, holder finds the ID
+// of the item that holds a title, and remove deletes that item. It returns the title the item
+// was written under or, if it was skipped, the ID of the item that holds its title. Renames
+// need neither holder nor remove. Titles that can't be a name of a path are refused.
+func Place(policy models.ConflictPolicy, title string, file bool, write func(title string) error, holder func(title string) (string, error), remove func(id string) error) (string, string, error) {
+	if err := CheckTitle(title); err != nil {
+		return "", "", err
+	}
+	overwritten := false
+	for n := 0; n <= db.MaxNumberedTitles; n++ {
+		numbered := db.NumberedTitle(title, n, file)
+		err := write(numbered)
+		if !db.IsTitleTaken(err) {
+			return numbered, "", err
+		}
 
-	select {
-	case <-ctx.Done():
-		// If context is done, exit the goroutine
-		return
-	// case <-time.After(2 * time.Second): // Simulate processing time
-	default:
-		parent, _ := globals.FolderDB.GetOneByID(candidateFolder.Parent)
-		if parent.Meta.Title == parentName {
-			resultChan <- candidateFolder
+		switch policy {
+		case models.ConflictRename:
+			continue
+		case models.ConflictSkip:
+			id, err := holder(title)
+			return "", id, err
+		case models.ConflictOverwrite:
+			// Should the title be taken again, it's left to the other writer
+			if overwritten {
+				return "", "", ErrTitleTaken
+			}
+			id, err := holder(title)
+			if err != nil {
+				return "", "", err
+			}
+			if err = remove(id); err != nil {
+				return "", "", err
+			}
+			overwritten = true
+			n--
+		default:
+			return "", "", ErrTitleTaken
 		}
 	}
+	return "", "", ErrTitleTaken
 }